@@ -0,0 +1,374 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package acl contains the border router's access control lists. An ACL is an
+// ordered list of rules, loaded from acl.yml in the router's config directory.
+// The first rule matching a packet decides whether it is allowed or denied;
+// packets that match no rule get the ACL's default action. For example:
+//
+//	Default: allow
+//	Rules:
+//	  - Name: no-telnet-from-children
+//	    Action: deny
+//	    IngressLink: CHILD
+//	    L4: tcp
+//	    DstPort: 23
+//	  - Name: isd2-only-via-core
+//	    Action: deny
+//	    DstIA: 2-0
+//	    EgressLink: PARENT
+//
+// All rule fields other than Name and Action are optional, and a rule matches
+// a packet only if every field it sets matches. An ISD or AS of 0 is a
+// wildcard, hosts are IP addresses or prefixes, and ports are single ports or
+// ranges (e.g. 1024-2047). Ports only match TCP and UDP packets, so rules
+// setting ports and any other L4 protocol are rejected. Interface 0 is the
+// local AS. Link types are those of the topology (CORE, PARENT, CHILD, PEER).
+package acl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/topology"
+)
+
+const CfgName = "acl.yml"
+
+const (
+	ErrorOpen  = "Unable to open ACL"
+	ErrorParse = "Unable to parse ACL"
+)
+
+// Action is what happens to packets matching a rule.
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// DefRuleName is the name used for the ACL's default action, e.g. in Stats.
+const DefRuleName = "default"
+
+// PortProtos are the L4 protocols with ports that rules can match on. Both
+// have the source and destination ports as the first two fields of their
+// header.
+var PortProtos = map[common.L4ProtocolType]bool{common.L4TCP: true, common.L4UDP: true}
+
+// Pkt contains the packet fields that rules can match on.
+type Pkt struct {
+	SrcIA, DstIA *addr.ISD_AS
+	// SrcHost and DstHost are nil for non-IP (e.g. SVC) addresses.
+	SrcHost, DstHost net.IP
+	// L4 is the L4 protocol, or common.L4None if it's unknown.
+	L4 common.L4ProtocolType
+	// HasPorts is set if SrcPort and DstPort are valid.
+	HasPorts         bool
+	SrcPort, DstPort int
+	// InIF and EgIF are the ingress and egress interfaces, 0 meaning the
+	// local AS.
+	InIF, EgIF spath.IntfID
+	// InLink and EgLink are the link types of InIF and EgIF, empty for the
+	// local AS.
+	InLink, EgLink string
+}
+
+// ACL is an ordered list of rules. Evaluating it is safe for concurrent use.
+type ACL struct {
+	Default Action
+	Rules   []*Rule
+	defHits uint64
+}
+
+// Load loads an ACL from a file.
+func Load(path string) (*ACL, *common.Error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, common.NewError(ErrorOpen, "err", err)
+	}
+	return Parse(b, path)
+}
+
+// Parse parses an ACL from its YAML representation.
+func Parse(data []byte, path string) (*ACL, *common.Error) {
+	c := &aclConf{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, common.NewError(ErrorParse, "err", err, "path", path)
+	}
+	a := &ACL{Default: Allow}
+	if c.Default != "" {
+		act, err := parseAction(c.Default)
+		if err != nil {
+			return nil, common.NewError(ErrorParse, "err", err, "path", path)
+		}
+		a.Default = act
+	}
+	names := make(map[string]bool)
+	for i, rc := range c.Rules {
+		r, err := rc.compile()
+		if err != nil {
+			return nil, common.NewError(ErrorParse, "rule", i, "err", err, "path", path)
+		}
+		if names[r.Name] {
+			return nil, common.NewError(ErrorParse, "rule", i, "err", "duplicate name",
+				"name", r.Name, "path", path)
+		}
+		names[r.Name] = true
+		a.Rules = append(a.Rules, r)
+	}
+	return a, nil
+}
+
+// Check evaluates the ACL for a packet, returning whether the packet is
+// allowed and the name of the deciding rule (DefRuleName if no rule
+// matched). The hit counter of the deciding rule is incremented.
+func (a *ACL) Check(p *Pkt) (bool, string) {
+	for _, r := range a.Rules {
+		if r.Match(p) {
+			atomic.AddUint64(&r.hits, 1)
+			return r.Action == Allow, r.Name
+		}
+	}
+	atomic.AddUint64(&a.defHits, 1)
+	return a.Default == Allow, DefRuleName
+}
+
+// RuleStats is the hit count of a rule.
+type RuleStats struct {
+	Name   string
+	Action Action
+	Hits   uint64
+}
+
+// Stats returns the hit counts of all rules in order, followed by the hit
+// count of the default action. Counts start from 0 whenever an ACL is
+// loaded.
+func (a *ACL) Stats() []RuleStats {
+	stats := make([]RuleStats, 0, len(a.Rules)+1)
+	for _, r := range a.Rules {
+		stats = append(stats, RuleStats{Name: r.Name, Action: r.Action, Hits: r.Hits()})
+	}
+	return append(stats, RuleStats{Name: DefRuleName, Action: a.Default,
+		Hits: atomic.LoadUint64(&a.defHits)})
+}
+
+// Rule is a single ACL entry. Nil/empty fields match any packet.
+type Rule struct {
+	Name             string
+	Action           Action
+	SrcIA, DstIA     *addr.ISD_AS
+	SrcHost, DstHost *net.IPNet
+	L4               *common.L4ProtocolType
+	SrcPort, DstPort *PortRange
+	InIF, EgIF       *spath.IntfID
+	InLink, EgLink   string
+	hits             uint64
+}
+
+// Match returns true if the packet matches all fields set in the rule.
+func (r *Rule) Match(p *Pkt) bool {
+	switch {
+	case r.SrcIA != nil && !iaMatch(r.SrcIA, p.SrcIA),
+		r.DstIA != nil && !iaMatch(r.DstIA, p.DstIA),
+		r.SrcHost != nil && (p.SrcHost == nil || !r.SrcHost.Contains(p.SrcHost)),
+		r.DstHost != nil && (p.DstHost == nil || !r.DstHost.Contains(p.DstHost)),
+		r.L4 != nil && *r.L4 != p.L4,
+		r.SrcPort != nil && (!p.HasPorts || !r.SrcPort.Contains(p.SrcPort)),
+		r.DstPort != nil && (!p.HasPorts || !r.DstPort.Contains(p.DstPort)),
+		r.InIF != nil && *r.InIF != p.InIF,
+		r.EgIF != nil && *r.EgIF != p.EgIF,
+		r.InLink != "" && r.InLink != p.InLink,
+		r.EgLink != "" && r.EgLink != p.EgLink:
+		return false
+	}
+	return true
+}
+
+// Hits returns the number of packets the rule has decided.
+func (r *Rule) Hits() uint64 {
+	return atomic.LoadUint64(&r.hits)
+}
+
+// iaMatch matches a rule ISD-AS, which can contain wildcards, with a (possibly
+// missing) packet ISD-AS.
+func iaMatch(ia, pktIA *addr.ISD_AS) bool {
+	return pktIA != nil && (ia.I == 0 || ia.I == pktIA.I) && (ia.A == 0 || ia.A == pktIA.A)
+}
+
+// PortRange is an inclusive range of L4 ports.
+type PortRange struct {
+	Min, Max int
+}
+
+func (pr *PortRange) Contains(port int) bool {
+	return port >= pr.Min && port <= pr.Max
+}
+
+func (pr *PortRange) String() string {
+	if pr.Min == pr.Max {
+		return strconv.Itoa(pr.Min)
+	}
+	return fmt.Sprintf("%d-%d", pr.Min, pr.Max)
+}
+
+// aclConf is the YAML representation of an ACL.
+type aclConf struct {
+	Default string     `yaml:"Default"`
+	Rules   []ruleConf `yaml:"Rules"`
+}
+
+// ruleConf is the YAML representation of a Rule.
+type ruleConf struct {
+	Name        string        `yaml:"Name"`
+	Action      string        `yaml:"Action"`
+	SrcIA       *addr.ISD_AS  `yaml:"SrcIA"`
+	DstIA       *addr.ISD_AS  `yaml:"DstIA"`
+	SrcHost     string        `yaml:"SrcHost"`
+	DstHost     string        `yaml:"DstHost"`
+	L4          string        `yaml:"L4"`
+	SrcPort     string        `yaml:"SrcPort"`
+	DstPort     string        `yaml:"DstPort"`
+	IngressIF   *spath.IntfID `yaml:"IngressIF"`
+	EgressIF    *spath.IntfID `yaml:"EgressIF"`
+	IngressLink string        `yaml:"IngressLink"`
+	EgressLink  string        `yaml:"EgressLink"`
+}
+
+func (rc *ruleConf) compile() (*Rule, error) {
+	if rc.Name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	if strings.EqualFold(rc.Name, DefRuleName) {
+		return nil, fmt.Errorf("reserved name %q", rc.Name)
+	}
+	r := &Rule{Name: rc.Name, SrcIA: rc.SrcIA, DstIA: rc.DstIA, InIF: rc.IngressIF,
+		EgIF: rc.EgressIF}
+	var err error
+	if r.Action, err = parseAction(rc.Action); err != nil {
+		return nil, err
+	}
+	if r.SrcHost, err = parseHost(rc.SrcHost); err != nil {
+		return nil, err
+	}
+	if r.DstHost, err = parseHost(rc.DstHost); err != nil {
+		return nil, err
+	}
+	if r.L4, err = parseL4(rc.L4); err != nil {
+		return nil, err
+	}
+	if r.SrcPort, err = parsePorts(rc.SrcPort); err != nil {
+		return nil, err
+	}
+	if r.DstPort, err = parsePorts(rc.DstPort); err != nil {
+		return nil, err
+	}
+	if (r.SrcPort != nil || r.DstPort != nil) && r.L4 != nil && !PortProtos[*r.L4] {
+		return nil, fmt.Errorf("ports can't be matched for L4 protocol %q", rc.L4)
+	}
+	if r.InLink, err = parseLink(rc.IngressLink); err != nil {
+		return nil, err
+	}
+	if r.EgLink, err = parseLink(rc.EgressLink); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func parseAction(s string) (Action, error) {
+	switch act := Action(strings.ToLower(s)); act {
+	case Allow, Deny:
+		return act, nil
+	}
+	return "", fmt.Errorf("unknown action %q", s)
+}
+
+// parseHost parses an IP address or prefix. A plain address is converted to
+// a prefix containing only that address.
+func parseHost(s string) (*net.IPNet, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid host %q", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+var l4Names = map[string]common.L4ProtocolType{
+	"scmp": common.L4SCMP, "tcp": common.L4TCP, "udp": common.L4UDP, "ssp": common.L4SSP,
+}
+
+func parseL4(s string) (*common.L4ProtocolType, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if proto, ok := l4Names[strings.ToLower(s)]; ok {
+		return &proto, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("unknown L4 protocol %q", s)
+	}
+	proto := common.L4ProtocolType(n)
+	return &proto, nil
+}
+
+// parsePorts parses a port, or a port range of the form "min-max".
+func parsePorts(s string) (*PortRange, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	min, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", s)
+	}
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid port %q", s)
+		}
+	}
+	if min > max {
+		return nil, fmt.Errorf("invalid port range %q", s)
+	}
+	return &PortRange{Min: int(min), Max: int(max)}, nil
+}
+
+func parseLink(s string) (string, error) {
+	switch link := strings.ToUpper(s); link {
+	case "", topology.LinkCore, topology.LinkParent, topology.LinkChild, topology.LinkPeer:
+		return link, nil
+	}
+	return "", fmt.Errorf("unknown link type %q", s)
+}
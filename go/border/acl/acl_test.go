@@ -0,0 +1,143 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+const testACL = `
+Default: deny
+Rules:
+  - Name: udp-from-isd1
+    Action: allow
+    SrcIA: 1-0
+    SrcHost: 10.0.0.0/8
+    L4: udp
+    DstPort: 1000-1999
+  - Name: no-children
+    Action: deny
+    IngressLink: CHILD
+  - Name: local-to-core
+    Action: allow
+    IngressIF: 0
+    DstIA: 2-20
+    DstHost: 192.168.0.1
+    EgressLink: core
+`
+
+func testPkt() *Pkt {
+	return &Pkt{
+		SrcIA: &addr.ISD_AS{I: 1, A: 13}, DstIA: &addr.ISD_AS{I: 2, A: 20},
+		SrcHost: net.IPv4(10, 0, 0, 1), DstHost: net.IPv4(192, 168, 0, 1),
+		L4: common.L4UDP, HasPorts: true, SrcPort: 3000, DstPort: 1500,
+		InIF: 2, EgIF: 5, InLink: "CHILD", EgLink: "CORE",
+	}
+}
+
+func Test_Check(t *testing.T) {
+	Convey("Rules are checked in order", t, func() {
+		a, err := Parse([]byte(testACL), "test")
+		So(err, ShouldBeNil)
+		cases := []struct {
+			desc  string
+			mod   func(p *Pkt)
+			allow bool
+			rule  string
+		}{
+			{"All fields match", func(p *Pkt) {}, true, "udp-from-isd1"},
+			{"ISD wildcard", func(p *Pkt) { p.SrcIA.A = 99 }, true, "udp-from-isd1"},
+			{"Other ISD", func(p *Pkt) { p.SrcIA.I = 2 }, false, "no-children"},
+			{"Host outside prefix", func(p *Pkt) { p.SrcHost = net.IPv4(11, 0, 0, 1) },
+				false, "no-children"},
+			{"Non-IP host", func(p *Pkt) { p.SrcHost = nil }, false, "no-children"},
+			{"Port outside range", func(p *Pkt) { p.DstPort = 2000 }, false, "no-children"},
+			{"No ports", func(p *Pkt) { p.HasPorts = false }, false, "no-children"},
+			{"From the local AS", func(p *Pkt) {
+				p.L4, p.InIF, p.InLink = common.L4TCP, 0, ""
+			}, true, "local-to-core"},
+			{"Single host", func(p *Pkt) {
+				p.L4, p.InIF, p.InLink = common.L4TCP, 0, ""
+				p.DstHost = net.IPv4(192, 168, 0, 2)
+			}, false, DefRuleName},
+			{"Default", func(p *Pkt) {
+				p.L4, p.InIF, p.InLink, p.EgLink = common.L4TCP, 3, "PEER", "PARENT"
+			}, false, DefRuleName},
+		}
+		for _, c := range cases {
+			Convey(c.desc, func() {
+				p := testPkt()
+				c.mod(p)
+				allow, rule := a.Check(p)
+				So(allow, ShouldEqual, c.allow)
+				So(rule, ShouldEqual, c.rule)
+			})
+		}
+	})
+	Convey("Hits are counted per rule", t, func() {
+		a, err := Parse([]byte(testACL), "test")
+		So(err, ShouldBeNil)
+		a.Check(testPkt())
+		a.Check(testPkt())
+		p := testPkt()
+		p.SrcIA = nil
+		a.Check(p)
+		So(a.Stats(), ShouldResemble, []RuleStats{
+			{Name: "udp-from-isd1", Action: Allow, Hits: 2},
+			{Name: "no-children", Action: Deny, Hits: 1},
+			{Name: "local-to-core", Action: Allow, Hits: 0},
+			{Name: DefRuleName, Action: Deny, Hits: 0},
+		})
+	})
+	Convey("An empty ACL allows everything", t, func() {
+		a, err := Parse(nil, "test")
+		So(err, ShouldBeNil)
+		allow, rule := a.Check(testPkt())
+		So(allow, ShouldBeTrue)
+		So(rule, ShouldEqual, DefRuleName)
+	})
+}
+
+func Test_Parse_Errors(t *testing.T) {
+	cases := map[string]string{
+		"Bad default":           "Default: drop",
+		"Missing name":          "Rules: [{Action: deny}]",
+		"Reserved name":         "Rules: [{Name: Default, Action: deny}]",
+		"Duplicate name":        "Rules: [{Name: a, Action: deny}, {Name: a, Action: allow}]",
+		"Missing action":        "Rules: [{Name: a}]",
+		"Bad ISD-AS":            "Rules: [{Name: a, Action: deny, SrcIA: 1}]",
+		"Bad host":              "Rules: [{Name: a, Action: deny, DstHost: 10.0.0}]",
+		"Bad prefix":            "Rules: [{Name: a, Action: deny, DstHost: 10.0.0.0/33}]",
+		"Bad L4 protocol":       "Rules: [{Name: a, Action: deny, L4: quic}]",
+		"Bad port":              "Rules: [{Name: a, Action: deny, DstPort: 65536}]",
+		"Reverse port range":    "Rules: [{Name: a, Action: deny, SrcPort: 20-10}]",
+		"Ports without TCP/UDP": "Rules: [{Name: a, Action: deny, L4: scmp, DstPort: 23}]",
+		"Bad link type":         "Rules: [{Name: a, Action: deny, EgressLink: sibling}]",
+		"Bad interface":         "Rules: [{Name: a, Action: deny, IngressIF: -1}]",
+	}
+	for desc, yml := range cases {
+		Convey(desc, t, func() {
+			_, err := Parse([]byte(yml), "test")
+			So(err, ShouldNotBeNil)
+			So(err.Desc, ShouldEqual, ErrorParse)
+		})
+	}
+}
@@ -0,0 +1,507 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file provides a JSON admin API, for inspecting the state of a running
+// router, and for administratively disabling interfaces and service instances.
+// It is served on its own address (see the -admin flag), and not on the local
+// data-plane addresses like the prometheus metrics.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/netsec-ethz/scion/go/border/acl"
+	"github.com/netsec-ethz/scion/go/border/capture"
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/netconf"
+	"github.com/netsec-ethz/scion/go/lib/assert"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/log"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+var (
+	adminAddr = flag.String("admin", "",
+		"Address to serve the admin API on (E.g. '127.0.0.1:30442'). Disabled if empty.")
+	captureDir = flag.String("capture.dir", "logs",
+		"Directory for packet capture files started via the admin API")
+	// buildVersion can be set at link time, using
+	// -ldflags "-X main.buildVersion=<version>".
+	buildVersion = "unknown"
+	// startTime is used to report the router's uptime.
+	startTime = time.Now()
+)
+
+// AdminInfo describes the running router process.
+type AdminInfo struct {
+	Id        string
+	IA        string
+	Version   string
+	GoVersion string
+	Assert    bool
+	StartTime time.Time
+	UptimeSec float64
+}
+
+// AdminConf describes the router's currently loaded configuration.
+type AdminConf struct {
+	IA          string
+	Dir         string
+	LocAddrs    []string
+	CtrlAddrIdx int
+	Interfaces  []AdminIntf
+}
+
+// AdminIntf describes the configuration of a single interface.
+type AdminIntf struct {
+	IFID       spath.IntfID
+	LinkType   string
+	MTU        int
+	BW         int
+	RemoteIA   string
+	LocAddrIdx int
+	Addr       string
+	RemoteAddr string
+}
+
+// AdminIFState describes the current state of a single interface, as last
+// reported by the beacon service, whether it is administratively down, and
+// the liveness of its link according to the IFID keepalives received.
+type AdminIFState struct {
+	IFID spath.IntfID
+	// Known is false if no state has been received from the beacon service
+	// yet, in which case Active and Revoked are not meaningful.
+	Known   bool
+	Active  bool
+	Revoked bool
+	// RevAgeSec is how long ago the current revocation was first received.
+	RevAgeSec float64 `json:",omitempty"`
+	AdminDown bool
+	// Link is the state of the link: unknown, up or down.
+	Link string
+	// LastSeenSec is how long ago the last IFID keepalive was received.
+	LastSeenSec float64 `json:",omitempty"`
+	// Flaps is the number of times the link has been declared down.
+	Flaps uint64
+}
+
+// AdminLink describes the measurements of a single link, from the link probes
+// sent to the neighbouring router.
+type AdminLink struct {
+	IFID      spath.IntfID
+	Sent      uint64
+	Received  uint64
+	Lost      uint64
+	Failed    uint64
+	RTTSec    float64
+	SRTTSec   float64
+	JitterSec float64
+	// Loss is the fraction of the recent probes that were lost.
+	Loss float64
+}
+
+// AdminSVCInstance describes the health of a service instance, as used for
+// anycast SVC addresses.
+type AdminSVCInstance struct {
+	Name    string
+	Addr    string
+	Healthy bool
+	// Down is set if the instance has been marked down via the admin API.
+	Down bool
+	// LastSeenSec is how long ago the last packet from the instance was
+	// received, if the -svc.timeout is enabled.
+	LastSeenSec float64 `json:",omitempty"`
+	// Deliveries is the number of packets to anycast SVC addresses that have
+	// been delivered to the instance.
+	Deliveries uint64
+}
+
+// AdminPktPool describes the state of the packet buffer pool (see
+// Router.getPktBuf).
+type AdminPktPool struct {
+	Free      int
+	Capacity  int
+	Created   float64
+	Reused    float64
+	Discarded float64
+}
+
+// AdminCapture describes the running packet capture, if any.
+type AdminCapture struct {
+	Running  bool
+	Filter   string     `json:",omitempty"`
+	MaxBytes int64      `json:",omitempty"`
+	MaxFiles int        `json:",omitempty"`
+	Started  *time.Time `json:",omitempty"`
+	Packets  uint64     `json:",omitempty"`
+	Dropped  uint64     `json:",omitempty"`
+	Files    []string   `json:",omitempty"`
+}
+
+// AdminACL describes the loaded ACL, with the number of packets decided by
+// each rule since it was loaded.
+type AdminACL struct {
+	// Loaded is false if there is no ACL file, in which case all packets are
+	// allowed.
+	Loaded bool
+	Rules  []acl.RuleStats `json:",omitempty"`
+}
+
+// AdminStatus combines all of the information available via the admin API.
+type AdminStatus struct {
+	Info     AdminInfo
+	Conf     AdminConf
+	IFStates []AdminIFState
+	Links    []AdminLink
+	SVC      []AdminSVCInstance
+	PktPool  AdminPktPool
+}
+
+// setupAdmin starts serving the admin API on the given address. The address
+// is bound before returning, so that errors are reported at startup.
+func (r *Router) setupAdmin(address string) *common.Error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return common.NewError("Unable to listen on admin address", "addr", address, "err", err)
+	}
+	log.Info("Serving admin API", "addr", ln.Addr())
+	mux := r.adminMux()
+	go func() {
+		defer liblog.PanicLog()
+		if err := http.Serve(ln, mux); err != nil {
+			log.Error("Admin API server stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+// adminMux returns the handlers of the admin API.
+func (r *Router) adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", adminGetHandler(func() interface{} { return r.adminStatus() }))
+	mux.HandleFunc("/info", adminGetHandler(func() interface{} { return r.adminInfo() }))
+	mux.HandleFunc("/conf", adminGetHandler(func() interface{} { return adminConf() }))
+	mux.HandleFunc("/ifstates", adminGetHandler(func() interface{} { return r.adminIFStates() }))
+	mux.HandleFunc("/links", adminGetHandler(func() interface{} { return r.adminLinks() }))
+	mux.HandleFunc("/svc", adminGetHandler(func() interface{} { return r.adminSVC() }))
+	mux.HandleFunc("/svc/down", r.adminSetSVCHandler(true))
+	mux.HandleFunc("/svc/up", r.adminSetSVCHandler(false))
+	mux.HandleFunc("/pktpool", adminGetHandler(func() interface{} { return r.adminPktPool() }))
+	mux.HandleFunc("/intf/down", r.adminSetIntfHandler(true))
+	mux.HandleFunc("/intf/up", r.adminSetIntfHandler(false))
+	mux.HandleFunc("/acl", adminGetHandler(func() interface{} { return adminACL() }))
+	mux.HandleFunc("/capture", adminGetHandler(func() interface{} { return adminCapture() }))
+	mux.HandleFunc("/capture/start", r.adminCaptureStart)
+	mux.HandleFunc("/capture/stop", adminCaptureStop)
+	return mux
+}
+
+// adminGetHandler returns an HTTP handler that replies with the JSON encoding
+// of the value returned by f.
+func adminGetHandler(f func() interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, req, f())
+	}
+}
+
+// writeJSON writes the JSON encoding of v as the reply to req.
+func writeJSON(w http.ResponseWriter, req *http.Request, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Error("Unable to encode admin API reply", "path", req.URL.Path, "err", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(b, '\n'))
+}
+
+func (r *Router) adminStatus() AdminStatus {
+	return AdminStatus{
+		Info: r.adminInfo(), Conf: adminConf(), IFStates: r.adminIFStates(),
+		Links: r.adminLinks(), SVC: r.adminSVC(), PktPool: r.adminPktPool(),
+	}
+}
+
+func (r *Router) adminInfo() AdminInfo {
+	return AdminInfo{
+		Id:        r.Id,
+		IA:        conf.Get().IA.String(),
+		Version:   buildVersion,
+		GoVersion: runtime.Version(),
+		Assert:    assert.On,
+		StartTime: startTime,
+		UptimeSec: time.Since(startTime).Seconds(),
+	}
+}
+
+func adminConf() AdminConf {
+	c := conf.Get()
+	ac := AdminConf{IA: c.IA.String(), Dir: c.Dir, CtrlAddrIdx: c.Net.CtrlAddrIdx}
+	for _, over := range c.Net.LocAddr {
+		ac.LocAddrs = append(ac.LocAddrs, over.BindAddr().String())
+	}
+	for _, ifid := range sortedIFIDs(c) {
+		intf := c.Net.IFs[ifid]
+		ac.Interfaces = append(ac.Interfaces, AdminIntf{
+			IFID:       ifid,
+			LinkType:   intf.Type,
+			MTU:        intf.MTU,
+			BW:         intf.BW,
+			RemoteIA:   intf.RemoteIA.String(),
+			LocAddrIdx: intf.LocAddrIdx,
+			Addr:       intf.IFAddr.BindAddr().String(),
+			RemoteAddr: intf.RemoteAddr.String(),
+		})
+	}
+	return ac
+}
+
+// adminSetIntfHandler returns an HTTP handler that marks the interface given
+// by the "ifid" form value as administratively down (or up, if down is false).
+// It replies with the resulting state of the interface.
+func (r *Router) adminSetIntfHandler(down bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v, err := strconv.ParseUint(req.FormValue("ifid"), 10, 16)
+		if err != nil {
+			http.Error(w, "Invalid ifid", http.StatusBadRequest)
+			return
+		}
+		ifid := spath.IntfID(v)
+		c := conf.Get()
+		if _, ok := c.Net.IFs[ifid]; !ok {
+			http.Error(w, "Unknown interface", http.StatusNotFound)
+			return
+		}
+		setAdminDown(c, ifid, down)
+		writeJSON(w, req, r.adminIFState(c, ifid, time.Now()))
+	}
+}
+
+// adminSetSVCHandler returns an HTTP handler that marks the service instance
+// given by the "name" form value as down (or up, if down is false), so that it
+// is skipped for anycast SVC addresses. It replies with the resulting state of
+// all instances.
+func (r *Router) adminSetSVCHandler(down bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := req.FormValue("name")
+		if !r.anycast.Health.SetDown(name, down) {
+			http.Error(w, "Unknown service instance", http.StatusNotFound)
+			return
+		}
+		if down {
+			log.Info("Service instance administratively down", "name", name)
+		} else {
+			log.Info("Service instance administratively up", "name", name)
+		}
+		writeJSON(w, req, r.adminSVC())
+	}
+}
+
+// adminCaptureStart starts a packet capture (replacing any running one). The
+// capture is configured by the "filter", "maxbytes" and "maxfiles" form
+// values, all of which are optional.
+func (r *Router) adminCaptureStart(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := capture.Config{Filter: req.FormValue("filter"), MaxFiles: 1}
+	var err error
+	if v := req.FormValue("maxbytes"); v != "" {
+		if cfg.MaxBytes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "Invalid maxbytes", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := req.FormValue("maxfiles"); v != "" {
+		if cfg.MaxFiles, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid maxfiles", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, cerr := capture.Start(*captureDir, r.Id, cfg); cerr != nil {
+		http.Error(w, cerr.String(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, req, adminCapture())
+}
+
+// adminCaptureStop stops the running packet capture, if any.
+func adminCaptureStop(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	capture.Stop()
+	writeJSON(w, req, adminCapture())
+}
+
+func adminCapture() AdminCapture {
+	c := capture.Get()
+	if c == nil {
+		return AdminCapture{}
+	}
+	files := c.Files()
+	sort.Strings(files)
+	return AdminCapture{
+		Running: true, Filter: c.Filter, MaxBytes: c.MaxBytes, MaxFiles: c.MaxFiles,
+		Started: &c.Started, Packets: atomic.LoadUint64(&c.Packets),
+		Dropped: atomic.LoadUint64(&c.Dropped), Files: files,
+	}
+}
+
+func adminACL() AdminACL {
+	a := conf.Get().ACL
+	if a == nil {
+		return AdminACL{}
+	}
+	return AdminACL{Loaded: true, Rules: a.Stats()}
+}
+
+// setAdminDown changes the administrative state of an interface.
+func setAdminDown(c *conf.Conf, ifid spath.IntfID, down bool) {
+	c.AdminDown.Set(ifid, down)
+	gauge := metrics.IFAdminDown.WithLabelValues(fmt.Sprintf("intf:%d", ifid))
+	if down {
+		log.Info("Interface administratively down", "ifid", ifid)
+		gauge.Set(1)
+	} else {
+		log.Info("Interface administratively up", "ifid", ifid)
+		gauge.Set(0)
+	}
+}
+
+func (r *Router) adminIFStates() []AdminIFState {
+	c := conf.Get()
+	now := time.Now()
+	states := []AdminIFState{}
+	for _, ifid := range sortedIFIDs(c) {
+		states = append(states, r.adminIFState(c, ifid, now))
+	}
+	return states
+}
+
+func (r *Router) adminIFState(c *conf.Conf, ifid spath.IntfID, now time.Time) AdminIFState {
+	link := r.liveness.get(ifid)
+	s := AdminIFState{IFID: ifid, AdminDown: c.AdminDown.IsDown(ifid),
+		Link: link.State.String(), Flaps: link.Flaps}
+	if !link.LastSeen.IsZero() {
+		s.LastSeenSec = now.Sub(link.LastSeen).Seconds()
+	}
+	c.IFStates.RLock()
+	state, ok := c.IFStates.M[ifid]
+	c.IFStates.RUnlock()
+	if !ok {
+		// No state received from the beacon service yet.
+		return s
+	}
+	s.Known = true
+	s.Active = state.P.Active()
+	s.Revoked = !s.Active
+	if !state.RevTime.IsZero() {
+		s.RevAgeSec = now.Sub(state.RevTime).Seconds()
+	}
+	return s
+}
+
+func (r *Router) adminLinks() []AdminLink {
+	links := []AdminLink{}
+	for _, ifid := range sortedIFIDs(conf.Get()) {
+		s := r.prober.get(ifid)
+		links = append(links, AdminLink{
+			IFID: ifid, Sent: s.Sent, Received: s.Received, Lost: s.Lost, Failed: s.Failed,
+			RTTSec: s.RTT.Seconds(), SRTTSec: s.SRTT.Seconds(),
+			JitterSec: s.Jitter.Seconds(), Loss: s.Loss,
+		})
+	}
+	return links
+}
+
+func (r *Router) adminSVC() []AdminSVCInstance {
+	now := time.Now()
+	insts := []AdminSVCInstance{}
+	for _, s := range r.anycast.Health.Stats(now) {
+		inst := AdminSVCInstance{
+			Name: s.Name, Addr: s.IP.String(), Healthy: s.Healthy, Down: s.Down,
+			Deliveries: s.Deliveries,
+		}
+		if !s.LastSeen.IsZero() {
+			inst.LastSeenSec = now.Sub(s.LastSeen).Seconds()
+		}
+		insts = append(insts, inst)
+	}
+	return insts
+}
+
+func (r *Router) adminPktPool() AdminPktPool {
+	return AdminPktPool{
+		Free:      len(r.freePkts),
+		Capacity:  cap(r.freePkts),
+		Created:   counterValue(metrics.PktBufNew),
+		Reused:    counterValue(metrics.PktBufReuse),
+		Discarded: counterValue(metrics.PktBufDiscard),
+	}
+}
+
+// counterValue retrieves the current value of a prometheus counter.
+func counterValue(c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
+
+// sortedIFIDs returns the interface IDs of the router, in ascending order.
+func sortedIFIDs(c *conf.Conf) []spath.IntfID {
+	ifids := make([]spath.IntfID, 0, len(c.Net.IFs))
+	for ifid := range c.Net.IFs {
+		ifids = append(ifids, ifid)
+	}
+	netconf.SortIFIDs(ifids)
+	return ifids
+}
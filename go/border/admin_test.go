@@ -0,0 +1,207 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/border/conf"
+)
+
+// adminReq sends a request to the router's admin API. The form values (if any)
+// are sent in the request body.
+func adminReq(r *Router, method, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	r.adminMux().ServeHTTP(w, req)
+	return w
+}
+
+// adminJSON decodes the JSON body of an admin API reply.
+func adminJSON(w *httptest.ResponseRecorder) interface{} {
+	So(w.Code, ShouldEqual, http.StatusOK)
+	So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+	var v interface{}
+	So(json.Unmarshal(w.Body.Bytes(), &v), ShouldBeNil)
+	return v
+}
+
+// jsonKeys returns the sorted keys of a decoded JSON object.
+func jsonKeys(v interface{}) []string {
+	obj, ok := v.(map[string]interface{})
+	So(ok, ShouldBeTrue)
+	var keys []string
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func Test_Admin_Get(t *testing.T) {
+	ifStateKeys := []string{"Active", "AdminDown", "Flaps", "IFID", "Known", "Link", "Revoked"}
+	Convey("The admin API", t, func() {
+		h := newHarness(t, "br1-11-1")
+		Convey("/info describes the router process", func() {
+			v := adminJSON(adminReq(h.r, "GET", "/info", nil))
+			So(jsonKeys(v), ShouldResemble, []string{"Assert", "GoVersion", "IA", "Id",
+				"StartTime", "UptimeSec", "Version"})
+			So(v.(map[string]interface{})["Id"], ShouldEqual, "br1-11-1")
+			So(v.(map[string]interface{})["IA"], ShouldEqual, "1-11")
+		})
+		Convey("/conf describes the loaded config", func() {
+			v := adminJSON(adminReq(h.r, "GET", "/conf", nil))
+			So(jsonKeys(v), ShouldResemble, []string{"CtrlAddrIdx", "Dir", "IA",
+				"Interfaces", "LocAddrs"})
+			intfs := v.(map[string]interface{})["Interfaces"].([]interface{})
+			So(len(intfs), ShouldEqual, len(conf.Get().Net.IFs))
+			So(jsonKeys(intfs[0]), ShouldResemble, []string{"Addr", "BW", "IFID",
+				"LinkType", "LocAddrIdx", "MTU", "RemoteAddr", "RemoteIA"})
+			So(intfs[0].(map[string]interface{})["IFID"], ShouldEqual, 1)
+		})
+		Convey("/ifstates lists the state of every interface", func() {
+			v := adminJSON(adminReq(h.r, "GET", "/ifstates", nil))
+			states := v.([]interface{})
+			So(len(states), ShouldEqual, len(conf.Get().Net.IFs))
+			for _, s := range states {
+				So(jsonKeys(s), ShouldResemble, ifStateKeys)
+				So(s.(map[string]interface{})["Known"], ShouldBeFalse)
+			}
+		})
+		Convey("/links lists the measurements of every link", func() {
+			v := adminJSON(adminReq(h.r, "GET", "/links", nil))
+			links := v.([]interface{})
+			So(len(links), ShouldEqual, len(conf.Get().Net.IFs))
+			So(jsonKeys(links[0]), ShouldResemble, []string{"Failed", "IFID", "JitterSec",
+				"Loss", "Lost", "RTTSec", "Received", "SRTTSec", "Sent"})
+		})
+		Convey("/svc lists the service instances", func() {
+			v := adminJSON(adminReq(h.r, "GET", "/svc", nil))
+			insts := v.([]interface{})
+			So(len(insts), ShouldBeGreaterThan, 0)
+			So(jsonKeys(insts[0]), ShouldResemble, []string{"Addr", "Deliveries", "Down",
+				"Healthy", "Name"})
+		})
+		Convey("/pktpool describes the packet buffer pool", func() {
+			v := adminJSON(adminReq(h.r, "GET", "/pktpool", nil))
+			So(jsonKeys(v), ShouldResemble, []string{"Capacity", "Created", "Discarded",
+				"Free", "Reused"})
+		})
+		Convey("/status combines all of the above", func() {
+			v := adminJSON(adminReq(h.r, "GET", "/status", nil))
+			So(jsonKeys(v), ShouldResemble, []string{"Conf", "IFStates", "Info", "Links",
+				"PktPool", "SVC"})
+		})
+		Convey("/acl reports that no ACL is loaded", func() {
+			v := adminJSON(adminReq(h.r, "GET", "/acl", nil))
+			So(jsonKeys(v), ShouldResemble, []string{"Loaded"})
+			So(v.(map[string]interface{})["Loaded"], ShouldBeFalse)
+		})
+		Convey("/capture reports that no capture is running", func() {
+			v := adminJSON(adminReq(h.r, "GET", "/capture", nil))
+			So(jsonKeys(v), ShouldResemble, []string{"Running"})
+		})
+		Convey("Read-only endpoints reject other methods", func() {
+			for _, path := range []string{"/status", "/info", "/conf", "/ifstates", "/links",
+				"/svc", "/pktpool", "/acl", "/capture"} {
+				for _, method := range []string{"POST", "PUT", "DELETE"} {
+					w := adminReq(h.r, method, path, nil)
+					So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+					So(w.Header().Get("Allow"), ShouldEqual, "GET")
+				}
+			}
+		})
+	})
+}
+
+func Test_Admin_Set(t *testing.T) {
+	Convey("The admin API", t, func() {
+		h := newHarness(t, "br1-11-1")
+		Convey("Mutating endpoints reject other methods", func() {
+			for _, path := range []string{"/intf/down", "/intf/up", "/svc/down", "/svc/up",
+				"/capture/start", "/capture/stop"} {
+				for _, method := range []string{"GET", "PUT", "DELETE"} {
+					w := adminReq(h.r, method, path, nil)
+					So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+					So(w.Header().Get("Allow"), ShouldEqual, "POST")
+				}
+			}
+		})
+		Convey("/intf/down and /intf/up set the administrative state", func() {
+			c := conf.Get()
+			defer c.AdminDown.Set(2, false)
+			v := adminJSON(adminReq(h.r, "POST", "/intf/down", url.Values{"ifid": {"2"}}))
+			So(v.(map[string]interface{})["IFID"], ShouldEqual, 2)
+			So(v.(map[string]interface{})["AdminDown"], ShouldBeTrue)
+			So(c.AdminDown.IsDown(2), ShouldBeTrue)
+			v = adminJSON(adminReq(h.r, "POST", "/intf/up", url.Values{"ifid": {"2"}}))
+			So(v.(map[string]interface{})["AdminDown"], ShouldBeFalse)
+			So(c.AdminDown.IsDown(2), ShouldBeFalse)
+		})
+		Convey("/intf/down rejects invalid and unknown interfaces", func() {
+			w := adminReq(h.r, "POST", "/intf/down", url.Values{"ifid": {"x"}})
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			w = adminReq(h.r, "POST", "/intf/down", url.Values{"ifid": {"99"}})
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("/svc/down and /svc/up set the state of a service instance", func() {
+			down := func(v interface{}) bool {
+				for _, inst := range v.([]interface{}) {
+					if inst.(map[string]interface{})["Name"] == "ps1-11-1" {
+						return inst.(map[string]interface{})["Down"].(bool)
+					}
+				}
+				t.Fatalf("ps1-11-1 not found")
+				return false
+			}
+			v := adminJSON(adminReq(h.r, "POST", "/svc/down", url.Values{"name": {"ps1-11-1"}}))
+			So(down(v), ShouldBeTrue)
+			v = adminJSON(adminReq(h.r, "POST", "/svc/up", url.Values{"name": {"ps1-11-1"}}))
+			So(down(v), ShouldBeFalse)
+		})
+		Convey("/svc/down rejects unknown service instances", func() {
+			w := adminReq(h.r, "POST", "/svc/down", url.Values{"name": {"ps1-11-99"}})
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("/capture/start and /capture/stop control the packet capture", func() {
+			dir, err := ioutil.TempDir("", "admin_test")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			oldDir := *captureDir
+			*captureDir = dir
+			defer func() { *captureDir = oldDir }()
+			w := adminReq(h.r, "POST", "/capture/start", url.Values{"maxbytes": {"x"}})
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			v := adminJSON(adminReq(h.r, "POST", "/capture/start", url.Values{}))
+			So(v.(map[string]interface{})["Running"], ShouldBeTrue)
+			v = adminJSON(adminReq(h.r, "POST", "/capture/stop", url.Values{}))
+			So(jsonKeys(v), ShouldResemble, []string{"Running"})
+			So(v.(map[string]interface{})["Running"], ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package anycast selects the instance of a local infrastructure service that
+// packets sent to an anycast SVC address are delivered to.
+//
+// A Resolver combines a Strategy, which picks an instance from the candidates,
+// with the instance Health, which removes unhealthy instances from the
+// candidates. If no instance is healthy, all of them are candidates, as
+// dropping the packet wouldn't help anyone.
+package anycast
+
+import (
+	"net"
+	"time"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/topology"
+)
+
+// Instance is an instance of a local infrastructure service.
+type Instance struct {
+	// Name is the name of the instance in the topology.
+	Name string
+	// IP is the address of the host the instance runs on. Packets for the
+	// instance are sent to the host's dispatcher.
+	IP net.IP
+	// Port is the L4 port of the instance.
+	Port int
+}
+
+// Instances returns the instances of svc in the topology, ordered by name.
+func Instances(tm *topology.TopoMeta, svc addr.HostSVC) []Instance {
+	var names []string
+	var elemMap map[string]topology.BasicElem
+	switch svc.Base() {
+	case addr.SvcBS:
+		names, elemMap = tm.BSNames, tm.T.BS
+	case addr.SvcPS:
+		names, elemMap = tm.PSNames, tm.T.PS
+	case addr.SvcCS:
+		names, elemMap = tm.CSNames, tm.T.CS
+	case addr.SvcSB:
+		names, elemMap = tm.SBNames, tm.T.SB
+	}
+	insts := make([]Instance, 0, len(names))
+	for _, name := range names {
+		elem := elemMap[name]
+		insts = append(insts, Instance{Name: name, IP: elem.Addr.IP, Port: elem.Port})
+	}
+	return insts
+}
+
+// AllInstances returns the instances of all services in the topology.
+func AllInstances(tm *topology.TopoMeta) []Instance {
+	var insts []Instance
+	for _, svc := range []addr.HostSVC{addr.SvcBS, addr.SvcPS, addr.SvcCS, addr.SvcSB} {
+		insts = append(insts, Instances(tm, svc)...)
+	}
+	return insts
+}
+
+// Resolver selects instances for packets sent to anycast SVC addresses.
+type Resolver struct {
+	Strategy Strategy
+	// Health is used to skip unhealthy instances. If it is nil, all instances
+	// are considered healthy.
+	Health *Health
+}
+
+// Resolve selects the instance of svc, out of insts, for a packet from src.
+// insts must not be empty.
+func (r *Resolver) Resolve(svc addr.HostSVC, insts []Instance, src addr.HostAddr,
+	now time.Time) Instance {
+	cands := insts
+	if r.Health != nil {
+		cands = make([]Instance, 0, len(insts))
+		for _, inst := range insts {
+			if r.Health.Healthy(inst.Name, now) {
+				cands = append(cands, inst)
+			}
+		}
+		if len(cands) == 0 {
+			cands = insts
+		}
+	}
+	inst := r.Strategy.Select(svc.Base(), cands, src)
+	if r.Health != nil {
+		r.Health.delivered(inst.Name)
+	}
+	return inst
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anycast
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+)
+
+func mkInsts(n int) []Instance {
+	insts := make([]Instance, n)
+	for i := range insts {
+		insts[i] = Instance{Name: fmt.Sprintf("ps1-11-%d", i+1), IP: net.IPv4(127, 0, 0, byte(i+1))}
+	}
+	return insts
+}
+
+func srcHost(i int) addr.HostAddr {
+	return addr.HostFromIP(net.IPv4(10, 0, byte(i>>8), byte(i)))
+}
+
+func Test_NewStrategy(t *testing.T) {
+	Convey("Strategies are created by name", t, func() {
+		for _, name := range []string{StrategyRandom, StrategyHash, StrategyRoundRobin} {
+			s, err := NewStrategy(name)
+			So(err, ShouldBeNil)
+			So(s, ShouldNotBeNil)
+		}
+		_, err := NewStrategy("fastest")
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, ErrorStrategy)
+	})
+}
+
+func Test_Hash(t *testing.T) {
+	insts := mkInsts(4)
+	Convey("A source is always mapped to the same instance", t, func() {
+		first := Hash{}.Select(addr.SvcPS, insts, srcHost(1))
+		for i := 0; i < 10; i++ {
+			So(Hash{}.Select(addr.SvcPS, insts, srcHost(1)), ShouldResemble, first)
+		}
+		// The order of the candidates doesn't matter.
+		reversed := []Instance{insts[3], insts[2], insts[1], insts[0]}
+		So(Hash{}.Select(addr.SvcPS, reversed, srcHost(1)), ShouldResemble, first)
+	})
+	Convey("Sources are spread over the instances", t, func() {
+		counts := make(map[string]int)
+		for i := 0; i < 400; i++ {
+			counts[Hash{}.Select(addr.SvcPS, insts, srcHost(i)).Name]++
+		}
+		So(len(counts), ShouldEqual, len(insts))
+		for _, c := range counts {
+			So(c, ShouldBeGreaterThan, 50)
+		}
+	})
+	Convey("Removing an instance only moves the sources mapped to it", t, func() {
+		for i := 0; i < 400; i++ {
+			before := Hash{}.Select(addr.SvcPS, insts, srcHost(i))
+			after := Hash{}.Select(addr.SvcPS, insts[1:], srcHost(i))
+			if before.Name != insts[0].Name {
+				So(after, ShouldResemble, before)
+			}
+		}
+	})
+}
+
+func Test_RoundRobin(t *testing.T) {
+	insts := mkInsts(3)
+	Convey("Instances are selected in turn, per service", t, func() {
+		r := &RoundRobin{}
+		for i := 0; i < 6; i++ {
+			So(r.Select(addr.SvcPS, insts, srcHost(1)), ShouldResemble, insts[i%3])
+			if i%2 == 0 {
+				So(r.Select(addr.SvcCS, insts[:2], srcHost(1)), ShouldResemble, insts[i/2%2])
+			}
+		}
+	})
+}
+
+func Test_Resolver(t *testing.T) {
+	insts := mkInsts(3)
+	now := time.Unix(1500000000, 0)
+	Convey("Unhealthy instances are skipped", t, func() {
+		r := &Resolver{Strategy: &RoundRobin{}, Health: NewHealth(0, insts)}
+		r.Health.SetDown(insts[1].Name, true)
+		for i := 0; i < 4; i++ {
+			So(r.Resolve(addr.SvcPS, insts, srcHost(1), now).Name, ShouldNotEqual, insts[1].Name)
+		}
+		Convey("unless all instances are unhealthy", func() {
+			r.Health.SetDown(insts[0].Name, true)
+			r.Health.SetDown(insts[2].Name, true)
+			seen := make(map[string]bool)
+			for i := 0; i < 3; i++ {
+				seen[r.Resolve(addr.SvcPS, insts, srcHost(1), now).Name] = true
+			}
+			So(len(seen), ShouldEqual, 3)
+		})
+	})
+	Convey("Without health, all instances are candidates", t, func() {
+		r := &Resolver{Strategy: &RoundRobin{}}
+		for i := 0; i < 3; i++ {
+			So(r.Resolve(addr.SvcPS, insts, srcHost(1), now), ShouldResemble, insts[i])
+		}
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anycast
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health tracks the health of service instances. An instance is unhealthy if
+// it has been marked down (e.g. via the router's admin API), or if Timeout is
+// set and it has been heard from before, but not within Timeout. Instances are
+// heard from whenever the router receives a valid packet whose source host
+// address and L4 source port are those of the instance, so the timeout suits
+// instances that regularly send packets via the router, e.g. beacon servers.
+// Instances that have never been heard from are healthy, as there is nothing
+// to go on.
+//
+// Seen and Healthy are lock free, as they are called for every packet from the
+// local AS and to an anycast SVC address respectively.
+type Health struct {
+	// Timeout is how long an instance may be silent before it is unhealthy.
+	// 0 disables the timeout.
+	Timeout time.Duration
+	// mu serializes updates of state.
+	mu sync.Mutex
+	// state holds the current *healthState. It is replaced on Update.
+	state atomic.Value
+}
+
+type healthState struct {
+	insts map[string]*instState
+	// addrs maps instance addresses to the last time a packet was received
+	// from them, in Unix nanoseconds.
+	addrs map[instAddr]*int64
+}
+
+type instState struct {
+	Instance
+	down       int32
+	deliveries uint64
+}
+
+// InstanceStats is a snapshot of the health of an instance.
+type InstanceStats struct {
+	Instance
+	Healthy bool
+	// Down is set if the instance has been marked down.
+	Down bool
+	// LastSeen is the last time a packet was received from the instance. It
+	// is zero if the instance hasn't been heard from.
+	LastSeen time.Time
+	// Deliveries is the number of packets delivered to the instance.
+	Deliveries uint64
+}
+
+// NewHealth creates a Health for the given instances.
+func NewHealth(timeout time.Duration, insts []Instance) *Health {
+	h := &Health{Timeout: timeout}
+	h.Update(insts)
+	return h
+}
+
+// Update replaces the tracked instances, e.g. after a topology change. The
+// state of instances (and addresses) that are still present is kept.
+func (h *Health) Update(insts []Instance) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old := h.get()
+	s := &healthState{insts: make(map[string]*instState), addrs: make(map[instAddr]*int64)}
+	for _, inst := range insts {
+		is := &instState{Instance: inst}
+		if o, ok := old.insts[inst.Name]; ok {
+			is.down = atomic.LoadInt32(&o.down)
+			is.deliveries = atomic.LoadUint64(&o.deliveries)
+		}
+		s.insts[inst.Name] = is
+		key := newInstAddr(inst.IP, inst.Port)
+		if _, ok := s.addrs[key]; ok {
+			continue
+		}
+		lastSeen := new(int64)
+		if o, ok := old.addrs[key]; ok {
+			*lastSeen = atomic.LoadInt64(o)
+		}
+		s.addrs[key] = lastSeen
+	}
+	h.state.Store(s)
+}
+
+// Seen records that a packet from port on host ip was received at time now.
+func (h *Health) Seen(ip net.IP, port int, now time.Time) {
+	if h.Timeout == 0 {
+		return
+	}
+	if lastSeen, ok := h.get().addrs[newInstAddr(ip, port)]; ok {
+		atomic.StoreInt64(lastSeen, now.UnixNano())
+	}
+}
+
+// SetDown marks the named instance as down (or up, if down is false). It
+// returns false if the instance is unknown.
+func (h *Health) SetDown(name string, down bool) bool {
+	is, ok := h.get().insts[name]
+	if !ok {
+		return false
+	}
+	var v int32
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&is.down, v)
+	return true
+}
+
+// Healthy returns true if the named instance is healthy at time now. Unknown
+// instances are healthy.
+func (h *Health) Healthy(name string, now time.Time) bool {
+	s := h.get()
+	is, ok := s.insts[name]
+	if !ok {
+		return true
+	}
+	return s.healthy(is, h.Timeout, now)
+}
+
+// Stats returns the state of all instances at time now, ordered by name.
+func (h *Health) Stats(now time.Time) []InstanceStats {
+	s := h.get()
+	stats := make([]InstanceStats, 0, len(s.insts))
+	for _, is := range s.insts {
+		st := InstanceStats{
+			Instance:   is.Instance,
+			Healthy:    s.healthy(is, h.Timeout, now),
+			Down:       atomic.LoadInt32(&is.down) != 0,
+			Deliveries: atomic.LoadUint64(&is.deliveries),
+		}
+		if ns := s.lastSeen(is); ns != 0 {
+			st.LastSeen = time.Unix(0, ns)
+		}
+		stats = append(stats, st)
+	}
+	sort.Sort(statsByName(stats))
+	return stats
+}
+
+type statsByName []InstanceStats
+
+func (s statsByName) Len() int           { return len(s) }
+func (s statsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s statsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// delivered counts a packet delivered to the named instance.
+func (h *Health) delivered(name string) {
+	if is, ok := h.get().insts[name]; ok {
+		atomic.AddUint64(&is.deliveries, 1)
+	}
+}
+
+func (h *Health) get() *healthState {
+	s, _ := h.state.Load().(*healthState)
+	if s == nil {
+		return &healthState{}
+	}
+	return s
+}
+
+func (s *healthState) healthy(is *instState, timeout time.Duration, now time.Time) bool {
+	if atomic.LoadInt32(&is.down) != 0 {
+		return false
+	}
+	if timeout == 0 {
+		return true
+	}
+	ns := s.lastSeen(is)
+	return ns == 0 || now.Sub(time.Unix(0, ns)) <= timeout
+}
+
+func (s *healthState) lastSeen(is *instState) int64 {
+	if lastSeen, ok := s.addrs[newInstAddr(is.IP, is.Port)]; ok {
+		return atomic.LoadInt64(lastSeen)
+	}
+	return 0
+}
+
+// instAddr is the address of an instance, used as a map key.
+type instAddr struct {
+	// ip is the IP address in 16 byte form.
+	ip   [net.IPv6len]byte
+	port int
+}
+
+func newInstAddr(ip net.IP, port int) instAddr {
+	a := instAddr{port: port}
+	copy(a.ip[:], ip.To16())
+	return a
+}
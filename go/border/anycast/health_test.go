@@ -0,0 +1,84 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anycast
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Health(t *testing.T) {
+	insts := []Instance{
+		{Name: "bs1-11-1", IP: net.IPv4(127, 0, 0, 65), Port: 30041},
+		{Name: "ps1-11-1", IP: net.IPv4(127, 0, 0, 65), Port: 30042},
+		{Name: "ps1-11-2", IP: net.IPv4(127, 0, 0, 66), Port: 30042},
+	}
+	now := time.Unix(1500000000, 0)
+	Convey("Instances that haven't been heard from are healthy", t, func() {
+		h := NewHealth(time.Second, insts)
+		So(h.Healthy("bs1-11-1", now), ShouldBeTrue)
+		So(h.Healthy("unknown", now), ShouldBeTrue)
+		Convey("Instances are unhealthy once they have been silent for too long", func() {
+			h.Seen(net.IPv4(127, 0, 0, 65), 30041, now)
+			h.Seen(net.IPv4(127, 0, 0, 65), 30042, now)
+			So(h.Healthy("bs1-11-1", now.Add(time.Second)), ShouldBeTrue)
+			So(h.Healthy("bs1-11-1", now.Add(time.Second+1)), ShouldBeFalse)
+			So(h.Healthy("ps1-11-1", now.Add(time.Second+1)), ShouldBeFalse)
+			So(h.Healthy("ps1-11-2", now.Add(time.Second+1)), ShouldBeTrue)
+			h.Seen(net.IPv4(127, 0, 0, 65), 30041, now.Add(time.Second))
+			So(h.Healthy("bs1-11-1", now.Add(time.Second+1)), ShouldBeTrue)
+			Convey("independently of other instances on the same host", func() {
+				So(h.Healthy("ps1-11-1", now.Add(time.Second+1)), ShouldBeFalse)
+				h.Seen(net.IPv4(127, 0, 0, 65), 1000, now.Add(time.Second))
+				So(h.Healthy("ps1-11-1", now.Add(time.Second+1)), ShouldBeFalse)
+			})
+		})
+		Convey("Instances marked down are unhealthy", func() {
+			So(h.SetDown("ps1-11-2", true), ShouldBeTrue)
+			So(h.Healthy("ps1-11-2", now), ShouldBeFalse)
+			So(h.SetDown("ps1-11-2", false), ShouldBeTrue)
+			So(h.Healthy("ps1-11-2", now), ShouldBeTrue)
+			So(h.SetDown("unknown", true), ShouldBeFalse)
+		})
+	})
+	Convey("Without a timeout, hosts aren't tracked", t, func() {
+		h := NewHealth(0, insts)
+		h.Seen(net.IPv4(127, 0, 0, 65), 30041, now)
+		So(h.Healthy("bs1-11-1", now.Add(time.Hour)), ShouldBeTrue)
+		So(h.Stats(now)[0].LastSeen.IsZero(), ShouldBeTrue)
+	})
+	Convey("State is kept across updates", t, func() {
+		h := NewHealth(time.Second, insts)
+		h.Seen(net.IPv4(127, 0, 0, 65), 30042, now)
+		h.SetDown("ps1-11-2", true)
+		h.delivered("ps1-11-2")
+		h.Update(append(insts[1:],
+			Instance{Name: "ps1-11-3", IP: net.IPv4(127, 0, 0, 67), Port: 30042}))
+		stats := h.Stats(now)
+		So(len(stats), ShouldEqual, 3)
+		So(stats[0].Name, ShouldEqual, "ps1-11-1")
+		So(stats[0].LastSeen, ShouldResemble, now)
+		So(stats[0].Healthy, ShouldBeTrue)
+		So(stats[1].Name, ShouldEqual, "ps1-11-2")
+		So(stats[1].Down, ShouldBeTrue)
+		So(stats[1].Healthy, ShouldBeFalse)
+		So(stats[1].Deliveries, ShouldEqual, 1)
+		So(stats[2].Name, ShouldEqual, "ps1-11-3")
+		So(stats[2].LastSeen.IsZero(), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anycast
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+// Names of the available strategies, see NewStrategy.
+const (
+	StrategyRandom     = "random"
+	StrategyHash       = "hash"
+	StrategyRoundRobin = "round-robin"
+)
+
+const ErrorStrategy = "Unknown anycast strategy"
+
+// Strategy selects one of the instances of a service. Implementations must be
+// safe for concurrent use.
+type Strategy interface {
+	// Select returns one of insts, which is never empty, for a packet from
+	// src to the (base) SVC address svc.
+	Select(svc addr.HostSVC, insts []Instance, src addr.HostAddr) Instance
+}
+
+// NewStrategy returns the strategy with the given name:
+//   - random: a random instance for every packet.
+//   - hash: the same instance for all packets from a source host, for as long
+//     as the instance is a candidate. This uses rendezvous hashing, so when an
+//     instance is added or removed, only the sources mapped to it move. As it
+//     only depends on the source and instance names, all routers of an AS
+//     select the same instance for a source.
+//   - round-robin: the instances in turn, per service.
+func NewStrategy(name string) (Strategy, *common.Error) {
+	switch name {
+	case StrategyRandom:
+		return Random{}, nil
+	case StrategyHash:
+		return Hash{}, nil
+	case StrategyRoundRobin:
+		return &RoundRobin{}, nil
+	}
+	return nil, common.NewError(ErrorStrategy, "name", name)
+}
+
+// Random selects a random instance.
+type Random struct{}
+
+func (Random) Select(svc addr.HostSVC, insts []Instance, src addr.HostAddr) Instance {
+	return insts[rand.Intn(len(insts))]
+}
+
+// Hash selects the instance with the highest hash of the source host and the
+// instance name.
+type Hash struct{}
+
+func (Hash) Select(svc addr.HostSVC, insts []Instance, src addr.HostAddr) Instance {
+	var srcRaw common.RawBytes
+	if src != nil {
+		srcRaw = src.Pack()
+	}
+	best, bestHash := 0, uint64(0)
+	for i, inst := range insts {
+		h := fnv.New64a()
+		h.Write(srcRaw)
+		h.Write([]byte(inst.Name))
+		if sum := mix(h.Sum64()); i == 0 || sum > bestHash {
+			best, bestHash = i, sum
+		}
+	}
+	return insts[best]
+}
+
+// mix is the 64 bit finalizer of MurmurHash3. FNV alone spreads inputs that
+// only differ in their last bytes (like instance names) poorly.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// RoundRobin selects the instances of each service in turn.
+type RoundRobin struct {
+	mu   sync.Mutex
+	next map[addr.HostSVC]int
+}
+
+func (r *RoundRobin) Select(svc addr.HostSVC, insts []Instance, src addr.HostAddr) Instance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next == nil {
+		r.next = make(map[addr.HostSVC]int)
+	}
+	i := r.next[svc] % len(insts)
+	r.next[svc] = i + 1
+	return insts[i]
+}
@@ -0,0 +1,351 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capture handles writing copies of packets handled by the router to
+// pcapng files, for debugging.
+//
+// A capture is started with Start, and stopped with Stop. While a capture is
+// running, Get returns it, and packets are passed to its Packet method. Each
+// socket (identified by its metrics label, e.g. "intf:1") and direction is
+// written to a separate file, as is each packet dropped by the router. Files
+// are rotated once they reach a size limit, keeping a limited number of old
+// files.
+//
+// Packet only copies the packet into a bounded queue, so that the router's
+// forwarding path never waits for a file to be written. The files are written
+// by a separate goroutine, and packets that don't fit in the queue are counted
+// as dropped instead of being captured.
+package capture
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+// Dir is the direction of a captured packet.
+type Dir int
+
+const (
+	// DirIn is for packets received by the router.
+	DirIn Dir = iota
+	// DirOut is for packets sent by the router.
+	DirOut
+	// DirDrop is for packets dropped by the router.
+	DirDrop
+)
+
+func (d Dir) String() string {
+	switch d {
+	case DirIn:
+		return "in"
+	case DirOut:
+		return "out"
+	case DirDrop:
+		return "drop"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int(d))
+}
+
+// DropID is the socket ID used for the capture file of dropped packets.
+const DropID = "drop"
+
+// Config describes a capture.
+type Config struct {
+	// Filter is a filter expression, see ParseFilter. Empty captures all
+	// packets.
+	Filter string
+	// MaxBytes is the maximum size of a single capture file, after which it
+	// is rotated. 0 means no limit.
+	MaxBytes int64
+	// MaxFiles is the maximum number of files kept per socket and direction,
+	// including the current one. Values below 1 are treated as 1.
+	MaxFiles int
+	// QueueLen is the maximum number of packets waiting to be written. Values
+	// below 1 mean DefQueueLen.
+	QueueLen int
+}
+
+// DefQueueLen is the default maximum number of packets waiting to be written.
+const DefQueueLen = 1024
+
+// Capture is a running capture.
+type Capture struct {
+	// Packets is the number of packets captured so far. It must be accessed
+	// atomically, and is the first field to ensure 64-bit alignment.
+	Packets uint64
+	// Dropped is the number of packets not captured because the queue was
+	// full. It must be accessed atomically.
+	Dropped uint64
+	Config
+	// Dir is the directory the capture files are written to.
+	Dir string
+	// Prefix is prepended to the name of each capture file.
+	Prefix  string
+	Started time.Time
+	filter  *Filter
+	// q holds the packets waiting to be written by run.
+	q chan *capPkt
+	// stop is closed to make run return, after which done is closed.
+	stop chan struct{}
+	done chan struct{}
+	// closed is set (atomically) once the capture is being stopped.
+	closed int32
+	// mu protects files, which is only modified by run.
+	mu    sync.Mutex
+	files map[fileKey]*ringFile
+}
+
+// capPkt is a copy of a packet waiting to be written.
+type capPkt struct {
+	key      fileKey
+	ts       time.Time
+	raw      common.RawBytes
+	src, dst *net.UDPAddr
+	comment  string
+}
+
+type fileKey struct {
+	id  string
+	dir Dir
+}
+
+// curr holds a pointer to the running capture, if any.
+var curr atomic.Value
+
+// startLock serializes starting and stopping captures.
+var startLock sync.Mutex
+
+// Get returns the running capture, or nil if there is none.
+func Get() *Capture {
+	c, _ := curr.Load().(*Capture)
+	return c
+}
+
+// Start starts a new capture, writing files to the given directory. Any
+// running capture is stopped first.
+func Start(dir, prefix string, cfg Config) (*Capture, *common.Error) {
+	filter, err := ParseFilter(cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxFiles < 1 {
+		cfg.MaxFiles = 1
+	}
+	if cfg.QueueLen < 1 {
+		cfg.QueueLen = DefQueueLen
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, common.NewError("Unable to create capture directory", "dir", dir, "err", err)
+	}
+	c := &Capture{Config: cfg, Dir: dir, Prefix: prefix, Started: time.Now(),
+		filter: filter, q: make(chan *capPkt, cfg.QueueLen), stop: make(chan struct{}),
+		done: make(chan struct{}), files: make(map[fileKey]*ringFile)}
+	startLock.Lock()
+	defer startLock.Unlock()
+	stop()
+	go c.run()
+	curr.Store(c)
+	log.Info("Packet capture started", "dir", dir, "filter", cfg.Filter,
+		"maxBytes", cfg.MaxBytes, "maxFiles", cfg.MaxFiles)
+	return c, nil
+}
+
+// Stop stops the running capture, if any.
+func Stop() {
+	startLock.Lock()
+	defer startLock.Unlock()
+	stop()
+}
+
+func stop() {
+	c := Get()
+	if c == nil {
+		return
+	}
+	curr.Store((*Capture)(nil))
+	c.close()
+	log.Info("Packet capture stopped", "packets", atomic.LoadUint64(&c.Packets),
+		"dropped", atomic.LoadUint64(&c.Dropped))
+}
+
+// Packet captures a raw SCION packet, if it matches the capture filter. id
+// identifies the socket the packet was received or sent on (or is DropID for
+// dropped packets), and src/dst are the packet's overlay addresses. comment
+// is added to the captured packet (e.g. the reason a packet was dropped).
+// The packet is copied, and written asynchronously.
+func (c *Capture) Packet(id string, dir Dir, raw common.RawBytes, src, dst *net.UDPAddr,
+	comment string) {
+	if atomic.LoadInt32(&c.closed) != 0 || !c.filter.Match(raw) {
+		return
+	}
+	p := &capPkt{key: fileKey{id, dir}, ts: time.Now(),
+		raw: append(common.RawBytes(nil), raw...), src: src, dst: dst, comment: comment}
+	select {
+	case c.q <- p:
+	default:
+		atomic.AddUint64(&c.Dropped, 1)
+	}
+}
+
+// run writes queued packets until the capture is stopped. Buffered data is
+// flushed to the files whenever the queue is empty.
+func (c *Capture) run() {
+	defer close(c.done)
+	for {
+		select {
+		case p := <-c.q:
+			c.write(p)
+			if len(c.q) == 0 {
+				c.flush()
+			}
+		case <-c.stop:
+			// Write the packets queued before the capture was stopped.
+			for {
+				select {
+				case p := <-c.q:
+					c.write(p)
+				default:
+					c.closeFiles()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Capture) write(p *capPkt) {
+	data, err := mkIPUDP(p.src, p.dst, p.raw)
+	if err != nil {
+		log.Debug("Unable to capture packet", "id", p.key.id, "dir", p.key.dir, "err", err)
+		return
+	}
+	flags := uint32(epbFlagInbound)
+	if p.key.dir == DirOut {
+		flags = epbFlagOutbound
+	}
+	block := mkEPB(p.ts, data, flags, p.comment)
+	rf, ok := c.files[p.key]
+	if !ok {
+		rf = &ringFile{path: c.path(p.key), ifName: p.key.id, maxBytes: c.MaxBytes,
+			maxFiles: c.MaxFiles}
+		c.mu.Lock()
+		c.files[p.key] = rf
+		c.mu.Unlock()
+	}
+	if err := rf.write(block); err != nil {
+		log.Error("Unable to write captured packet", err.Ctx...)
+		return
+	}
+	atomic.AddUint64(&c.Packets, 1)
+}
+
+func (c *Capture) flush() {
+	for _, rf := range c.files {
+		if err := rf.flush(); err != nil {
+			log.Error("Unable to write captured packets", err.Ctx...)
+		}
+	}
+}
+
+// Files returns the paths of the current capture files.
+func (c *Capture) Files() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var paths []string
+	for _, rf := range c.files {
+		paths = append(paths, rf.path)
+	}
+	return paths
+}
+
+func (c *Capture) path(key fileKey) string {
+	name := fmt.Sprintf("%s_%s_%s.pcapng", c.Prefix, strings.Replace(key.id, ":", "-", -1),
+		key.dir)
+	if key.id == DropID {
+		name = fmt.Sprintf("%s_%s.pcapng", c.Prefix, DropID)
+	}
+	return filepath.Join(c.Dir, name)
+}
+
+// close stops the capture, and waits for the queued packets to be written.
+func (c *Capture) close() {
+	atomic.StoreInt32(&c.closed, 1)
+	close(c.stop)
+	<-c.done
+}
+
+func (c *Capture) closeFiles() {
+	for _, rf := range c.files {
+		rf.close()
+	}
+}
+
+// ringFile is a capture file that is rotated once it reaches maxBytes, with
+// old files being renamed to <path>.1, <path>.2, etc, up to maxFiles-1.
+type ringFile struct {
+	path     string
+	ifName   string
+	maxBytes int64
+	maxFiles int
+	w        *pcapWriter
+}
+
+func (rf *ringFile) write(block []byte) *common.Error {
+	if rf.w != nil && rf.maxBytes > 0 && rf.w.size+int64(len(block)) > rf.maxBytes {
+		rf.rotate()
+	}
+	if rf.w == nil {
+		w, err := newPcapWriter(rf.path, rf.ifName)
+		if err != nil {
+			return err
+		}
+		rf.w = w
+	}
+	return rf.w.write(block)
+}
+
+func (rf *ringFile) flush() *common.Error {
+	if rf.w == nil {
+		return nil
+	}
+	return rf.w.flush()
+}
+
+func (rf *ringFile) rotate() {
+	rf.close()
+	for i := rf.maxFiles - 1; i > 0; i-- {
+		src := rf.path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", rf.path, i-1)
+		}
+		os.Rename(src, fmt.Sprintf("%s.%d", rf.path, i))
+	}
+}
+
+func (rf *ringFile) close() {
+	if rf.w != nil {
+		rf.w.Close()
+		rf.w = nil
+	}
+}
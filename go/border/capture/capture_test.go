@@ -0,0 +1,166 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+)
+
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+// readBlocks splits a pcapng file into blocks.
+func readBlocks(t *testing.T, path string) []block {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading capture file: %v", err)
+	}
+	var blocks []block
+	for len(b) > 0 {
+		l := order.Uint32(b[4:])
+		if l < 12 || int(l) > len(b) || order.Uint32(b[l-4:]) != l {
+			t.Fatalf("Invalid block length %d", l)
+		}
+		blocks = append(blocks, block{order.Uint32(b), b[8 : l-4]})
+		b = b[l:]
+	}
+	return blocks
+}
+
+// epbOpts returns the options of an Enhanced Packet Block, by code.
+func epbOpts(b block) map[uint16][]byte {
+	dataLen := int(order.Uint32(b.body[12:]))
+	opts := b.body[20+dataLen+(4-dataLen%4)%4:]
+	m := make(map[uint16][]byte)
+	for len(opts) >= 4 && order.Uint16(opts) != optEndOfOpt {
+		l := int(order.Uint16(opts[2:]))
+		m[order.Uint16(opts)] = opts[4 : 4+l]
+		opts = opts[4+l+(4-l%4)%4:]
+	}
+	return m
+}
+
+func Test_Capture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ia11 := &addr.ISD_AS{I: 1, A: 11}
+	ia12 := &addr.ISD_AS{I: 1, A: 12}
+	raw := mkRawPkt(ia11, ia12, common.L4UDP, false, 0)
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+	dst := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 50001}
+	Convey("Captured packets are written per socket and direction", t, func() {
+		c, cerr := Start(dir, "br1-11-1", Config{Filter: "src ia 1-11"})
+		So(cerr, ShouldBeNil)
+		So(Get(), ShouldEqual, c)
+		c.Packet("intf:1", DirIn, raw, src, dst, "")
+		c.Packet("intf:1", DirIn, mkRawPkt(ia12, ia11, common.L4SCMP, false, scmp.C_Path),
+			src, dst, "")
+		c.Packet("intf:1", DirOut, raw, dst, src, "")
+		c.Packet(DropID, DirDrop, raw, src, dst, "Error routing packet")
+		Stop()
+		So(Get(), ShouldBeNil)
+		So(c.Packets, ShouldEqual, 3)
+		in := readBlocks(t, filepath.Join(dir, "br1-11-1_intf-1_in.pcapng"))
+		So(len(in), ShouldEqual, 3)
+		So(in[0].typ, ShouldEqual, blockSHB)
+		So(in[1].typ, ShouldEqual, blockIDB)
+		So(order.Uint16(in[1].body), ShouldEqual, linkTypeRaw)
+		So(in[2].typ, ShouldEqual, blockEPB)
+		Convey("With synthetic IP/UDP headers", func() {
+			data := in[2].body[20 : 20+order.Uint32(in[2].body[12:])]
+			So(len(data), ShouldEqual, ipv4HdrLen+udpHdrLen+len(raw))
+			So(net.IP(data[12:16]).Equal(src.IP), ShouldBeTrue)
+			So(net.IP(data[16:20]).Equal(dst.IP), ShouldBeTrue)
+			So(checksum(0, data[:ipv4HdrLen]), ShouldEqual, 0xFFFF)
+			So([]byte(data[ipv4HdrLen+udpHdrLen:]), ShouldResemble, []byte(raw))
+			So(order.Uint32(epbOpts(in[2])[optEPBFlags]), ShouldEqual, epbFlagInbound)
+		})
+		out := readBlocks(t, filepath.Join(dir, "br1-11-1_intf-1_out.pcapng"))
+		So(len(out), ShouldEqual, 3)
+		So(order.Uint32(epbOpts(out[2])[optEPBFlags]), ShouldEqual, epbFlagOutbound)
+		Convey("Dropped packets are annotated", func() {
+			drop := readBlocks(t, filepath.Join(dir, "br1-11-1_drop.pcapng"))
+			So(len(drop), ShouldEqual, 3)
+			So(string(epbOpts(drop[2])[optComment]), ShouldEqual, "Error routing packet")
+		})
+	})
+	Convey("Capture files are rotated", t, func() {
+		hdrLen := int64(len(mkSHB()) + len(mkIDB("intf:2")))
+		data, _ := mkIPUDP(src, dst, raw)
+		pktLen := int64(len(mkEPB(time.Time{}, data, epbFlagInbound, "")))
+		c, cerr := Start(dir, "rot", Config{MaxBytes: hdrLen + 2*pktLen, MaxFiles: 3})
+		So(cerr, ShouldBeNil)
+		for i := 0; i < 7; i++ {
+			c.Packet("intf:2", DirIn, raw, src, dst, "")
+		}
+		Stop()
+		path := filepath.Join(dir, "rot_intf-2_in.pcapng")
+		So(len(readBlocks(t, path)), ShouldEqual, 3)
+		So(len(readBlocks(t, path+".1")), ShouldEqual, 4)
+		So(len(readBlocks(t, path+".2")), ShouldEqual, 4)
+		_, err := os.Stat(path + ".3")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+	Convey("IPv6 overlay addresses are supported", t, func() {
+		src6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50000}
+		dst6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 50001}
+		data, err := mkIPUDP(src6, dst6, raw)
+		So(err, ShouldBeNil)
+		So(len(data), ShouldEqual, ipv6HdrLen+udpHdrLen+len(raw))
+		So(data[0]>>4, ShouldEqual, 6)
+		sum := pseudoSum(src6.IP, dst6.IP, len(raw))
+		So(checksum(sum, data[ipv6HdrLen:]), ShouldEqual, 0xFFFF)
+		_, err = mkIPUDP(src, dst6, raw)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Packets that don't fit in the queue are dropped", t, func() {
+		c, cerr := Start(dir, "queue", Config{QueueLen: 1})
+		So(cerr, ShouldBeNil)
+		const n = 1000
+		for i := 0; i < n; i++ {
+			c.Packet("intf:3", DirIn, raw, src, dst, "")
+		}
+		Stop()
+		So(c.Dropped, ShouldBeGreaterThan, 0)
+		So(c.Packets+c.Dropped, ShouldEqual, n)
+		blocks := readBlocks(t, filepath.Join(dir, "queue_intf-3_in.pcapng"))
+		So(len(blocks), ShouldEqual, 2+c.Packets)
+		Convey("and packets aren't queued once the capture is stopped", func() {
+			c.Packet("intf:3", DirIn, raw, src, dst, "")
+			So(c.Packets+c.Dropped, ShouldEqual, n)
+		})
+	})
+	Convey("Invalid filters are rejected", t, func() {
+		_, cerr := Start(dir, "bad", Config{Filter: "l4"})
+		So(cerr, ShouldNotBeNil)
+		So(Get(), ShouldBeNil)
+	})
+}
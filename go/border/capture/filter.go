@@ -0,0 +1,307 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file handles capture filters. A filter is an expression over SCION
+// header fields, in the style of BPF filter expressions:
+//
+//   expr  := term { "or" term }
+//   term  := factor { "and" factor }
+//   factor:= "not" factor | "(" expr ")" | prim
+//   prim  := "src" "ia" IA | "dst" "ia" IA | "ia" IA | "l4" PROTO |
+//            "scmp" "class" CLASS
+//
+// IA is an ISD-AS (e.g. 1-11). PROTO is one of scmp, tcp, udp, ssp, or a
+// protocol number. CLASS is an SCMP class name (e.g. path) or number. For
+// example:
+//
+//   src ia 1-11 and not (l4 udp or scmp class general)
+
+package capture
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+const ErrorFilter = "Invalid capture filter"
+
+// Filter is a compiled capture filter.
+type Filter struct {
+	expr string
+	// root is nil for an empty filter.
+	root matcher
+}
+
+// ParseFilter compiles a filter expression. An empty expression matches all
+// packets.
+func ParseFilter(expr string) (*Filter, *common.Error) {
+	p := &filterParser{toks: tokenize(expr)}
+	f := &Filter{expr: expr}
+	if len(p.toks) == 0 {
+		return f, nil
+	}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, common.NewError(ErrorFilter, "expr", expr, "err", err)
+	}
+	if !p.done() {
+		return nil, common.NewError(ErrorFilter, "expr", expr,
+			"err", fmt.Sprintf("unexpected %q", p.peek()))
+	}
+	f.root = root
+	return f, nil
+}
+
+// Match returns true if the raw SCION packet matches the filter. Packets that
+// can't be parsed far enough to evaluate the filter don't match, unless the
+// filter is empty.
+func (f *Filter) Match(raw common.RawBytes) bool {
+	if f.root == nil {
+		return true
+	}
+	fields := extractFields(raw)
+	return fields.complete && f.root(fields)
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// pktFields contains the packet fields that filters can match on. Fields that
+// couldn't be parsed are nil, in which case complete is false.
+type pktFields struct {
+	srcIA, dstIA *addr.ISD_AS
+	l4           *common.L4ProtocolType
+	// scmpClass is only set for SCMP packets.
+	scmpClass *scmp.Class
+	complete  bool
+}
+
+// extractFields parses the fields used by filters from a raw SCION packet,
+// without assuming that the packet is valid.
+func extractFields(raw common.RawBytes) *pktFields {
+	f := &pktFields{}
+	if len(raw) < spkt.CmnHdrLen+2*addr.IABytes {
+		return f
+	}
+	f.dstIA = addr.IAFromRaw(raw[spkt.CmnHdrLen:])
+	f.srcIA = addr.IAFromRaw(raw[spkt.CmnHdrLen+addr.IABytes:])
+	// Walk the extension header chain to find the L4 protocol.
+	w := spkt.NewExtnWalker(raw, common.L4ProtocolType(raw[7]), int(raw[4]))
+	for !w.Done() {
+		if _, err := w.Next(); err != nil {
+			return f
+		}
+	}
+	nextHdr, offset := w.NextHdr(), w.Offset()
+	f.l4 = &nextHdr
+	if nextHdr == common.L4SCMP {
+		if offset+2 > len(raw) {
+			return f
+		}
+		class := scmp.Class(common.Order.Uint16(raw[offset:]))
+		f.scmpClass = &class
+	}
+	f.complete = true
+	return f
+}
+
+type matcher func(*pktFields) bool
+
+type filterParser struct {
+	toks []string
+	pos  int
+}
+
+func tokenize(expr string) []string {
+	expr = strings.Replace(expr, "(", " ( ", -1)
+	expr = strings.Replace(expr, ")", " ) ", -1)
+	return strings.Fields(strings.ToLower(expr))
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.toks)
+}
+
+func (p *filterParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.toks[p.pos]
+}
+
+func (p *filterParser) next() (string, error) {
+	if p.done() {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	return p.toks[p.pos-1], nil
+}
+
+func (p *filterParser) expect(tok string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t != tok {
+		return fmt.Errorf("expected %q, got %q", tok, t)
+	}
+	return nil
+}
+
+func (p *filterParser) parseExpr() (matcher, error) {
+	m, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.pos++
+		r, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		l := m
+		m = func(f *pktFields) bool { return l(f) || r(f) }
+	}
+	return m, nil
+}
+
+func (p *filterParser) parseTerm() (matcher, error) {
+	m, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.pos++
+		r, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		l := m
+		m = func(f *pktFields) bool { return l(f) && r(f) }
+	}
+	return m, nil
+}
+
+func (p *filterParser) parseFactor() (matcher, error) {
+	switch p.peek() {
+	case "not":
+		p.pos++
+		m, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *pktFields) bool { return !m(f) }, nil
+	case "(":
+		p.pos++
+		m, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return p.parsePrim()
+}
+
+func (p *filterParser) parsePrim() (matcher, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case "src", "dst":
+		if err := p.expect("ia"); err != nil {
+			return nil, err
+		}
+		ia, err := p.parseIA()
+		if err != nil {
+			return nil, err
+		}
+		if t == "src" {
+			return func(f *pktFields) bool { return iaEq(ia, f.srcIA) }, nil
+		}
+		return func(f *pktFields) bool { return iaEq(ia, f.dstIA) }, nil
+	case "ia":
+		ia, err := p.parseIA()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *pktFields) bool { return iaEq(ia, f.srcIA) || iaEq(ia, f.dstIA) }, nil
+	case "l4":
+		proto, err := p.parseL4()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *pktFields) bool { return f.l4 != nil && *f.l4 == proto }, nil
+	case "scmp":
+		if err := p.expect("class"); err != nil {
+			return nil, err
+		}
+		class, err := p.parseSCMPClass()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *pktFields) bool { return f.scmpClass != nil && *f.scmpClass == class }, nil
+	}
+	return nil, fmt.Errorf("unknown primitive %q", t)
+}
+
+func (p *filterParser) parseIA() (*addr.ISD_AS, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	return addr.IAFromString(t)
+}
+
+// iaEq compares a filter ISD-AS with a (possibly missing) packet ISD-AS.
+func iaEq(ia, pktIA *addr.ISD_AS) bool {
+	return pktIA != nil && ia.Eq(pktIA)
+}
+
+var l4Names = map[string]common.L4ProtocolType{
+	"scmp": common.L4SCMP, "tcp": common.L4TCP, "udp": common.L4UDP, "ssp": common.L4SSP,
+}
+
+func (p *filterParser) parseL4() (common.L4ProtocolType, error) {
+	t, err := p.next()
+	if err != nil {
+		return 0, err
+	}
+	if proto, ok := l4Names[t]; ok {
+		return proto, nil
+	}
+	n, err := strconv.ParseUint(t, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown L4 protocol %q", t)
+	}
+	return common.L4ProtocolType(n), nil
+}
+
+func (p *filterParser) parseSCMPClass() (scmp.Class, error) {
+	t, err := p.next()
+	if err != nil {
+		return 0, err
+	}
+	return scmp.ClassFromString(t)
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+// mkRawPkt creates the start of a SCION packet with IPv4 host addresses and
+// no path. If hbh is set, a hop-by-hop extension precedes the L4 header.
+func mkRawPkt(src, dst *addr.ISD_AS, l4 common.L4ProtocolType, hbh bool,
+	class scmp.Class) common.RawBytes {
+	hdrLen := spkt.CmnHdrLen + 2*addr.IABytes + 2*addr.HostLenIPv4
+	raw := make(common.RawBytes, hdrLen+common.LineLen+scmp.HdrLen)
+	cmnHdr := spkt.CmnHdr{DstType: addr.HostTypeIPv4, SrcType: addr.HostTypeIPv4,
+		TotalLen: uint16(len(raw)), HdrLen: uint8(hdrLen), NextHdr: l4}
+	offset := hdrLen
+	if hbh {
+		cmnHdr.NextHdr = common.HopByHopClass
+		raw[offset] = uint8(l4)
+		offset += common.LineLen
+	}
+	cmnHdr.Write(raw)
+	dst.Write(raw[spkt.CmnHdrLen:])
+	src.Write(raw[spkt.CmnHdrLen+addr.IABytes:])
+	common.Order.PutUint16(raw[offset:], uint16(class))
+	return raw
+}
+
+func Test_Filter(t *testing.T) {
+	ia11 := &addr.ISD_AS{I: 1, A: 11}
+	ia12 := &addr.ISD_AS{I: 1, A: 12}
+	udp := mkRawPkt(ia11, ia12, common.L4UDP, false, 0)
+	scmpPath := mkRawPkt(ia12, ia11, common.L4SCMP, false, scmp.C_Path)
+	scmpHBH := mkRawPkt(ia12, ia11, common.L4SCMP, true, scmp.C_General)
+	tests := []struct {
+		expr     string
+		udp      bool
+		scmpPath bool
+		scmpHBH  bool
+	}{
+		{"", true, true, true},
+		{"src ia 1-11", true, false, false},
+		{"dst ia 1-11", false, true, true},
+		{"ia 1-12", true, true, true},
+		{"l4 udp", true, false, false},
+		{"l4 17", true, false, false},
+		{"l4 scmp", false, true, true},
+		{"scmp class path", false, true, false},
+		{"scmp class GENERAL", false, false, true},
+		{"not l4 udp", false, true, true},
+		{"src ia 1-12 and scmp class 3", false, true, false},
+		{"l4 udp or scmp class general", true, false, true},
+		{"not (l4 udp or scmp class general)", false, true, false},
+		{"ia 1-11 and (l4 udp or scmp class path)", true, true, false},
+	}
+	for _, test := range tests {
+		Convey("Filter `"+test.expr+"`", t, func() {
+			f, err := ParseFilter(test.expr)
+			So(err, ShouldBeNil)
+			So(f.Match(udp), ShouldEqual, test.udp)
+			So(f.Match(scmpPath), ShouldEqual, test.scmpPath)
+			So(f.Match(scmpHBH), ShouldEqual, test.scmpHBH)
+		})
+	}
+	Convey("Truncated packets only match empty filters", t, func() {
+		f, err := ParseFilter("not src ia 1-11")
+		So(err, ShouldBeNil)
+		So(f.Match(udp[:4]), ShouldBeFalse)
+		f, err = ParseFilter("")
+		So(err, ShouldBeNil)
+		So(f.Match(udp[:4]), ShouldBeTrue)
+	})
+	for _, expr := range []string{"src 1-11", "l4", "l4 foo", "scmp class x", "(l4 udp",
+		"l4 udp)", "l4 udp and", "ia 1_11", "bogus"} {
+		Convey("Invalid filter `"+expr+"`", t, func() {
+			_, err := ParseFilter(expr)
+			So(err, ShouldNotBeNil)
+			So(err.Desc, ShouldEqual, ErrorFilter)
+		})
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file handles writing pcapng files. Only the small subset of the format
+// needed by the router is supported: a single section with a single interface
+// per file, and Enhanced Packet Blocks with flags and comments.
+//
+// As there is no link type for SCION, each packet is prefixed with synthetic
+// IP and UDP headers built from the overlay addresses, so that it can be
+// decoded by the SCION dissectors of common tools.
+
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/util"
+)
+
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+	// linkTypeRaw is LINKTYPE_RAW, i.e. packets start with an IPv4 or IPv6
+	// header.
+	linkTypeRaw = 101
+
+	optEndOfOpt = 0
+	optComment  = 1
+	optIfName   = 2
+	optEPBFlags = 2
+
+	// Direction values of the epb_flags option.
+	epbFlagInbound  = 1
+	epbFlagOutbound = 2
+
+	ipv4HdrLen = 20
+	ipv6HdrLen = 40
+	udpHdrLen  = 8
+	ipProtoUDP = 17
+	ipTTL      = 64
+	blockAlign = 4
+)
+
+var order = binary.LittleEndian
+
+// pcapWriterBufSize is the size of the write buffer of each capture file.
+const pcapWriterBufSize = 64 << 10
+
+// pcapWriter writes pcapng blocks to a file via a buffer, keeping track of the
+// file size.
+type pcapWriter struct {
+	f    *os.File
+	bw   *bufio.Writer
+	size int64
+}
+
+// newPcapWriter creates the given file, writing the section header and a
+// single interface description, named ifName.
+func newPcapWriter(path, ifName string) (*pcapWriter, *common.Error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, common.NewError("Unable to create capture file", "path", path, "err", err)
+	}
+	w := &pcapWriter{f: f, bw: bufio.NewWriterSize(f, pcapWriterBufSize)}
+	if err := w.write(mkSHB()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := w.write(mkIDB(ifName)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *pcapWriter) write(b []byte) *common.Error {
+	n, err := w.bw.Write(b)
+	w.size += int64(n)
+	if err != nil {
+		return common.NewError("Unable to write capture file", "path", w.f.Name(), "err", err)
+	}
+	return nil
+}
+
+// flush writes any buffered data to the file.
+func (w *pcapWriter) flush() *common.Error {
+	if err := w.bw.Flush(); err != nil {
+		return common.NewError("Unable to write capture file", "path", w.f.Name(), "err", err)
+	}
+	return nil
+}
+
+func (w *pcapWriter) Close() error {
+	ferr := w.bw.Flush()
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// mkSHB creates a Section Header Block, with an unspecified section length.
+func mkSHB() []byte {
+	b := make([]byte, 28)
+	order.PutUint32(b[0:], blockSHB)
+	order.PutUint32(b[4:], uint32(len(b)))
+	order.PutUint32(b[8:], byteOrderMagic)
+	order.PutUint16(b[12:], 1) // Major version
+	order.PutUint16(b[14:], 0) // Minor version
+	order.PutUint64(b[16:], ^uint64(0))
+	order.PutUint32(b[24:], uint32(len(b)))
+	return b
+}
+
+// mkIDB creates an Interface Description Block for raw IP packets, with
+// microsecond timestamps (the default resolution).
+func mkIDB(ifName string) []byte {
+	opts := mkOpts(option{optIfName, []byte(ifName)})
+	b := make([]byte, 16+len(opts)+4)
+	order.PutUint32(b[0:], blockIDB)
+	order.PutUint32(b[4:], uint32(len(b)))
+	order.PutUint16(b[8:], linkTypeRaw)
+	order.PutUint16(b[10:], 0) // Reserved
+	order.PutUint32(b[12:], 0) // No snap length limit
+	copy(b[16:], opts)
+	order.PutUint32(b[len(b)-4:], uint32(len(b)))
+	return b
+}
+
+// mkEPB creates an Enhanced Packet Block for interface 0.
+func mkEPB(ts time.Time, data []byte, flags uint32, comment string) []byte {
+	optList := []option{{optEPBFlags, make([]byte, 4)}}
+	order.PutUint32(optList[0].val, flags)
+	if comment != "" {
+		optList = append(optList, option{optComment, []byte(comment)})
+	}
+	opts := mkOpts(optList...)
+	dataLen := len(data) + util.CalcPadding(len(data), blockAlign)
+	b := make([]byte, 28+dataLen+len(opts)+4)
+	order.PutUint32(b[0:], blockEPB)
+	order.PutUint32(b[4:], uint32(len(b)))
+	order.PutUint32(b[8:], 0) // Interface ID
+	usec := uint64(ts.UnixNano() / 1000)
+	order.PutUint32(b[12:], uint32(usec>>32))
+	order.PutUint32(b[16:], uint32(usec))
+	order.PutUint32(b[20:], uint32(len(data))) // Captured length
+	order.PutUint32(b[24:], uint32(len(data))) // Original length
+	copy(b[28:], data)
+	copy(b[28+dataLen:], opts)
+	order.PutUint32(b[len(b)-4:], uint32(len(b)))
+	return b
+}
+
+type option struct {
+	code uint16
+	val  []byte
+}
+
+// mkOpts encodes a list of options, including the terminating opt_endofopt.
+func mkOpts(opts ...option) []byte {
+	var b []byte
+	for _, o := range opts {
+		hdr := make([]byte, 4)
+		order.PutUint16(hdr[0:], o.code)
+		order.PutUint16(hdr[2:], uint16(len(o.val)))
+		b = append(b, hdr...)
+		b = append(b, o.val...)
+		b = append(b, make([]byte, util.CalcPadding(len(o.val), blockAlign))...)
+	}
+	return append(b, 0, 0, 0, 0) // opt_endofopt
+}
+
+// mkIPUDP prepends synthetic IP and UDP headers to a SCION packet, using the
+// given overlay addresses. If either address is unknown, the unspecified
+// address of the other's family is used.
+func mkIPUDP(src, dst *net.UDPAddr, pld common.RawBytes) ([]byte, error) {
+	if src == nil && dst == nil {
+		return nil, fmt.Errorf("no overlay addresses")
+	}
+	srcIP, dstIP, srcPort, dstPort := overlayAddrs(src, dst)
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		b := make([]byte, ipv4HdrLen+udpHdrLen+len(pld))
+		ip := b[:ipv4HdrLen]
+		ip[0] = 0x45 // Version 4, 5 word header.
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(b)))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // Don't fragment.
+		ip[8] = ipTTL
+		ip[9] = ipProtoUDP
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], ^checksum(0, ip))
+		writeUDP(b[ipv4HdrLen:], srcPort, dstPort, pld, pseudoSum(src4, dst4, len(pld)))
+		return b, nil
+	}
+	src16, dst16 := srcIP.To16(), dstIP.To16()
+	if src16 == nil || dst16 == nil || srcIP.To4() != nil || dstIP.To4() != nil {
+		return nil, fmt.Errorf("mismatched overlay address families: %v %v", src, dst)
+	}
+	b := make([]byte, ipv6HdrLen+udpHdrLen+len(pld))
+	ip := b[:ipv6HdrLen]
+	ip[0] = 0x60 // Version 6.
+	binary.BigEndian.PutUint16(ip[4:], uint16(udpHdrLen+len(pld)))
+	ip[6] = ipProtoUDP
+	ip[7] = ipTTL
+	copy(ip[8:], src16)
+	copy(ip[24:], dst16)
+	writeUDP(b[ipv6HdrLen:], srcPort, dstPort, pld, pseudoSum(src16, dst16, len(pld)))
+	return b, nil
+}
+
+func overlayAddrs(src, dst *net.UDPAddr) (net.IP, net.IP, int, int) {
+	if src == nil {
+		src = &net.UDPAddr{IP: unspecified(dst.IP)}
+	}
+	if dst == nil {
+		dst = &net.UDPAddr{IP: unspecified(src.IP)}
+	}
+	return src.IP, dst.IP, src.Port, dst.Port
+}
+
+func unspecified(ip net.IP) net.IP {
+	if ip.To4() != nil {
+		return net.IPv4zero
+	}
+	return net.IPv6unspecified
+}
+
+// writeUDP writes a UDP header followed by pld into b.
+func writeUDP(b []byte, srcPort, dstPort int, pld common.RawBytes, sum uint32) {
+	binary.BigEndian.PutUint16(b[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(b[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(b[4:], uint16(udpHdrLen+len(pld)))
+	copy(b[udpHdrLen:], pld)
+	csum := ^checksum(sum, b[:udpHdrLen+len(pld)])
+	if csum == 0 {
+		csum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(b[6:], csum)
+}
+
+// pseudoSum calculates the partial checksum of the IP pseudo-header used by
+// UDP.
+func pseudoSum(src, dst net.IP, pldLen int) uint32 {
+	var sum uint32
+	sum = addWords(sum, src)
+	sum = addWords(sum, dst)
+	return sum + ipProtoUDP + uint32(udpHdrLen+pldLen)
+}
+
+// checksum calculates the folded internet checksum of b, starting from the
+// partial sum.
+func checksum(sum uint32, b []byte) uint16 {
+	sum = addWords(sum, b)
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return uint16(sum)
+}
+
+func addWords(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
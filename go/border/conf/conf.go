@@ -17,13 +17,19 @@
 package conf
 
 import (
+	"bytes"
 	"crypto/cipher"
 	"crypto/sha256"
+	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/crypto/pbkdf2"
 
+	"github.com/netsec-ethz/scion/go/border/acl"
+	"github.com/netsec-ethz/scion/go/border/maccache"
 	"github.com/netsec-ethz/scion/go/border/netconf"
 	"github.com/netsec-ethz/scion/go/lib/addr"
 	"github.com/netsec-ethz/scion/go/lib/as_conf"
@@ -47,15 +53,91 @@ type Conf struct {
 	ASConf *as_conf.ASConf
 	// HFGenBlock is the Hop Field generation block cipher instance.
 	HFGenBlock cipher.Block
+	// HFKeys are the Hop Field verification keys. The first is the current
+	// key, whose block is HFGenBlock, followed by the previous and next keys,
+	// if configured.
+	HFKeys []*HFKey
+	// MACCache caches Hop Fields whose MAC has been verified with HFKeys.
+	// It is shared between successive configurations, unless the keys change.
+	MACCache *maccache.Cache
 	// Net is the network configuration of this router.
 	Net *netconf.NetConf
 	// Dir is the configuration directory.
 	Dir string
-	// IFStates is a map of interface IDs to interface states, protected by a RWMutex.
-	IFStates struct {
-		sync.RWMutex
-		M map[spath.IntfID]IFState
+	// ACL is the access control list applied to forwarded packets. It is nil
+	// if there is no ACL file in the configuration directory.
+	ACL *acl.ACL
+	// IFStates holds the current interface states. It is shared between
+	// successive configurations, as it is not loaded from disk.
+	IFStates *IFStates
+	// AdminDown holds the interfaces that have been administratively disabled.
+	// Like IFStates, it is shared between successive configurations.
+	AdminDown *AdminDown
+}
+
+// Names of the Hop Field keys, as used in metrics and the MAC cache.
+const (
+	HFKeyCurrent = "current"
+	HFKeyPrev    = "previous"
+	HFKeyNext    = "next"
+)
+
+// HFKey is a Hop Field verification key, derived from a master AS key.
+type HFKey struct {
+	// Name is one of HFKeyCurrent, HFKeyPrev or HFKeyNext.
+	Name string
+	// Block is the block cipher instance used to verify MACs.
+	Block cipher.Block
+	// Master is the master AS key, along with its validity window.
+	Master *as_conf.MasterKey
+}
+
+// Valid returns true if the key may be used to verify MACs at time now.
+func (k *HFKey) Valid(now time.Time) bool {
+	return k.Master.Valid(now)
+}
+
+// Expiry returns the earlier of expiry and the end of the key's validity.
+func (k *HFKey) Expiry(expiry time.Time) time.Time {
+	if notAfter := k.Master.NotAfter; !notAfter.IsZero() && notAfter.Before(expiry) {
+		return notAfter.Time
 	}
+	return expiry
+}
+
+// IFStates is a map of interface IDs to interface states, protected by a RWMutex.
+type IFStates struct {
+	sync.RWMutex
+	M map[spath.IntfID]IFState
+}
+
+// AdminDown is a set of administratively disabled interface IDs, protected by
+// a RWMutex.
+type AdminDown struct {
+	sync.RWMutex
+	M map[spath.IntfID]bool
+}
+
+// IsDown returns true if the given interface is administratively disabled.
+func (a *AdminDown) IsDown(ifid spath.IntfID) bool {
+	a.RLock()
+	defer a.RUnlock()
+	return a.M[ifid]
+}
+
+// Set marks the given interface as administratively disabled (or enabled, if
+// down is false).
+func (a *AdminDown) Set(ifid spath.IntfID, down bool) {
+	a.Lock()
+	defer a.Unlock()
+	if !down {
+		delete(a.M, ifid)
+		return
+	}
+	if a.M == nil {
+		a.M = make(map[spath.IntfID]bool)
+	}
+	a.M[ifid] = true
 }
 
 // IFState stores the IFStateInfo capnp message, as well as the raw revocation
@@ -63,47 +145,183 @@ type Conf struct {
 type IFState struct {
 	P      proto.IFStateInfo
 	RawRev common.RawBytes
+	// RevTime is when the current revocation was first received. It is zero
+	// if the interface is active.
+	RevTime time.Time
+}
+
+// MaxMTU returns the largest MTU of the local AS and the router's interfaces.
+func (c *Conf) MaxMTU() int {
+	mtu := c.TopoMeta.T.MTU
+	for _, intf := range c.Net.IFs {
+		if intf.MTU > mtu {
+			mtu = intf.MTU
+		}
+	}
+	return mtu
 }
 
-// C is a pointer to the current configuration.
-var C *Conf
+// c holds a pointer to the current configuration. It is accessed atomically,
+// so that a reloaded configuration can be swapped in while packets are being
+// processed.
+var c atomic.Value
+
+// Get returns a pointer to the current configuration. The returned Conf must
+// be treated as read-only (except for IFStates). Code that needs a consistent
+// view of the configuration over several accesses should call Get once and
+// keep the result.
+func Get() *Conf {
+	conf, _ := c.Load().(*Conf)
+	return conf
+}
 
-// Load sets up the configuration, loading it from the supplied config directory.
-func Load(id, confDir string) *common.Error {
+// Set atomically replaces the current configuration. If the new configuration
+// has no IFStates or AdminDown, the ones from the current configuration (if
+// any) are carried over. The same goes for MACCache, as long as the master AS
+// keys haven't changed.
+func Set(conf *Conf) {
+	old := Get()
+	if conf.MACCache == nil {
+		if old != nil && old.MACCache != nil && sameMasterKey(old, conf) {
+			conf.MACCache = old.MACCache
+		} else {
+			conf.MACCache = maccache.New()
+		}
+	}
+	if conf.IFStates == nil {
+		if old != nil {
+			conf.IFStates = old.IFStates
+		} else {
+			conf.IFStates = &IFStates{}
+		}
+	}
+	if conf.AdminDown == nil {
+		if old != nil {
+			conf.AdminDown = old.AdminDown
+		} else {
+			conf.AdminDown = &AdminDown{}
+		}
+	}
+	c.Store(conf)
+}
+
+// sameMasterKey returns true if a and b use the same master AS keys, with the
+// same validity windows.
+func sameMasterKey(a, b *Conf) bool {
+	return a.ASConf != nil && b.ASConf != nil &&
+		bytes.Equal(a.ASConf.MasterASKey, b.ASConf.MasterASKey) &&
+		sameKeyWindow(a.ASConf.PrevMasterASKey, b.ASConf.PrevMasterASKey) &&
+		sameKeyWindow(a.ASConf.NextMasterASKey, b.ASConf.NextMasterASKey)
+}
+
+func sameKeyWindow(a, b *as_conf.MasterKey) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(a.Key, b.Key) && a.NotBefore.Equal(b.NotBefore.Time) &&
+		a.NotAfter.Equal(b.NotAfter.Time)
+}
+
+// Load loads a new configuration from the supplied config directory. The
+// result is not installed as the current configuration, see Set.
+func Load(id, confDir string) (*Conf, *common.Error) {
 	var err *common.Error
 
 	// Declare a new Conf instance, and load the topology config.
 	conf := &Conf{}
 	conf.Dir = confDir
 	topoPath := filepath.Join(conf.Dir, topology.CfgName)
-	if err = topology.Load(topoPath); err != nil {
-		return err
+	if conf.TopoMeta, err = topology.Load(topoPath); err != nil {
+		return nil, err
 	}
-	conf.TopoMeta = topology.Curr
 	conf.IA = conf.TopoMeta.T.IA
+	if conf.IA == nil {
+		return nil, common.NewError("No ISD-AS specified in topology", "path", topoPath)
+	}
 	// Find the config for this router.
 	topoBR, ok := conf.TopoMeta.T.BR[id]
 	if !ok {
-		return common.NewError("Unable to find element ID in topology", "id", id, "path", topoPath)
+		return nil, common.NewError("Unable to find element ID in topology",
+			"id", id, "path", topoPath)
+	}
+	if err = validateBR(&topoBR); err != nil {
+		err.Ctx = append(err.Ctx, "id", id, "path", topoPath)
+		return nil, err
 	}
 	conf.BR = &topoBR
 	// Load AS configuration
 	asConfPath := filepath.Join(conf.Dir, as_conf.CfgName)
-	if err = as_conf.Load(asConfPath); err != nil {
-		return err
+	if conf.ASConf, err = as_conf.Load(asConfPath); err != nil {
+		return nil, err
+	}
+	if len(conf.ASConf.MasterASKey) == 0 {
+		return nil, common.NewError("No MasterASKey specified in AS conf", "path", asConfPath)
 	}
-	conf.ASConf = as_conf.CurrConf
 
-	// Generate keys
-	// This uses 16B keys with 1000 hash iterations, which is the same as the
-	// defaults used by pycrypto.
-	hfGenKey := pbkdf2.Key(conf.ASConf.MasterASKey, []byte("Derive OF Key"), 1000, 16, sha256.New)
-	if conf.HFGenBlock, err = util.InitAES(hfGenKey); err != nil {
-		return err
+	// Generate keys. The current key is always valid, the others only within
+	// their windows.
+	masters := []struct {
+		name string
+		key  *as_conf.MasterKey
+	}{
+		{HFKeyCurrent, &as_conf.MasterKey{Key: conf.ASConf.MasterASKey}},
+		{HFKeyPrev, conf.ASConf.PrevMasterASKey},
+		{HFKeyNext, conf.ASConf.NextMasterASKey},
+	}
+	for _, m := range masters {
+		if m.key == nil {
+			continue
+		}
+		block, err := deriveHFKey(m.key.Key)
+		if err != nil {
+			err.Ctx = append(err.Ctx, "key", m.name, "path", asConfPath)
+			return nil, err
+		}
+		conf.HFKeys = append(conf.HFKeys, &HFKey{Name: m.name, Block: block, Master: m.key})
+	}
+	conf.HFGenBlock = conf.HFKeys[0].Block
+	// Load the ACL, if there is one.
+	aclPath := filepath.Join(conf.Dir, acl.CfgName)
+	if _, serr := os.Stat(aclPath); !os.IsNotExist(serr) {
+		if conf.ACL, err = acl.Load(aclPath); err != nil {
+			return nil, err
+		}
 	}
 	// Create network configuration
 	conf.Net = netconf.FromTopo(conf.BR)
-	// Save config
-	C = conf
+	return conf, nil
+}
+
+// deriveHFKey derives the Hop Field key from a master AS key.
+func deriveHFKey(master util.B64Bytes) (cipher.Block, *common.Error) {
+	// This uses 16B keys with 1000 hash iterations, which is the same as the
+	// defaults used by pycrypto.
+	hfGenKey := pbkdf2.Key(master, []byte("Derive OF Key"), 1000, 16, sha256.New)
+	return util.InitAES(hfGenKey)
+}
+
+// validateBR checks that the topology entry for this router contains the
+// information needed to set up networking.
+func validateBR(br *topology.TopoBR) *common.Error {
+	if br.Addr == nil {
+		return common.NewError("No local address specified for router")
+	}
+	if len(br.IFs) == 0 {
+		return common.NewError("No interfaces specified for router")
+	}
+	for _, intf := range br.IFs {
+		if intf.Addr == nil || intf.ToAddr == nil {
+			return common.NewError("Missing interface address", "ifid", intf.IFID)
+		}
+		if intf.IA == nil {
+			return common.NewError("Missing interface remote ISD-AS", "ifid", intf.IFID)
+		}
+		switch intf.LinkType {
+		case topology.LinkCore, topology.LinkParent, topology.LinkChild, topology.LinkPeer:
+		default:
+			return common.NewError("Unknown interface link type",
+				"ifid", intf.IFID, "type", intf.LinkType)
+		}
+	}
 	return nil
 }
@@ -14,5 +14,7 @@
 
 // Border is a Go implementation of the SCION border router. It is designed to
 // work with the linux network stack (the default), and/or with DPDK (via
-// libhsr and the "hsr" build flag).
+// libhsr and the "hsr" build flag). On Linux, the -batch flag makes the
+// linux network stack backend use recvmmsg/sendmmsg to read and write
+// packets in batches.
 package main
@@ -18,9 +18,12 @@
 package main
 
 import (
+	"fmt"
+
 	//log "github.com/inconshreveable/log15"
 
-	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/capture"
+	"github.com/netsec-ethz/scion/go/border/metrics"
 	"github.com/netsec-ethz/scion/go/border/rpkt"
 	"github.com/netsec-ethz/scion/go/lib/addr"
 	"github.com/netsec-ethz/scion/go/lib/common"
@@ -28,17 +31,48 @@ import (
 	"github.com/netsec-ethz/scion/go/lib/spkt"
 )
 
-// handlePktError is called for protocol-level packet errors. If there's SCMP
-// metadata attached to the error object, then an SCMP error response is
-// generated and sent.
-func (r *Router) handlePktError(rp *rpkt.RtrPkt, perr *common.Error, desc string) {
+// Reasons for dropping packets, used to label metrics.PktsDropped. Packet
+// errors with SCMP metadata are labelled with the SCMP class/type instead.
+const (
+	dropParse       = "parse"
+	dropValidate    = "validate"
+	dropLocal       = "local_processing"
+	dropPayload     = "payload"
+	dropProcess     = "process"
+	dropRoute       = "route"
+	dropSend        = "send"
+	dropTruncated   = "truncated"
+	dropWorkerQueue = "worker_queue_full"
+)
+
+// countDrop counts a dropped packet, labelled with the metrics id of the
+// socket it was received on (or was to be sent on).
+func countDrop(id, reason string) {
+	metrics.PktsDropped.WithLabelValues(id, reason).Inc()
+}
+
+// handlePktError is called for protocol-level packet errors. The packet is
+// counted as dropped, either with the SCMP class/type of the error or the
+// given reason. If there's SCMP metadata attached to the error object, then
+// an SCMP error response is generated and sent.
+func (r *Router) handlePktError(rp *rpkt.RtrPkt, perr *common.Error, desc, reason string) {
 	sdata, ok := perr.Data.(*scmp.ErrData)
 	if ok {
 		perr.Ctx = append(perr.Ctx, "SCMP", sdata.CT)
+		reason = sdata.CT.String()
 	}
+	countDrop(rp.Ingress.Id, reason)
 	// XXX(kormat): uncomment for debugging:
 	// perr.Ctx = append(perr.Ctx, "raw", rp.Raw)
 	rp.Error(desc, perr.Ctx...)
+	if c := capture.Get(); c != nil {
+		comment := fmt.Sprintf("%s: %s", desc, perr.Desc)
+		if ok {
+			comment = fmt.Sprintf("%s (SCMP %s)", comment, sdata.CT)
+		}
+		c.Packet(capture.DropID, capture.DirDrop, rp.Raw, rp.Ingress.Src, rp.Ingress.Dst,
+			comment)
+	}
 	if !ok || perr.Data == nil || rp.DirFrom == rpkt.DirSelf || rp.SCMPError {
 		// No scmp error data, packet is from self, or packet is already an SCMPError, so no reply.
 		return
@@ -59,7 +93,7 @@ func (r *Router) handlePktError(rp *rpkt.RtrPkt, perr *common.Error, desc string
 		return
 	}
 	// Certain errors are not respondable to if the source lies in a remote AS.
-	if !srcIA.Eq(conf.C.IA) {
+	if !srcIA.Eq(rp.Conf().IA) {
 		switch sdata.CT.Class {
 		case scmp.C_CmnHdr:
 			switch sdata.CT.Type {
@@ -74,6 +108,9 @@ func (r *Router) handlePktError(rp *rpkt.RtrPkt, perr *common.Error, desc string
 		}
 
 	}
+	if !r.scmpLimit.allow(rp, sdata.CT, srcIA) {
+		return
+	}
 	reply, err := r.createSCMPErrorReply(rp, sdata.CT, sdata.Info)
 	if err != nil {
 		rp.Error("Error creating SCMP response", err.Ctx...)
@@ -85,6 +122,20 @@ func (r *Router) handlePktError(rp *rpkt.RtrPkt, perr *common.Error, desc string
 // createSCMPErrorReply generates an SCMP error reply to the supplied packet.
 func (r *Router) createSCMPErrorReply(rp *rpkt.RtrPkt, ct scmp.ClassType,
 	info scmp.Info) (*rpkt.RtrPkt, *common.Error) {
+	ext := &scmp.Extn{Error: true}
+	if ct.Class == scmp.C_Path && ct.Type == scmp.T_P_RevokedIF {
+		// Revocation SCMP errors have to be inspected by intermediate routers.
+		ext.HopByHop = true
+	}
+	return r.createSCMPReply(rp, ct, info, ext)
+}
+
+// createSCMPReply generates an SCMP reply of the given class/type to the
+// supplied packet, quoting the parts of the packet required by the
+// class/type. If ext is not nil, it is added as the first hop-by-hop
+// extension of the reply.
+func (r *Router) createSCMPReply(rp *rpkt.RtrPkt, ct scmp.ClassType, info scmp.Info,
+	ext *scmp.Extn) (*rpkt.RtrPkt, *common.Error) {
 	// Create generic ScnPkt reply
 	sp, err := r.createReplyScnPkt(rp)
 	if err != nil {
@@ -92,13 +143,10 @@ func (r *Router) createSCMPErrorReply(rp *rpkt.RtrPkt, ct scmp.ClassType,
 	}
 	oldHBH := sp.HBHExt
 	sp.HBHExt = make([]common.Extension, 0, common.ExtnMaxHBH+1)
-	// Add new SCMP HBH extension at the start.
-	ext := &scmp.Extn{Error: true}
-	if ct.Class == scmp.C_Path && ct.Type == scmp.T_P_RevokedIF {
-		// Revocation SCMP errors have to be inspected by intermediate routers.
-		ext.HopByHop = true
+	if ext != nil {
+		// Add new SCMP HBH extension at the start.
+		sp.HBHExt = append(sp.HBHExt, ext)
 	}
-	sp.HBHExt = append(sp.HBHExt, ext)
 	// Filter out any existing SCMP HBH headers, and trim the list to
 	// common.ExtnMaxHBH.
 	for _, e := range oldHBH {
@@ -123,7 +171,7 @@ func (r *Router) createSCMPErrorReply(rp *rpkt.RtrPkt, ct scmp.ClassType,
 		return nil, err
 	}
 	// Only (potentially) call IncPath if the dest is not in the local AS.
-	if !dstIA.Eq(conf.C.IA) {
+	if !dstIA.Eq(rp.Conf().IA) {
 		hopF, err := reply.HopF()
 		if err != nil {
 			return nil, err
@@ -173,7 +221,7 @@ func (r *Router) createReplyScnPkt(rp *rpkt.RtrPkt) (*spkt.ScnPkt, *common.Error
 		return nil, err
 	}
 	// Use the ingress address as the source host
-	sp.SrcIA = conf.C.IA
+	sp.SrcIA = rp.Conf().IA
 	sp.SrcHost = addr.HostFromIP(rp.Ingress.Dst.IP)
 	return sp, nil
 }
@@ -182,12 +230,13 @@ func (r *Router) createReplyScnPkt(rp *rpkt.RtrPkt) (*spkt.ScnPkt, *common.Error
 // address to use when replying to a packet.
 func (r *Router) replyEgress(rp *rpkt.RtrPkt) (rpkt.EgressPair, *common.Error) {
 	if rp.DirFrom == rpkt.DirLocal {
-		locIdx := conf.C.Net.LocAddrMap[rp.Ingress.Dst.String()]
-		return rpkt.EgressPair{F: r.locOutFs[locIdx], Dst: rp.Ingress.Src}, nil
+		locIdx := rp.Conf().Net.LocAddrMap[rp.Ingress.Dst.String()]
+		return rpkt.EgressPair{F: rpkt.GetOutputFuncs().Loc[locIdx], Dst: rp.Ingress.Src}, nil
 	}
 	intf, err := rp.IFCurr()
 	if err != nil {
 		return rpkt.EgressPair{}, err
 	}
-	return rpkt.EgressPair{F: r.intfOutFs[*intf], Dst: rp.Ingress.Src}, nil
+	return rpkt.EgressPair{F: rpkt.GetOutputFuncs().Intf[*intf], Dst: rp.Ingress.Src,
+		IfID: *intf}, nil
 }
@@ -0,0 +1,353 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains a test harness that runs a Router in-process. The
+// router's sockets are replaced by output functions that record every packet
+// sent, so that tests can inject packets and check what the router emits.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gavv/monotime"
+
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/overlay"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+// sentPkt records a packet passed to one of the harness's output functions.
+type sentPkt struct {
+	out string
+	dst *net.UDPAddr
+	raw common.RawBytes
+}
+
+// harness runs a Router whose output functions record the packets sent.
+type harness struct {
+	t    *testing.T
+	r    *Router
+	sent []sentPkt
+}
+
+// newHarness creates a Router with the given element ID from the config in
+// testdata/. The AS in testdata/topology.yml has links of every type on
+// br1-11-1 (which would not all be found in a real AS), so that all kinds of
+// path segments can be tested on a single router. br1-11-2 has a single link,
+// for testing forwarding via the local network.
+func newHarness(t *testing.T, id string) *harness {
+	r, err := NewRouter(id, "testdata")
+	if err != nil {
+		t.Fatalf("Unable to create router: %v", err)
+	}
+	h := &harness{t: t, r: r}
+	c := conf.Get()
+	for idx := range c.Net.LocAddr {
+		r.locOutFs[idx] = h.outF(fmt.Sprintf("loc:%d", idx))
+	}
+	for ifid := range c.Net.IFs {
+		r.intfOutFs[ifid] = h.outF(fmt.Sprintf("intf:%d", ifid))
+	}
+	r.publishOutputFuncs()
+	return h
+}
+
+// netRouter creates a Router from a copy of testdata/, with the topology
+// modified by edit. Unlike newHarness, the router uses real POSIX sockets (as
+// set up by Router.setupNet, without dropping capabilities), so that config
+// reloads can be tested. The returned function closes all sockets.
+func netRouter(t *testing.T, id string, edit func(topo string) string) (
+	*Router, string, func()) {
+	dir, err := ioutil.TempDir("", "border-test")
+	if err != nil {
+		t.Fatalf("Unable to create config dir: %v", err)
+	}
+	for _, name := range []string{"as.yml", "topology.yml"} {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("Unable to read %s: %v", name, err)
+		}
+		if name == "topology.yml" {
+			b = []byte(edit(string(b)))
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatalf("Unable to write %s: %v", name, err)
+		}
+	}
+	r, cerr := NewRouter(id, dir)
+	if cerr != nil {
+		t.Fatalf("Unable to create router: %v", cerr)
+	}
+	oldAddLoc, oldAddExt := setupAddLocalHooks, setupAddExtHooks
+	oldDelLoc, oldDelExt := setupDelLocalHooks, setupDelExtHooks
+	setupAddLocalHooks = append(setupAddLocalHooks, setupPosixAddLocal)
+	setupAddExtHooks = append(setupAddExtHooks, setupPosixAddExt)
+	setupDelLocalHooks = append(setupDelLocalHooks, setupPosixDelLocal)
+	setupDelExtHooks = append(setupDelExtHooks, setupPosixDelExt)
+	c := conf.Get()
+	for idx := range c.Net.LocAddr {
+		if err := r.addLocal(c.Net, idx); err != nil {
+			t.Fatalf("Unable to add local address: %v", err)
+		}
+	}
+	for _, intf := range c.Net.IFs {
+		if err := r.addExt(intf); err != nil {
+			t.Fatalf("Unable to add interface: %v", err)
+		}
+	}
+	r.publishOutputFuncs()
+	for _, q := range r.inQs {
+		r.startQueue(q)
+	}
+	r.netReady = true
+	return r, dir, func() {
+		n := conf.Get().Net
+		for _, over := range n.LocAddr {
+			over.Conn.Close()
+		}
+		for _, intf := range n.IFs {
+			intf.IFAddr.Conn.Close()
+		}
+		setupAddLocalHooks, setupAddExtHooks = oldAddLoc, oldAddExt
+		setupDelLocalHooks, setupDelExtHooks = oldDelLoc, oldDelExt
+		os.RemoveAll(dir)
+	}
+}
+
+func (h *harness) outF(name string) rpkt.OutputFunc {
+	return func(rp *rpkt.RtrPkt, dst *net.UDPAddr) {
+		h.sent = append(h.sent, sentPkt{name, dst, append(common.RawBytes(nil), rp.Raw...)})
+	}
+}
+
+// inject processes a packet the same way the router's workers do, and returns
+// the packets sent as a result.
+func (h *harness) inject(rp *rpkt.RtrPkt) []sentPkt {
+	h.sent = nil
+	h.r.processPacket(rp)
+	return h.sent
+}
+
+// extPkt creates a packet as if received from the neighbouring ISD-AS over
+// the given interface.
+func (h *harness) extPkt(ifid spath.IntfID, sp *spkt.ScnPkt) *rpkt.RtrPkt {
+	intf := conf.Get().Net.IFs[ifid]
+	return h.mkPkt(sp, rpkt.DirExternal, intf.IFAddr.BindAddr(), intf.RemoteAddr,
+		[]spath.IntfID{ifid}, fmt.Sprintf("intf:%d", ifid))
+}
+
+// locPkt creates a packet as if received from a host in the local ISD-AS.
+func (h *harness) locPkt(src net.IP, sp *spkt.ScnPkt) *rpkt.RtrPkt {
+	n := conf.Get().Net
+	loc := n.LocAddr[0].BindAddr()
+	return h.mkPkt(sp, rpkt.DirLocal, loc, &net.UDPAddr{IP: src, Port: overlay.EndhostPort},
+		n.LocAddrIFIDMap[loc.String()], "loc:0")
+}
+
+func (h *harness) mkPkt(sp *spkt.ScnPkt, dirFrom rpkt.Dir, dst, src *net.UDPAddr,
+	ifids []spath.IntfID, id string) *rpkt.RtrPkt {
+	tmp, err := rpkt.RtrPktFromScnPkt(sp, dirFrom)
+	if err != nil {
+		h.t.Fatalf("Error creating packet: %v", err)
+	}
+	rp := rpkt.NewRtrPkt()
+	rp.Raw = rp.Raw[:copy(rp.Raw, tmp.Raw)]
+	rp.DirFrom = dirFrom
+	rp.TimeIn = monotime.Now()
+	rp.Ingress.Dst = dst
+	rp.Ingress.Src = src
+	rp.Ingress.IfIDs = ifids
+	rp.Ingress.Id = id
+	return rp
+}
+
+// hop describes a Hop Field of a test path.
+type hop struct {
+	in, eg              spath.IntfID
+	xover, vonly, fonly bool
+	// ver is the position of the Hop Field whose bytes are included in this
+	// Hop Field's MAC, as an offset in lines from this Hop Field (0 for
+	// none). It may point into another segment.
+	ver int
+}
+
+// seg describes a segment of a test path. Its Hop Fields are listed in
+// packet order.
+type seg struct {
+	up, shortcut, peer bool
+	// ts is the segment's timestamp. 0 means now.
+	ts   uint32
+	hops []hop
+}
+
+// upSeg creates an up segment whose Hop Field MACs are chained as for a
+// segment without shortcuts (i.e. each includes the next Hop Field).
+func upSeg(hops ...hop) seg {
+	for i := 0; i < len(hops)-1; i++ {
+		hops[i].ver = 1
+	}
+	return seg{up: true, hops: hops}
+}
+
+// downSeg creates a down segment whose Hop Field MACs are chained as for a
+// segment without shortcuts (i.e. each includes the previous Hop Field).
+func downSeg(hops ...hop) seg {
+	for i := 1; i < len(hops); i++ {
+		hops[i].ver = -1
+	}
+	return seg{hops: hops}
+}
+
+// mkPath creates a path from the given segments, with the current Hop Field
+// set to hop currHop of segment currSeg. All MACs are calculated with the
+// local AS key.
+func (h *harness) mkPath(segs []seg, currSeg, currHop int) *spath.Path {
+	lines := 0
+	for _, s := range segs {
+		lines += 1 + len(s.hops)
+	}
+	raw := make(common.RawBytes, lines*common.LineLen)
+	p := &spath.Path{Raw: raw}
+	// hopFs and tss are indexed by line.
+	hopFs := make([]*spath.HopField, lines)
+	vers := make([]int, lines)
+	tss := make([]uint32, lines)
+	line := 0
+	for i, s := range segs {
+		ts := s.ts
+		if ts == 0 {
+			ts = uint32(time.Now().Unix())
+		}
+		infoF := &spath.InfoField{Up: s.up, Shortcut: s.shortcut, Peer: s.peer, TsInt: ts,
+			ISD: 1, Hops: uint8(len(s.hops))}
+		infoF.Write(raw[line*common.LineLen:])
+		if i == currSeg {
+			p.InfOff = uint8(line * common.LineLen)
+			p.HopOff = uint8((line + 1 + currHop) * common.LineLen)
+		}
+		line++
+		for _, hp := range s.hops {
+			off := line * common.LineLen
+			hopF := spath.NewHopField(raw[off:off+common.LineLen], hp.in, hp.eg)
+			hopF.Xover, hopF.VerifyOnly, hopF.ForwardOnly = hp.xover, hp.vonly, hp.fonly
+			hopF.Write()
+			hopFs[line], vers[line], tss[line] = hopF, hp.ver, ts
+			line++
+		}
+	}
+	// Calculate the MACs, each once the Hop Field it includes is complete.
+	done := make([]bool, lines)
+	for remaining := len(segs); remaining < lines; {
+		progress := false
+		for l, hopF := range hopFs {
+			if hopF == nil || done[l] {
+				continue
+			}
+			var prev common.RawBytes
+			if vers[l] != 0 {
+				v := l + vers[l]
+				if v < 0 || v >= lines {
+					h.t.Fatalf("Hop Field %d includes line %d outside of path", l, v)
+				}
+				if hopFs[v] != nil && !done[v] {
+					continue
+				}
+				prev = raw[v*common.LineLen+1 : (v+1)*common.LineLen]
+			}
+			mac, err := hopF.CalcMac(conf.Get().HFGenBlock, tss[l], prev)
+			if err != nil {
+				h.t.Fatalf("Error calculating MAC: %v", err)
+			}
+			hopF.Mac = mac
+			hopF.Write()
+			done[l] = true
+			remaining++
+			progress = true
+		}
+		if !progress {
+			h.t.Fatalf("Cyclic Hop Field MAC dependencies")
+		}
+	}
+	return p
+}
+
+// ia is a shorthand for ISD-ASes in ISD 1.
+func ia(as int) *addr.ISD_AS {
+	return &addr.ISD_AS{I: 1, A: as}
+}
+
+// host is a shorthand for IPv4 host addresses.
+func host(ip string) addr.HostAddr {
+	return addr.HostFromIP(net.ParseIP(ip))
+}
+
+// sentSCMP returns the SCMP header of a sent packet, skipping over any
+// extensions, or nil if it isn't an SCMP packet.
+func sentSCMP(raw common.RawBytes) *scmp.Hdr {
+	w, err := spkt.ExtnWalkerFromRaw(raw)
+	if err != nil {
+		return nil
+	}
+	for !w.Done() {
+		if _, err := w.Next(); err != nil {
+			return nil
+		}
+	}
+	offset := w.Offset()
+	if w.NextHdr() != common.L4SCMP || offset+scmp.HdrLen > len(raw) {
+		return nil
+	}
+	hdr, err := scmp.HdrFromRaw(raw[offset:])
+	if err != nil {
+		return nil
+	}
+	return hdr
+}
+
+// addHBH inserts hop-by-hop extensions with the given types and empty
+// (single-line) bodies before the L4 header of a raw packet.
+func addHBH(rp *rpkt.RtrPkt, types ...uint8) {
+	cmnHdr, err := spkt.CmnHdrFromRaw(rp.Raw)
+	if err != nil {
+		panic(err)
+	}
+	hdrLen := int(cmnHdr.HdrLen)
+	exts := make(common.RawBytes, len(types)*common.LineLen)
+	for i, t := range types {
+		next := uint8(common.HopByHopClass)
+		if i == len(types)-1 {
+			next = uint8(cmnHdr.NextHdr)
+		}
+		exts[i*common.LineLen] = next
+		exts[i*common.LineLen+2] = t
+	}
+	raw := append(append(append(common.RawBytes(nil), rp.Raw[:hdrLen]...), exts...),
+		rp.Raw[hdrLen:]...)
+	cmnHdr.NextHdr = common.HopByHopClass
+	cmnHdr.TotalLen = uint16(len(raw))
+	cmnHdr.Write(raw)
+	rp.Raw = rp.Raw[:copy(rp.Raw[:cap(rp.Raw)], raw)]
+}
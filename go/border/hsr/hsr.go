@@ -165,6 +165,7 @@ func (h *HSR) GetPackets(rps []*rpkt.RtrPkt, usedPorts []bool) (int, *common.Err
 		// Fill out packet metadata from AddrMs
 		rp.Ingress.Dst = AddrMs[cp.port_id].GoAddr
 		rp.Ingress.IfIDs = AddrMs[cp.port_id].IfIDs
+		rp.Ingress.Id = AddrMs[cp.port_id].Labels["id"]
 		rp.DirFrom = AddrMs[cp.port_id].DirFrom
 		// Indicate this port was used
 		usedPorts[cp.port_id] = true
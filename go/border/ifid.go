@@ -14,11 +14,13 @@
 
 // This file handles generating periodic Interface ID (IFID) packets that are
 // sent to the Beacon Service in the neighbouring AS. These function as both
-// keep-alives, and to inform the neighbour of the local interface ID.
+// keep-alives (see liveness.go), and to inform the neighbour of the local
+// interface ID.
 
 package main
 
 import (
+	"context"
 	"time"
 
 	log "github.com/inconshreveable/log15"
@@ -36,15 +38,31 @@ import (
 // ifIDFreq is how often IFID packets are sent to the neighbouring AS.
 const ifIDFreq = 1 * time.Second
 
-func (r *Router) SyncInterface() {
+// SyncInterface periodically sends IFID packets, and checks that the
+// neighbours' IFID packets are still arriving, until ctx is cancelled.
+func (r *Router) SyncInterface(ctx context.Context) {
 	defer liblog.PanicLog()
-	for range time.Tick(ifIDFreq) {
-		r.GenIFIDPkts()
+	ticker := time.NewTicker(ifIDFreq)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			r.GenIFIDPkts()
+			r.CheckLinks(now)
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
+// GenIFIDPkts generates IFID packets for all interfaces that aren't
+// administratively down.
 func (r *Router) GenIFIDPkts() {
-	for ifid := range conf.C.Net.IFs {
+	c := conf.Get()
+	for ifid := range c.Net.IFs {
+		if c.AdminDown.IsDown(ifid) {
+			continue
+		}
 		r.GenIFIDPkt(ifid)
 	}
 }
@@ -52,11 +70,12 @@ func (r *Router) GenIFIDPkts() {
 // GenIFIDPkt generates IFID packets.
 func (r *Router) GenIFIDPkt(ifid spath.IntfID) {
 	logger := log.New("ifid", ifid)
-	intf := conf.C.Net.IFs[ifid]
+	c := conf.Get()
+	intf := c.Net.IFs[ifid]
 	srcAddr := intf.IFAddr.PublicAddr()
 	// Create base packet
 	rp, err := rpkt.RtrPktFromScnPkt(&spkt.ScnPkt{
-		DstIA: intf.RemoteIA, SrcIA: conf.C.IA,
+		DstIA: intf.RemoteIA, SrcIA: c.IA,
 		DstHost: addr.HostFromIP(intf.RemoteAddr.IP), SrcHost: addr.HostFromIP(srcAddr.IP),
 		L4: &l4.UDP{SrcPort: uint16(srcAddr.Port), DstPort: uint16(intf.RemoteAddr.Port)},
 	}, rpkt.DirExternal)
@@ -64,7 +83,8 @@ func (r *Router) GenIFIDPkt(ifid spath.IntfID) {
 		logger.Error("Error creating IFID packet", err.Ctx...)
 		return
 	}
-	rp.Egress = append(rp.Egress, rpkt.EgressPair{F: r.intfOutFs[ifid], Dst: intf.RemoteAddr})
+	rp.Egress = append(rp.Egress, rpkt.EgressPair{F: rpkt.GetOutputFuncs().Intf[ifid],
+		Dst: intf.RemoteAddr, IfID: ifid})
 	// Create IFID msg
 	scion, ifidMsg, err := proto.NewIFIDMsg()
 	if err != nil {
@@ -23,6 +23,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"time"
 
@@ -43,45 +45,90 @@ import (
 // from the beacon service.
 const ifStateFreq = 30 * time.Second
 
-func (r *Router) IFStateUpdate() {
+// ifStateReqAll is the interface ID used to request the state of all
+// interfaces.
+const ifStateReqAll spath.IntfID = 0
+
+// IFStateUpdate periodically requests Interface State updates, until ctx is
+// cancelled.
+func (r *Router) IFStateUpdate(ctx context.Context) {
 	defer liblog.PanicLog()
-	r.GenIFStateReq()
-	for range time.Tick(ifStateFreq) {
-		r.GenIFStateReq()
+	r.GenIFStateReq(ifStateReqAll)
+	ticker := time.NewTicker(ifStateFreq)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.GenIFStateReq(ifStateReqAll)
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
 // GenIFStateReq generates an Interface State request packet to the local
-// beacon service.
-func (r *Router) GenIFStateReq() {
-	dstHost := addr.SvcBS.Multicast()
-	// Pick first local address from topology as source.
-	srcAddr := conf.C.Net.LocAddr[0].PublicAddr()
-	// Create base packet
-	rp, err := rpkt.RtrPktFromScnPkt(&spkt.ScnPkt{
-		DstIA: conf.C.IA, SrcIA: conf.C.IA,
-		DstHost: dstHost, SrcHost: addr.HostFromIP(srcAddr.IP),
-		L4: &l4.UDP{SrcPort: uint16(srcAddr.Port), DstPort: 0},
-	}, rpkt.DirLocal)
+// beacon service, for the given interface (or all of them, see ifStateReqAll).
+func (r *Router) GenIFStateReq(ifid spath.IntfID) {
+	scion, pathMgmt, err := proto.NewPathMgmtMsg()
 	if err != nil {
-		log.Error("Error creating IFState packet", err.Ctx...)
+		log.Error("Error creating PathMgmt payload", err.Ctx...)
+		return
+	}
+	req, cerr := pathMgmt.NewIfStateReq()
+	if cerr != nil {
+		log.Error("Unable to create IFStateReq struct", "err", cerr)
 		return
 	}
-	// Create payload
+	req.SetIfID(uint16(ifid))
+	r.sendToBS(scion, "IFStateReq")
+}
+
+// GenIFStateInfo notifies the local beacon service that the router has
+// detected a change of an interface's state. The notification carries no
+// revocation, as only the beacon service can issue those.
+func (r *Router) GenIFStateInfo(ifid spath.IntfID, active bool) {
 	scion, pathMgmt, err := proto.NewPathMgmtMsg()
 	if err != nil {
 		log.Error("Error creating PathMgmt payload", err.Ctx...)
 		return
 	}
-	_, cerr := pathMgmt.NewIfStateReq()
+	ifStates, cerr := pathMgmt.NewIfStateInfos()
 	if cerr != nil {
-		log.Error("Unable to create IFStateReq struct", "err", cerr)
+		log.Error("Unable to create IFStateInfos struct", "err", cerr)
+		return
+	}
+	infos, cerr := ifStates.NewInfos(1)
+	if cerr != nil {
+		log.Error("Unable to create IFStateInfo list", "err", cerr)
+		return
+	}
+	info := infos.At(0)
+	info.SetIfID(uint16(ifid))
+	info.SetActive(active)
+	r.sendToBS(scion, "IFStateInfos")
+}
+
+// sendToBS sends a control payload to the local beacon service. desc
+// describes the payload for logging.
+func (r *Router) sendToBS(scion *proto.SCION, desc string) {
+	dstHost := addr.SvcBS.Multicast()
+	// Use the control address from the topology as source.
+	c := conf.Get()
+	srcAddr := c.Net.CtrlAddr().PublicAddr()
+	// Create base packet
+	rp, err := rpkt.RtrPktFromScnPkt(&spkt.ScnPkt{
+		DstIA: c.IA, SrcIA: c.IA,
+		DstHost: dstHost, SrcHost: addr.HostFromIP(srcAddr.IP),
+		L4: &l4.UDP{SrcPort: uint16(srcAddr.Port), DstPort: 0},
+	}, rpkt.DirLocal)
+	if err != nil {
+		log.Error("Error creating "+desc+" packet", err.Ctx...)
 		return
 	}
 	rp.SetPld(&spkt.CtrlPld{SCION: scion})
-	_, err = rp.RouteResolveSVCMulti(dstHost, r.locOutFs[0])
+	_, err = rp.RouteResolveSVCMulti(dstHost, rpkt.GetOutputFuncs().Loc[c.Net.CtrlAddrIdx])
 	if err != nil {
-		log.Error("Unable to route IFStateReq packet", err.Ctx...)
+		log.Error("Unable to route "+desc+" packet", err.Ctx...)
 	}
 	rp.Route()
 }
@@ -93,6 +140,11 @@ func (r *Router) ProcessIFStates(ifStates proto.IFStateInfos) {
 		log.Error("Unable to extract IFStateInfos from message", "err", serr)
 		return
 	}
+	states := conf.Get().IFStates
+	states.RLock()
+	oldM := states.M
+	states.RUnlock()
+	now := time.Now()
 	// Convert IFState infos to map
 	m := make(map[spath.IntfID]conf.IFState, infos.Len())
 	for i := 0; i < infos.Len(); i++ {
@@ -108,7 +160,17 @@ func (r *Router) ProcessIFStates(ifStates proto.IFStateInfos) {
 			log.Error("Unable to pack RevInfo", err.Ctx...)
 			return
 		}
-		m[ifid] = conf.IFState{P: info, RawRev: rawRev}
+		state := conf.IFState{P: info, RawRev: rawRev}
+		if !info.Active() {
+			// Keep the time of an existing revocation, so its age can be
+			// reported.
+			state.RevTime = now
+			if old, ok := oldM[ifid]; ok && !old.RevTime.IsZero() &&
+				bytes.Equal(old.RawRev, rawRev) {
+				state.RevTime = old.RevTime
+			}
+		}
+		m[ifid] = state
 		gauge := metrics.IFState.WithLabelValues(fmt.Sprintf("intf:%d", ifid))
 		if info.Active() {
 			gauge.Set(1)
@@ -117,7 +179,7 @@ func (r *Router) ProcessIFStates(ifStates proto.IFStateInfos) {
 		}
 	}
 	// Lock local IFState config for writing, and replace existing map
-	conf.C.IFStates.Lock()
-	conf.C.IFStates.M = m
-	conf.C.IFStates.Unlock()
+	states.Lock()
+	states.M = m
+	states.Unlock()
 }
@@ -0,0 +1,96 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file handles input from network stacks that read batches of packets
+// for several ports at once (e.g. libhsr, see io-hsr.go).
+
+package main
+
+import (
+	"github.com/gavv/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netsec-ethz/scion/go/border/capture"
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/log"
+)
+
+// batchReadF reads up to len(rpkts) packets into rpkts, filling in their
+// metadata, and returns how many were read. The port each packet was received
+// on is marked in usedPorts.
+type batchReadF func(rpkts []*rpkt.RtrPkt, usedPorts []bool) (int, *common.Error)
+
+// readBatchInput reads batches of packets with read, and processes them in
+// place. portLabels holds the metrics labels of each port.
+//
+// In order to have per-port metrics (and to ensure each port metric is only
+// updated once per batch), readBatchInput uses a slice of port IDs to keep
+// track of which metrics need updating.
+//
+// It runs until the router is shutting down, at which point q is closed. read
+// must return periodically (e.g. with a count of 0) for this to be noticed.
+func (r *Router) readBatchInput(read batchReadF, batchSize int,
+	portLabels []prometheus.Labels, q chan *rpkt.RtrPkt) {
+	defer liblog.PanicLog()
+	defer close(q)
+	// Allocate slice of empty packets.
+	rpkts := make([]*rpkt.RtrPkt, batchSize)
+	for i := range rpkts {
+		rpkts[i] = r.getPktBuf()
+	}
+	usedPorts := make([]bool, len(portLabels))
+	for r.ctx.Err() == nil {
+		start := monotime.Now()
+		// Read a batch of packets.
+		count, err := read(rpkts, usedPorts)
+		if err != nil {
+			log.Error("Error getting packets", "err", err)
+			// Zero the port counters for next loop
+			for i := range usedPorts {
+				usedPorts[i] = false
+			}
+			continue
+		}
+		timeIn := monotime.Now()
+		// Iterate over received packets
+		for i := 0; i < count; i++ {
+			rp := rpkts[i]
+			rp.TimeIn = timeIn
+			if c := capture.Get(); c != nil {
+				c.Packet(rp.Ingress.Id, capture.DirIn, rp.Raw, rp.Ingress.Src, rp.Ingress.Dst,
+					"")
+			}
+			// Process packet.
+			r.processPacket(rp)
+			metrics.PktProcessTime.WithLabelValues(rp.Ingress.Id).Observe(
+				monotime.Since(rp.TimeIn).Seconds())
+			// Reset packet.
+			rp.Reset()
+		}
+		// Update port metrics
+		duration := monotime.Since(start).Seconds()
+		for id := range usedPorts {
+			if usedPorts[id] {
+				usedPorts[id] = false
+				labels := portLabels[id]
+				metrics.InputLoops.With(labels).Inc()
+				metrics.InputProcessTime.With(labels).Observe(duration)
+			}
+		}
+	}
+	log.Info("Shutting down, stopping batch input")
+}
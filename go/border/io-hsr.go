@@ -22,76 +22,43 @@ import (
 	"net"
 
 	"github.com/gavv/monotime"
-	log "github.com/inconshreveable/log15"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/netsec-ethz/scion/go/border/capture"
 	"github.com/netsec-ethz/scion/go/border/hsr"
 	"github.com/netsec-ethz/scion/go/border/metrics"
 	"github.com/netsec-ethz/scion/go/border/rpkt"
-	"github.com/netsec-ethz/scion/go/lib/log"
 )
 
 // readHSRInput reads batches of packets from libhsr, and dispatches them for
 // processing.
 //
 // libhsr has the concept of Ports, which correspond to the interfaces it
-// manages. In order to have per-port metrics (and to ensure each port metric
-// is only updated once), readHSRInput uses a map of port IDs to keep track of
-// which metrics need updating.
+// manages. These are used for per-port metrics (see readBatchInput).
+//
+// It runs until the router is shutting down, at which point q is closed.
 // FIXME(kormat): the chan argument is currently unused.
-func (r *Router) readHSRInput(_ chan *rpkt.RtrPkt) {
-	defer liblog.PanicLog()
-	// Allocate slice of empty packets.
-	rpkts := make([]*rpkt.RtrPkt, hsr.MaxPkts)
-	for i := range rpkts {
-		rpkts[i] = r.getPktBuf()
+// N.B. q is closed so that the queue goroutine started for it by Router.Run
+// exits on shutdown. Packets are still processed without going through it.
+func (r *Router) readHSRInput(q chan *rpkt.RtrPkt) {
+	labels := make([]prometheus.Labels, len(hsr.AddrMs))
+	for i := range hsr.AddrMs {
+		labels[i] = hsr.AddrMs[i].Labels
 	}
-	usedPorts := make([]bool, len(hsrAddrMs))
 	h := hsr.NewHSR()
-	// Run forever.
-	for {
-		start := monotime.Now()
-		// Read packets from libhsr.
-		count, err := h.GetPackets(rpkts, usedPorts)
-		if err != nil {
-			log.Error("Error getting packets from HSR", "err", err)
-			// Zero the port counters for next loop
-			for i := range usedPorts {
-				usedPorts[i] = false
-			}
-			continue
-		}
-		timeIn := monotime.Now()
-		// Iterate over received packets
-		for i := 0; i < count; i++ {
-			rp := rpkts[i]
-			rp.TimeIn = timeIn
-			// Process packet.
-			r.processPacket(rp)
-			metrics.PktProcessTime.Add(monotime.Since(rp.TimeIn).Seconds())
-			// Reset packet.
-			rp.Reset()
-		}
-		// Update port metrics
-		duration := monotime.Since(start).Seconds()
-		for id := range usedPorts {
-			if usedPorts[id] {
-				usedPorts[id] = false
-				labels := hsr.AddrMs[id].Labels
-				metrics.InputLoops.With(labels).Inc()
-				metrics.InputProcessTime.With(labels).Add(duration)
-			}
-		}
-	}
+	r.readBatchInput(h.GetPackets, hsr.MaxPkts, labels, q)
 }
 
 // writeHSROutput sends a single output packet via libhsr.
 func (r *Router) writeHSROutput(rp *rpkt.RtrPkt, dst *net.UDPAddr, portID int,
 	labels prometheus.Labels) {
+	if c := capture.Get(); c != nil {
+		c.Packet(labels["id"], capture.DirOut, rp.Raw, hsr.AddrMs[portID].GoAddr, dst, "")
+	}
 	start := monotime.Now()
 	hsr.SendPacket(dst, portID, rp.Raw)
 	duration := monotime.Since(start).Seconds()
-	metrics.OutputProcessTime.With(labels).Add(duration)
+	metrics.OutputProcessTime.With(labels).Observe(duration)
 	metrics.BytesSent.With(labels).Add(float64(len(rp.Raw)))
 	metrics.PktsSent.With(labels).Inc()
 }
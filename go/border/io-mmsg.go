@@ -0,0 +1,236 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux,go1.9
+
+// This file handles IO using batched POSIX(/BSD) socket calls (via the
+// go/border/mmsg package).
+
+package main
+
+import (
+	"net"
+
+	"github.com/gavv/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netsec-ethz/scion/go/border/capture"
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/mmsg"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/log"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+// readMmsgInput is the batched equivalent of readPosixInput. Each call to
+// recvmmsg reads up to -batch packets, which are then dispatched individually.
+// It runs until the socket is closed or the router is shutting down, at which
+// point q is closed too.
+func (r *Router) readMmsgInput(conn *mmsg.Conn, dirFrom rpkt.Dir, ifids []spath.IntfID,
+	labels prometheus.Labels, q chan *rpkt.RtrPkt) {
+	defer liblog.PanicLog()
+	defer close(q)
+	dst := conn.Conn().LocalAddr().(*net.UDPAddr)
+	log.Info("Listening", "addr", dst, "batch", *batchSize)
+	rpkts := make([]*rpkt.RtrPkt, *batchSize)
+	msgs := make([]mmsg.Msg, *batchSize)
+	// Return any unused buffers on exit.
+	defer func() {
+		for _, rp := range rpkts {
+			if rp != nil {
+				r.recyclePkt(rp)
+			}
+		}
+	}()
+	for {
+		metrics.InputLoops.With(labels).Inc()
+		// Replace the buffers of packets dispatched in the previous loop.
+		for i, rp := range rpkts {
+			if rp == nil {
+				rp = r.getPktBuf()
+				rpkts[i] = rp
+			}
+			msgs[i].Buf = rp.Raw
+		}
+		start := monotime.Now()
+		count, err := conn.ReadBatch(msgs)
+		if err != nil {
+			if isClosedErr(err) {
+				log.Info("Socket closed, stopping input", "socket", dst)
+				return
+			}
+			if r.ctx.Err() != nil {
+				log.Info("Shutting down, stopping input", "socket", dst)
+				return
+			}
+			log.Error("Error reading from socket", "socket", dst, "err", err)
+			continue
+		}
+		t := monotime.Since(start).Seconds()
+		metrics.InputProcessTime.With(labels).Observe(t)
+		timeIn := monotime.Now()
+		for i := 0; i < count; i++ {
+			m := &msgs[i]
+			if m.Trunc {
+				// The packet was larger than the buffer, so it can't be
+				// processed. The buffer is reused for the next read.
+				log.Error("Dropping truncated packet", "socket", dst, "src", m.Addr,
+					"len", m.N)
+				countDrop(labels["id"], dropTruncated)
+				continue
+			}
+			rp := rpkts[i]
+			rpkts[i] = nil
+			rp.DirFrom = dirFrom
+			rp.TimeIn = timeIn
+			rp.Raw = rp.Raw[:m.N] // Set the length of the slice
+			rp.Ingress.Dst = dst
+			rp.Ingress.Src = m.Addr
+			rp.Ingress.IfIDs = ifids
+			rp.Ingress.Id = labels["id"]
+			metrics.PktsRecv.With(labels).Inc()
+			metrics.BytesRecv.With(labels).Add(float64(m.N))
+			if c := capture.Get(); c != nil {
+				c.Packet(labels["id"], capture.DirIn, rp.Raw, m.Addr, dst, "")
+			}
+			q <- rp
+		}
+	}
+}
+
+// mmsgWriter sends packets from a single socket in batches. Packets are copied
+// into its own buffers by write (as the caller reuses the packet once the
+// output function returns), and queued for the output goroutine, which sends
+// as many as are available with a single sendmmsg call.
+type mmsgWriter struct {
+	conn   *mmsg.Conn
+	labels prometheus.Labels
+	local  *net.UDPAddr
+	// remote is the fixed destination of a connected socket, if any.
+	remote *net.UDPAddr
+	q      chan mmsg.Msg
+	// free is a leaky buffer list of packet copies.
+	free chan common.RawBytes
+	done chan struct{}
+}
+
+func newMmsgWriter(conn *mmsg.Conn, labels prometheus.Labels,
+	remote *net.UDPAddr) *mmsgWriter {
+	return &mmsgWriter{
+		conn:   conn,
+		labels: labels,
+		local:  conn.Conn().LocalAddr().(*net.UDPAddr),
+		remote: remote,
+		q:      make(chan mmsg.Msg, *batchSize),
+		free:   make(chan common.RawBytes, 2**batchSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// write is the rpkt.OutputFunc for a batched socket. It blocks if the output
+// queue is full.
+func (w *mmsgWriter) write(rp *rpkt.RtrPkt, dst *net.UDPAddr) {
+	if w.remote != nil {
+		dst = w.remote
+	}
+	var b common.RawBytes
+	select {
+	case b = <-w.free:
+	default:
+	}
+	if cap(b) < len(rp.Raw) {
+		b = make(common.RawBytes, rpkt.PktBufSize())
+	}
+	b = b[:len(rp.Raw)]
+	copy(b, rp.Raw)
+	select {
+	case w.q <- mmsg.Msg{Buf: b, Addr: dst}:
+	case <-w.done:
+		rp.Error("Socket removed, dropping packet", "dst", dst)
+		countDrop(w.labels["id"], dropSend)
+	}
+}
+
+// run is the output goroutine. It runs until stop is called, or the socket
+// is closed.
+func (w *mmsgWriter) run() {
+	defer liblog.PanicLog()
+	msgs := make([]mmsg.Msg, 0, cap(w.q))
+	for {
+		select {
+		case m := <-w.q:
+			msgs = append(msgs, m)
+		case <-w.done:
+			return
+		}
+		// Collect any other queued packets, without waiting for more.
+	Collect:
+		for len(msgs) < cap(msgs) {
+			select {
+			case m := <-w.q:
+				msgs = append(msgs, m)
+			default:
+				break Collect
+			}
+		}
+		if closed := w.send(msgs); closed {
+			log.Info("Socket closed, stopping output", "socket", w.local)
+			return
+		}
+		for i := range msgs {
+			select {
+			case w.free <- msgs[i].Buf:
+			default:
+			}
+			msgs[i] = mmsg.Msg{}
+		}
+		msgs = msgs[:0]
+	}
+}
+
+// send writes msgs to the socket. Packets that cannot be sent are logged and
+// skipped. It returns true if the socket has been closed.
+func (w *mmsgWriter) send(msgs []mmsg.Msg) bool {
+	for len(msgs) > 0 {
+		start := monotime.Now()
+		count, err := w.conn.WriteBatch(msgs)
+		// All packets sent by the same call are given the same latency.
+		t := monotime.Since(start).Seconds()
+		for _, m := range msgs[:count] {
+			metrics.OutputProcessTime.With(w.labels).Observe(t)
+			metrics.BytesSent.With(w.labels).Add(float64(len(m.Buf)))
+			metrics.PktsSent.With(w.labels).Inc()
+			if c := capture.Get(); c != nil {
+				c.Packet(w.labels["id"], capture.DirOut, m.Buf, w.local, m.Addr, "")
+			}
+		}
+		msgs = msgs[count:]
+		if err != nil {
+			if isClosedErr(err) {
+				return true
+			}
+			log.Error("Error sending packet", "err", err, "dst", msgs[0].Addr)
+			countDrop(w.labels["id"], dropSend)
+			msgs = msgs[1:]
+		}
+	}
+	return false
+}
+
+// stop terminates the output goroutine. Any packets still queued are dropped.
+func (w *mmsgWriter) stop() {
+	close(w.done)
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux,go1.9
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/border/mmsg"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+func Test_Router_MmsgInputShutdown(t *testing.T) {
+	labels := prometheus.Labels{"id": "loc:0"}
+	Convey("Batched input", t, func() {
+		h := newHarness(t, "br1-11-1")
+		oldBatch := *batchSize
+		*batchSize = 4
+		defer func() { *batchSize = oldBatch }()
+		conn, err := net.ListenUDP("udp4", mustUDPAddr("127.0.0.1:0"))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		mconn, err := mmsg.New(conn, *batchSize)
+		So(err, ShouldBeNil)
+		q := make(chan *rpkt.RtrPkt)
+		go h.r.readMmsgInput(mconn, rpkt.DirLocal, []spath.IntfID{1}, labels, q)
+		// Check that packets are read before stopping input.
+		_, err = conn.WriteToUDP([]byte("packet"), conn.LocalAddr().(*net.UDPAddr))
+		So(err, ShouldBeNil)
+		select {
+		case rp := <-q:
+			So(string(rp.Raw), ShouldEqual, "packet")
+		case <-time.After(time.Second):
+			So("timeout", ShouldBeNil)
+		}
+		Convey("stops when the router shuts down", func() {
+			h.r.Stop()
+			conn.SetReadDeadline(time.Now())
+			So(waitClosed(q), ShouldBeTrue)
+		})
+		Convey("stops when the socket is closed", func() {
+			conn.Close()
+			So(waitClosed(q), ShouldBeTrue)
+		})
+	})
+}
+
+// The benchmarks below measure the router's input path, from reading packets
+// off a loopback socket to queueing them for processing, in rounds of
+// benchBatch packets. Each benchmark op is a single packet. The go/border/mmsg
+// benchmarks measure the system calls alone.
+const (
+	benchBatch   = 32
+	benchPktSize = 128
+)
+
+type benchInputF func(r *Router, conn *net.UDPConn, q chan *rpkt.RtrPkt)
+
+func benchInput(b *testing.B, start benchInputF) {
+	r, err := NewRouter("br1-11-1", "testdata")
+	if err != nil {
+		b.Fatalf("Unable to create router: %v", err)
+	}
+	r.freePkts = make(chan *rpkt.RtrPkt, 2*benchBatch)
+	rconn, lerr := net.ListenUDP("udp4", mustUDPAddr("127.0.0.1:0"))
+	if lerr != nil {
+		b.Fatal(lerr)
+	}
+	wconn, lerr := net.ListenUDP("udp4", mustUDPAddr("127.0.0.1:0"))
+	if lerr != nil {
+		b.Fatal(lerr)
+	}
+	defer wconn.Close()
+	dst := rconn.LocalAddr().(*net.UDPAddr)
+	q := make(chan *rpkt.RtrPkt, benchBatch)
+	start(r, rconn, q)
+	out := make([]byte, benchPktSize)
+	b.SetBytes(benchPktSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += benchBatch {
+		count := benchBatch
+		if b.N-i < count {
+			count = b.N - i
+		}
+		for j := 0; j < count; j++ {
+			if _, err := wconn.WriteToUDP(out, dst); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for j := 0; j < count; j++ {
+			r.recyclePkt(<-q)
+		}
+	}
+	b.StopTimer()
+	rconn.Close()
+	for range q {
+	}
+}
+
+func BenchmarkRouter_PosixInput(b *testing.B) {
+	labels := prometheus.Labels{"id": "loc:0"}
+	benchInput(b, func(r *Router, conn *net.UDPConn, q chan *rpkt.RtrPkt) {
+		go r.readPosixInput(conn, rpkt.DirLocal, []spath.IntfID{1}, labels, q)
+	})
+}
+
+func BenchmarkRouter_MmsgInput(b *testing.B) {
+	labels := prometheus.Labels{"id": "loc:0"}
+	oldBatch := *batchSize
+	*batchSize = benchBatch
+	defer func() { *batchSize = oldBatch }()
+	benchInput(b, func(r *Router, conn *net.UDPConn, q chan *rpkt.RtrPkt) {
+		mconn, err := mmsg.New(conn, *batchSize)
+		if err != nil {
+			b.Fatal(err)
+		}
+		go r.readMmsgInput(mconn, rpkt.DirLocal, []spath.IntfID{1}, labels, q)
+	})
+}
@@ -18,11 +18,13 @@ package main
 
 import (
 	"net"
+	"strings"
 
 	"github.com/gavv/monotime"
 	log "github.com/inconshreveable/log15"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/netsec-ethz/scion/go/border/capture"
 	"github.com/netsec-ethz/scion/go/border/metrics"
 	"github.com/netsec-ethz/scion/go/border/rpkt"
 	"github.com/netsec-ethz/scion/go/lib/common"
@@ -34,31 +36,56 @@ import (
 // buffers via getPktBuf, and fills in some important packet metadata such as
 // the overlay source/destination addresses, the direction the packet came
 // from, and the list of interfaces that it could belong to (as some sockets
-// may be associated with more than one interface).
+// may be associated with more than one interface). It runs until the socket
+// is closed or the router is shutting down, at which point q is closed too.
 func (r *Router) readPosixInput(in *net.UDPConn, dirFrom rpkt.Dir, ifids []spath.IntfID,
 	labels prometheus.Labels, q chan *rpkt.RtrPkt) {
 	defer liblog.PanicLog()
+	defer close(q)
 	log.Info("Listening", "addr", in.LocalAddr())
 	dst := in.LocalAddr().(*net.UDPAddr)
-	for { // Run forever.
+	for {
 		metrics.InputLoops.With(labels).Inc()
 		rp := r.getPktBuf()
 		rp.DirFrom = dirFrom
 		start := monotime.Now()
-		length, src, err := in.ReadFromUDP(rp.Raw)
+		// Read into the whole buffer, including the spare byte (see
+		// RtrPkt.Reset), so that packets larger than the buffer are detected.
+		length, src, err := in.ReadFromUDP(rp.Raw[:cap(rp.Raw)])
 		if err != nil {
+			r.recyclePkt(rp)
+			if isClosedErr(err) {
+				log.Info("Socket closed, stopping input", "socket", dst)
+				return
+			}
+			if r.ctx.Err() != nil {
+				log.Info("Shutting down, stopping input", "socket", dst)
+				return
+			}
 			log.Error("Error reading from socket", "socket", dst, "err", err)
 			continue
 		}
 		t := monotime.Since(start).Seconds()
-		metrics.InputProcessTime.With(labels).Add(t)
+		metrics.InputProcessTime.With(labels).Observe(t)
+		if length > len(rp.Raw) {
+			// The packet was larger than the buffer, so it has been truncated.
+			log.Error("Dropping truncated packet", "socket", dst, "src", src,
+				"len", length)
+			r.recyclePkt(rp)
+			countDrop(labels["id"], dropTruncated)
+			continue
+		}
 		rp.TimeIn = monotime.Now()
 		rp.Raw = rp.Raw[:length] // Set the length of the slice
 		rp.Ingress.Dst = dst
 		rp.Ingress.Src = src
 		rp.Ingress.IfIDs = ifids
+		rp.Ingress.Id = labels["id"]
 		metrics.PktsRecv.With(labels).Inc()
 		metrics.BytesRecv.With(labels).Add(float64(length))
+		if c := capture.Get(); c != nil {
+			c.Packet(labels["id"], capture.DirIn, rp.Raw, src, dst, "")
+		}
 		// TODO(kormat): experiment with performance by calling processPacket directly instead.
 		q <- rp
 	}
@@ -68,18 +95,30 @@ type posixOutputFunc func(common.RawBytes, *net.UDPAddr) (int, error)
 
 // writePosixOutput writes packets to a POSIX(/BSD) socket using the provided
 // function (a wrapper around net.UDPConn.WriteToUDP or net.UDPConn.Write).
-func (r *Router) writePosixOutput(labels prometheus.Labels,
-	rp *rpkt.RtrPkt, dst *net.UDPAddr, f posixOutputFunc) {
+// local is the socket's local address.
+func (r *Router) writePosixOutput(labels prometheus.Labels, rp *rpkt.RtrPkt,
+	local, dst *net.UDPAddr, f posixOutputFunc) {
 	start := monotime.Now()
 	if count, err := f(rp.Raw, dst); err != nil {
 		rp.Error("Error sending packet", "err", err, "dst", dst)
+		countDrop(labels["id"], dropSend)
 		return
 	} else if count != len(rp.Raw) {
 		rp.Error("Unable to write full packet", "len", len(rp.Raw), "written", count)
+		countDrop(labels["id"], dropSend)
 		return
 	}
 	t := monotime.Since(start).Seconds()
-	metrics.OutputProcessTime.With(labels).Add(t)
+	metrics.OutputProcessTime.With(labels).Observe(t)
 	metrics.BytesSent.With(labels).Add(float64(len(rp.Raw)))
 	metrics.PktsSent.With(labels).Inc()
+	if c := capture.Get(); c != nil {
+		c.Packet(labels["id"], capture.DirOut, rp.Raw, local, dst, "")
+	}
+}
+
+// isClosedErr returns true if err was caused by using a closed socket.
+func isClosedErr(err error) bool {
+	// The net package doesn't export an error value for this case.
+	return strings.Contains(err.Error(), "use of closed network connection")
 }
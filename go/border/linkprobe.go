@@ -0,0 +1,368 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file measures the RTT, jitter and loss of the router's links, by
+// periodically sending SCMP echo requests over a one-hop path to the router
+// on the other side of each link. Neighbouring routers answer these like any
+// other echo request addressed to them (see scmpecho.go).
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/log"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+var (
+	probeInterval = flag.Duration("probe.interval", 0,
+		"How often link probes are sent to the neighbouring routers (0 to disable)")
+	probeTimeout = flag.Duration("probe.timeout", 2*time.Second,
+		"How long to wait for a link probe reply before counting the probe as lost")
+	probeWindow = flag.Int("probe.window", 100,
+		"Number of recent link probes the loss ratio is calculated over")
+)
+
+// LinkStats are the measurements of a single link.
+type LinkStats struct {
+	Sent     uint64
+	Received uint64
+	Lost     uint64
+	// Failed is the number of probes that couldn't be sent. They are also
+	// counted as lost.
+	Failed uint64
+	// RTT is the round-trip time of the last probe answered.
+	RTT time.Duration
+	// SRTT is the smoothed round-trip time, as defined in RFC 6298.
+	SRTT time.Duration
+	// Jitter is the smoothed difference between consecutive round-trip times,
+	// as defined in RFC 3550.
+	Jitter time.Duration
+	// Loss is the fraction of the most recent probes that were lost.
+	Loss float64
+}
+
+// linkProbes is the probing state of a single link.
+type linkProbes struct {
+	stats   LinkStats
+	nextSeq uint16
+	// pending maps the sequence numbers of unanswered probes to the time
+	// they were sent.
+	pending map[uint16]time.Time
+	// outcomes is a ring buffer of the most recent probe outcomes (true if
+	// lost), which the loss ratio is calculated over.
+	outcomes []bool
+	next     int
+	full     bool
+}
+
+// record adds a probe outcome, and updates the loss ratio.
+func (l *linkProbes) record(lost bool) {
+	l.outcomes[l.next] = lost
+	l.next++
+	if l.next == len(l.outcomes) {
+		l.next = 0
+		l.full = true
+	}
+	n := l.next
+	if l.full {
+		n = len(l.outcomes)
+	}
+	var nLost int
+	for _, o := range l.outcomes[:n] {
+		if o {
+			nLost++
+		}
+	}
+	l.stats.Loss = float64(nLost) / float64(n)
+}
+
+// linkProber keeps track of the probes sent on each link. It is safe for
+// concurrent use.
+type linkProber struct {
+	// id is the echo ID of the router's probes, to tell replies to them from
+	// replies to other echo requests.
+	id      uint16
+	timeout time.Duration
+	window  int
+	mu      sync.Mutex
+	links   map[spath.IntfID]*linkProbes
+}
+
+func newLinkProber(id uint16, timeout time.Duration, window int) *linkProber {
+	return &linkProber{id: id, timeout: timeout, window: window,
+		links: make(map[spath.IntfID]*linkProbes)}
+}
+
+// newLinkProberFromFlags creates a linkProber using the -probe.* flags.
+func newLinkProberFromFlags() (*linkProber, *common.Error) {
+	if *probeInterval < 0 || *probeTimeout <= 0 || *probeWindow <= 0 {
+		return nil, common.NewError("Invalid link probe flags", "interval", *probeInterval,
+			"timeout", *probeTimeout, "window", *probeWindow)
+	}
+	return newLinkProber(uint16(rand.Uint32()), *probeTimeout, *probeWindow), nil
+}
+
+// link returns the state of a link, creating it if necessary. The lock must
+// be held.
+func (p *linkProber) link(ifid spath.IntfID) *linkProbes {
+	l, ok := p.links[ifid]
+	if !ok {
+		l = &linkProbes{pending: make(map[uint16]time.Time),
+			outcomes: make([]bool, p.window)}
+		p.links[ifid] = l
+	}
+	return l
+}
+
+// sent records a probe sent on ifid at time now, and returns its sequence
+// number.
+func (p *linkProber) sent(ifid spath.IntfID, now time.Time) uint16 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l := p.link(ifid)
+	seq := l.nextSeq
+	l.nextSeq++
+	l.pending[seq] = now
+	l.stats.Sent++
+	return seq
+}
+
+// recv records the reply to probe seq, received on ifid at time now. It
+// returns false if there is no such pending probe, e.g. because it has
+// already timed out.
+func (p *linkProber) recv(ifid spath.IntfID, seq uint16, now time.Time) (LinkStats, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.links[ifid]
+	if !ok {
+		return LinkStats{}, false
+	}
+	sent, ok := l.pending[seq]
+	if !ok {
+		return LinkStats{}, false
+	}
+	delete(l.pending, seq)
+	rtt := now.Sub(sent)
+	s := &l.stats
+	if s.Received == 0 {
+		s.SRTT = rtt
+	} else {
+		s.SRTT += (rtt - s.SRTT) / 8
+		d := rtt - s.RTT
+		if d < 0 {
+			d = -d
+		}
+		s.Jitter += (d - s.Jitter) / 16
+	}
+	s.RTT = rtt
+	s.Received++
+	l.record(false)
+	return *s, true
+}
+
+// failed records that probe seq on ifid couldn't be sent, and counts it as
+// lost.
+func (p *linkProber) failed(ifid spath.IntfID, seq uint16) LinkStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l := p.link(ifid)
+	if _, ok := l.pending[seq]; !ok {
+		return l.stats
+	}
+	delete(l.pending, seq)
+	l.record(true)
+	l.stats.Lost++
+	l.stats.Failed++
+	return l.stats
+}
+
+// expire counts all probes sent more than the timeout before now as lost. It
+// returns the number of probes lost on each link that lost any.
+func (p *linkProber) expire(now time.Time) map[spath.IntfID]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var lost map[spath.IntfID]int
+	for ifid, l := range p.links {
+		n := 0
+		for seq, sent := range l.pending {
+			if now.Sub(sent) > p.timeout {
+				delete(l.pending, seq)
+				l.record(true)
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		l.stats.Lost += uint64(n)
+		if lost == nil {
+			lost = make(map[spath.IntfID]int)
+		}
+		lost[ifid] = n
+	}
+	return lost
+}
+
+// prune removes the probing state of all links not in ifids, e.g. after an
+// interface has been removed by a config reload. It returns the interface IDs
+// of the links removed.
+func (p *linkProber) prune(ifids map[spath.IntfID]bool) []spath.IntfID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var removed []spath.IntfID
+	for ifid := range p.links {
+		if !ifids[ifid] {
+			delete(p.links, ifid)
+			removed = append(removed, ifid)
+		}
+	}
+	return removed
+}
+
+// get returns the current stats of a link.
+func (p *linkProber) get(ifid spath.IntfID) LinkStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l, ok := p.links[ifid]; ok {
+		return l.stats
+	}
+	return LinkStats{}
+}
+
+// ProbeLinks periodically sends link probes on all interfaces that aren't
+// administratively down, until ctx is cancelled.
+func (r *Router) ProbeLinks(ctx context.Context) {
+	defer liblog.PanicLog()
+	ticker := time.NewTicker(*probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			r.expireLinkProbes(now)
+			c := conf.Get()
+			for ifid := range c.Net.IFs {
+				if !c.AdminDown.IsDown(ifid) {
+					r.sendLinkProbe(ifid, now)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendLinkProbe sends an SCMP echo request to the router on the other side
+// of the given interface. It uses a one-hop path, whose second Hop Field is
+// filled in by the neighbouring router.
+func (r *Router) sendLinkProbe(ifid spath.IntfID, now time.Time) {
+	logger := log.New("ifid", ifid)
+	id := fmt.Sprintf("intf:%d", ifid)
+	c := conf.Get()
+	intf := c.Net.IFs[ifid]
+	raw := make(common.RawBytes, spath.InfoFieldLength+2*spath.HopFieldLength)
+	infoF := &spath.InfoField{TsInt: uint32(now.Unix()), ISD: uint16(c.IA.I), Hops: 2}
+	infoF.Write(raw)
+	hopF := spath.NewHopField(raw[spath.InfoFieldLength:], 0, ifid)
+	mac, err := hopF.CalcMac(c.HFGenBlock, infoF.TsInt, nil)
+	if err != nil {
+		logger.Error("Error calculating link probe MAC", err.Ctx...)
+		return
+	}
+	hopF.Mac = mac
+	hopF.Write()
+	ct := scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoRequest}
+	info := &scmp.InfoEcho{Id: r.prober.id, Seq: r.prober.sent(ifid, now)}
+	// fail counts the probe as failed, so that it doesn't wait to time out.
+	fail := func(desc string, err *common.Error) {
+		logger.Error(desc, err.Ctx...)
+		stats := r.prober.failed(ifid, info.Seq)
+		metrics.LinkProbes.WithLabelValues(id, "failed").Inc()
+		metrics.LinkLoss.WithLabelValues(id).Set(stats.Loss)
+	}
+	pld := scmp.PldFromQuotes(ct, info, common.L4None, nil)
+	srcAddr := intf.IFAddr.PublicAddr()
+	rp, err := rpkt.RtrPktFromScnPkt(&spkt.ScnPkt{
+		DstIA: intf.RemoteIA, SrcIA: c.IA,
+		DstHost: addr.HostFromIP(intf.RemoteAddr.IP), SrcHost: addr.HostFromIP(srcAddr.IP),
+		// The current Hop Field is the one the neighbouring router fills in.
+		Path: &spath.Path{Raw: raw, InfOff: 0,
+			HopOff: spath.InfoFieldLength + spath.HopFieldLength},
+		HBHExt: []common.Extension{&spkt.OneHopPath{}},
+		L4:     scmp.NewHdr(ct, pld.Len()),
+		Pld:    pld,
+	}, rpkt.DirExternal)
+	if err != nil {
+		fail("Error creating link probe", err)
+		return
+	}
+	rp.Egress = append(rp.Egress, rpkt.EgressPair{F: rpkt.GetOutputFuncs().Intf[ifid],
+		Dst: intf.RemoteAddr, IfID: ifid})
+	if err := rp.Route(); err != nil {
+		fail("Error sending link probe", err)
+		return
+	}
+	metrics.LinkProbes.WithLabelValues(id, "sent").Inc()
+}
+
+// SCMPEchoReplyCallback is called for SCMP echo replies addressed to the
+// router, and records the replies to link probes.
+func (r *Router) SCMPEchoReplyCallback(rp *rpkt.RtrPkt, info *scmp.InfoEcho) {
+	if info.Id != r.prober.id {
+		rp.Debug("Ignoring SCMP echo reply to unknown request", "id", info.Id)
+		return
+	}
+	ifid, err := rp.IFCurr()
+	if err != nil || ifid == nil {
+		rp.Error("Unable to get interface of link probe reply")
+		return
+	}
+	stats, ok := r.prober.recv(*ifid, info.Seq, time.Now())
+	if !ok {
+		rp.Debug("Ignoring late or unknown link probe reply", "seq", info.Seq)
+		return
+	}
+	id := fmt.Sprintf("intf:%d", *ifid)
+	metrics.LinkProbes.WithLabelValues(id, "received").Inc()
+	metrics.LinkRTT.WithLabelValues(id).Observe(stats.RTT.Seconds())
+	metrics.LinkJitter.WithLabelValues(id).Set(stats.Jitter.Seconds())
+	metrics.LinkLoss.WithLabelValues(id).Set(stats.Loss)
+}
+
+// expireLinkProbes counts the probes that haven't been answered in time as
+// lost.
+func (r *Router) expireLinkProbes(now time.Time) {
+	for ifid, n := range r.prober.expire(now) {
+		id := fmt.Sprintf("intf:%d", ifid)
+		loss := r.prober.get(ifid).Loss
+		log.Debug("Link probes lost", "ifid", ifid, "lost", n, "loss", loss)
+		metrics.LinkProbes.WithLabelValues(id, "lost").Add(float64(n))
+		metrics.LinkLoss.WithLabelValues(id).Set(loss)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file tracks the liveness of the router's links, using the IFID packets
+// received from the neighbouring ISD-ASes as keepalives (see ifid.go). A link
+// is declared down once no IFID packet has been received on it for -ifid.miss
+// intervals, and up again when the next one arrives. The local beacon service
+// is notified of every change with an IFStateInfos message, so that it can
+// revoke a link that is down without waiting for it to time out.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+var ifIDMiss = flag.Int("ifid.miss", 3,
+	"Number of missed IFID intervals after which a link is declared down (0 to disable)")
+
+// LinkState is the state of a link, as determined from IFID keepalives.
+type LinkState int
+
+const (
+	// LinkUnknown means no IFID packet has been received on the link yet.
+	LinkUnknown LinkState = iota
+	LinkUp
+	LinkDown
+)
+
+func (s LinkState) String() string {
+	switch s {
+	case LinkUnknown:
+		return "unknown"
+	case LinkUp:
+		return "up"
+	case LinkDown:
+		return "down"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int(s))
+}
+
+// LinkInfo is the liveness information of a single link.
+type LinkInfo struct {
+	State LinkState
+	// LastSeen is when the last IFID packet was received on the link.
+	LastSeen time.Time
+	// Flaps is the number of times the link has been declared down.
+	Flaps uint64
+}
+
+// linkLiveness tracks the liveness of the router's links. It is safe for
+// concurrent use.
+type linkLiveness struct {
+	// timeout is how long a link may go without an IFID packet before it is
+	// declared down. A timeout of 0 means links are never declared down.
+	timeout time.Duration
+	mu      sync.Mutex
+	links   map[spath.IntfID]*LinkInfo
+}
+
+func newLinkLiveness(timeout time.Duration) *linkLiveness {
+	return &linkLiveness{timeout: timeout, links: make(map[spath.IntfID]*LinkInfo)}
+}
+
+// recv records an IFID packet received on ifid at time now. It returns true
+// if this brought the link up.
+func (l *linkLiveness) recv(ifid spath.IntfID, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	info, ok := l.links[ifid]
+	if !ok {
+		info = &LinkInfo{}
+		l.links[ifid] = info
+	}
+	info.LastSeen = now
+	if info.State == LinkUp {
+		return false
+	}
+	info.State = LinkUp
+	return true
+}
+
+// check declares all links down that haven't received an IFID packet within
+// the timeout, and returns their interface IDs.
+func (l *linkLiveness) check(now time.Time) []spath.IntfID {
+	if l.timeout == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var down []spath.IntfID
+	for ifid, info := range l.links {
+		if info.State == LinkUp && now.Sub(info.LastSeen) > l.timeout {
+			info.State = LinkDown
+			info.Flaps++
+			down = append(down, ifid)
+		}
+	}
+	return down
+}
+
+// get returns the liveness information of a link.
+func (l *linkLiveness) get(ifid spath.IntfID) LinkInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if info, ok := l.links[ifid]; ok {
+		return *info
+	}
+	return LinkInfo{}
+}
+
+// prune removes the liveness information of all links not in ifids, e.g.
+// after an interface has been removed by a config reload. It returns the
+// interface IDs of the links removed.
+func (l *linkLiveness) prune(ifids map[spath.IntfID]bool) []spath.IntfID {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var removed []spath.IntfID
+	for ifid := range l.links {
+		if !ifids[ifid] {
+			delete(l.links, ifid)
+			removed = append(removed, ifid)
+		}
+	}
+	return removed
+}
+
+// newLinkLivenessFromFlags creates a linkLiveness using the -ifid.miss flag.
+func newLinkLivenessFromFlags() (*linkLiveness, *common.Error) {
+	if *ifIDMiss < 0 {
+		return nil, common.NewError("Invalid -ifid.miss value", "value", *ifIDMiss)
+	}
+	return newLinkLiveness(time.Duration(*ifIDMiss) * ifIDFreq), nil
+}
+
+// IFIDCallback is called by the rpkt package for every IFID packet received
+// from a neighbouring ISD-AS.
+func (r *Router) IFIDCallback(ifid spath.IntfID) {
+	now := time.Now()
+	id := fmt.Sprintf("intf:%d", ifid)
+	metrics.IFLinkLastSeen.WithLabelValues(id).Set(float64(now.UnixNano()) / 1e9)
+	if r.liveness.recv(ifid, now) {
+		r.linkChanged(ifid, LinkUp)
+	}
+}
+
+// CheckLinks declares links down that have missed too many IFID packets.
+func (r *Router) CheckLinks(now time.Time) {
+	for _, ifid := range r.liveness.check(now) {
+		metrics.IFLinkFlaps.WithLabelValues(fmt.Sprintf("intf:%d", ifid)).Inc()
+		r.linkChanged(ifid, LinkDown)
+	}
+}
+
+// PruneLinks removes the liveness information and link probe measurements, and
+// the corresponding metrics, of links whose interface is no longer in the
+// configuration.
+func (r *Router) PruneLinks(c *conf.Conf) {
+	ifids := make(map[spath.IntfID]bool, len(c.Net.IFs))
+	for ifid := range c.Net.IFs {
+		ifids[ifid] = true
+	}
+	for _, ifid := range r.liveness.prune(ifids) {
+		id := fmt.Sprintf("intf:%d", ifid)
+		metrics.IFLinkUp.DeleteLabelValues(id)
+		metrics.IFLinkLastSeen.DeleteLabelValues(id)
+		metrics.IFLinkFlaps.DeleteLabelValues(id)
+	}
+	for _, ifid := range r.prober.prune(ifids) {
+		id := fmt.Sprintf("intf:%d", ifid)
+		for _, result := range []string{"sent", "received", "lost", "failed"} {
+			metrics.LinkProbes.DeleteLabelValues(id, result)
+		}
+		metrics.LinkRTT.DeleteLabelValues(id)
+		metrics.LinkJitter.DeleteLabelValues(id)
+		metrics.LinkLoss.DeleteLabelValues(id)
+	}
+}
+
+// linkChanged reports a change of a link's state, and notifies the local
+// beacon service.
+func (r *Router) linkChanged(ifid spath.IntfID, state LinkState) {
+	gauge := metrics.IFLinkUp.WithLabelValues(fmt.Sprintf("intf:%d", ifid))
+	if state == LinkUp {
+		log.Info("Link up", "ifid", ifid)
+		gauge.Set(1)
+	} else {
+		log.Warn("Link down, IFID keepalives missed", "ifid", ifid, "timeout", r.liveness.timeout)
+		gauge.Set(0)
+	}
+	r.GenIFStateInfo(ifid, state == LinkUp)
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package maccache contains a cache of verified Hop Field MACs. A flow uses
+// the same Hop Fields for as long as its path is valid, so caching the result
+// of the MAC verification saves running AES for every packet.
+//
+// Only Hop Fields whose MAC has been verified are added, so the cache can't
+// be filled with forged Hop Fields. Each entry records the name of the key
+// that verified it. A cache must be discarded when the set of keys used to
+// verify the MACs changes.
+package maccache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+const (
+	// DefMaxEntries is the default maximum number of cached Hop Fields.
+	DefMaxEntries = 50000
+	// expireInterval limits how often a full cache is scanned for expired
+	// entries.
+	expireInterval = time.Second
+)
+
+// Key identifies a verified Hop Field. It contains all the inputs of the MAC
+// calculation, as well as the MAC itself.
+type Key struct {
+	// TsInt is the timestamp of the Hop Field's Info Field.
+	TsInt uint32
+	// HopF is the raw Hop Field, including the MAC.
+	HopF [spath.HopFieldLength]byte
+	// Prev is the raw Hop Field the MAC is chained to, excluding the flags.
+	// It's all zeros if there is none.
+	Prev [spath.HopFieldLength - 1]byte
+}
+
+// NewKey creates a Key from the raw Hop Field and previous Hop Field. Both are
+// truncated or zero-padded to their length in Key.
+func NewKey(tsInt uint32, hopF, prev common.RawBytes) Key {
+	k := Key{TsInt: tsInt}
+	copy(k.HopF[:], hopF)
+	copy(k.Prev[:], prev)
+	return k
+}
+
+// entry is a cached verification result.
+type entry struct {
+	expiry time.Time
+	// keyName is the name of the key that verified the MAC.
+	keyName string
+}
+
+// Cache is a bounded cache of verified Hop Fields, each of which expires along
+// with the Hop Field. It is safe for concurrent use.
+type Cache struct {
+	// MaxEntries limits the number of cached entries. If it is reached and no
+	// entries have expired, new entries are not cached.
+	MaxEntries int
+	mu         sync.RWMutex
+	entries    map[Key]entry
+	lastExpire time.Time
+}
+
+// New creates a cache with the default size limit.
+func New() *Cache {
+	return &Cache{MaxEntries: DefMaxEntries, entries: make(map[Key]entry)}
+}
+
+// Get returns true if k is cached and hasn't expired, along with the name of
+// the key that verified it.
+func (c *Cache) Get(k Key, now time.Time) (string, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[k]
+	c.mu.RUnlock()
+	if !ok || !now.Before(e.expiry) {
+		return "", false
+	}
+	return e.keyName, true
+}
+
+// Add caches k, as verified by the key called keyName, until expiry. This
+// must be no later than the expiry of both the Hop Field and the key.
+func (c *Cache) Add(k Key, keyName string, expiry, now time.Time) {
+	if !now.Before(expiry) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.MaxEntries && now.Sub(c.lastExpire) >= expireInterval {
+		c.expire(now)
+	}
+	if len(c.entries) < c.MaxEntries {
+		c.entries[k] = entry{expiry: expiry, keyName: keyName}
+	}
+}
+
+// Len returns the number of cached entries, including expired ones that
+// haven't been removed yet.
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// expire removes all expired entries.
+func (c *Cache) expire(now time.Time) {
+	c.lastExpire = now
+	for k, e := range c.entries {
+		if !now.Before(e.expiry) {
+			delete(c.entries, k)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maccache
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+// get returns true if k is cached, ignoring the name of the key.
+func get(c *Cache, k Key, now time.Time) bool {
+	_, ok := c.Get(k, now)
+	return ok
+}
+
+func Test_Cache(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	hopF := common.RawBytes{0, 63, 0, 0x10, 0x02, 0xaa, 0xbb, 0xcc}
+	prev := common.RawBytes{63, 0, 0x20, 0x01, 0xdd, 0xee, 0xff}
+	k1 := NewKey(1000, hopF, prev)
+	k2 := NewKey(1001, hopF, prev)
+	k3 := NewKey(1000, hopF, nil)
+	Convey("Entries are cached until they expire", t, func() {
+		c := New()
+		So(get(c, k1, now), ShouldBeFalse)
+		c.Add(k1, "current", now.Add(time.Minute), now)
+		So(get(c, k1, now), ShouldBeTrue)
+		So(get(c, k1, now.Add(time.Minute-time.Millisecond)), ShouldBeTrue)
+		So(get(c, k1, now.Add(time.Minute)), ShouldBeFalse)
+		Convey("The verifying key is recorded", func() {
+			c.Add(k2, "previous", now.Add(time.Minute), now)
+			name, _ := c.Get(k1, now)
+			So(name, ShouldEqual, "current")
+			name, _ = c.Get(k2, now)
+			So(name, ShouldEqual, "previous")
+		})
+		Convey("All MAC inputs are part of the key", func() {
+			So(get(c, k2, now), ShouldBeFalse)
+			So(get(c, k3, now), ShouldBeFalse)
+		})
+	})
+	Convey("Expired entries aren't added", t, func() {
+		c := New()
+		c.Add(k1, "current", now, now)
+		So(c.Len(), ShouldEqual, 0)
+	})
+	Convey("The cache is bounded", t, func() {
+		c := New()
+		c.MaxEntries = 2
+		c.Add(k1, "current", now.Add(time.Second), now)
+		c.Add(k2, "current", now.Add(time.Minute), now)
+		Convey("New entries aren't cached while the cache is full", func() {
+			c.Add(k3, "current", now.Add(time.Minute), now)
+			So(get(c, k3, now), ShouldBeFalse)
+			So(c.Len(), ShouldEqual, 2)
+		})
+		Convey("Expired entries make room", func() {
+			later := now.Add(expireInterval)
+			c.Add(k3, "current", now.Add(time.Minute), later)
+			So(get(c, k3, later), ShouldBeTrue)
+			So(get(c, k2, later), ShouldBeTrue)
+			So(c.Len(), ShouldEqual, 2)
+		})
+	})
+}
+
+func BenchmarkCache_Get(b *testing.B) {
+	now := time.Now()
+	c := New()
+	keys := make([]Key, 1000)
+	for i := range keys {
+		keys[i] = NewKey(uint32(i), common.RawBytes{0, 63, 0, 0x10, 0x02}, nil)
+		c.Add(keys[i], "current", now.Add(time.Hour), now)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(keys[i%len(keys)], now)
+			i++
+		}
+	})
+}
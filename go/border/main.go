@@ -26,6 +26,7 @@ import (
 
 	log "github.com/inconshreveable/log15"
 
+	"github.com/netsec-ethz/scion/go/border/capture"
 	liblog "github.com/netsec-ethz/scion/go/lib/log"
 	"github.com/netsec-ethz/scion/go/lib/profile"
 )
@@ -49,28 +50,59 @@ func main() {
 		// Start profiling if requested.
 		profile.Start(*id)
 	}
-	setupSignals()
 	r, err := NewRouter(*id, *confDir)
 	if err != nil {
 		log.Crit("Startup failed", err.Ctx...)
 		os.Exit(1)
 	}
+	setupSignals(r)
+	setupReload(r)
+	if *adminAddr != "" {
+		if err := r.setupAdmin(*adminAddr); err != nil {
+			log.Crit("Admin API setup failed", err.Ctx...)
+			os.Exit(1)
+		}
+	}
 	log.Info("Starting up", "id", *id)
 	if err := r.Run(); err != nil {
 		log.Crit("Run failed", err.Ctx...)
 		os.Exit(1)
 	}
+	log.Info("Exiting")
+	capture.Stop()
+	profile.Stop()
+	liblog.Flush()
 }
 
-func setupSignals() {
-	sig := make(chan os.Signal)
+// setupSignals shuts the router down gracefully when SIGINT or SIGTERM is
+// received. A second signal causes an immediate exit.
+func setupSignals(r *Router) {
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
 	signal.Notify(sig, syscall.SIGTERM)
 	go func() {
+		defer liblog.PanicLog()
+		<-sig
+		r.Stop()
 		<-sig
-		log.Info("Exiting")
+		log.Info("Exiting immediately")
 		profile.Stop()
 		liblog.Flush()
 		os.Exit(1)
 	}()
 }
+
+// setupReload reloads the router config whenever SIGHUP is received.
+func setupReload(r *Router) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		defer liblog.PanicLog()
+		for range sig {
+			log.Info("Reloading config")
+			if err := r.ReloadConf(); err != nil {
+				log.Error("Unable to reload config", err.Ctx...)
+			}
+		}
+	}()
+}
@@ -23,6 +23,18 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// latencyBuckets are the histogram buckets used for per-packet latencies,
+// ranging from 1us to ~0.5s.
+var latencyBuckets = prometheus.ExponentialBuckets(1e-6, 2, 20)
+
+// inputBuckets are the histogram buckets used for input read times, ranging
+// from 1us to ~16s. Reads block until a packet arrives, so on idle links they
+// take much longer than processing a packet.
+var inputBuckets = prometheus.ExponentialBuckets(1e-6, 4, 13)
+
+// rttBuckets range from 100µs to ~1.6s, for inter-AS round-trip times.
+var rttBuckets = prometheus.ExponentialBuckets(1e-4, 2, 15)
+
 // Declare prometheus metrics to export.
 var (
 	PktsRecv = prometheus.NewCounterVec(
@@ -72,11 +84,32 @@ var (
 		Name:      "pbuf_discarded_total",
 		Help:      "Number of packet buffers discarded.",
 	})
-	PktProcessTime = prometheus.NewCounter(prometheus.CounterOpts{
-		Namespace: "border",
-		Name:      "pkt_process_seconds",
-		Help:      "Packet processing time.",
-	})
+	PktsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "pkts_dropped_total",
+			Help:      "Number of packets dropped, by SCMP class/type or internal reason.",
+		},
+		[]string{"id", "reason"},
+	)
+	PktProcessTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "border",
+			Name:      "pkt_process_seconds",
+			Help:      "Time from a packet being received until its processing is finished.",
+			Buckets:   latencyBuckets,
+		},
+		[]string{"id"},
+	)
+	InputLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "border",
+			Name:      "input_latency_seconds",
+			Help:      "Time from a packet being received until its processing starts.",
+			Buckets:   latencyBuckets,
+		},
+		[]string{"id"},
+	)
 	IFState = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: "border",
@@ -85,6 +118,151 @@ var (
 		},
 		[]string{"id"},
 	)
+	IFAdminDown = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "border",
+			Name:      "interface_admin_down",
+			Help:      "Interface is administratively down.",
+		},
+		[]string{"id"},
+	)
+	IFLinkUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "border",
+			Name:      "interface_link_up",
+			Help:      "Link is up, according to the IFID keepalives received.",
+		},
+		[]string{"id"},
+	)
+	IFLinkLastSeen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "border",
+			Name:      "interface_keepalive_last_seen_seconds",
+			Help:      "Unix time the last IFID keepalive was received.",
+		},
+		[]string{"id"},
+	)
+	IFLinkFlaps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "interface_link_flaps_total",
+			Help:      "Number of times a link was declared down due to missed IFID keepalives.",
+		},
+		[]string{"id"},
+	)
+	LinkProbes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "link_probes_total",
+			Help:      "Number of link probes, by result (sent/received/lost/failed).",
+		},
+		[]string{"id", "result"},
+	)
+	LinkRTT = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "border",
+			Name:      "link_rtt_seconds",
+			Help:      "Round-trip time of link probes to the neighbouring router.",
+			Buckets:   rttBuckets,
+		},
+		[]string{"id"},
+	)
+	LinkJitter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "border",
+			Name:      "link_jitter_seconds",
+			Help:      "Smoothed variation of the link probe round-trip time.",
+		},
+		[]string{"id"},
+	)
+	LinkLoss = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "border",
+			Name:      "link_loss_ratio",
+			Help:      "Fraction of the recent link probes that were lost.",
+		},
+		[]string{"id"},
+	)
+	SCMPErrSuppressed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "scmp_err_suppressed_total",
+			Help:      "Number of SCMP error replies suppressed by rate limits.",
+		},
+		[]string{"ifid", "limit", "class_type"},
+	)
+	SCMPEchoRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "scmp_echo_requests_total",
+			Help:      "Number of SCMP echo requests addressed to the router, by result.",
+		},
+		[]string{"result"},
+	)
+	RevInfos = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "revinfos_total",
+			Help:      "Number of revocations received, by result.",
+		},
+		[]string{"result"},
+	)
+	RevInfoVerifyFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "revinfo_verify_failures_total",
+			Help:      "Number of revocations dropped because they failed verification, by reason.",
+		},
+		[]string{"reason"},
+	)
+	SVCDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "svc_anycast_deliveries_total",
+			Help:      "Number of packets to anycast SVC addresses, by service instance.",
+		},
+		[]string{"instance"},
+	)
+	ACLHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "acl_hits_total",
+			Help:      "Number of packets decided by each ACL rule.",
+		},
+		[]string{"rule", "action"},
+	)
+	MACCacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "mac_cache_lookups_total",
+			Help:      "Number of Hop Field MAC cache lookups, by result (hit/miss).",
+		},
+		[]string{"result"},
+	)
+	HopFVerified = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "hopf_mac_verified_total",
+			Help:      "Number of Hop Fields verified, by key (current/previous/next).",
+		},
+		[]string{"key"},
+	)
+	WorkerQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "border",
+			Name:      "worker_queue_depth",
+			Help:      "Number of packets queued for each worker.",
+		},
+		[]string{"worker"},
+	)
+	WorkerDrops = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "border",
+			Name:      "worker_drops_total",
+			Help:      "Number of packets dropped because a worker's queue was full.",
+		},
+		[]string{"worker"},
+	)
 	InputLoops = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "border",
@@ -93,19 +271,21 @@ var (
 		},
 		[]string{"id"},
 	)
-	InputProcessTime = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
+	InputProcessTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
 			Namespace: "border",
 			Name:      "input_process_seconds",
-			Help:      "Input processing time.",
+			Help:      "Time taken by each input loop to read packets.",
+			Buckets:   inputBuckets,
 		},
 		[]string{"id"},
 	)
-	OutputProcessTime = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
+	OutputProcessTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
 			Namespace: "border",
 			Name:      "output_process_seconds",
-			Help:      "Output processing time.",
+			Help:      "Time taken to send a packet.",
+			Buckets:   latencyBuckets,
 		},
 		[]string{"id"},
 	)
@@ -120,8 +300,28 @@ func init() {
 	prometheus.MustRegister(PktBufNew)
 	prometheus.MustRegister(PktBufReuse)
 	prometheus.MustRegister(PktBufDiscard)
+	prometheus.MustRegister(PktsDropped)
 	prometheus.MustRegister(PktProcessTime)
+	prometheus.MustRegister(InputLatency)
 	prometheus.MustRegister(IFState)
+	prometheus.MustRegister(IFAdminDown)
+	prometheus.MustRegister(IFLinkUp)
+	prometheus.MustRegister(IFLinkLastSeen)
+	prometheus.MustRegister(IFLinkFlaps)
+	prometheus.MustRegister(LinkProbes)
+	prometheus.MustRegister(LinkRTT)
+	prometheus.MustRegister(LinkJitter)
+	prometheus.MustRegister(LinkLoss)
+	prometheus.MustRegister(SCMPErrSuppressed)
+	prometheus.MustRegister(SCMPEchoRequests)
+	prometheus.MustRegister(RevInfos)
+	prometheus.MustRegister(RevInfoVerifyFailures)
+	prometheus.MustRegister(SVCDeliveries)
+	prometheus.MustRegister(ACLHits)
+	prometheus.MustRegister(MACCacheLookups)
+	prometheus.MustRegister(HopFVerified)
+	prometheus.MustRegister(WorkerQueueDepth)
+	prometheus.MustRegister(WorkerDrops)
 	prometheus.MustRegister(InputLoops)
 	prometheus.MustRegister(InputProcessTime)
 	prometheus.MustRegister(OutputProcessTime)
@@ -0,0 +1,298 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux,go1.9
+
+// Package mmsg implements batched reading and writing of UDP packets, using
+// the Linux recvmmsg(2) and sendmmsg(2) system calls. This allows a single
+// system call to transfer many packets, which considerably reduces the
+// per-packet overhead compared to net.UDPConn.ReadFromUDP/WriteToUDP.
+//
+// It requires Go 1.9 or later (for net.UDPConn.SyscallConn); with older
+// versions, the package is empty.
+package mmsg
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+// Msg describes a single packet to be read or written.
+type Msg struct {
+	// Buf is the packet buffer. When reading, its length is the maximum
+	// packet size that can be received. When writing, it is the packet to send.
+	Buf common.RawBytes
+	// N is the number of bytes read into Buf.
+	N int
+	// Trunc is set if a received packet was larger than Buf, and has been
+	// truncated to N = len(Buf) bytes.
+	Trunc bool
+	// Addr is the source address of a received packet, or the destination
+	// address of a packet to be sent. It is ignored when sending on a
+	// connected socket.
+	Addr *net.UDPAddr
+}
+
+// mmsghdr mirrors struct mmsghdr from <sys/socket.h>. Go's struct layout rules
+// add the same trailing padding as C does on 64-bit platforms.
+type mmsghdr struct {
+	Hdr unix.Msghdr
+	Len uint32
+}
+
+// batch holds the kernel-facing structures for one direction of a Conn.
+type batch struct {
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrAny
+}
+
+func newBatch(size int) batch {
+	return batch{
+		hdrs:  make([]mmsghdr, size),
+		iovs:  make([]unix.Iovec, size),
+		names: make([]unix.RawSockaddrAny, size),
+	}
+}
+
+// Conn wraps a net.UDPConn to provide batched IO. ReadBatch and WriteBatch
+// can be called concurrently with each other, but neither may be called
+// concurrently with itself.
+type Conn struct {
+	conn      *net.UDPConn
+	rc        syscall.RawConn
+	v4        bool
+	connected bool
+	rb        batch
+	wb        batch
+}
+
+// New creates a Conn for conn, which transfers at most size packets per
+// system call.
+func New(conn *net.UDPConn, size int) (*Conn, error) {
+	if size < 1 {
+		return nil, common.NewError("Invalid batch size", "size", size)
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{conn: conn, rc: rc, connected: conn.RemoteAddr() != nil,
+		rb: newBatch(size), wb: newBatch(size)}
+	// The socket's address family determines how destination addresses have
+	// to be encoded.
+	var sa unix.Sockaddr
+	cerr := rc.Control(func(fd uintptr) {
+		sa, err = unix.Getsockname(int(fd))
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+	if err != nil {
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	_, c.v4 = sa.(*unix.SockaddrInet4)
+	return c, nil
+}
+
+// Conn returns the underlying net.UDPConn.
+func (c *Conn) Conn() *net.UDPConn {
+	return c.conn
+}
+
+// ReadBatch reads up to len(msgs) packets (limited to the batch size), and
+// blocks until at least one is available. It returns the number of packets
+// read, and sets N, Trunc and Addr for each of them.
+func (c *Conn) ReadBatch(msgs []Msg) (int, error) {
+	b := &c.rb
+	if len(msgs) > len(b.hdrs) {
+		msgs = msgs[:len(b.hdrs)]
+	}
+	for i := range msgs {
+		b.iovs[i].Base = &msgs[i].Buf[0]
+		b.iovs[i].SetLen(len(msgs[i].Buf))
+		h := &b.hdrs[i].Hdr
+		h.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		h.Namelen = unix.SizeofSockaddrAny
+		h.Iov = &b.iovs[i]
+		h.Iovlen = 1
+	}
+	n, err := c.mmsg(unix.SYS_RECVMMSG, b.hdrs[:len(msgs)], false)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		msgs[i].N = int(b.hdrs[i].Len)
+		msgs[i].Trunc = b.hdrs[i].Hdr.Flags&unix.MSG_TRUNC != 0
+		msgs[i].Addr = sockaddrToUDP(&b.names[i])
+	}
+	return n, nil
+}
+
+// WriteBatch writes the packets in msgs, using as few system calls as
+// possible. It returns the number of packets written; if this is less than
+// len(msgs), the error describes why msgs[n] could not be sent.
+func (c *Conn) WriteBatch(msgs []Msg) (int, error) {
+	b := &c.wb
+	sent := 0
+	for sent < len(msgs) {
+		todo := msgs[sent:]
+		if len(todo) > len(b.hdrs) {
+			todo = todo[:len(b.hdrs)]
+		}
+		for i := range todo {
+			b.iovs[i].Base = &todo[i].Buf[0]
+			b.iovs[i].SetLen(len(todo[i].Buf))
+			h := &b.hdrs[i].Hdr
+			h.Name = nil
+			h.Namelen = 0
+			if !c.connected {
+				l, err := udpToSockaddr(todo[i].Addr, &b.names[i], c.v4)
+				if err != nil {
+					return sent + i, err
+				}
+				h.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+				h.Namelen = l
+			}
+			h.Iov = &b.iovs[i]
+			h.Iovlen = 1
+		}
+		n, err := c.mmsg(unix.SYS_SENDMMSG, b.hdrs[:len(todo)], true)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// mmsg calls recvmmsg/sendmmsg, waiting for the socket to become readable or
+// writable as necessary.
+func (c *Conn) mmsg(trap uintptr, hdrs []mmsghdr, write bool) (int, error) {
+	var n int
+	var errno syscall.Errno
+	f := func(fd uintptr) bool {
+		r, _, e := unix.Syscall6(trap, fd, uintptr(unsafe.Pointer(&hdrs[0])),
+			uintptr(len(hdrs)), 0, 0, 0)
+		if e == unix.EAGAIN || e == unix.EINTR {
+			return false
+		}
+		n, errno = int(r), e
+		return true
+	}
+	var err error
+	if write {
+		err = c.rc.Write(f)
+	} else {
+		err = c.rc.Read(f)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		name := "recvmmsg"
+		if write {
+			name = "sendmmsg"
+		}
+		return 0, os.NewSyscallError(name, errno)
+	}
+	return n, nil
+}
+
+func sockaddrToUDP(rsa *unix.RawSockaddrAny) *net.UDPAddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, sa.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: ntohs(sa.Port)}
+	case unix.AF_INET6:
+		sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: ntohs(sa.Port), Zone: zoneName(sa.Scope_id)}
+	}
+	return nil
+}
+
+// udpToSockaddr encodes a into rsa, using an IPv4 or IPv6 socket address
+// depending on the socket's address family. It returns the length of the
+// encoded address.
+func udpToSockaddr(a *net.UDPAddr, rsa *unix.RawSockaddrAny, v4 bool) (uint32, error) {
+	if a == nil {
+		return 0, common.NewError("Missing destination address")
+	}
+	if v4 {
+		ip := a.IP.To4()
+		if ip == nil {
+			return 0, common.NewError("Non-IPv4 destination on IPv4 socket", "addr", a)
+		}
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		*sa = unix.RawSockaddrInet4{Family: unix.AF_INET}
+		sa.Port = htons(a.Port)
+		copy(sa.Addr[:], ip)
+		return unix.SizeofSockaddrInet4, nil
+	}
+	ip := a.IP.To16()
+	if ip == nil {
+		return 0, common.NewError("Invalid destination address", "addr", a)
+	}
+	sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+	*sa = unix.RawSockaddrInet6{Family: unix.AF_INET6}
+	sa.Port = htons(a.Port)
+	copy(sa.Addr[:], ip)
+	if a.Zone != "" {
+		sa.Scope_id = zoneIndex(a.Zone)
+	}
+	return unix.SizeofSockaddrInet6, nil
+}
+
+// ntohs converts a port from network byte order, as stored in a sockaddr.
+func ntohs(port uint16) int {
+	p := (*[2]byte)(unsafe.Pointer(&port))
+	return int(p[0])<<8 | int(p[1])
+}
+
+// htons converts a port to network byte order, for storing in a sockaddr.
+func htons(port int) uint16 {
+	var n uint16
+	p := (*[2]byte)(unsafe.Pointer(&n))
+	p[0], p[1] = byte(port>>8), byte(port)
+	return n
+}
+
+func zoneName(idx uint32) string {
+	if idx == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(int(idx)); err == nil {
+		return ifi.Name
+	}
+	return strconv.Itoa(int(idx))
+}
+
+func zoneIndex(zone string) uint32 {
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return uint32(ifi.Index)
+	}
+	idx, _ := strconv.Atoi(zone)
+	return uint32(idx)
+}
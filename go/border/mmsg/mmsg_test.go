@@ -0,0 +1,222 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux,go1.9
+
+package mmsg
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+const (
+	benchBatch   = 32
+	benchPktSize = 128
+)
+
+func listen(t testing.TB, network, addr string) *net.UDPConn {
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: net.ParseIP(addr)})
+	if err != nil {
+		t.Fatalf("Unable to listen on %s: %v", addr, err)
+	}
+	return conn
+}
+
+func mkMsgs(count, size int) []Msg {
+	msgs := make([]Msg, count)
+	for i := range msgs {
+		msgs[i].Buf = make(common.RawBytes, size)
+	}
+	return msgs
+}
+
+func Test_Conn(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		addr := "127.0.0.1"
+		if network == "udp6" {
+			addr = "::1"
+		}
+		Convey(fmt.Sprintf("Batched IO over %s loopback", network), t, func() {
+			rconn := listen(t, network, addr)
+			defer rconn.Close()
+			wconn := listen(t, network, addr)
+			defer wconn.Close()
+			r, err := New(rconn, 8)
+			So(err, ShouldBeNil)
+			w, err := New(wconn, 4)
+			So(err, ShouldBeNil)
+			dst := rconn.LocalAddr().(*net.UDPAddr)
+			// More messages than the writer's batch size, to exercise splitting.
+			out := make([]Msg, 6)
+			for i := range out {
+				out[i] = Msg{Buf: common.RawBytes(fmt.Sprintf("packet %d", i)), Addr: dst}
+			}
+			n, err := w.WriteBatch(out)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(out))
+			in := mkMsgs(8, 64)
+			read := 0
+			for read < len(out) {
+				n, err := r.ReadBatch(in[read:])
+				So(err, ShouldBeNil)
+				read += n
+			}
+			for i := range out {
+				So(string(in[i].Buf[:in[i].N]), ShouldEqual, string(out[i].Buf))
+				So(in[i].Addr.String(), ShouldEqual, wconn.LocalAddr().String())
+			}
+		})
+	}
+	Convey("Batched IO on a connected socket ignores the destination address", t, func() {
+		rconn := listen(t, "udp4", "127.0.0.1")
+		defer rconn.Close()
+		wconn, err := net.DialUDP("udp4", nil, rconn.LocalAddr().(*net.UDPAddr))
+		So(err, ShouldBeNil)
+		defer wconn.Close()
+		w, err := New(wconn, 4)
+		So(err, ShouldBeNil)
+		n, err := w.WriteBatch([]Msg{{Buf: common.RawBytes("hello")}})
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+		buf := make([]byte, 64)
+		n, src, err := rconn.ReadFromUDP(buf)
+		So(err, ShouldBeNil)
+		So(string(buf[:n]), ShouldEqual, "hello")
+		So(src.String(), ShouldEqual, wconn.LocalAddr().String())
+	})
+	Convey("Writing an IPv6 destination on an IPv4 socket fails", t, func() {
+		conn := listen(t, "udp4", "127.0.0.1")
+		defer conn.Close()
+		c, err := New(conn, 4)
+		So(err, ShouldBeNil)
+		dst := &net.UDPAddr{IP: net.ParseIP("::1"), Port: 50000}
+		n, err := c.WriteBatch([]Msg{{Buf: common.RawBytes("hello"), Addr: dst}})
+		So(err, ShouldNotBeNil)
+		So(n, ShouldEqual, 0)
+	})
+	Convey("Truncated packets are flagged", t, func() {
+		rconn := listen(t, "udp4", "127.0.0.1")
+		defer rconn.Close()
+		wconn := listen(t, "udp4", "127.0.0.1")
+		defer wconn.Close()
+		r, err := New(rconn, 4)
+		So(err, ShouldBeNil)
+		dst := rconn.LocalAddr().(*net.UDPAddr)
+		for _, size := range []int{16, 100} {
+			_, err := wconn.WriteToUDP(make([]byte, size), dst)
+			So(err, ShouldBeNil)
+		}
+		in := mkMsgs(4, 64)
+		read := 0
+		for read < 2 {
+			n, err := r.ReadBatch(in[read:])
+			So(err, ShouldBeNil)
+			read += n
+		}
+		So(in[0].N, ShouldEqual, 16)
+		So(in[0].Trunc, ShouldBeFalse)
+		So(in[1].N, ShouldEqual, 64)
+		So(in[1].Trunc, ShouldBeTrue)
+	})
+	Convey("Reading from a closed socket fails", t, func() {
+		conn := listen(t, "udp4", "127.0.0.1")
+		c, err := New(conn, 4)
+		So(err, ShouldBeNil)
+		conn.Close()
+		_, err = c.ReadBatch(mkMsgs(4, 64))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// The benchmarks below send packets over loopback and read them back, in
+// rounds of benchBatch packets. Each benchmark op is a single packet, so
+// packets/sec = 1e9 / (ns/op). BenchmarkPosix measures the one-syscall-per-
+// packet net.UDPConn API that the router uses by default, and BenchmarkBatch
+// measures recvmmsg/sendmmsg.
+
+func benchConns(b *testing.B) (*net.UDPConn, *net.UDPConn, *net.UDPAddr) {
+	rconn := listen(b, "udp4", "127.0.0.1")
+	wconn := listen(b, "udp4", "127.0.0.1")
+	return rconn, wconn, rconn.LocalAddr().(*net.UDPAddr)
+}
+
+func BenchmarkPosix(b *testing.B) {
+	rconn, wconn, dst := benchConns(b)
+	defer rconn.Close()
+	defer wconn.Close()
+	out := make([]byte, benchPktSize)
+	in := make([]byte, benchPktSize)
+	b.SetBytes(benchPktSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += benchBatch {
+		count := min(benchBatch, b.N-i)
+		for j := 0; j < count; j++ {
+			if _, err := wconn.WriteToUDP(out, dst); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for j := 0; j < count; j++ {
+			if _, _, err := rconn.ReadFromUDP(in); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkBatch(b *testing.B) {
+	rconn, wconn, dst := benchConns(b)
+	defer rconn.Close()
+	defer wconn.Close()
+	r, err := New(rconn, benchBatch)
+	if err != nil {
+		b.Fatal(err)
+	}
+	w, err := New(wconn, benchBatch)
+	if err != nil {
+		b.Fatal(err)
+	}
+	out := mkMsgs(benchBatch, benchPktSize)
+	for i := range out {
+		out[i].Addr = dst
+	}
+	in := mkMsgs(benchBatch, benchPktSize)
+	b.SetBytes(benchPktSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += benchBatch {
+		count := min(benchBatch, b.N-i)
+		if _, err := w.WriteBatch(out[:count]); err != nil {
+			b.Fatal(err)
+		}
+		for read := 0; read < count; {
+			n, err := r.ReadBatch(in[read:count])
+			if err != nil {
+				b.Fatal(err)
+			}
+			read += n
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file handles comparing network configurations, for use when reloading
+// the router configuration.
+
+package netconf
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+// Diff describes the sockets that have to be closed and opened to get from
+// one NetConf to another. A local address or interface whose socket needs to
+// be recreated (e.g. because its address changed) is listed both as deleted
+// and as added.
+type Diff struct {
+	// LocDel lists the indices of local addresses to remove, in the old
+	// NetConf.
+	LocDel []int
+	// LocAdd lists the indices of local addresses to add, in the new NetConf.
+	LocAdd []int
+	// IFDel lists the interface IDs to remove.
+	IFDel []spath.IntfID
+	// IFAdd lists the interface IDs to add.
+	IFAdd []spath.IntfID
+}
+
+// NewDiff compares two NetConfs.
+func NewDiff(oldN, newN *NetConf) *Diff {
+	d := &Diff{}
+	for i := 0; i < len(oldN.LocAddr) || i < len(newN.LocAddr); i++ {
+		if i < len(oldN.LocAddr) && i < len(newN.LocAddr) && locEq(oldN, newN, i) {
+			continue
+		}
+		if i < len(oldN.LocAddr) {
+			d.LocDel = append(d.LocDel, i)
+		}
+		if i < len(newN.LocAddr) {
+			d.LocAdd = append(d.LocAdd, i)
+		}
+	}
+	for ifid, oldIntf := range oldN.IFs {
+		newIntf, ok := newN.IFs[ifid]
+		if !ok || !oldIntf.sockEq(newIntf) {
+			d.IFDel = append(d.IFDel, ifid)
+		}
+	}
+	for ifid, newIntf := range newN.IFs {
+		oldIntf, ok := oldN.IFs[ifid]
+		if !ok || !oldIntf.sockEq(newIntf) {
+			d.IFAdd = append(d.IFAdd, ifid)
+		}
+	}
+	SortIFIDs(d.IFDel)
+	SortIFIDs(d.IFAdd)
+	return d
+}
+
+// Empty returns true if no sockets need to be changed.
+func (d *Diff) Empty() bool {
+	return len(d.LocDel) == 0 && len(d.LocAdd) == 0 && len(d.IFDel) == 0 && len(d.IFAdd) == 0
+}
+
+func (d *Diff) String() string {
+	return fmt.Sprintf("LocDel: %v LocAdd: %v IFDel: %v IFAdd: %v",
+		d.LocDel, d.LocAdd, d.IFDel, d.IFAdd)
+}
+
+// locEq checks if the local address with index idx is the same in both
+// NetConfs, including the set of interfaces that use it (as that is passed to
+// the socket's input goroutine).
+func locEq(oldN, newN *NetConf, idx int) bool {
+	oldKey := oldN.LocAddr[idx].BindAddr().String()
+	newKey := newN.LocAddr[idx].BindAddr().String()
+	if oldKey != newKey || !oldN.LocAddr[idx].PublicAddr().IP.Equal(
+		newN.LocAddr[idx].PublicAddr().IP) {
+		return false
+	}
+	oldIFIDs := append([]spath.IntfID(nil), oldN.LocAddrIFIDMap[oldKey]...)
+	newIFIDs := append([]spath.IntfID(nil), newN.LocAddrIFIDMap[newKey]...)
+	if len(oldIFIDs) != len(newIFIDs) {
+		return false
+	}
+	SortIFIDs(oldIFIDs)
+	SortIFIDs(newIFIDs)
+	for i := range oldIFIDs {
+		if oldIFIDs[i] != newIFIDs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sockEq checks if two Interfaces can share the same socket.
+func (intf *Interface) sockEq(o *Interface) bool {
+	return intf.LocAddrIdx == o.LocAddrIdx &&
+		intf.IFAddr.BindAddr().String() == o.IFAddr.BindAddr().String() &&
+		intf.RemoteAddr.String() == o.RemoteAddr.String()
+}
+
+// SortIFIDs sorts a slice of interface IDs in ascending order.
+func SortIFIDs(ifids []spath.IntfID) {
+	sort.Sort(ifidSlice(ifids))
+}
+
+// ifidSlice implements sort.Interface for a slice of interface IDs.
+type ifidSlice []spath.IntfID
+
+func (s ifidSlice) Len() int           { return len(s) }
+func (s ifidSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s ifidSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,130 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netconf
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/overlay"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+// testIF describes an interface of a test NetConf.
+type testIF struct {
+	id     spath.IntfID
+	loc    int
+	bind   string
+	remote string
+	mtu    int
+}
+
+// mkNetConf creates a NetConf with the given local addresses and interfaces,
+// with the same maps as FromTopo creates.
+func mkNetConf(locs []string, ifs ...testIF) *NetConf {
+	n := &NetConf{
+		IFs:            make(map[spath.IntfID]*Interface),
+		LocAddrMap:     make(map[string]int),
+		IFAddrMap:      make(map[string]spath.IntfID),
+		LocAddrIFIDMap: make(map[string][]spath.IntfID),
+	}
+	for i, loc := range locs {
+		a := mustUDPAddr(loc)
+		n.LocAddr = append(n.LocAddr, overlay.NewUDP(a.IP, a.Port))
+		n.LocAddrMap[loc] = i
+	}
+	for _, tif := range ifs {
+		a := mustUDPAddr(tif.bind)
+		intf := &Interface{Id: tif.id, LocAddrIdx: tif.loc, IFAddr: overlay.NewUDP(a.IP, a.Port),
+			RemoteAddr: mustUDPAddr(tif.remote), MTU: tif.mtu}
+		n.IFs[tif.id] = intf
+		n.IFAddrMap[tif.bind] = tif.id
+		n.LocAddrIFIDMap[locs[tif.loc]] = append(n.LocAddrIFIDMap[locs[tif.loc]], tif.id)
+	}
+	for _, loc := range locs {
+		if _, ok := n.LocAddrIFIDMap[loc]; !ok {
+			for ifid := range n.IFs {
+				n.LocAddrIFIDMap[loc] = append(n.LocAddrIFIDMap[loc], ifid)
+			}
+			SortIFIDs(n.LocAddrIFIDMap[loc])
+		}
+	}
+	return n
+}
+
+func mustUDPAddr(s string) *net.UDPAddr {
+	a, err := net.ResolveUDPAddr("udp", s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func Test_NewDiff(t *testing.T) {
+	locs := []string{"127.0.0.1:30041", "127.0.0.2:30041"}
+	if1 := testIF{id: 1, bind: "127.0.1.1:50000", remote: "127.0.2.1:50000", mtu: 1472}
+	if2 := testIF{id: 2, loc: 1, bind: "127.0.1.2:50000", remote: "127.0.2.2:50000", mtu: 1472}
+	base := mkNetConf(locs, if1, if2)
+	if1Remote := if1
+	if1Remote.remote = "127.0.2.9:50000"
+	if1Bind := if1
+	if1Bind.bind = "127.0.1.9:50000"
+	if1MTU := if1
+	if1MTU.mtu = 1280
+	if2Loc := if2
+	if2Loc.loc = 0
+	cases := []struct {
+		desc string
+		newN *NetConf
+		diff Diff
+	}{
+		{"Unchanged", mkNetConf(locs, if1, if2), Diff{}},
+		{"MTU changed", mkNetConf(locs, if1MTU, if2), Diff{}},
+		{"Interface added", mkNetConf(locs, if1, if2,
+			testIF{id: 3, loc: 1, bind: "127.0.1.3:50000", remote: "127.0.2.3:50000"}),
+			Diff{LocDel: []int{1}, LocAdd: []int{1}, IFAdd: []spath.IntfID{3}}},
+		// Local address 1 is left without interfaces, so it is used for all
+		// of them instead.
+		{"Interface removed", mkNetConf(locs, if1),
+			Diff{LocDel: []int{1}, LocAdd: []int{1}, IFDel: []spath.IntfID{2}}},
+		{"Interface remote address changed", mkNetConf(locs, if1Remote, if2),
+			Diff{IFDel: []spath.IntfID{1}, IFAdd: []spath.IntfID{1}}},
+		{"Interface bind address changed", mkNetConf(locs, if1Bind, if2),
+			Diff{IFDel: []spath.IntfID{1}, IFAdd: []spath.IntfID{1}}},
+		{"Interface moved to another local address", mkNetConf(locs, if1, if2Loc),
+			Diff{LocDel: []int{0, 1}, LocAdd: []int{0, 1}, IFDel: []spath.IntfID{2},
+				IFAdd: []spath.IntfID{2}}},
+		{"Local address added", mkNetConf(append(locs, "127.0.0.3:30041"), if1, if2),
+			Diff{LocAdd: []int{2}}},
+		{"Local address removed", mkNetConf(locs[:1], if1, testIF{id: 2,
+			bind: "127.0.1.2:50000", remote: "127.0.2.2:50000", mtu: 1472}),
+			Diff{LocDel: []int{0, 1}, LocAdd: []int{0}, IFDel: []spath.IntfID{2},
+				IFAdd: []spath.IntfID{2}}},
+		{"Local address changed", mkNetConf([]string{"127.0.0.9:30041", locs[1]}, if1, if2),
+			Diff{LocDel: []int{0}, LocAdd: []int{0}}},
+	}
+	Convey("NewDiff", t, func() {
+		for _, c := range cases {
+			Convey(c.desc, func() {
+				d := NewDiff(base, c.newN)
+				So(*d, ShouldResemble, c.diff)
+				So(d.Empty(), ShouldEqual, c.diff.LocDel == nil && c.diff.LocAdd == nil &&
+					c.diff.IFDel == nil && c.diff.IFAdd == nil)
+			})
+		}
+	})
+}
@@ -43,22 +43,28 @@ type NetConf struct {
 	// IFAddrMap maps external address strings to interface IDs.
 	IFAddrMap map[string]spath.IntfID
 	// LocAddrIFIDMap maps local address strings to (potentially multiple)
-	// interface IDs.
+	// interface IDs. A local address that isn't used by any interface is
+	// mapped to all interfaces of the router.
 	LocAddrIFIDMap map[string][]spath.IntfID
+	// CtrlAddrIdx is the LocAddr index to use as the source of control
+	// traffic generated by the router itself.
+	CtrlAddrIdx int
 }
 
 // FromTopo creates a NetConf instance from the topology.
 func FromTopo(t *topology.TopoBR) *NetConf {
-	n := &NetConf{}
-	// TODO(kormat): support multiple addresses
-	n.LocAddr = append(n.LocAddr, overlay.NewUDP(t.BasicElem.Addr.IP, t.BasicElem.Port))
+	n := &NetConf{CtrlAddrIdx: t.CtrlAddrIdx}
+	for _, loc := range t.LocAddrs() {
+		n.LocAddr = append(n.LocAddr, overlay.NewUDP(loc.Addr.IP, loc.Port))
+	}
 	n.IFs = make(map[spath.IntfID]*Interface)
 	n.LocAddrMap = make(map[string]int, len(n.LocAddr))
 	n.IFAddrMap = make(map[string]spath.IntfID, len(n.IFs))
 	n.LocAddrIFIDMap = make(map[string][]spath.IntfID, len(n.LocAddr))
-	// TODO(kormat): support multiple interfaces
-	x := intfFromTopoIF(t.IF)
-	n.IFs[x.Id] = x
+	for _, topoIF := range t.IFs {
+		x := intfFromTopoIF(topoIF)
+		n.IFs[x.Id] = x
+	}
 	for i, addr := range n.LocAddr {
 		n.LocAddrMap[addr.BindAddr().String()] = i
 	}
@@ -69,6 +75,17 @@ func FromTopo(t *topology.TopoBR) *NetConf {
 		// Add interface ID to local addr -> ifid mapping.
 		n.LocAddrIFIDMap[key] = append(n.LocAddrIFIDMap[key], ifid)
 	}
+	for _, addr := range n.LocAddr {
+		// Packets received on a local address need at least one associated
+		// interface, so unused local addresses get all of them.
+		key := addr.BindAddr().String()
+		if _, ok := n.LocAddrIFIDMap[key]; !ok {
+			for ifid := range n.IFs {
+				n.LocAddrIFIDMap[key] = append(n.LocAddrIFIDMap[key], ifid)
+			}
+			SortIFIDs(n.LocAddrIFIDMap[key])
+		}
+	}
 	return n
 }
 
@@ -78,6 +95,29 @@ func (n *NetConf) IntfLocalAddr(ifid spath.IntfID) *overlay.UDP {
 	return n.LocAddr[intf.LocAddrIdx]
 }
 
+// CtrlAddr retrieves the local address to use for control traffic generated
+// by the router.
+func (n *NetConf) CtrlAddr() *overlay.UDP {
+	return n.LocAddr[n.CtrlAddrIdx]
+}
+
+// LocAddrIdxFor returns the index of the local address to use to reach dst.
+// The preferred index is returned if its address family matches that of dst,
+// otherwise the first local address with a matching family is used. If there
+// is none, the preferred index is returned.
+func (n *NetConf) LocAddrIdxFor(dst net.IP, pref int) int {
+	isV4 := dst.To4() != nil
+	if (n.LocAddr[pref].BindAddr().IP.To4() != nil) == isV4 {
+		return pref
+	}
+	for i, loc := range n.LocAddr {
+		if (loc.BindAddr().IP.To4() != nil) == isV4 {
+			return i
+		}
+	}
+	return pref
+}
+
 // Interface describes the configuration of a router interface.
 type Interface struct {
 	// Id is the interface ID. It is unique per AS.
@@ -110,8 +150,7 @@ type Interface struct {
 func intfFromTopoIF(t *topology.TopoIF) *Interface {
 	intf := Interface{}
 	intf.Id = spath.IntfID(t.IFID)
-	// FIXME(kormat): to be changed when the topo format is updated.
-	intf.LocAddrIdx = 0
+	intf.LocAddrIdx = t.InternalAddrIdx
 	intf.IFAddr = overlay.NewUDP(t.Addr.IP, t.UdpPort)
 	intf.RemoteAddr = &net.UDPAddr{IP: t.ToAddr.IP, Port: t.ToUdpPort}
 	intf.RemoteIA = t.IA
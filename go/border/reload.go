@@ -0,0 +1,268 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file handles reloading the router configuration (topology, AS config
+// and ACL) at runtime, without restarting the router.
+
+package main
+
+import (
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/netconf"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+// ReloadConf loads the configuration from the config directory again, and
+// swaps it in for the current one. Sockets are reconciled with the new
+// configuration: new or changed local addresses and interfaces are opened, and
+// removed or changed ones are closed. New sockets are opened before any old
+// ones are closed, except where a new socket needs the address of an old one.
+// If any step fails, the sockets opened so far are closed again, any closed
+// sockets are reopened, and the current configuration is kept.
+func (r *Router) ReloadConf() *common.Error {
+	r.netLock.Lock()
+	defer r.netLock.Unlock()
+	if !r.netReady {
+		return common.NewError("Unable to reload config, router not running")
+	}
+	oldConf := conf.Get()
+	newConf, err := conf.Load(r.Id, oldConf.Dir)
+	if err != nil {
+		return err
+	}
+	if !newConf.IA.Eq(oldConf.IA) {
+		return common.NewError("Changing the ISD-AS requires a restart",
+			"old", oldConf.IA, "new", newConf.IA)
+	}
+	diff := netconf.NewDiff(oldConf.Net, newConf.Net)
+	log.Info("Reloading config", "diff", diff)
+	inheritConns(oldConf.Net, newConf.Net)
+	nr := newNetReload(r, oldConf.Net, newConf.Net, diff)
+	// Size packet buffers for the new MTUs before any new sockets are opened.
+	rpkt.SetMaxMTU(newConf.MaxMTU())
+	numQs := len(r.inQs)
+	err = nr.open()
+	if err != nil {
+		nr.rollback()
+		rpkt.SetMaxMTU(oldConf.MaxMTU())
+	}
+	// Start a processing goroutine for any new input queues. This is done even
+	// if the reload failed, so that packets read before a socket was closed
+	// again are drained, and for the queues of any reopened sockets.
+	for _, q := range r.inQs[numQs:] {
+		r.startQueue(q)
+	}
+	if err != nil {
+		log.Error("Reloading config failed, keeping current config", "err", err)
+		return err
+	}
+	conf.Set(newConf)
+	r.PruneLinks(newConf)
+	r.updateAnycast(newConf)
+	r.publishOutputFuncs()
+	// Only close the remaining old sockets once no more packets are routed to
+	// them.
+	nr.closeOld()
+	log.Info("Config reloaded")
+	return nil
+}
+
+// netReload tracks the socket changes made by a config reload, so that they
+// can be undone if the reload fails.
+type netReload struct {
+	r          *Router
+	oldN, newN *netconf.NetConf
+	diff       *netconf.Diff
+	// oldLocOutFs and oldIntfOutFs are copies of the router's output functions
+	// from before the reload.
+	oldLocOutFs  map[int]rpkt.OutputFunc
+	oldIntfOutFs map[spath.IntfID]rpkt.OutputFunc
+	// locAdded and ifAdded list the new sockets opened so far.
+	locAdded []int
+	ifAdded  []spath.IntfID
+	// locClosed and ifClosed list the old sockets closed so far.
+	locClosed []int
+	ifClosed  []spath.IntfID
+}
+
+func newNetReload(r *Router, oldN, newN *netconf.NetConf, diff *netconf.Diff) *netReload {
+	nr := &netReload{r: r, oldN: oldN, newN: newN, diff: diff,
+		oldLocOutFs:  make(map[int]rpkt.OutputFunc, len(r.locOutFs)),
+		oldIntfOutFs: make(map[spath.IntfID]rpkt.OutputFunc, len(r.intfOutFs)),
+	}
+	for k, v := range r.locOutFs {
+		nr.oldLocOutFs[k] = v
+	}
+	for k, v := range r.intfOutFs {
+		nr.oldIntfOutFs[k] = v
+	}
+	return nr
+}
+
+// open opens the new sockets, and updates the router's output functions
+// accordingly. Sockets whose address is free are opened first. Only then are
+// the old sockets whose address is needed by a new one closed, and the
+// remaining new sockets opened.
+func (nr *netReload) open() *common.Error {
+	r := nr.r
+	for _, idx := range nr.diff.LocDel {
+		delete(r.locOutFs, idx)
+	}
+	for _, ifid := range nr.diff.IFDel {
+		delete(r.intfOutFs, ifid)
+	}
+	// Map the addresses of the old sockets to be removed to a function for
+	// closing them.
+	inUse := make(map[string]func() *common.Error)
+	for _, idx := range nr.diff.LocDel {
+		idx := idx
+		inUse[nr.oldN.LocAddr[idx].BindAddr().String()] = func() *common.Error {
+			if err := r.delLocal(nr.oldN, idx); err != nil {
+				return err
+			}
+			nr.locClosed = append(nr.locClosed, idx)
+			return nil
+		}
+	}
+	for _, ifid := range nr.diff.IFDel {
+		intf := nr.oldN.IFs[ifid]
+		inUse[intf.IFAddr.BindAddr().String()] = func() *common.Error {
+			if err := r.delExt(intf); err != nil {
+				return err
+			}
+			nr.ifClosed = append(nr.ifClosed, intf.Id)
+			return nil
+		}
+	}
+	// In the first pass, open the sockets whose address is free, and in the
+	// second pass those whose address is still held by an old socket.
+	for _, pass := range []bool{false, true} {
+		for _, idx := range nr.diff.LocAdd {
+			free, held := inUse[nr.newN.LocAddr[idx].BindAddr().String()]
+			if held != pass {
+				continue
+			}
+			if held {
+				if err := free(); err != nil {
+					return err
+				}
+			}
+			if err := r.addLocal(nr.newN, idx); err != nil {
+				return common.NewError("Unable to add local address", "idx", idx, "err", err)
+			}
+			nr.locAdded = append(nr.locAdded, idx)
+		}
+		for _, ifid := range nr.diff.IFAdd {
+			free, held := inUse[nr.newN.IFs[ifid].IFAddr.BindAddr().String()]
+			if held != pass {
+				continue
+			}
+			if held {
+				if err := free(); err != nil {
+					return err
+				}
+			}
+			if err := r.addExt(nr.newN.IFs[ifid]); err != nil {
+				return common.NewError("Unable to add interface", "ifid", ifid, "err", err)
+			}
+			nr.ifAdded = append(nr.ifAdded, ifid)
+		}
+	}
+	return nil
+}
+
+// rollback closes the new sockets opened by open, reopens the old sockets it
+// closed, and restores the router's output functions.
+func (nr *netReload) rollback() {
+	r := nr.r
+	for _, idx := range nr.locAdded {
+		if err := r.delLocal(nr.newN, idx); err != nil {
+			log.Error("Unable to remove new local address", "idx", idx, "err", err)
+		}
+	}
+	for _, ifid := range nr.ifAdded {
+		if err := r.delExt(nr.newN.IFs[ifid]); err != nil {
+			log.Error("Unable to remove new interface", "ifid", ifid, "err", err)
+		}
+	}
+	r.locOutFs, r.intfOutFs = nr.oldLocOutFs, nr.oldIntfOutFs
+	for _, idx := range nr.locClosed {
+		if err := r.addLocal(nr.oldN, idx); err != nil {
+			log.Crit("Unable to restore local address", "idx", idx, "err", err)
+		}
+	}
+	for _, ifid := range nr.ifClosed {
+		if err := r.addExt(nr.oldN.IFs[ifid]); err != nil {
+			log.Crit("Unable to restore interface", "ifid", ifid, "err", err)
+		}
+	}
+}
+
+// closeOld closes the old sockets that open didn't need to close.
+func (nr *netReload) closeOld() {
+	r := nr.r
+	for _, idx := range nr.diff.LocDel {
+		if !containsInt(nr.locClosed, idx) {
+			if err := r.delLocal(nr.oldN, idx); err != nil {
+				log.Error("Unable to remove local address", "idx", idx, "err", err)
+			}
+		}
+	}
+	for _, ifid := range nr.diff.IFDel {
+		if !containsIFID(nr.ifClosed, ifid) {
+			if err := r.delExt(nr.oldN.IFs[ifid]); err != nil {
+				log.Error("Unable to remove interface", "ifid", ifid, "err", err)
+			}
+		}
+	}
+}
+
+func containsInt(l []int, v int) bool {
+	for _, x := range l {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIFID(l []spath.IntfID, v spath.IntfID) bool {
+	for _, x := range l {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// inheritConns copies the open sockets of unchanged local addresses and
+// interfaces from the old NetConf to the new one.
+func inheritConns(oldN, newN *netconf.NetConf) {
+	for i, over := range newN.LocAddr {
+		if i < len(oldN.LocAddr) &&
+			over.BindAddr().String() == oldN.LocAddr[i].BindAddr().String() {
+			over.Conn = oldN.LocAddr[i].Conn
+		}
+	}
+	for ifid, intf := range newN.IFs {
+		if oldIntf, ok := oldN.IFs[ifid]; ok &&
+			intf.IFAddr.BindAddr().String() == oldIntf.IFAddr.BindAddr().String() {
+			intf.IFAddr.Conn = oldIntf.IFAddr.Conn
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package revcache contains a cache of recently handled revocations (RevInfos),
+// used by the router to forward each revocation to the local services only
+// once, however many SCMP revocation errors carrying it are received.
+package revcache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+)
+
+const (
+	// EpochTime is the length of a hash tree epoch, i.e. the time a RevInfo
+	// is valid for.
+	EpochTime = 10 * time.Second
+	// EpochTolerance is the clock skew allowed when checking RevInfo epochs.
+	EpochTolerance = 5 * time.Second
+	// DefTTL is how long a RevInfo is cached by default, i.e. its validity
+	// period including the tolerance.
+	DefTTL = EpochTime + EpochTolerance
+	// DefMaxEntries is the default maximum number of cached RevInfos.
+	DefMaxEntries = 1000
+)
+
+// Key identifies a RevInfo.
+type Key struct {
+	IA    addr.ISD_AS
+	IfID  uint64
+	Epoch uint16
+}
+
+func (k Key) String() string {
+	return fmt.Sprintf("%v#%d@%d", k.IA, k.IfID, k.Epoch)
+}
+
+// Cache is a cache of RevInfo keys with expiry. It is safe for concurrent use.
+type Cache struct {
+	// TTL is how long an entry is cached for.
+	TTL time.Duration
+	// MaxEntries limits the number of cached entries. If it is reached and no
+	// entries have expired, new entries are not cached.
+	MaxEntries int
+	mu         sync.Mutex
+	entries    map[Key]time.Time
+}
+
+// New creates a cache with the default TTL and size limit.
+func New() *Cache {
+	return &Cache{TTL: DefTTL, MaxEntries: DefMaxEntries, entries: make(map[Key]time.Time)}
+}
+
+// Add adds k to the cache, returning false if it was already cached (i.e. the
+// RevInfo is a duplicate, and shouldn't be forwarded again).
+func (c *Cache) Add(k Key, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if expiry, ok := c.entries[k]; ok && now.Before(expiry) {
+		return false
+	}
+	if len(c.entries) >= c.MaxEntries {
+		c.expire(now)
+	}
+	if len(c.entries) < c.MaxEntries {
+		c.entries[k] = now.Add(c.TTL)
+	}
+	return true
+}
+
+// Len returns the number of cached entries, including expired ones that
+// haven't been removed yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// expire removes all expired entries.
+func (c *Cache) expire(now time.Time) {
+	for k, expiry := range c.entries {
+		if !now.Before(expiry) {
+			delete(c.entries, k)
+		}
+	}
+}
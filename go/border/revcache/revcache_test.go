@@ -0,0 +1,58 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revcache
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+)
+
+func Test_Cache(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	k1 := Key{IA: addr.ISD_AS{I: 1, A: 11}, IfID: 1, Epoch: 10}
+	k2 := Key{IA: addr.ISD_AS{I: 1, A: 11}, IfID: 1, Epoch: 11}
+	k3 := Key{IA: addr.ISD_AS{I: 1, A: 12}, IfID: 1, Epoch: 10}
+	Convey("Each RevInfo is only added once per TTL", t, func() {
+		c := New()
+		So(c.Add(k1, now), ShouldBeTrue)
+		So(c.Add(k1, now.Add(time.Second)), ShouldBeFalse)
+		So(c.Add(k2, now), ShouldBeTrue)
+		So(c.Add(k3, now), ShouldBeTrue)
+		So(c.Add(k1, now.Add(DefTTL-time.Millisecond)), ShouldBeFalse)
+		So(c.Add(k1, now.Add(DefTTL)), ShouldBeTrue)
+		So(c.Add(k1, now.Add(DefTTL+time.Second)), ShouldBeFalse)
+	})
+	Convey("Expired entries are removed when the cache is full", t, func() {
+		c := New()
+		c.MaxEntries = 2
+		So(c.Add(k1, now), ShouldBeTrue)
+		So(c.Add(k2, now.Add(time.Second)), ShouldBeTrue)
+		Convey("New entries aren't cached while the cache is full", func() {
+			So(c.Add(k3, now), ShouldBeTrue)
+			So(c.Add(k3, now), ShouldBeTrue)
+			So(c.Len(), ShouldEqual, 2)
+		})
+		Convey("Expired entries make room", func() {
+			later := now.Add(DefTTL)
+			So(c.Add(k3, later), ShouldBeTrue)
+			So(c.Add(k3, later), ShouldBeFalse)
+			So(c.Len(), ShouldEqual, 2)
+		})
+	})
+}
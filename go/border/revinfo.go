@@ -12,46 +12,118 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// This file handles Revocation Info (RevInfo) packets.
+// This file handles Revocation Info (RevInfo) packets. Received RevInfos are
+// rate limited per source ISD-AS, and verified (see the revinfo package) before
+// being forwarded. RevInfos are verified against the hash tree roots learnt
+// from the path segments sent by the local beacon and path services, which have
+// verified the segments' signatures, and dropped if there is no root for their
+// issuer. Each RevInfo is only forwarded once per validity period (see the
+// revcache package).
 
 package main
 
 import (
 	"bytes"
+	"context"
+	"flag"
+	"time"
 
 	log "github.com/inconshreveable/log15"
 	"zombiezen.com/go/capnproto2"
 
 	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/revcache"
 	"github.com/netsec-ethz/scion/go/border/rpkt"
 	"github.com/netsec-ethz/scion/go/lib/addr"
 	"github.com/netsec-ethz/scion/go/lib/common"
 	"github.com/netsec-ethz/scion/go/lib/l4"
 	"github.com/netsec-ethz/scion/go/lib/log"
+	"github.com/netsec-ethz/scion/go/lib/revinfo"
 	"github.com/netsec-ethz/scion/go/lib/spkt"
 	"github.com/netsec-ethz/scion/go/proto"
 )
 
+var revLimit = flag.String("rev.limit", "10/20",
+	"Rate limit for received revocations per source ISD-AS, as '[isd-as=]rate[/burst],...'")
+
+// Results of handling a received RevInfo, used as metrics labels.
+const (
+	revAccepted    = "accepted"
+	revDuplicate   = "duplicate"
+	revRateLimited = "rate_limited"
+	revDropped     = "dropped"
+	revInvalid     = "invalid"
+	revUnverified  = "unverified"
+)
+
+// revVerifyReasons maps RevInfo verification errors to metrics labels.
+var revVerifyReasons = map[string]string{
+	revinfo.ErrorMalformed:  "malformed",
+	revinfo.ErrorStaleEpoch: "stale_epoch",
+	revinfo.ErrorNoRoot:     "no_root",
+	revinfo.ErrorBadProof:   "bad_proof",
+}
+
 // RevTokenCallback is called to enqueue RevInfos for handling by the
 // RevInfoFwd goroutine.
 func (r *Router) RevTokenCallback(args rpkt.RevTokenCallbackArgs) {
+	if args.SrcIA != nil && !r.revLimit.Allow(args.SrcIA.String(), time.Now()) {
+		metrics.RevInfos.WithLabelValues(revRateLimited).Inc()
+		log.Debug("Rate limiting rev token", "src", args.SrcIA)
+		return
+	}
 	select {
 	case r.revInfoQ <- args:
 	default:
+		metrics.RevInfos.WithLabelValues(revDropped).Inc()
 		log.Debug("Dropping rev token")
 	}
 }
 
+// RevRootCallback adds a hash tree root of an ISD-AS, found in a path segment,
+// to the roots RevInfos are verified against.
+func (r *Router) RevRootCallback(ia addr.ISD_AS, root common.RawBytes) {
+	if !r.revRoots.Add(ia, root, time.Now()) {
+		log.Debug("Ignoring hash tree root", "ia", ia, "len", len(root))
+	}
+}
+
 // RevInfoFwd takes RevInfos, and forwards them to the local Beacon Service
-// (BS) and Path Service (PS).
-func (r *Router) RevInfoFwd() {
+// (BS) and Path Service (PS), until ctx is cancelled.
+func (r *Router) RevInfoFwd(ctx context.Context) {
 	defer liblog.PanicLog()
-	// Run forever.
-	for args := range r.revInfoQ {
+	for {
+		var args rpkt.RevTokenCallbackArgs
+		select {
+		case args = <-r.revInfoQ:
+		case <-ctx.Done():
+			return
+		}
 		revInfo := r.decodeRevToken(args.RevInfo)
 		if revInfo == nil {
+			metrics.RevInfos.WithLabelValues(revInvalid).Inc()
 			continue
 		}
+		proof, err := revinfo.ProofFromProto(*revInfo)
+		if err == nil {
+			err = verifyRevProof(proof, r.revRoots, time.Now())
+		}
+		if err != nil {
+			metrics.RevInfos.WithLabelValues(revUnverified).Inc()
+			metrics.RevInfoVerifyFailures.WithLabelValues(revVerifyReasons[err.Desc]).Inc()
+			log.Debug("Dropping unverified revocation", "err", err)
+			continue
+		}
+		// Only verified RevInfos are cached, so that forged ones can't
+		// suppress the forwarding of genuine ones.
+		key := revKey(proof)
+		if !r.revCache.Add(key, time.Now()) {
+			metrics.RevInfos.WithLabelValues(revDuplicate).Inc()
+			log.Debug("Ignoring duplicate revocation", "key", key)
+			continue
+		}
+		metrics.RevInfos.WithLabelValues(revAccepted).Inc()
 		for _, svcAddr := range args.Addrs {
 			log.Debug("Forwarding revocation.", "target", svcAddr, "revInfo", revInfo)
 			r.fwdRevInfo(revInfo, &svcAddr)
@@ -60,6 +132,22 @@ func (r *Router) RevInfoFwd() {
 
 }
 
+// revKey returns the revocation cache key of a RevInfo.
+func revKey(proof *revinfo.Proof) revcache.Key {
+	return revcache.Key{IA: proof.IA, IfID: proof.IfID, Epoch: proof.Epoch}
+}
+
+// verifyRevProof checks that a RevInfo is for the current epoch, and that its
+// proof leads to one of the roots of its issuer.
+func verifyRevProof(proof *revinfo.Proof, roots *revinfo.RootStore,
+	now time.Time) *common.Error {
+	if err := revinfo.VerifyEpoch(proof.Epoch, now); err != nil {
+		err.Ctx = append(err.Ctx, "revInfo", proof)
+		return err
+	}
+	return roots.Verify(proof, now)
+}
+
 // decodeRevToken decodes RevInfo payloads.
 func (r *Router) decodeRevToken(b common.RawBytes) *proto.RevInfo {
 	buf := bytes.NewBuffer(b)
@@ -84,11 +172,12 @@ func (r *Router) decodeRevToken(b common.RawBytes) *proto.RevInfo {
 
 // fwdRevInfo forwards RevInfo payloads to a designated local host.
 func (r *Router) fwdRevInfo(revInfo *proto.RevInfo, dstHost addr.HostAddr) {
-	// Pick first local address from topology as source.
-	srcAddr := conf.C.Net.LocAddr[0].PublicAddr()
+	// Use the control address from the topology as source.
+	c := conf.Get()
+	srcAddr := c.Net.CtrlAddr().PublicAddr()
 	// Create base packet
 	rp, err := rpkt.RtrPktFromScnPkt(&spkt.ScnPkt{
-		DstIA: conf.C.IA, SrcIA: conf.C.IA,
+		DstIA: c.IA, SrcIA: c.IA,
 		DstHost: dstHost, SrcHost: addr.HostFromIP(srcAddr.IP),
 		L4: &l4.UDP{SrcPort: uint16(srcAddr.Port), DstPort: 0},
 	}, rpkt.DirLocal)
@@ -103,7 +192,8 @@ func (r *Router) fwdRevInfo(revInfo *proto.RevInfo, dstHost addr.HostAddr) {
 	}
 	pathMgmt.SetRevInfo(*revInfo)
 	rp.SetPld(&spkt.CtrlPld{SCION: scion})
-	_, err = rp.RouteResolveSVCMulti(*dstHost.(*addr.HostSVC), r.locOutFs[0])
+	_, err = rp.RouteResolveSVCMulti(*dstHost.(*addr.HostSVC),
+		rpkt.GetOutputFuncs().Loc[c.Net.CtrlAddrIdx])
 	if err != nil {
 		log.Error("Unable to route RevInfo packet", err.Ctx...)
 		return
@@ -17,17 +17,24 @@
 package main
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/gavv/monotime"
 	log "github.com/inconshreveable/log15"
 	logext "github.com/inconshreveable/log15/ext"
 
+	"github.com/netsec-ethz/scion/go/border/anycast"
+	"github.com/netsec-ethz/scion/go/border/conf"
 	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/revcache"
 	"github.com/netsec-ethz/scion/go/border/rpkt"
 	"github.com/netsec-ethz/scion/go/lib/assert"
 	"github.com/netsec-ethz/scion/go/lib/common"
 	"github.com/netsec-ethz/scion/go/lib/log"
+	"github.com/netsec-ethz/scion/go/lib/ratelimit"
+	"github.com/netsec-ethz/scion/go/lib/revinfo"
 	"github.com/netsec-ethz/scion/go/lib/spath"
 )
 
@@ -40,20 +47,78 @@ type Router struct {
 	inQs []chan *rpkt.RtrPkt
 	// locOutFs is a slice of functions for sending packets to local
 	// destinations (i.e. within the local ISD-AS), indexed by the local
-	// address id.
+	// address id. It is only modified by the setup hooks, and copied to the
+	// rpkt package via Router.publishOutputFuncs.
 	locOutFs map[int]rpkt.OutputFunc
 	// intfOutFs is a slice of functions for sending packets to neighbouring
-	// ISD-ASes, indexed by the interface ID of the relevant link.
+	// ISD-ASes, indexed by the interface ID of the relevant link. It is
+	// handled the same way as locOutFs.
 	intfOutFs map[spath.IntfID]rpkt.OutputFunc
 	// freePkts is a buffered channel for recycled packets. See
 	// Router.recyclePkt
 	freePkts chan *rpkt.RtrPkt
 	// revInfoQ is a channel for handling RevInfo payloads.
 	revInfoQ chan rpkt.RevTokenCallbackArgs
+	// netLock serializes changes to the router's sockets, i.e. the initial
+	// network setup and config reloads.
+	netLock sync.Mutex
+	// netReady is set once the initial network setup has finished, and
+	// cleared again when shutting down.
+	netReady bool
+	// ctx is cancelled by Router.Stop, to shut down the router.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// queueWG tracks the goroutines processing packets from inQs.
+	queueWG sync.WaitGroup
+	// workers processes the packets from all inQs, if enabled (see -workers).
+	workers *workerPool
+	// scmpLimit rate limits SCMP error replies.
+	scmpLimit *scmpLimiter
+	// revLimit rate limits received revocations per source ISD-AS.
+	revLimit *ratelimit.Limiter
+	// revCache contains the revocations forwarded recently, to avoid
+	// forwarding duplicates.
+	revCache *revcache.Cache
+	// revRoots contains the hash tree roots that received revocations are
+	// verified against, as learnt from path segments.
+	revRoots *revinfo.RootStore
+	// liveness tracks the state of the router's links, based on the IFID
+	// packets received.
+	liveness *linkLiveness
+	// prober measures the RTT, jitter and loss of the router's links.
+	prober *linkProber
+	// anycast selects the instances for packets to anycast SVC addresses.
+	anycast *anycast.Resolver
 }
 
+// shutdownTimeout is how long the router waits for queued packets to be
+// processed when shutting down.
+const shutdownTimeout = 5 * time.Second
+
 func NewRouter(id, confDir string) (*Router, *common.Error) {
 	r := &Router{Id: id}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	var err *common.Error
+	if r.scmpLimit, err = newSCMPLimiter(); err != nil {
+		return nil, err
+	}
+	if r.revLimit, err = ratelimit.Parse(*revLimit, normIAKey); err != nil {
+		return nil, err
+	}
+	r.revCache = revcache.New()
+	r.revRoots = revinfo.NewRootStore()
+	if r.liveness, err = newLinkLivenessFromFlags(); err != nil {
+		return nil, err
+	}
+	if r.prober, err = newLinkProberFromFlags(); err != nil {
+		return nil, err
+	}
+	if r.anycast, err = newAnycastResolverFromFlags(); err != nil {
+		return nil, err
+	}
+	if *numWorkers > 0 {
+		r.workers = newWorkerPool(*numWorkers, *workerQLen)
+	}
 	if err := r.setup(confDir); err != nil {
 		return nil, err
 	}
@@ -61,32 +126,112 @@ func NewRouter(id, confDir string) (*Router, *common.Error) {
 }
 
 // Run sets up networking, and starts go routines for handling the main packet
-// processing as well as various other router functions.
+// processing as well as various other router functions. It returns once the
+// router has been shut down via Router.Stop.
 func (r *Router) Run() *common.Error {
+	r.netLock.Lock()
 	if err := r.setupNet(); err != nil {
+		r.netLock.Unlock()
 		return err
 	}
-	go r.SyncInterface()
-	go r.IFStateUpdate()
-	go r.RevInfoFwd()
-	var wg sync.WaitGroup
+	go r.SyncInterface(r.ctx)
+	go r.IFStateUpdate(r.ctx)
+	go r.RevInfoFwd(r.ctx)
+	if *probeInterval > 0 {
+		go r.ProbeLinks(r.ctx)
+	}
+	if r.workers != nil {
+		r.workers.start(r.ctx, r)
+	}
 	for _, q := range r.inQs {
-		wg.Add(1)
-		go r.handleQueue(q)
+		r.startQueue(q)
 	}
-	wg.Wait()
+	r.netReady = true
+	r.netLock.Unlock()
+	<-r.ctx.Done()
+	r.shutdown()
 	return nil
 }
 
+// Stop signals the router to shut down, causing Router.Run to return once
+// the shutdown is complete. It is safe to call Stop more than once.
+func (r *Router) Stop() {
+	r.cancel()
+}
+
+// shutdown stops all input, and then waits (up to shutdownTimeout) for the
+// packets already queued to be processed. The background goroutines stop by
+// themselves once the router's context is cancelled.
+func (r *Router) shutdown() {
+	log.Info("Shutting down")
+	r.netLock.Lock()
+	r.netReady = false
+	r.stopInput()
+	r.netLock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		r.queueWG.Wait()
+		if r.workers != nil {
+			r.workers.stop()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("Packet queues drained")
+	case <-time.After(shutdownTimeout):
+		log.Warn("Timed out draining packet queues", "timeout", shutdownTimeout)
+	}
+}
+
+// stopInput makes all POSIX input goroutines return (which closes their
+// queues), by interrupting any blocking reads. The sockets are left open so
+// that queued packets can still be sent.
+func (r *Router) stopInput() {
+	n := conf.Get().Net
+	now := time.Now()
+	for _, over := range n.LocAddr {
+		if over.Conn != nil {
+			over.Conn.SetReadDeadline(now)
+		}
+	}
+	for _, intf := range n.IFs {
+		if intf.IFAddr.Conn != nil {
+			intf.IFAddr.Conn.SetReadDeadline(now)
+		}
+	}
+}
+
+// startQueue starts a goroutine to handle packets from q, until q is closed.
+func (r *Router) startQueue(q chan *rpkt.RtrPkt) {
+	r.queueWG.Add(1)
+	go r.handleQueue(q)
+}
+
+// handleQueue either dispatches packets from q to the worker pool, or, if
+// there is none, processes them itself.
 func (r *Router) handleQueue(q chan *rpkt.RtrPkt) {
 	defer liblog.PanicLog()
+	defer r.queueWG.Done()
 	for rp := range q {
-		r.processPacket(rp)
-		metrics.PktProcessTime.Add(monotime.Since(rp.TimeIn).Seconds())
-		r.recyclePkt(rp)
+		if r.workers != nil {
+			r.workers.dispatch(r, rp)
+			continue
+		}
+		r.handlePkt(rp)
 	}
 }
 
+// handlePkt processes a single packet, and then recycles it.
+func (r *Router) handlePkt(rp *rpkt.RtrPkt) {
+	metrics.InputLatency.WithLabelValues(rp.Ingress.Id).Observe(
+		monotime.Since(rp.TimeIn).Seconds())
+	r.processPacket(rp)
+	metrics.PktProcessTime.WithLabelValues(rp.Ingress.Id).Observe(
+		monotime.Since(rp.TimeIn).Seconds())
+	r.recyclePkt(rp)
+}
+
 // processPacket is the heart of the router's packet handling. It delegates
 // everything from parsing the incoming packet, to routing the outgoing packet.
 func (r *Router) processPacket(rp *rpkt.RtrPkt) {
@@ -103,19 +248,20 @@ func (r *Router) processPacket(rp *rpkt.RtrPkt) {
 	rp.Id = logext.RandId(4)
 	rp.Logger = log.New("rpkt", rp.Id)
 	if err := rp.Parse(); err != nil {
-		r.handlePktError(rp, err, "Error parsing packet")
+		r.handlePktError(rp, err, "Error parsing packet", dropParse)
 		return
 	}
 	// Validation looks for errors in the packet that didn't break basic
 	// parsing.
 	if err := rp.Validate(); err != nil {
-		r.handlePktError(rp, err, "Error validating packet")
+		r.handlePktError(rp, err, "Error validating packet", dropValidate)
 		return
 	}
+	r.svcSeen(rp)
 	// Check if the packet needs to be processed locally, and if so register
 	// hooks for doing so.
 	if err := rp.NeedsLocalProcessing(); err != nil {
-		rp.Error("Error checking for local processing", err.Ctx...)
+		r.handlePktError(rp, err, "Error checking for local processing", dropLocal)
 		return
 	}
 	// Parse the packet payload, if a previous step has registered a relevant
@@ -124,19 +270,20 @@ func (r *Router) processPacket(rp *rpkt.RtrPkt) {
 		// Any errors at this point are application-level, and hence not
 		// calling handlePktError, as no SCMP errors will be sent.
 		rp.Error("Error parsing payload", err.Ctx...)
+		countDrop(rp.Ingress.Id, dropPayload)
 		return
 	}
 	// Process the packet, if a previous step has registered a relevant hook
 	// for doing so.
 	if err := rp.Process(); err != nil {
-		r.handlePktError(rp, err, "Error processing packet")
+		r.handlePktError(rp, err, "Error processing packet", dropProcess)
 		return
 	}
 	// If the packet's destination is this router, there's no need to forward
 	// it.
 	if rp.DirTo != rpkt.DirSelf {
 		if err := rp.Route(); err != nil {
-			r.handlePktError(rp, err, "Error routing packet")
+			r.handlePktError(rp, err, "Error routing packet", dropRoute)
 		}
 	}
 }
@@ -146,6 +293,12 @@ func (r *Router) processPacket(rp *rpkt.RtrPkt) {
 func (r *Router) getPktBuf() *rpkt.RtrPkt {
 	select {
 	case rp := <-r.freePkts:
+		if cap(rp.Raw) < rpkt.PktBufSize() {
+			// Buffer is too small for the current MTU, so replace it.
+			metrics.PktBufDiscard.Inc()
+			metrics.PktBufNew.Inc()
+			return rpkt.NewRtrPkt()
+		}
 		// Got one
 		metrics.PktBufReuse.Inc()
 		return rp
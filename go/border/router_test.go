@@ -0,0 +1,1386 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/border/acl"
+	"github.com/netsec-ethz/scion/go/border/anycast"
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/netconf"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/as_conf"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/l4"
+	"github.com/netsec-ethz/scion/go/lib/ratelimit"
+	"github.com/netsec-ethz/scion/go/lib/revinfo"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+// routerCase describes a packet injected into the harness, and the packets
+// the router is expected to send in response.
+type routerCase struct {
+	desc string
+	pkt  func(h *harness) *rpkt.RtrPkt
+	// setup, if set, is called before the packet is injected. It returns a
+	// function that undoes any changes made.
+	setup func() func()
+	// out lists the expected packets as "output dst". If dst is omitted, any
+	// destination is accepted.
+	out []string
+	// ct is the SCMP class/type of the packets sent. If nil, none of the
+	// packets sent may be SCMP packets.
+	ct *scmp.ClassType
+	// dropCT, if set, is the SCMP class/type of the error the packet must be
+	// dropped with.
+	dropCT *scmp.ClassType
+}
+
+func runRouterCases(t *testing.T, h *harness, cases []routerCase) {
+	for _, c := range cases {
+		Convey(c.desc, t, func() {
+			if c.setup != nil {
+				defer c.setup()()
+			}
+			rp := c.pkt(h)
+			var drops prometheus.Counter
+			var dropsBefore float64
+			if c.dropCT != nil {
+				drops = metrics.PktsDropped.WithLabelValues(rp.Ingress.Id, c.dropCT.String())
+				dropsBefore = counterValue(drops)
+			}
+			sent := h.inject(rp)
+			if drops != nil {
+				So(counterValue(drops), ShouldEqual, dropsBefore+1)
+			}
+			var got []string
+			for _, s := range sent {
+				got = append(got, fmt.Sprintf("%s %s", s.out, s.dst))
+			}
+			sort.Strings(got)
+			want := append([]string(nil), c.out...)
+			sort.Strings(want)
+			So(len(got), ShouldEqual, len(want))
+			for i := range want {
+				if i < len(got) && len(want[i]) == len(sent[i].out) {
+					// No destination specified.
+					got[i] = sent[i].out
+				}
+			}
+			So(got, ShouldResemble, want)
+			for _, s := range sent {
+				hdr := sentSCMP(s.raw)
+				if c.ct == nil {
+					So(hdr, ShouldBeNil)
+				} else {
+					So(hdr, ShouldNotBeNil)
+					So(hdr.Class, ShouldEqual, c.ct.Class)
+					So(hdr.Type, ShouldEqual, c.ct.Type)
+				}
+			}
+		})
+	}
+}
+
+// udp creates a UDP packet with the given path.
+func udp(src, dst *addr.ISD_AS, srcHost, dstHost addr.HostAddr, path *spath.Path) *spkt.ScnPkt {
+	return &spkt.ScnPkt{DstIA: dst, SrcIA: src, DstHost: dstHost, SrcHost: srcHost, Path: path,
+		L4: &l4.UDP{SrcPort: 1000, DstPort: 2000}}
+}
+
+// fromExt creates a UDP packet from 10.0.0.1 in the given ISD-AS, received on
+// interface ifid with the current Hop Field set to hop currHop of segment
+// currSeg.
+func fromExt(ifid spath.IntfID, src, dst *addr.ISD_AS, dstHost addr.HostAddr,
+	segs []seg, currSeg, currHop int) func(h *harness) *rpkt.RtrPkt {
+	return func(h *harness) *rpkt.RtrPkt {
+		path := h.mkPath(segs, currSeg, currHop)
+		return h.extPkt(ifid, udp(src, dst, host(remoteHost), dstHost, path))
+	}
+}
+
+// fromLoc creates a UDP packet from localHost in the local ISD-AS. If segs is
+// empty, the packet has no path.
+func fromLoc(dst *addr.ISD_AS, segs []seg, currSeg, currHop int) func(h *harness) *rpkt.RtrPkt {
+	return func(h *harness) *rpkt.RtrPkt {
+		var path *spath.Path
+		if len(segs) > 0 {
+			path = h.mkPath(segs, currSeg, currHop)
+		}
+		sp := udp(conf.Get().IA, dst, host(localHost), host(remoteHost), path)
+		return h.locPkt(host(localHost).IP(), sp)
+	}
+}
+
+// mutate modifies the raw bytes of a packet created by f.
+func mutate(f func(h *harness) *rpkt.RtrPkt,
+	m func(raw common.RawBytes)) func(h *harness) *rpkt.RtrPkt {
+	return func(h *harness) *rpkt.RtrPkt {
+		rp := f(h)
+		m(rp.Raw)
+		return rp
+	}
+}
+
+// withHBH adds hop-by-hop extensions to a packet created by f.
+func withHBH(f func(h *harness) *rpkt.RtrPkt, types ...uint8) func(h *harness) *rpkt.RtrPkt {
+	return func(h *harness) *rpkt.RtrPkt {
+		rp := f(h)
+		addHBH(rp, types...)
+		return rp
+	}
+}
+
+const (
+	remoteHost = "10.0.0.1"
+	localHost  = "127.0.0.100"
+)
+
+var (
+	// Frequently used path segments, through br1-11-1.
+	childToParent = []seg{upSeg(hop{in: 21}, hop{in: 1, eg: 2}, hop{eg: 11})}
+	parentToChild = []seg{downSeg(hop{eg: 11}, hop{in: 1, eg: 2}, hop{in: 21})}
+	parentToLocal = []seg{downSeg(hop{eg: 11}, hop{in: 1})}
+	localToParent = []seg{upSeg(hop{in: 1}, hop{eg: 11})}
+)
+
+// echoToRouter creates an SCMP echo request from a child ISD-AS to interface
+// 2 of br1-11-1, with the given end-to-end extensions.
+func echoToRouter(e2e ...common.Extension) func(h *harness) *rpkt.RtrPkt {
+	return func(h *harness) *rpkt.RtrPkt {
+		ct := scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoRequest}
+		pld := scmp.PldFromQuotes(ct, &scmp.InfoEcho{Id: 1, Seq: 2}, common.L4None, nil)
+		return h.extPkt(2, &spkt.ScnPkt{
+			DstIA: conf.Get().IA, SrcIA: ia(13),
+			DstHost: addr.HostFromIP(conf.Get().Net.IFs[2].IFAddr.PublicAddr().IP),
+			SrcHost: host(remoteHost),
+			Path:    h.mkPath([]seg{upSeg(hop{in: 21}, hop{eg: 2})}, 0, 1),
+			E2EExt:  e2e,
+			L4:      scmp.NewHdr(ct, pld.Len()),
+			Pld:     pld,
+		})
+	}
+}
+
+func ct(class scmp.Class, t scmp.Type) *scmp.ClassType {
+	return &scmp.ClassType{Class: class, Type: t}
+}
+
+func Test_Router_Forward(t *testing.T) {
+	h := newHarness(t, "br1-11-1")
+	cases := []routerCase{
+		{
+			desc: "Up segment, child to parent",
+			pkt:  fromExt(2, ia(13), ia(10), host(remoteHost), childToParent, 0, 1),
+			out:  []string{"intf:1 127.0.2.1:50000"},
+		},
+		{
+			desc: "Down segment, parent to child",
+			pkt:  fromExt(1, ia(10), ia(13), host(remoteHost), parentToChild, 0, 1),
+			out:  []string{"intf:2 127.0.2.2:50000"},
+		},
+		{
+			desc: "Core segment",
+			pkt: fromExt(5, ia(16), ia(17), host(remoteHost),
+				[]seg{downSeg(hop{eg: 51}, hop{in: 5, eg: 6}, hop{in: 61})}, 0, 1),
+			out: []string{"intf:6 127.0.2.6:50000"},
+		},
+		{
+			desc: "Egress interface on another router, via the local network",
+			pkt: fromExt(1, ia(10), ia(18), host(remoteHost),
+				[]seg{downSeg(hop{eg: 11}, hop{in: 1, eg: 7}, hop{in: 71})}, 0, 1),
+			out: []string{"loc:0 127.0.0.70:30097"},
+		},
+		{
+			desc: "From a local host",
+			pkt:  fromLoc(ia(10), localToParent, 0, 0),
+			out:  []string{"intf:1 127.0.2.1:50000"},
+		},
+		{
+			desc: "To a local host",
+			pkt:  fromExt(1, ia(10), ia(11), host(localHost), parentToLocal, 0, 1),
+			out:  []string{"loc:0 127.0.0.100:30041"},
+		},
+		{
+			desc: "Xover, up segment to down segment",
+			pkt: fromExt(2, ia(13), ia(14), host(remoteHost), []seg{
+				upSeg(hop{in: 21}, hop{eg: 2, xover: true}),
+				downSeg(hop{eg: 3, xover: true}, hop{in: 31}),
+			}, 0, 1),
+			out: []string{"intf:3 127.0.2.3:50000"},
+		},
+		{
+			desc: "Xover, up segment to core segment",
+			pkt: fromExt(2, ia(13), ia(16), host(remoteHost), []seg{
+				upSeg(hop{in: 21}, hop{eg: 2, xover: true}),
+				downSeg(hop{eg: 5, xover: true}, hop{in: 51}),
+			}, 0, 1),
+			out: []string{"intf:5 127.0.2.5:50000"},
+		},
+		{
+			desc: "Xover, up segment to up segment over a CORE link",
+			pkt: fromExt(2, ia(13), ia(16), host(remoteHost), []seg{
+				upSeg(hop{in: 21}, hop{eg: 2, xover: true}),
+				upSeg(hop{in: 5, xover: true}, hop{eg: 51}),
+			}, 0, 1),
+			out: []string{"intf:5 127.0.2.5:50000"},
+		},
+		{
+			desc: "Xover, down segment over a CORE link to down segment",
+			pkt: fromExt(5, ia(16), ia(14), host(remoteHost), []seg{
+				downSeg(hop{eg: 51}, hop{in: 5, xover: true}),
+				downSeg(hop{eg: 3, xover: true}, hop{in: 31}),
+			}, 0, 1),
+			out: []string{"intf:3 127.0.2.3:50000"},
+		},
+		{
+			desc: "Peering shortcut, child to peer",
+			pkt: fromExt(2, ia(13), ia(15), host(remoteHost),
+				peerPath(2, 4, true), 0, 1),
+			out: []string{"intf:4 127.0.2.4:50000"},
+		},
+		{
+			desc: "Peering shortcut, peer to child",
+			pkt: fromExt(4, ia(15), ia(13), host(remoteHost),
+				peerPath(4, 2, false), 1, 1),
+			out: []string{"intf:2 127.0.2.2:50000"},
+		},
+		{
+			desc: "SVC anycast to a path server",
+			pkt:  fromExt(1, ia(10), ia(11), addr.SvcPS, parentToLocal, 0, 1),
+			out:  []string{"loc:0"},
+		},
+		{
+			desc: "SVC multicast to all beacon servers",
+			pkt: fromExt(1, ia(10), ia(11), addr.SvcBS.Multicast(),
+				parentToLocal, 0, 1),
+			out: []string{"loc:0 127.0.0.65:30041", "loc:0 127.0.0.66:30041"},
+		},
+		{
+			desc: "SCMP echo request to the router",
+			pkt:  echoToRouter(),
+			out:  []string{"intf:2 127.0.2.2:50000"},
+			ct:   ct(scmp.C_General, scmp.T_G_EchoReply),
+		},
+	}
+	runRouterCases(t, h, cases)
+}
+
+// peerPath creates a peering shortcut path from a child of one AS to a child
+// of its peer, that crosses br1-11-1 between interfaces from and to. up
+// selects whether br1-11-1 is on the up or down segment.
+//
+// At each peering AS, the segment contains two XOVER Hop Fields (for the
+// child and peering interfaces), and a VERIFY_ONLY Hop Field for the
+// upstream interface. The MAC of the first XOVER Hop Field on the packet's
+// route includes the upstream Hop Field, and the second includes the first.
+func peerPath(from, to spath.IntfID, up bool) []seg {
+	if up {
+		// br1-11-1 is in the up segment, and the peer is in the down segment.
+		return []seg{
+			{up: true, shortcut: true, peer: true, hops: []hop{
+				{in: 21, ver: 1},
+				{in: to, eg: from, xover: true, ver: 2},
+				{in: to, eg: from, xover: true, ver: -1},
+				{in: 1, eg: from, vonly: true},
+			}},
+			{shortcut: true, peer: true, hops: []hop{
+				{in: 11, eg: 41, vonly: true},
+				{in: 41, eg: 31, xover: true, ver: 1},
+				{in: 41, eg: 31, xover: true, ver: -2},
+				{in: 31, ver: -1},
+			}},
+		}
+	}
+	// br1-11-1 is in the down segment, and the peer is in the up segment.
+	return []seg{
+		{up: true, shortcut: true, peer: true, hops: []hop{
+			{in: 31, ver: 1},
+			{in: 41, eg: 31, xover: true, ver: 2},
+			{in: 41, eg: 31, xover: true, ver: -1},
+			{in: 11, eg: 31, vonly: true},
+		}},
+		{shortcut: true, peer: true, hops: []hop{
+			{in: 1, eg: to, vonly: true},
+			{in: from, eg: to, xover: true, ver: 1},
+			{in: from, eg: to, xover: true, ver: -2},
+			{in: 21, ver: -1},
+		}},
+	}
+}
+
+// withoutIFs returns a copy of c without the given interfaces, as if they had
+// been removed by a config reload.
+func withoutIFs(c *conf.Conf, ifids ...spath.IntfID) *conf.Conf {
+	nc := *c
+	n := *c.Net
+	n.IFs = make(map[spath.IntfID]*netconf.Interface)
+	for ifid, intf := range c.Net.IFs {
+		n.IFs[ifid] = intf
+	}
+	for _, ifid := range ifids {
+		delete(n.IFs, ifid)
+	}
+	nc.Net = &n
+	return &nc
+}
+
+func Test_Router_ConfSnapshot(t *testing.T) {
+	h := newHarness(t, "br1-11-1")
+	old := conf.Get()
+	Convey("A packet is processed with the config it started with", t, func() {
+		rp := fromExt(2, ia(13), ia(10), host(remoteHost), childToParent, 0, 1)(h)
+		So(rp.Conf(), ShouldEqual, old)
+		conf.Set(withoutIFs(old, 1, 2))
+		defer conf.Set(old)
+		sent := h.inject(rp)
+		So(len(sent), ShouldEqual, 1)
+		So(fmt.Sprintf("%s %s", sent[0].out, sent[0].dst), ShouldEqual,
+			"intf:1 127.0.2.1:50000")
+	})
+	Convey("A packet from a removed interface is dropped", t, func() {
+		rp := fromExt(2, ia(13), ia(10), host(remoteHost), childToParent, 0, 1)(h)
+		conf.Set(withoutIFs(old, 2))
+		defer conf.Set(old)
+		So(h.inject(rp), ShouldBeEmpty)
+	})
+}
+
+// editTopo rewrites the topology in dir with edit.
+func editTopo(t *testing.T, dir string, edit func(topo string) string) {
+	path := filepath.Join(dir, "topology.yml")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read topology: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(edit(string(b))), 0644); err != nil {
+		t.Fatalf("Unable to write topology: %v", err)
+	}
+}
+
+// replaceTopo returns a topology edit that replaces each old string with the
+// corresponding new one.
+func replaceTopo(oldNew ...string) func(string) string {
+	return func(topo string) string {
+		return strings.NewReplacer(oldNew...).Replace(topo)
+	}
+}
+
+// bindable checks if a UDP socket can be bound to addr.
+func bindable(addr string) bool {
+	conn, err := net.ListenUDP("udp", mustUDPAddr(addr))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// isOpen checks if conn hasn't been closed.
+func isOpen(conn *net.UDPConn) bool {
+	return conn.SetReadBuffer(1<<16) == nil
+}
+
+func mustUDPAddr(s string) *net.UDPAddr {
+	a, err := net.ResolveUDPAddr("udp", s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func Test_Router_ReloadConf(t *testing.T) {
+	noEdit := func(topo string) string { return topo }
+	Convey("Reloading the config", t, func() {
+		r, dir, cleanup := netRouter(t, "br1-11-1", noEdit)
+		defer cleanup()
+		old := conf.Get()
+		oldIF1 := old.Net.IFs[1].IFAddr.Conn
+		oldIF2 := old.Net.IFs[2].IFAddr.Conn
+		oldIF3 := old.Net.IFs[3].IFAddr.Conn
+		Convey("without changes keeps all sockets", func() {
+			So(r.ReloadConf(), ShouldBeNil)
+			So(conf.Get(), ShouldNotEqual, old)
+			So(conf.Get().Net.IFs[1].IFAddr.Conn, ShouldEqual, oldIF1)
+			So(conf.Get().Net.LocAddr[0].Conn, ShouldEqual, old.Net.LocAddr[0].Conn)
+		})
+		Convey("replaces changed interfaces, and closes removed ones", func() {
+			// Change the remote address of interface 1, which needs a new
+			// socket on the same address, and move interface 2 to a new
+			// address. Interface 3 is removed by renaming it to an interface of
+			// another router.
+			editTopo(t, dir, replaceTopo(
+				"ToAddr: 127.0.2.1\n", "ToAddr: 127.0.2.9\n",
+				"- Addr: 127.0.1.2\n", "- Addr: 127.0.1.8\n",
+				"IFID: 3\n", "IFID: 99\n"))
+			So(r.ReloadConf(), ShouldBeNil)
+			n := conf.Get().Net
+			So(n.IFs[1].IFAddr.Conn, ShouldNotEqual, oldIF1)
+			So(isOpen(n.IFs[1].IFAddr.Conn), ShouldBeTrue)
+			So(n.IFs[1].IFAddr.Conn.RemoteAddr().String(), ShouldEqual, "127.0.2.9:50000")
+			So(isOpen(n.IFs[2].IFAddr.Conn), ShouldBeTrue)
+			So(n.IFs[2].IFAddr.Conn.LocalAddr().String(), ShouldEqual, "127.0.1.8:50001")
+			So(n.IFs, ShouldNotContainKey, spath.IntfID(3))
+			So(isOpen(oldIF1), ShouldBeFalse)
+			So(isOpen(oldIF2), ShouldBeFalse)
+			So(isOpen(oldIF3), ShouldBeFalse)
+			So(r.intfOutFs, ShouldNotContainKey, spath.IntfID(3))
+			So(rpkt.GetOutputFuncs().Intf, ShouldNotContainKey, spath.IntfID(3))
+		})
+		Convey("keeps the old config if the new one is invalid", func() {
+			// The new topology is valid, but the AS config isn't.
+			editTopo(t, dir, replaceTopo("PathServers:", "OldPathServers:"))
+			err := ioutil.WriteFile(filepath.Join(dir, as_conf.CfgName),
+				[]byte("MasterASKey: ''\n"), 0644)
+			So(err, ShouldBeNil)
+			So(r.ReloadConf(), ShouldNotBeNil)
+			So(conf.Get(), ShouldEqual, old)
+			So(len(conf.Get().TopoMeta.T.PS), ShouldEqual, 2)
+		})
+		Convey("keeps the old config and sockets if a socket can't be opened", func() {
+			// Interface 1 moves to a free address, but interface 2 moves to an
+			// address that is already in use.
+			busy, err := net.ListenUDP("udp", mustUDPAddr("127.0.1.9:50001"))
+			So(err, ShouldBeNil)
+			defer busy.Close()
+			editTopo(t, dir, replaceTopo(
+				"- Addr: 127.0.1.1\n", "- Addr: 127.0.1.8\n",
+				"- Addr: 127.0.1.2\n", "- Addr: 127.0.1.9\n",
+				"IFID: 3\n", "IFID: 99\n"))
+			So(r.ReloadConf(), ShouldNotBeNil)
+			So(conf.Get(), ShouldEqual, old)
+			for _, conn := range []*net.UDPConn{oldIF1, oldIF2, oldIF3} {
+				So(isOpen(conn), ShouldBeTrue)
+			}
+			// The socket opened for interface 1 has been closed again.
+			So(bindable("127.0.1.8:50001"), ShouldBeTrue)
+			So(r.intfOutFs, ShouldContainKey, spath.IntfID(3))
+			So(rpkt.GetOutputFuncs().Intf, ShouldContainKey, spath.IntfID(3))
+		})
+	})
+}
+
+// waitClosed checks that q is closed within a second, discarding any packets
+// still in it.
+func waitClosed(q chan *rpkt.RtrPkt) bool {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-q:
+			if !ok {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func Test_Router_InputShutdown(t *testing.T) {
+	labels := prometheus.Labels{"id": "loc:0"}
+	Convey("POSIX input", t, func() {
+		h := newHarness(t, "br1-11-1")
+		conn, err := net.ListenUDP("udp4", mustUDPAddr("127.0.0.1:0"))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		q := make(chan *rpkt.RtrPkt)
+		go h.r.readPosixInput(conn, rpkt.DirLocal, []spath.IntfID{1}, labels, q)
+		Convey("stops when the router shuts down", func() {
+			h.r.Stop()
+			conn.SetReadDeadline(time.Now())
+			So(waitClosed(q), ShouldBeTrue)
+		})
+		Convey("stops when the socket is closed", func() {
+			conn.Close()
+			So(waitClosed(q), ShouldBeTrue)
+		})
+	})
+	Convey("Batch input processes packets until the router shuts down", t, func() {
+		h := newHarness(t, "br1-11-1")
+		pkt := fromExt(2, ia(13), ia(10), host(remoteHost), childToParent, 0, 1)(h)
+		calls := 0
+		// processed is closed once the first batch has been processed.
+		processed := make(chan struct{})
+		read := func(rpkts []*rpkt.RtrPkt, usedPorts []bool) (int, *common.Error) {
+			calls++
+			if calls > 1 {
+				if calls == 2 {
+					close(processed)
+				}
+				time.Sleep(time.Millisecond)
+				return 0, nil
+			}
+			rp := rpkts[0]
+			rp.Raw = rp.Raw[:copy(rp.Raw, pkt.Raw)]
+			rp.DirFrom = pkt.DirFrom
+			rp.Ingress = pkt.Ingress
+			usedPorts[0] = true
+			return 1, nil
+		}
+		q := make(chan *rpkt.RtrPkt)
+		go h.r.readBatchInput(read, 4, []prometheus.Labels{labels}, q)
+		<-processed
+		h.r.Stop()
+		So(waitClosed(q), ShouldBeTrue)
+		So(len(h.sent), ShouldEqual, 1)
+		So(h.sent[0].out, ShouldEqual, "intf:1")
+	})
+}
+
+func Test_Router_PosixInput(t *testing.T) {
+	labels := prometheus.Labels{"id": "loc:0"}
+	Convey("POSIX input drops packets larger than the packet buffers", t, func() {
+		h := newHarness(t, "br1-11-1")
+		conn, err := net.ListenUDP("udp4", mustUDPAddr("127.0.0.1:0"))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		wconn, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+		So(err, ShouldBeNil)
+		defer wconn.Close()
+		q := make(chan *rpkt.RtrPkt)
+		go h.r.readPosixInput(conn, rpkt.DirLocal, []spath.IntfID{1}, labels, q)
+		max := rpkt.PktBufSize() - 1
+		for _, size := range []int{max + 1, max} {
+			_, err := wconn.Write(make([]byte, size))
+			So(err, ShouldBeNil)
+		}
+		select {
+		case rp := <-q:
+			So(len(rp.Raw), ShouldEqual, max)
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for packet")
+		}
+		conn.Close()
+		So(waitClosed(q), ShouldBeTrue)
+	})
+}
+
+func Test_WorkerPool(t *testing.T) {
+	Convey("A worker pool", t, func() {
+		h := newHarness(t, "br1-11-1")
+		defer h.r.Stop()
+		pkt := fromExt(2, ia(13), ia(10), host(remoteHost), childToParent, 0, 1)
+		p := newWorkerPool(2, 2)
+		i := rpkt.FlowHash(pkt(h).Raw) % 2
+		Convey("queues packets of the same flow for the same worker", func() {
+			p.dispatch(h.r, pkt(h))
+			p.dispatch(h.r, pkt(h))
+			So(len(p.qs[i]), ShouldEqual, 2)
+			So(len(p.qs[1-i]), ShouldEqual, 0)
+			Convey("and drops packets once the worker's queue is full", func() {
+				free := len(h.r.freePkts)
+				p.dispatch(h.r, pkt(h))
+				So(len(p.qs[i]), ShouldEqual, 2)
+				So(len(h.r.freePkts), ShouldEqual, free+1)
+			})
+			Convey("and processes the queued packets before stopping", func() {
+				p.start(h.r.ctx, h.r)
+				p.stop()
+				So(len(h.sent), ShouldEqual, 2)
+				So(h.sent[0].out, ShouldEqual, "intf:1")
+				So(h.sent[1].out, ShouldEqual, "intf:1")
+			})
+		})
+	})
+}
+
+func Test_Router_SCMPErrors(t *testing.T) {
+	h := newHarness(t, "br1-11-1")
+	childPkt := fromExt(2, ia(13), ia(10), host(remoteHost), childToParent, 0, 1)
+	localPkt := fromLoc(ia(10), localToParent, 0, 0)
+	// Offsets of fields in packets created by fromExt and fromLoc.
+	const (
+		offTotalLen  = 2
+		offHdrLen    = 4
+		offCurrInfoF = 5
+		offCurrHopF  = 6
+	)
+	setTypes := func(dst, src addr.HostAddrType) func(raw common.RawBytes) {
+		return func(raw common.RawBytes) {
+			v := common.Order.Uint16(raw)
+			if dst != 0 {
+				v = v&^(0x3F<<6) | uint16(dst)<<6
+			}
+			if src != 0 {
+				v = v&^0x3F | uint16(src)
+			}
+			common.Order.PutUint16(raw, v)
+		}
+	}
+	badHopFOffset := func(raw common.RawBytes) { raw[offCurrHopF] = raw[offHdrLen] }
+	badInfoFOffset := func(raw common.RawBytes) { raw[offCurrInfoF] = spkt.CmnHdrLen }
+	// hopFOff returns the offset of the current Hop Field.
+	hopFOff := func(raw common.RawBytes) int { return int(raw[offCurrHopF]) }
+	// longCorePath crosses br1-11-1 from interface 5 to 6, with enough Hop
+	// Fields to exceed the MTU of interface 6.
+	longCorePath := []seg{downSeg(hop{eg: 51}, hop{in: 5, eg: 6})}
+	for i := 0; i < 12; i++ {
+		longCorePath[0].hops = append(longCorePath[0].hops, hop{in: 61, eg: 62, ver: -1})
+	}
+	longCorePath[0].hops = append(longCorePath[0].hops, hop{in: 61, ver: -1})
+	peerMismatch := peerPath(2, 4, true)
+	peerMismatch[0].hops[2].eg = 3
+	cases := []routerCase{
+		{
+			desc: "Bad packet length",
+			pkt: mutate(childPkt, func(raw common.RawBytes) {
+				common.Order.PutUint16(raw[offTotalLen:], uint16(len(raw)+1))
+			}),
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_CmnHdr, scmp.T_C_BadPktLen),
+		},
+		{
+			desc:   "Bad version (no reply)",
+			pkt:    mutate(childPkt, func(raw common.RawBytes) { raw[0] |= 0xF0 }),
+			dropCT: ct(scmp.C_CmnHdr, scmp.T_C_BadVersion),
+		},
+		{
+			desc:   "Bad destination address type (no reply)",
+			pkt:    mutate(childPkt, setTypes(0x3F, 0)),
+			dropCT: ct(scmp.C_CmnHdr, scmp.T_C_BadDstType),
+		},
+		{
+			desc:   "Bad source address type (no reply)",
+			pkt:    mutate(childPkt, setTypes(0, 0x3F)),
+			dropCT: ct(scmp.C_CmnHdr, scmp.T_C_BadSrcType),
+		},
+		{
+			desc:   "SVC source address (no reply)",
+			pkt:    mutate(childPkt, setTypes(0, addr.HostTypeSVC)),
+			dropCT: ct(scmp.C_CmnHdr, scmp.T_C_BadSrcType),
+		},
+		{
+			desc: "Bad Hop Field offset from a local host",
+			pkt:  mutate(localPkt, badHopFOffset),
+			out:  []string{"loc:0 127.0.0.100:30041"},
+			ct:   ct(scmp.C_CmnHdr, scmp.T_C_BadHopFOffset),
+		},
+		{
+			desc:   "Bad Hop Field offset from a remote host (no reply)",
+			pkt:    mutate(childPkt, badHopFOffset),
+			dropCT: ct(scmp.C_CmnHdr, scmp.T_C_BadHopFOffset),
+		},
+		{
+			desc: "Bad Info Field offset from a local host",
+			pkt:  mutate(localPkt, badInfoFOffset),
+			out:  []string{"loc:0 127.0.0.100:30041"},
+			ct:   ct(scmp.C_CmnHdr, scmp.T_C_BadInfoFOffset),
+		},
+		{
+			desc:   "Bad Info Field offset from a remote host (no reply)",
+			pkt:    mutate(childPkt, badInfoFOffset),
+			dropCT: ct(scmp.C_CmnHdr, scmp.T_C_BadInfoFOffset),
+		},
+		{
+			desc: "Path required from a local host",
+			pkt:  fromLoc(ia(10), nil, 0, 0),
+			out:  []string{"loc:0 127.0.0.100:30041"},
+			ct:   ct(scmp.C_Path, scmp.T_P_PathRequired),
+		},
+		{
+			desc: "Bad MAC",
+			pkt: mutate(childPkt, func(raw common.RawBytes) {
+				raw[hopFOff(raw)+common.LineLen-1] ^= 0xFF
+			}),
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_BadMac),
+		},
+		{
+			desc: "Expired Hop Field",
+			pkt: fromExt(2, ia(13), ia(10), host(remoteHost), []seg{{up: true, ts: 1,
+				hops: []hop{{in: 21, ver: 1}, {in: 1, eg: 2, ver: 1}, {eg: 11}}}}, 0, 1),
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_ExpiredHopF),
+		},
+		{
+			desc: "Unknown interface",
+			pkt: fromExt(2, ia(13), ia(10), host(remoteHost),
+				[]seg{upSeg(hop{in: 21}, hop{in: 99, eg: 2}, hop{eg: 11})}, 0, 1),
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_BadIF),
+		},
+		{
+			desc: "Revoked interface",
+			pkt:  childPkt,
+			setup: func() func() {
+				c := conf.Get()
+				c.IFStates.Lock()
+				if c.IFStates.M == nil {
+					c.IFStates.M = make(map[spath.IntfID]conf.IFState)
+				}
+				c.IFStates.M[1] = conf.IFState{RawRev: common.RawBytes{1, 2, 3}}
+				c.IFStates.Unlock()
+				return func() {
+					c.IFStates.Lock()
+					delete(c.IFStates.M, 1)
+					c.IFStates.Unlock()
+				}
+			},
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_RevokedIF),
+		},
+		{
+			desc: "Administratively down interface",
+			pkt:  childPkt,
+			setup: func() func() {
+				conf.Get().AdminDown.Set(1, true)
+				return func() { conf.Get().AdminDown.Set(1, false) }
+			},
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_Routing, scmp.T_R_AdminDenied),
+		},
+		{
+			desc: "VERIFY_ONLY Hop Field",
+			pkt: fromExt(2, ia(13), ia(10), host(remoteHost),
+				[]seg{upSeg(hop{in: 21}, hop{in: 1, eg: 2, vonly: true}, hop{eg: 11})}, 0, 1),
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_NonRoutingHopF),
+		},
+		{
+			desc: "FORWARD_ONLY Hop Field for local delivery",
+			pkt: fromExt(1, ia(10), ia(11), host(localHost),
+				[]seg{downSeg(hop{eg: 11}, hop{in: 1, fonly: true})}, 0, 1),
+			out: []string{"intf:1 127.0.2.1:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_DeliveryFwdOnly),
+		},
+		{
+			desc: "Segment change between CORE links",
+			pkt: fromExt(5, ia(16), ia(17), host(remoteHost), []seg{
+				upSeg(hop{in: 51}, hop{eg: 5, xover: true}),
+				downSeg(hop{eg: 6, xover: true}, hop{in: 61}),
+			}, 0, 1),
+			out: []string{"intf:5 127.0.2.5:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_BadSegment),
+		},
+		{
+			desc: "Segment change from up to up segment with a non-CORE next link",
+			pkt: fromExt(2, ia(13), ia(10), host(remoteHost), []seg{
+				upSeg(hop{in: 21}, hop{eg: 2, xover: true}),
+				upSeg(hop{in: 1, xover: true}, hop{eg: 11}),
+			}, 0, 1),
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_BadSegment),
+		},
+		{
+			desc: "Segment change from down to down segment with a non-CORE previous link",
+			pkt: fromExt(1, ia(10), ia(14), host(remoteHost), []seg{
+				downSeg(hop{eg: 11}, hop{in: 1, xover: true}),
+				downSeg(hop{eg: 3, xover: true}, hop{in: 31}),
+			}, 0, 1),
+			out: []string{"intf:1 127.0.2.1:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_BadSegment),
+		},
+		{
+			// The reversed path also changes from a down to an up segment, so
+			// no reply can be sent.
+			desc: "Segment change from down to up segment (no reply)",
+			pkt: fromExt(5, ia(16), ia(10), host(remoteHost), []seg{
+				downSeg(hop{eg: 51}, hop{in: 5, xover: true}),
+				upSeg(hop{in: 1, xover: true}, hop{eg: 11}),
+			}, 0, 1),
+		},
+		{
+			desc: "Segment change at a peering XOVER Hop Field",
+			pkt: fromExt(2, ia(13), ia(15), host(remoteHost), []seg{
+				{up: true, shortcut: true, peer: true, hops: []hop{
+					{in: 21, ver: 1},
+					{in: 4, eg: 2, xover: true, ver: 2},
+				}},
+				{shortcut: true, peer: true, hops: []hop{{in: 41, eg: 4}}},
+			}, 0, 1),
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_Path, scmp.T_P_BadSegment),
+		},
+		{
+			desc: "Peering XOVER Hop Fields with different child interfaces",
+			pkt:  fromExt(2, ia(13), ia(15), host(remoteHost), peerMismatch, 0, 1),
+			out:  []string{"intf:2 127.0.2.2:50000"},
+			ct:   ct(scmp.C_Path, scmp.T_P_BadHopField),
+		},
+		{
+			// The reversed path also has the VERIFY_ONLY Hop Field in the
+			// middle of a segment, so no reply can be sent.
+			desc: "VERIFY_ONLY Hop Field in the middle of a segment (no reply)",
+			pkt: fromExt(1, ia(10), ia(13), host(remoteHost), []seg{downSeg(
+				hop{eg: 11}, hop{in: 1, eg: 2}, hop{in: 21, eg: 22, vonly: true}, hop{in: 23},
+			)}, 0, 1),
+		},
+		{
+			desc: "Packet larger than the egress MTU",
+			pkt:  fromExt(5, ia(16), ia(17), host(remoteHost), longCorePath, 0, 1),
+			out:  []string{"intf:5 127.0.2.5:50000"},
+			ct:   ct(scmp.C_Routing, scmp.T_R_OversizePkt),
+		},
+		{
+			desc: "Unknown SVC address",
+			pkt:  fromExt(1, ia(10), ia(11), addr.HostSVC(0x00FF), parentToLocal, 0, 1),
+			out:  []string{"intf:1 127.0.2.1:50000"},
+			ct:   ct(scmp.C_Routing, scmp.T_R_BadHost),
+		},
+		{
+			desc: "SVC address without instances",
+			pkt:  fromExt(1, ia(10), ia(11), addr.SvcCS, parentToLocal, 0, 1),
+			out:  []string{"intf:1 127.0.2.1:50000"},
+			ct:   ct(scmp.C_Routing, scmp.T_R_UnreachHost),
+		},
+		{
+			desc: "Unsupported hop-by-hop extension",
+			pkt:  withHBH(childPkt, 0x7F),
+			out:  []string{"intf:2 127.0.2.2:50000"},
+			ct:   ct(scmp.C_Ext, scmp.T_E_BadHopByHop),
+		},
+		{
+			desc: "Too many hop-by-hop extensions",
+			pkt:  withHBH(childPkt, 0, 0, 0, 0),
+			out:  []string{"intf:2 127.0.2.2:50000"},
+			ct:   ct(scmp.C_Ext, scmp.T_E_TooManyHopbyHop),
+		},
+		{
+			desc: "SCMP hop-by-hop extension after another extension",
+			pkt:  withHBH(childPkt, common.ExtnTracerouteType.Type, common.ExtnSCMPType.Type),
+			out:  []string{"intf:2 127.0.2.2:50000"},
+			ct:   ct(scmp.C_Ext, scmp.T_E_BadExtOrder),
+		},
+		{
+			desc: "Error in an SCMP error packet (no reply)",
+			pkt: func(h *harness) *rpkt.RtrPkt {
+				sp := udp(ia(13), ia(10), host(remoteHost), host(remoteHost),
+					h.mkPath(childToParent, 0, 1))
+				sp.HBHExt = []common.Extension{&scmp.Extn{Error: true}}
+				rp := h.extPkt(2, sp)
+				raw := rp.Raw
+				raw[hopFOff(raw)+common.LineLen-1] ^= 0xFF
+				return rp
+			},
+		},
+	}
+	runRouterCases(t, h, cases)
+}
+
+// withACL returns a routerCase setup function that installs the ACL given in
+// YAML form.
+func withACL(t *testing.T, yml string) func() func() {
+	return func() func() {
+		a, err := acl.Parse([]byte(yml), "test")
+		if err != nil {
+			t.Fatalf("Unable to parse ACL: %v", err)
+		}
+		c := conf.Get()
+		c.ACL = a
+		return func() { c.ACL = nil }
+	}
+}
+
+func Test_Router_ACL(t *testing.T) {
+	h := newHarness(t, "br1-11-1")
+	childPkt := fromExt(2, ia(13), ia(10), host(remoteHost), childToParent, 0, 1)
+	parentPkt := fromExt(1, ia(10), ia(13), host(remoteHost), parentToChild, 0, 1)
+	// tcpPkt sets the next header field of childPkt to TCP, so that its UDP
+	// ports are read as TCP ports.
+	tcpPkt := mutate(childPkt, func(raw common.RawBytes) { raw[7] = byte(common.L4TCP) })
+	denied := ct(scmp.C_Routing, scmp.T_R_AdminDenied)
+	cases := []routerCase{
+		{
+			desc:  "Denied by destination port",
+			pkt:   childPkt,
+			setup: withACL(t, "Rules: [{Name: r1, Action: deny, L4: udp, DstPort: 1024-2047}]"),
+			out:   []string{"intf:2 127.0.2.2:50000"},
+			ct:    denied,
+		},
+		{
+			// No SCMP error is sent, as TCP headers can't be parsed.
+			desc:  "TCP denied by destination port (no reply)",
+			pkt:   tcpPkt,
+			setup: withACL(t, "Rules: [{Name: r1, Action: deny, L4: tcp, DstPort: 2000}]"),
+		},
+		{
+			desc:  "TCP destination port outside the denied range",
+			pkt:   tcpPkt,
+			setup: withACL(t, "Rules: [{Name: r1, Action: deny, L4: tcp, DstPort: 23}]"),
+			out:   []string{"intf:1 127.0.2.1:50000"},
+		},
+		{
+			desc:  "Source port outside the denied range",
+			pkt:   childPkt,
+			setup: withACL(t, "Rules: [{Name: r1, Action: deny, SrcPort: 1001-2047}]"),
+			out:   []string{"intf:1 127.0.2.1:50000"},
+		},
+		{
+			desc: "First matching rule wins",
+			pkt:  childPkt,
+			setup: withACL(t, `
+Default: deny
+Rules:
+  - {Name: r1, Action: allow, SrcIA: 1-0, DstIA: 1-10}
+  - {Name: r2, Action: deny, SrcIA: 1-13}
+`),
+			out: []string{"intf:1 127.0.2.1:50000"},
+		},
+		{
+			desc:  "Denied by default",
+			pkt:   childPkt,
+			setup: withACL(t, "Default: deny"),
+			out:   []string{"intf:2 127.0.2.2:50000"},
+			ct:    denied,
+		},
+		{
+			desc:  "Denied by egress link type",
+			pkt:   childPkt,
+			setup: withACL(t, "Rules: [{Name: r1, Action: deny, EgressLink: parent}]"),
+			out:   []string{"intf:2 127.0.2.2:50000"},
+			ct:    denied,
+		},
+		{
+			desc:  "Egress link type doesn't match",
+			pkt:   parentPkt,
+			setup: withACL(t, "Rules: [{Name: r1, Action: deny, EgressLink: parent}]"),
+			out:   []string{"intf:2 127.0.2.2:50000"},
+		},
+		{
+			desc: "Denied by ingress interface and link type",
+			pkt:  parentPkt,
+			setup: withACL(t,
+				"Rules: [{Name: r1, Action: deny, IngressIF: 1, IngressLink: PARENT}]"),
+			out: []string{"intf:1 127.0.2.1:50000"},
+			ct:  denied,
+		},
+		{
+			desc:  "Denied by source host prefix",
+			pkt:   fromExt(1, ia(10), ia(11), host(localHost), parentToLocal, 0, 1),
+			setup: withACL(t, "Rules: [{Name: r1, Action: deny, SrcHost: 10.0.0.0/8}]"),
+			out:   []string{"intf:1 127.0.2.1:50000"},
+			ct:    denied,
+		},
+		{
+			desc:  "Denied from the local AS",
+			pkt:   fromLoc(ia(10), localToParent, 0, 0),
+			setup: withACL(t, "Rules: [{Name: r1, Action: deny, IngressIF: 0, DstHost: 10.0.0.1}]"),
+			out:   []string{"loc:0"},
+			ct:    denied,
+		},
+	}
+	runRouterCases(t, h, cases)
+}
+
+func Test_Router_E2E(t *testing.T) {
+	h := newHarness(t, "br1-11-1")
+	probe := &spkt.PathProbe{ProbeID: 7}
+	// firstExt sets a byte of the first extension of a packet without
+	// hop-by-hop extensions.
+	firstExt := func(off int, v byte) func(raw common.RawBytes) {
+		return func(raw common.RawBytes) {
+			cmnHdr, err := spkt.CmnHdrFromRaw(raw)
+			if err != nil {
+				panic(err)
+			}
+			raw[int(cmnHdr.HdrLen)+off] = v
+		}
+	}
+	cases := []routerCase{
+		{
+			desc: "Echo request with a path probe",
+			pkt:  echoToRouter(probe),
+			out:  []string{"intf:2 127.0.2.2:50000"},
+			ct:   ct(scmp.C_General, scmp.T_G_EchoReply),
+		},
+		{
+			desc: "Unsupported end-to-end extensions to the router are skipped",
+			pkt:  mutate(echoToRouter(probe), firstExt(2, 0x7F)),
+			out:  []string{"intf:2 127.0.2.2:50000"},
+			ct:   ct(scmp.C_General, scmp.T_G_EchoReply),
+		},
+		{
+			desc: "Echo request with an unknown path transport type",
+			pkt: mutate(echoToRouter(&spkt.PathTrans{
+				PathType: spkt.PathTransPCBPath, Path: make(common.RawBytes, 8)}),
+				firstExt(common.ExtnSubHdrLen, 0x7F)),
+			out: []string{"intf:2 127.0.2.2:50000"},
+			ct:  ct(scmp.C_Ext, scmp.T_E_BadEnd2End),
+		},
+		{
+			// The L4 header can't be located to be quoted, so no reply can be
+			// sent.
+			desc: "Hop-by-hop extension after an end-to-end extension (no reply)",
+			pkt:  mutate(echoToRouter(probe), firstExt(0, uint8(common.HopByHopClass))),
+		},
+		{
+			desc: "Unsupported end-to-end extensions are forwarded untouched",
+			pkt: mutate(func(h *harness) *rpkt.RtrPkt {
+				sp := udp(ia(13), ia(10), host(remoteHost), host(remoteHost),
+					h.mkPath(childToParent, 0, 1))
+				sp.E2EExt = []common.Extension{probe}
+				return h.extPkt(2, sp)
+			}, firstExt(2, 0x7F)),
+			out: []string{"intf:1 127.0.2.1:50000"},
+		},
+	}
+	runRouterCases(t, h, cases)
+	Convey("Path probes are acknowledged in replies", t, func() {
+		sent := h.inject(echoToRouter(probe)(h))
+		So(len(sent), ShouldEqual, 1)
+		reply, err := spkt.ParseScnPkt(sent[0].raw)
+		So(err, ShouldBeNil)
+		So(reply.E2EExt, ShouldResemble,
+			[]common.Extension{&spkt.PathProbe{IsAck: true, ProbeID: 7}})
+	})
+}
+
+func Test_Router_SVCAnycast(t *testing.T) {
+	h := newHarness(t, "br1-11-1")
+	psAnycast := fromExt(1, ia(10), ia(11), addr.SvcPS, parentToLocal, 0, 1)
+	// sendToPS sends an anycast packet to the path servers, and returns the
+	// address it was delivered to.
+	sendToPS := func() string {
+		sent := h.inject(psAnycast(h))
+		So(len(sent), ShouldEqual, 1)
+		So(sent[0].out, ShouldEqual, "loc:0")
+		return sent[0].dst.IP.String()
+	}
+	// other returns the address of the other path server.
+	other := func(ip string) string {
+		if ip == "127.0.0.73" {
+			return "127.0.0.74"
+		}
+		return "127.0.0.73"
+	}
+	names := map[string]string{"127.0.0.73": "ps1-11-1", "127.0.0.74": "ps1-11-2"}
+	Convey("Anycast packets from one source go to the same instance", t, func() {
+		h.r.anycast.Strategy = anycast.Hash{}
+		h.r.anycast.Health = anycast.NewHealth(time.Minute,
+			anycast.AllInstances(conf.Get().TopoMeta))
+		first := sendToPS()
+		for i := 0; i < 4; i++ {
+			So(sendToPS(), ShouldEqual, first)
+		}
+		Convey("Instances marked down are skipped", func() {
+			So(h.r.anycast.Health.SetDown(names[first], true), ShouldBeTrue)
+			So(sendToPS(), ShouldEqual, other(first))
+		})
+		Convey("Instances that have gone silent are skipped", func() {
+			ip := net.ParseIP(first)
+			// fromPort sends a packet from port on the instance's host.
+			fromPort := func(port uint16) {
+				sp := udp(ia(11), ia(10), host(first), host(remoteHost),
+					h.mkPath(localToParent, 0, 0))
+				sp.L4.(*l4.UDP).SrcPort = port
+				So(len(h.inject(h.locPkt(ip, sp))), ShouldEqual, 1)
+			}
+			fromPort(30091)
+			So(sendToPS(), ShouldEqual, first)
+			h.r.anycast.Health.Seen(ip, 30091, time.Now().Add(-2*time.Minute))
+			So(sendToPS(), ShouldEqual, other(first))
+			Convey("but packets from other ports on the host don't count", func() {
+				fromPort(1000)
+				So(sendToPS(), ShouldEqual, other(first))
+				fromPort(30091)
+				So(sendToPS(), ShouldEqual, first)
+			})
+		})
+		Convey("If no instance is healthy, all are used", func() {
+			h.r.anycast.Health.SetDown("ps1-11-1", true)
+			h.r.anycast.Health.SetDown("ps1-11-2", true)
+			So(sendToPS(), ShouldEqual, first)
+		})
+		Convey("Deliveries are counted per instance", func() {
+			sendToPS()
+			for _, s := range h.r.anycast.Health.Stats(time.Now()) {
+				if s.Name == names[first] {
+					So(s.Deliveries, ShouldEqual, 6)
+				} else {
+					So(s.Deliveries, ShouldEqual, 0)
+				}
+			}
+		})
+	})
+	h.r.anycast.Strategy = anycast.Random{}
+	h.r.anycast.Health = anycast.NewHealth(0, anycast.AllInstances(conf.Get().TopoMeta))
+}
+
+func Test_Router_Liveness(t *testing.T) {
+	h := newHarness(t, "br1-11-1")
+	timeout := h.r.liveness.timeout
+	// sentToBS checks that an IFStateInfos notification was sent to each
+	// beacon service.
+	sentToBS := func() {
+		So(len(h.sent), ShouldEqual, len(conf.Get().TopoMeta.T.BS))
+		for _, s := range h.sent {
+			So(s.out, ShouldEqual, "loc:0")
+		}
+	}
+	// A link that never received an IFID packet is never declared down.
+	Convey("Links are unknown until an IFID packet is received", t, func() {
+		h.r.liveness = newLinkLiveness(timeout)
+		h.sent = nil
+		h.r.CheckLinks(time.Now().Add(2 * timeout))
+		So(h.r.liveness.get(1).State, ShouldEqual, LinkUnknown)
+		So(len(h.sent), ShouldEqual, 0)
+	})
+	Convey("An IFID packet brings a link up", t, func() {
+		h.r.liveness = newLinkLiveness(timeout)
+		h.sent = nil
+		h.r.IFIDCallback(1)
+		info := h.r.liveness.get(1)
+		So(info.State, ShouldEqual, LinkUp)
+		// The beacon service is notified of the change.
+		sentToBS()
+		Convey("Further IFID packets only update the last seen time", func() {
+			h.sent = nil
+			h.r.IFIDCallback(1)
+			So(h.r.liveness.get(1).State, ShouldEqual, LinkUp)
+			So(len(h.sent), ShouldEqual, 0)
+		})
+		Convey("The link stays up until the timeout passes", func() {
+			h.sent = nil
+			h.r.CheckLinks(info.LastSeen.Add(timeout))
+			So(h.r.liveness.get(1).State, ShouldEqual, LinkUp)
+			So(len(h.sent), ShouldEqual, 0)
+		})
+		Convey("Missed IFID packets take the link down", func() {
+			h.sent = nil
+			h.r.CheckLinks(info.LastSeen.Add(timeout + time.Millisecond))
+			down := h.r.liveness.get(1)
+			So(down.State, ShouldEqual, LinkDown)
+			So(down.Flaps, ShouldEqual, 1)
+			sentToBS()
+			So(h.r.adminIFState(conf.Get(), 1, time.Now()).Link, ShouldEqual, "down")
+			Convey("and the next IFID packet brings it back up", func() {
+				h.sent = nil
+				h.r.IFIDCallback(1)
+				So(h.r.liveness.get(1).State, ShouldEqual, LinkUp)
+				So(h.r.liveness.get(1).Flaps, ShouldEqual, 1)
+				sentToBS()
+			})
+		})
+		Convey("Links of removed interfaces are pruned", func() {
+			h.r.IFIDCallback(2)
+			h.r.PruneLinks(withoutIFs(conf.Get(), 2))
+			So(h.r.liveness.get(1).State, ShouldEqual, LinkUp)
+			So(h.r.liveness.get(2).State, ShouldEqual, LinkUnknown)
+		})
+	})
+}
+
+func Test_Router_LinkProbe(t *testing.T) {
+	h := newHarness(t, "br1-11-1")
+	echoReq := scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoRequest}
+	echoReply := scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoReply}
+	// reply creates the neighbouring router's reply to a link probe, by
+	// filling in its Hop Field and reversing the probe, the same way the
+	// neighbour would.
+	reply := func(probe *spkt.ScnPkt, info *scmp.InfoEcho) *rpkt.RtrPkt {
+		sp := *probe
+		sp.Path = &spath.Path{Raw: append(common.RawBytes(nil), probe.Path.Raw...),
+			InfOff: probe.Path.InfOff, HopOff: probe.Path.HopOff}
+		spath.NewHopField(sp.Path.Raw[sp.Path.HopOff:], 42, 0)
+		if err := sp.Reverse(); err != nil {
+			t.Fatalf("Error reversing probe: %v", err)
+		}
+		sp.Path.HopOff += spath.HopFieldLength
+		sp.Pld = scmp.PldFromQuotes(echoReply, info, common.L4None, nil)
+		sp.L4 = scmp.NewHdr(echoReply, sp.Pld.Len())
+		return h.extPkt(1, &sp)
+	}
+	Convey("Link probes measure the RTT of a link", t, func() {
+		h.r.prober = newLinkProber(7, time.Second, 4)
+		h.sent = nil
+		now := time.Now()
+		h.r.sendLinkProbe(1, now)
+		So(len(h.sent), ShouldEqual, 1)
+		intf := conf.Get().Net.IFs[1]
+		So(h.sent[0].out, ShouldEqual, "intf:1")
+		So(h.sent[0].dst, ShouldResemble, intf.RemoteAddr)
+		probe, err := spkt.ParseScnPkt(h.sent[0].raw)
+		So(err, ShouldBeNil)
+		So(probe.DstIA, ShouldResemble, intf.RemoteIA)
+		So(probe.HBHExt, ShouldResemble, []common.Extension{&spkt.OneHopPath{}})
+		hdr := probe.L4.(*scmp.Hdr)
+		So(scmp.ClassType{Class: hdr.Class, Type: hdr.Type}, ShouldResemble, echoReq)
+		info := probe.Pld.(*scmp.Payload).Info.(*scmp.InfoEcho)
+		So(info, ShouldResemble, &scmp.InfoEcho{Id: 7, Seq: 0})
+		So(h.r.prober.get(1).Sent, ShouldEqual, 1)
+		Convey("The neighbour's reply is matched to the probe", func() {
+			So(len(h.inject(reply(probe, info))), ShouldEqual, 0)
+			s := h.r.prober.get(1)
+			So(s.Received, ShouldEqual, 1)
+			So(s.RTT, ShouldBeGreaterThan, 0)
+			So(s.SRTT, ShouldEqual, s.RTT)
+			So(s.Loss, ShouldEqual, 0)
+			Convey("Duplicate replies are ignored", func() {
+				h.inject(reply(probe, info))
+				So(h.r.prober.get(1).Received, ShouldEqual, 1)
+			})
+			Convey("and so are replies to other requests", func() {
+				h.inject(reply(probe, &scmp.InfoEcho{Id: 8, Seq: 1}))
+				So(h.r.prober.get(1).Received, ShouldEqual, 1)
+			})
+			Convey("Unanswered probes are counted as lost", func() {
+				h.r.sendLinkProbe(1, now)
+				h.r.expireLinkProbes(now.Add(time.Second))
+				So(h.r.prober.get(1).Lost, ShouldEqual, 0)
+				h.r.expireLinkProbes(now.Add(time.Second + time.Millisecond))
+				s := h.r.prober.get(1)
+				So(s.Sent, ShouldEqual, 2)
+				So(s.Lost, ShouldEqual, 1)
+				So(s.Loss, ShouldEqual, 0.5)
+				So(h.r.adminLinks()[0], ShouldResemble, AdminLink{IFID: 1, Sent: 2,
+					Received: 1, Lost: 1, RTTSec: s.RTT.Seconds(), SRTTSec: s.SRTT.Seconds(),
+					Loss: 0.5})
+			})
+		})
+		Convey("Probes that can't be sent are counted as failed and lost", func() {
+			f := h.r.intfOutFs[1]
+			delete(h.r.intfOutFs, 1)
+			h.r.publishOutputFuncs()
+			defer func() {
+				h.r.intfOutFs[1] = f
+				h.r.publishOutputFuncs()
+			}()
+			h.sent = nil
+			h.r.sendLinkProbe(1, now)
+			So(len(h.sent), ShouldEqual, 0)
+			s := h.r.prober.get(1)
+			So(s.Sent, ShouldEqual, 2)
+			So(s.Failed, ShouldEqual, 1)
+			So(s.Lost, ShouldEqual, 1)
+			// The first probe is still pending, so only the failed one counts
+			// towards the loss ratio.
+			So(s.Loss, ShouldEqual, 1)
+		})
+		Convey("Probes of removed interfaces are pruned", func() {
+			h.r.sendLinkProbe(2, now)
+			h.r.expireLinkProbes(now.Add(2 * time.Second))
+			h.r.PruneLinks(withoutIFs(conf.Get(), 2))
+			So(h.r.prober.get(1).Sent, ShouldEqual, 1)
+			So(h.r.prober.get(2), ShouldResemble, LinkStats{})
+			// The metrics of the removed link are gone, those of the
+			// remaining link are kept.
+			So(metrics.LinkProbes.DeleteLabelValues("intf:2", "sent"), ShouldBeFalse)
+			So(metrics.LinkProbes.DeleteLabelValues("intf:2", "lost"), ShouldBeFalse)
+			So(metrics.LinkLoss.DeleteLabelValues("intf:2"), ShouldBeFalse)
+			So(metrics.LinkLoss.DeleteLabelValues("intf:1"), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_LinkProber(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	ms := time.Millisecond
+	Convey("RTT, jitter and loss are calculated from probe replies", t, func() {
+		p := newLinkProber(1, time.Second, 4)
+		for i, rtt := range []time.Duration{10 * ms, 26 * ms, 10 * ms} {
+			seq := p.sent(1, now)
+			So(seq, ShouldEqual, i)
+			_, ok := p.recv(1, seq, now.Add(rtt))
+			So(ok, ShouldBeTrue)
+		}
+		s := p.get(1)
+		So(s.RTT, ShouldEqual, 10*ms)
+		// 10ms, then 10 + 16/8 = 12ms, then 12 - 2/8 = 11.75ms.
+		So(s.SRTT, ShouldEqual, 11750*time.Microsecond)
+		// 0, then 16/16 = 1ms, then 1 + 15/16 = 1.9375ms.
+		So(s.Jitter, ShouldEqual, 1937500*time.Nanosecond)
+		Convey("Loss is calculated over the most recent probes", func() {
+			p.sent(1, now)
+			So(p.expire(now.Add(2*time.Second)), ShouldResemble, map[spath.IntfID]int{1: 1})
+			So(p.get(1).Loss, ShouldEqual, 0.25)
+			for i := 0; i < 3; i++ {
+				p.recv(1, p.sent(1, now), now)
+			}
+			So(p.get(1).Loss, ShouldEqual, 0.25)
+			p.recv(1, p.sent(1, now), now)
+			So(p.get(1).Loss, ShouldEqual, 0)
+			So(p.get(1).Lost, ShouldEqual, 1)
+		})
+	})
+}
+
+func Test_SCMPLimiter(t *testing.T) {
+	Convey("A reply suppressed by one limit doesn't take tokens from the others", t, func() {
+		intf, err := ratelimit.Parse("2/2", normIntfKey)
+		So(err, ShouldBeNil)
+		ct, err := ratelimit.Parse("", normCTKey)
+		So(err, ShouldBeNil)
+		iaL, err := ratelimit.Parse("1/1", normIAKey)
+		So(err, ShouldBeNil)
+		l := &scmpLimiter{intf: intf, ct: ct, ia: iaL}
+		rp := rpkt.NewRtrPkt()
+		rp.DirFrom = rpkt.DirExternal
+		rp.Ingress.IfIDs = []spath.IntfID{1}
+		badMac := scmp.ClassType{Class: scmp.C_Path, Type: scmp.T_P_BadMac}
+		So(l.allow(rp, badMac, ia(10)), ShouldBeTrue)
+		So(l.allow(rp, badMac, ia(10)), ShouldBeFalse)
+		So(l.allow(rp, badMac, ia(11)), ShouldBeTrue)
+		So(l.allow(rp, badMac, ia(12)), ShouldBeFalse)
+	})
+}
+
+func Test_VerifyRevProof(t *testing.T) {
+	isdas := addr.ISD_AS{I: 1, A: 12}
+	prev := revinfo.NewHashTree(isdas, []uint64{1, 2}, []byte("seed0")).Root()
+	ht := revinfo.NewHashTree(isdas, []uint64{1, 2}, []byte("seed1"))
+	// 1500000000 is the start of epoch 60.
+	now := time.Unix(1500000000, 0)
+	roots := revinfo.NewRootStore()
+	roots.Add(isdas, revinfo.ConnectedRoot(prev, ht.Root()), now)
+	proof := func(epoch uint16) *revinfo.Proof {
+		p, err := ht.Proof(1, epoch, prev, make(common.RawBytes, revinfo.HashLen))
+		if err != nil {
+			t.Fatalf("Unable to create proof: %v", err)
+		}
+		return p
+	}
+	shouldFail := func(err *common.Error, desc string) {
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, desc)
+		So(revVerifyReasons[err.Desc], ShouldNotBeEmpty)
+	}
+	Convey("RevInfos for the current epoch with a valid proof are accepted", t, func() {
+		So(verifyRevProof(proof(60), roots, now), ShouldBeNil)
+		So(verifyRevProof(proof(59), roots, now), ShouldBeNil)
+	})
+	Convey("RevInfos for other epochs are rejected", t, func() {
+		shouldFail(verifyRevProof(proof(58), roots, now), revinfo.ErrorStaleEpoch)
+	})
+	Convey("RevInfos with a forged proof are rejected", t, func() {
+		p := proof(60)
+		p.IfID = 2
+		shouldFail(verifyRevProof(p, roots, now), revinfo.ErrorBadProof)
+		p = proof(60)
+		p.IA = addr.ISD_AS{I: 1, A: 13}
+		shouldFail(verifyRevProof(p, roots, now), revinfo.ErrorNoRoot)
+	})
+	Convey("RevInfos are rejected once their issuer's roots have expired", t, func() {
+		later := now.Add(revinfo.RootTTL)
+		p, err := ht.Proof(1, revinfo.CurrentEpoch(later), prev,
+			make(common.RawBytes, revinfo.HashLen))
+		So(err, ShouldBeNil)
+		shouldFail(verifyRevProof(p, roots, later), revinfo.ErrorNoRoot)
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file handles checking packets against the router's ACL (see the acl
+// package).
+
+package rpkt
+
+import (
+	"github.com/netsec-ethz/scion/go/border/acl"
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+// checkACL checks the packet against the ACL once for each egress, returning
+// an AdminDenied SCMP error if any of them is denied. Packets created by the
+// router (e.g. SCMP replies) are not subject to the ACL, nor are packets
+// addressed to the router itself, as they are never routed.
+func (rp *RtrPkt) checkACL(c *conf.Conf) *common.Error {
+	if c.ACL == nil || rp.DirFrom == DirSelf {
+		return nil
+	}
+	p, err := rp.aclPkt(c)
+	if err != nil {
+		return err
+	}
+	for _, epair := range rp.Egress {
+		p.EgIF = epair.IfID
+		p.EgLink = linkType(c, epair.IfID)
+		allowed, rule := c.ACL.Check(p)
+		action := acl.Allow
+		if !allowed {
+			action = acl.Deny
+		}
+		metrics.ACLHits.WithLabelValues(rule, string(action)).Inc()
+		if !allowed {
+			sdata := scmp.NewErrData(scmp.C_Routing, scmp.T_R_AdminDenied, nil)
+			return common.NewErrorData(errAdminDenied, sdata, "rule", rule,
+				"ifid", epair.IfID)
+		}
+	}
+	return nil
+}
+
+// aclPkt extracts the fields the ACL matches on, except for the egress
+// interface.
+func (rp *RtrPkt) aclPkt(c *conf.Conf) (*acl.Pkt, *common.Error) {
+	p := &acl.Pkt{}
+	var err *common.Error
+	if p.SrcIA, err = rp.SrcIA(); err != nil {
+		return nil, err
+	}
+	if p.DstIA, err = rp.DstIA(); err != nil {
+		return nil, err
+	}
+	srcHost, err := rp.SrcHost()
+	if err != nil {
+		return nil, err
+	}
+	p.SrcHost = srcHost.IP()
+	dstHost, err := rp.DstHost()
+	if err != nil {
+		return nil, err
+	}
+	p.DstHost = dstHost.IP()
+	// Headers of unsupported L4 protocols can't be parsed, but the protocol
+	// is still known.
+	if _, err := rp.L4Hdr(false); err != nil && err.Desc != UnsupportedL4 {
+		return nil, err
+	}
+	p.L4 = rp.L4Type
+	// The ports are read from the raw header, as TCP headers aren't parsed.
+	if acl.PortProtos[p.L4] && rp.idxs.l4+4 <= len(rp.Raw) {
+		p.HasPorts = true
+		p.SrcPort = int(common.Order.Uint16(rp.Raw[rp.idxs.l4:]))
+		p.DstPort = int(common.Order.Uint16(rp.Raw[rp.idxs.l4+2:]))
+	}
+	if rp.DirFrom == DirExternal {
+		p.InIF = *rp.ifCurr
+		p.InLink = linkType(c, p.InIF)
+	}
+	return p, nil
+}
+
+// linkType returns the link type of a local interface, or "" for the local
+// AS (interface 0) and unknown interfaces.
+func linkType(c *conf.Conf, ifid spath.IntfID) string {
+	if intf, ok := c.Net.IFs[ifid]; ok {
+		return intf.Type
+	}
+	return ""
+}
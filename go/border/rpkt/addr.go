@@ -59,6 +59,9 @@ func (rp *RtrPkt) hookIA(hooks []hookIA, idx int) (*addr.ISD_AS, *common.Error)
 			return ia, nil
 		}
 	}
+	if idx+addr.IABytes > len(rp.Raw) {
+		return nil, common.NewError(errPktTooShort, "min", idx+addr.IABytes, "actual", len(rp.Raw))
+	}
 	return addr.IAFromRaw(rp.Raw[idx:]), nil
 }
 
@@ -102,5 +105,8 @@ func (rp *RtrPkt) hookHost(
 			return host, nil
 		}
 	}
+	if hlen, err := addr.HostLen(htype); err == nil && idx+int(hlen) > len(rp.Raw) {
+		return nil, common.NewError(errPktTooShort, "min", idx+int(hlen), "actual", len(rp.Raw))
+	}
 	return addr.HostFromRaw(rp.Raw[idx:], htype)
 }
@@ -0,0 +1,70 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpkt
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/l4"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+func Test_RtrPktFromScnPkt(t *testing.T) {
+	setupTestConf(t, "br1-11-1")
+	tr := spkt.NewTraceroute(2)
+	tr.Hops = append(tr.Hops, &spkt.TracerouteEntry{IA: addr.ISD_AS{I: 1, A: 12}, IfID: 5,
+		TimeStamp: 1234})
+	sp := &spkt.ScnPkt{
+		DstIA: &addr.ISD_AS{I: 1, A: 13}, SrcIA: &addr.ISD_AS{I: 1, A: 12},
+		DstHost: addr.HostFromIP(net.IPv4(10, 0, 0, 1)),
+		SrcHost: addr.HostFromIP(net.IPv4(10, 0, 0, 2)),
+		Path:    mkDownPath(t, [][2]spath.IntfID{{0, 5}, {1, 2}, {6, 0}}, 1),
+		HBHExt:  []common.Extension{&scmp.Extn{Error: true}, tr},
+		E2EExt:  []common.Extension{&spkt.PathProbe{ProbeID: 42}},
+		L4:      &l4.UDP{SrcPort: 1000, DstPort: 2000},
+		Pld:     common.RawBytes{1, 2, 3, 4, 5},
+	}
+	Convey("Packets created by the router are parsed back by spkt.ParseScnPkt", t, func() {
+		rp, err := RtrPktFromScnPkt(sp, DirExternal)
+		So(err, ShouldBeNil)
+		sp2, err := spkt.ParseScnPkt(rp.Raw)
+		So(err, ShouldBeNil)
+		So(sp2.DstIA, ShouldResemble, sp.DstIA)
+		So(sp2.SrcIA, ShouldResemble, sp.SrcIA)
+		So(sp2.DstHost.String(), ShouldEqual, sp.DstHost.String())
+		So(sp2.SrcHost.String(), ShouldEqual, sp.SrcHost.String())
+		So(sp2.Path.Raw, ShouldResemble, sp.Path.Raw)
+		So(sp2.Path.InfOff, ShouldEqual, sp.Path.InfOff)
+		So(sp2.Path.HopOff, ShouldEqual, sp.Path.HopOff)
+		So(sp2.HBHExt, ShouldResemble, sp.HBHExt)
+		So(sp2.E2EExt, ShouldResemble, sp.E2EExt)
+		So(sp2.L4.(*l4.UDP).SrcPort, ShouldEqual, 1000)
+		So(sp2.L4.(*l4.UDP).DstPort, ShouldEqual, 2000)
+		So(sp2.Pld, ShouldResemble, sp.Pld)
+		Convey("Truncated packets are rejected", func() {
+			for i := 0; i < len(rp.Raw); i++ {
+				_, err := spkt.ParseScnPkt(rp.Raw[:i])
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
@@ -20,8 +20,8 @@ package rpkt
 import (
 	log "github.com/inconshreveable/log15"
 
-	"github.com/netsec-ethz/scion/go/border/conf"
 	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
 	"github.com/netsec-ethz/scion/go/lib/spath"
 	"github.com/netsec-ethz/scion/go/lib/spkt"
 )
@@ -59,12 +59,26 @@ func (o *rOneHopPath) HopF() (HookResult, *spath.HopField, *common.Error) {
 	if err != nil {
 		return HookError, nil, err
 	}
+	if infoF == nil {
+		// There's no path, which is caught by path validation.
+		return HookContinue, nil, nil
+	}
+	// There must be a previous HopF in the current segment, and room for the
+	// new one.
+	hOff := int(o.rp.CmnHdr.CurrHopF)
+	if hOff < int(o.rp.CmnHdr.CurrInfoF)+spath.InfoFieldLength+spath.HopFieldLength ||
+		hOff+spath.HopFieldLength > int(o.rp.CmnHdr.HdrLen) {
+		sdata := scmp.NewErrData(scmp.C_CmnHdr, scmp.T_C_BadHopFOffset, nil)
+		return HookError, nil, common.NewErrorData("Invalid One Hop Path HopF offset", sdata,
+			"offset", hOff, "infoF", o.rp.CmnHdr.CurrInfoF, "hdrLen", o.rp.CmnHdr.HdrLen)
+	}
 	// Retrieve the previous HopF, create a new HopF for this AS, and write it into the path header.
 	prevIdx := o.rp.CmnHdr.CurrHopF - spath.HopFieldLength
 	prevHof := o.rp.Raw[prevIdx+1 : o.rp.CmnHdr.CurrHopF]
-	inIF := conf.C.Net.IFAddrMap[o.rp.Ingress.Dst.String()]
+	c := o.rp.Conf()
+	inIF := c.Net.IFAddrMap[o.rp.Ingress.Dst.String()]
 	hopF := spath.NewHopField(o.rp.Raw[o.rp.CmnHdr.CurrHopF:], inIF, 0)
-	mac, err := hopF.CalcMac(conf.C.HFGenBlock, infoF.TsInt, prevHof)
+	mac, err := hopF.CalcMac(c.HFGenBlock, infoF.TsInt, prevHof)
 	if err != nil {
 		return HookError, nil, err
 	}
@@ -0,0 +1,51 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains the router's representation of the PathProbe end-to-end
+// extension.
+
+package rpkt
+
+import (
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+var _ rExtension = (*rPathProbe)(nil)
+
+// rPathProbe is the router's representation of the PathProbe extension. Probes
+// addressed to the router are acknowledged by any reply it sends, as reversing
+// the extension turns it into an acknowledgement.
+type rPathProbe struct {
+	*spkt.PathProbe
+	log.Logger
+}
+
+func rPathProbeFromRaw(rp *RtrPkt, start, end int) (*rPathProbe, *common.Error) {
+	p, err := spkt.PathProbeFromRaw(rp.Raw[start:end])
+	if err != nil {
+		return nil, err
+	}
+	return &rPathProbe{PathProbe: p, Logger: rp.Logger.New("ext", "PathProbe")}, nil
+}
+
+func (p *rPathProbe) RegisterHooks(h *hooks) *common.Error {
+	return nil
+}
+
+func (p *rPathProbe) GetExtn() (common.Extension, *common.Error) {
+	return p.PathProbe.Copy(), nil
+}
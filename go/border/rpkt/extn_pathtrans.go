@@ -0,0 +1,51 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains the router's representation of the PathTrans end-to-end
+// extension.
+
+package rpkt
+
+import (
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+var _ rExtension = (*rPathTrans)(nil)
+
+// rPathTrans is the router's representation of the PathTrans extension. The
+// router doesn't use the transported path itself, so the extension is only
+// validated and made available to hooks.
+type rPathTrans struct {
+	*spkt.PathTrans
+	log.Logger
+}
+
+func rPathTransFromRaw(rp *RtrPkt, start, end int) (*rPathTrans, *common.Error) {
+	p, err := spkt.PathTransFromRaw(rp.Raw[start:end])
+	if err != nil {
+		return nil, err
+	}
+	return &rPathTrans{PathTrans: p, Logger: rp.Logger.New("ext", "PathTrans")}, nil
+}
+
+func (p *rPathTrans) RegisterHooks(h *hooks) *common.Error {
+	return nil
+}
+
+func (p *rPathTrans) GetExtn() (common.Extension, *common.Error) {
+	return p.PathTrans.Copy(), nil
+}
@@ -23,7 +23,6 @@ import (
 
 	log "github.com/inconshreveable/log15"
 
-	"github.com/netsec-ethz/scion/go/border/conf"
 	"github.com/netsec-ethz/scion/go/lib/addr"
 	"github.com/netsec-ethz/scion/go/lib/common"
 	"github.com/netsec-ethz/scion/go/lib/spkt"
@@ -48,7 +47,7 @@ func rTracerouteFromRaw(rp *RtrPkt, start, end int) (*rTraceroute, *common.Error
 	t := &rTraceroute{rp: rp, raw: rp.Raw[start:end]}
 	t.NumHops = t.raw[0]
 	// Ignore subheader line
-	t.TotalHops = uint8(len(t.raw)-common.ExtnFirstLineLen) / common.LineLen
+	t.TotalHops = uint8((len(t.raw) - common.ExtnFirstLineLen) / common.LineLen)
 	t.Logger = rp.Logger.New("ext", "traceroute")
 	return t, nil
 }
@@ -70,8 +69,9 @@ func (t *rTraceroute) Add(entry *spkt.TracerouteEntry) *common.Error {
 
 // Entry parses a specified traceroute entry from the underlying buffer.
 func (t *rTraceroute) Entry(idx int) (*spkt.TracerouteEntry, *common.Error) {
-	if idx > int(t.NumHops-1) {
-		return nil, common.NewError("Entry index out of range", "idx", idx, "max", t.NumHops-1)
+	if idx >= int(t.NumHops) || idx >= int(t.TotalHops) {
+		return nil, common.NewError("Entry index out of range", "idx", idx,
+			"numHops", t.NumHops, "totalHops", t.TotalHops)
 	}
 	entry := spkt.TracerouteEntry{}
 	offset := common.ExtnFirstLineLen + common.LineLen*idx
@@ -105,7 +105,7 @@ func (t *rTraceroute) Process() (HookResult, *common.Error) {
 	// Take the current time in milliseconds, and truncate it to 16bits.
 	ts := (time.Now().UnixNano() / 1000) % (1 << 16)
 	entry := spkt.TracerouteEntry{
-		IA: *conf.C.IA, IfID: uint16(*t.rp.ifCurr), TimeStamp: uint16(ts),
+		IA: *t.rp.Conf().IA, IfID: uint16(*t.rp.ifCurr), TimeStamp: uint16(ts),
 	}
 	if err := t.Add(&entry); err != nil {
 		t.Error("Unable to add entry", err)
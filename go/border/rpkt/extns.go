@@ -34,11 +34,6 @@ type rExtension interface {
 	RegisterHooks(*hooks) *common.Error
 }
 
-const (
-	// FIXME(kormat): remove when generic header walker is implemented.
-	errExtChainTooLong = "Extension header chain longer than packet"
-)
-
 // extnParseHBH parses a specified hop-by-hop extension in a packet.
 func (rp *RtrPkt) extnParseHBH(extType common.ExtnType,
 	start, end, pos int) (rExtension, *common.Error) {
@@ -57,6 +52,59 @@ func (rp *RtrPkt) extnParseHBH(extType common.ExtnType,
 	}
 }
 
+// extnParseE2E parses a specified end-to-end extension in a packet. pos is the
+// index of the extension in the packet's extension chain (hop-by-hop
+// extensions included), which is used in SCMP errors. Unsupported extensions
+// are not an error, as end-to-end extensions are meant for the end hosts, so
+// nil is returned for them.
+func (rp *RtrPkt) extnParseE2E(extType common.ExtnType,
+	start, end, pos int) (rExtension, *common.Error) {
+	var e rExtension
+	var err *common.Error
+	switch {
+	case extType == common.ExtnPathTransType:
+		e, err = rPathTransFromRaw(rp, start, end)
+	case extType == common.ExtnPathProbeType:
+		e, err = rPathProbeFromRaw(rp, start, end)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		// The extension is malformed, so send an SCMP error in response.
+		err.Data = scmp.NewErrData(scmp.C_Ext, scmp.T_E_BadEnd2End,
+			&scmp.InfoExtIdx{Idx: uint8(pos)})
+		return nil, err
+	}
+	return e, nil
+}
+
+// parseE2EExtns parses the end-to-end extensions of a packet, and registers
+// their hooks. This is only done when the router is the packet's
+// destination, as other packets' end-to-end extensions are none of the
+// router's business. Unsupported extensions are skipped.
+func (rp *RtrPkt) parseE2EExtns() *common.Error {
+	if _, err := rp.findL4(); err != nil {
+		return err
+	}
+	for i, eIdx := range rp.idxs.e2eExt {
+		start := eIdx.Index + common.ExtnSubHdrLen
+		end := eIdx.Index + (int(rp.Raw[eIdx.Index+1])+1)*common.LineLen
+		e, err := rp.extnParseE2E(eIdx.Type, start, end, len(rp.idxs.hbhExt)+i)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			rp.Debug("Skipping unsupported end-to-end extension", "type", eIdx.Type)
+			continue
+		}
+		if err := e.RegisterHooks(&rp.hooks); err != nil {
+			return err
+		}
+		rp.E2EExt = append(rp.E2EExt, e)
+	}
+	return nil
+}
+
 // extnAddHBH adds a hop-by-hop extension to a packet the router is creating.
 // This method does not add SCMP data to errors as this is a packet that's been
 // constructed locally.
@@ -110,6 +158,46 @@ func (rp *RtrPkt) extnAddHBH(e common.Extension) *common.Error {
 	return nil
 }
 
+// extnAddE2E adds an end-to-end extension to a packet the router is creating.
+// It must be called after all hop-by-hop extensions have been added. As with
+// extnAddHBH, errors don't have SCMP data.
+func (rp *RtrPkt) extnAddE2E(e common.Extension) *common.Error {
+	// The new extension goes after the last extension, if any.
+	offset := rp.idxs.l4
+	nextHdr := (*uint8)(&rp.CmnHdr.NextHdr)
+	if n := len(rp.idxs.hbhExt); n > 0 {
+		nextHdr = &rp.Raw[rp.idxs.hbhExt[n-1].Index]
+	}
+	if n := len(rp.idxs.e2eExt); n > 0 {
+		nextHdr = &rp.Raw[rp.idxs.e2eExt[n-1].Index]
+	}
+	eLen := e.Len() + common.ExtnSubHdrLen
+	if eLen%common.LineLen != 0 {
+		return common.NewError("E2E Ext length not multiple of line length",
+			"lineLen", common.LineLen, "actual", eLen)
+	}
+	et := e.Type()
+	*nextHdr = uint8(et.Class)
+	rp.Raw[offset] = uint8(common.L4None)
+	rp.Raw[offset+1] = uint8(eLen/common.LineLen) - 1
+	rp.Raw[offset+2] = et.Type
+	if err := e.Write(rp.Raw[offset+common.ExtnSubHdrLen : offset+eLen]); err != nil {
+		return err
+	}
+	re, err := rp.extnParseE2E(et, offset+common.ExtnSubHdrLen, offset+eLen,
+		len(rp.idxs.hbhExt)+len(rp.idxs.e2eExt))
+	if err != nil {
+		err.Data = nil
+		return err
+	}
+	re.RegisterHooks(&rp.hooks)
+	rp.E2EExt = append(rp.E2EExt, re)
+	rp.idxs.e2eExt = append(rp.idxs.e2eExt, extnIdx{et, offset})
+	rp.idxs.l4 = offset + eLen
+	rp.idxs.pld = rp.idxs.l4
+	return nil
+}
+
 // validateExtns validates the order and number of extensions.
 func (rp *RtrPkt) validateExtns() *common.Error {
 	max := rp.maxHBHExtns()
@@ -0,0 +1,75 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file calculates flow hashes, used to spread packets over worker
+// goroutines without reordering packets within a flow.
+
+package rpkt
+
+import (
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+	// l4PortsLen is the length of the source and destination ports at the
+	// start of UDP (and similar) L4 headers.
+	l4PortsLen = 4
+)
+
+// FlowHash calculates a hash over the source/destination ISD-ASes and hosts,
+// and, for UDP, the source/destination ports of a raw packet. It only reads
+// the fields it needs (no parsing or validation is done), so it is cheap
+// enough to call before a packet is handed to a worker. The path is not
+// included, as it changes at every hop. Truncated or malformed packets still
+// return a hash of whatever fields could be read.
+func FlowHash(b common.RawBytes) uint32 {
+	h := uint32(fnvOffset32)
+	if len(b) < spkt.CmnHdrLen {
+		return fnvBytes(h, b)
+	}
+	cmnHdr := &spkt.CmnHdr{}
+	// A version error is ignored, as all fields needed are still parsed.
+	cmnHdr.Parse(b)
+	dstLen, _ := addr.HostLen(cmnHdr.DstType)
+	srcLen, _ := addr.HostLen(cmnHdr.SrcType)
+	addrEnd := spkt.CmnHdrLen + 2*addr.IABytes + int(dstLen) + int(srcLen)
+	if addrEnd > len(b) {
+		return fnvBytes(h, b[spkt.CmnHdrLen:])
+	}
+	h = fnvBytes(h, b[spkt.CmnHdrLen:addrEnd])
+	// Skip over any extensions to find the L4 header.
+	w := spkt.NewExtnWalker(b, cmnHdr.NextHdr, int(cmnHdr.HdrLen))
+	for !w.Done() {
+		if _, err := w.Next(); err != nil {
+			return h
+		}
+	}
+	if offset := w.Offset(); w.NextHdr() == common.L4UDP && offset+l4PortsLen <= len(b) {
+		h = fnvBytes(h, b[offset:offset+l4PortsLen])
+	}
+	return h
+}
+
+// fnvBytes extends the 32-bit FNV-1a hash h with b.
+func fnvBytes(h uint32, b common.RawBytes) uint32 {
+	for _, c := range b {
+		h ^= uint32(c)
+		h *= fnvPrime32
+	}
+	return h
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpkt
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/l4"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+// mkUDPPkt creates a UDP packet from 1-12 to 1-13, with the current Hop
+// Field set to curr.
+func mkUDPPkt(t testing.TB, srcHost net.IP, srcPort, dstPort uint16, curr int) *RtrPkt {
+	return mkExtPkt(t, &spkt.ScnPkt{
+		DstIA: &addr.ISD_AS{I: 1, A: 13}, SrcIA: &addr.ISD_AS{I: 1, A: 12},
+		DstHost: addr.HostFromIP(net.IPv4(10, 0, 0, 1)),
+		SrcHost: addr.HostFromIP(srcHost),
+		Path:    mkDownPath(t, [][2]spath.IntfID{{0, 5}, {1, 2}, {6, 0}}, curr),
+		L4:      &l4.UDP{SrcPort: srcPort, DstPort: dstPort},
+	}, 1)
+}
+
+func Test_FlowHash(t *testing.T) {
+	setupTestConf(t, "br1-11-1")
+	host := net.IPv4(10, 0, 0, 2)
+	Convey("Packets of the same flow have the same hash", t, func() {
+		a := mkUDPPkt(t, host, 1000, 2000, 1)
+		b := mkUDPPkt(t, host, 1000, 2000, 2)
+		So(a.Raw[6], ShouldNotEqual, b.Raw[6]) // CurrHopF
+		So(FlowHash(a.Raw), ShouldEqual, FlowHash(b.Raw))
+	})
+	Convey("Packets of different flows have different hashes", t, func() {
+		base := FlowHash(mkUDPPkt(t, host, 1000, 2000, 1).Raw)
+		So(FlowHash(mkUDPPkt(t, host, 1001, 2000, 1).Raw), ShouldNotEqual, base)
+		So(FlowHash(mkUDPPkt(t, host, 1000, 2001, 1).Raw), ShouldNotEqual, base)
+		So(FlowHash(mkUDPPkt(t, net.IPv4(10, 0, 0, 3), 1000, 2000, 1).Raw),
+			ShouldNotEqual, base)
+	})
+	Convey("Truncated packets don't cause a panic", t, func() {
+		raw := mkUDPPkt(t, host, 1000, 2000, 1).Raw
+		for i := 0; i <= len(raw); i++ {
+			So(func() { FlowHash(raw[:i]) }, ShouldNotPanic)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.18
+
+// Native fuzzing (testing.F) requires Go 1.18 or later.
+
+package rpkt
+
+import (
+	"net"
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+	"github.com/netsec-ethz/scion/go/proto"
+)
+
+// FuzzRtrPkt runs arbitrary packets through the same stages as
+// Router.processPacket, including the path reversal done when replying with
+// an SCMP error. Any panic is a bug, as packet contents must only ever cause
+// errors.
+func FuzzRtrPkt(f *testing.F) {
+	sent := setupTestConf(f, "br1-11-1")
+	Init(func(proto.IFStateInfos) {}, func(RevTokenCallbackArgs) {},
+		func(*RtrPkt, *scmp.InfoEcho) {}, func(*RtrPkt, *scmp.InfoEcho) {},
+		func(spath.IntfID) {}, func(addr.ISD_AS, common.RawBytes) {})
+	log.Root().SetHandler(log.DiscardHandler())
+	defer log.Root().SetHandler(log.StdoutHandler)
+	// Seed corpus of valid packets.
+	host := net.IPv4(10, 0, 0, 2)
+	for curr := 0; curr < 3; curr++ {
+		f.Add([]byte(mkUDPPkt(f, host, 1000, 2000, curr).Raw), uint8(1))
+	}
+	echo := scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoRequest}
+	f.Add([]byte(mkSCMPPkt(f, echo, &scmp.InfoEcho{Id: 1, Seq: 2}).Raw), uint8(1))
+	trace := mkExtPkt(f, &spkt.ScnPkt{
+		DstIA: &addr.ISD_AS{I: 1, A: 13}, SrcIA: &addr.ISD_AS{I: 1, A: 12},
+		DstHost: addr.HostFromIP(net.IPv4(10, 0, 0, 1)), SrcHost: addr.HostFromIP(host),
+		Path:   mkDownPath(f, [][2]spath.IntfID{{0, 5}, {1, 2}, {6, 0}}, 1),
+		HBHExt: []common.Extension{spkt.NewTraceroute(3), &scmp.Extn{Error: true}},
+	}, 1)
+	f.Add([]byte(trace.Raw), uint8(1))
+	f.Add([]byte(mkUDPPkt(f, host, 1000, 2000, 1).Raw), uint8(0))
+	f.Fuzz(func(t *testing.T, raw []byte, ifid uint8) {
+		*sent = nil
+		rp := NewRtrPkt()
+		if len(raw) > cap(rp.Raw) {
+			return
+		}
+		rp.Raw = rp.Raw[:copy(rp.Raw[:cap(rp.Raw)], raw)]
+		rp.Logger = log.New("rpkt", "fuzz")
+		n := conf.Get().Net
+		if intf, ok := n.IFs[spath.IntfID(ifid)]; ok {
+			rp.DirFrom = DirExternal
+			rp.Ingress.Dst = intf.IFAddr.BindAddr()
+			rp.Ingress.Src = intf.RemoteAddr
+			rp.Ingress.IfIDs = []spath.IntfID{intf.Id}
+		} else {
+			loc := n.LocAddr[0].BindAddr()
+			rp.DirFrom = DirLocal
+			rp.Ingress.Dst = loc
+			rp.Ingress.Src = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 30041}
+			rp.Ingress.IfIDs = n.LocAddrIFIDMap[loc.String()]
+		}
+		err := processPkt(rp)
+		if err == nil {
+			return
+		}
+		if _, ok := err.Data.(*scmp.ErrData); !ok || rp.SCMPError {
+			return
+		}
+		// The router would reply with an SCMP error, so create the reply
+		// packet in the same way.
+		if _, err := rp.SrcIA(); err != nil {
+			return
+		}
+		sp, err := rp.ToScnPkt(false)
+		if err != nil {
+			return
+		}
+		sp.Reverse()
+	})
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/netsec-ethz/scion/go/lib/common"
 	"github.com/netsec-ethz/scion/go/lib/l4"
 	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
 )
 
 const (
@@ -67,37 +68,39 @@ func (rp *RtrPkt) L4Hdr(verify bool) (l4.L4Header, *common.Error) {
 	return rp.l4, nil
 }
 
-// findL4 finds the layer 4 header, if any.
+// findL4 finds the layer 4 header, if any, walking past any extensions that
+// haven't been parsed yet. Any header type other than an extension is treated
+// as the L4 protocol, even if it's unsupported. A hop-by-hop extension after
+// an end-to-end extension is an error.
 func (rp *RtrPkt) findL4() (bool, *common.Error) {
 	// Start from the next unparsed header, if any.
-	nextHdr := rp.idxs.nextHdrIdx.Type
-	offset := rp.idxs.nextHdrIdx.Index
-	for offset < len(rp.Raw) {
-		currHdr := nextHdr
-		if _, ok := common.L4Protocols[currHdr]; ok {
-			// Reached L4 protocol
-			rp.L4Type = nextHdr
-			rp.idxs.l4 = offset
-			break
+	w := spkt.NewExtnWalker(rp.Raw, rp.idxs.nextHdrIdx.Type, rp.idxs.nextHdrIdx.Index)
+	e2eExt := rp.idxs.e2eExt
+	for !w.Done() {
+		if w.NextHdr() == common.HopByHopClass && len(e2eExt) > 0 {
+			idx := len(rp.idxs.hbhExt) + len(e2eExt)
+			sdata := scmp.NewErrData(scmp.C_Ext, scmp.T_E_BadExtOrder,
+				&scmp.InfoExtIdx{Idx: uint8(idx)})
+			return false, common.NewErrorData("Hop-by-hop extension after end-to-end extension",
+				sdata, "idx", idx, "offset", w.Offset())
 		}
-		// TODO(kormat): handle detecting unknown L4 protocols.
-		currExtn := common.ExtnType{Class: currHdr, Type: rp.Raw[offset+2]}
-		hdrLen := int((rp.Raw[offset+1] + 1) * common.LineLen)
-		rp.idxs.e2eExt = append(rp.idxs.e2eExt, extnIdx{currExtn, offset})
-		nextHdr = common.L4ProtocolType(rp.Raw[offset])
-		offset += hdrLen
-		if hdrLen == 0 {
+		eh, err := w.Next()
+		if err != nil {
 			// FIXME(kormat): Can't return an SCMP error as we can't parse the headers
-			return false, common.NewError("0-length header", "nextHdr", nextHdr, "offset", offset)
+			return false, err
+		}
+		if eh.Type.Class == common.End2EndClass {
+			e2eExt = append(e2eExt, extnIdx{eh.Type, eh.Offset})
 		}
 	}
-	if offset > len(rp.Raw) {
-		// FIXME(kormat): Can't generally return an SCMP error as parsing the
-		// headers has failed.
-		return false, common.NewError(errExtChainTooLong, "curr", offset, "max", len(rp.Raw))
+	if w.Offset() < len(rp.Raw) {
+		// Reached L4 protocol
+		rp.L4Type = w.NextHdr()
+		rp.idxs.l4 = w.Offset()
 	}
-	rp.idxs.nextHdrIdx.Type = nextHdr
-	rp.idxs.nextHdrIdx.Index = offset
+	rp.idxs.e2eExt = e2eExt
+	rp.idxs.nextHdrIdx.Type = w.NextHdr()
+	rp.idxs.nextHdrIdx.Index = w.Offset()
 	return true, nil
 }
 
@@ -18,7 +18,6 @@
 package rpkt
 
 import (
-	"github.com/netsec-ethz/scion/go/border/conf"
 	"github.com/netsec-ethz/scion/go/lib/addr"
 	"github.com/netsec-ethz/scion/go/lib/assert"
 	"github.com/netsec-ethz/scion/go/lib/common"
@@ -27,13 +26,17 @@ import (
 	"github.com/netsec-ethz/scion/go/lib/util"
 )
 
+const (
+	errPktTooShort = "Packet shorter than its headers"
+)
+
 // Parse handles the basic parsing of a packet.
 func (rp *RtrPkt) Parse() *common.Error {
 	if err := rp.parseBasic(); err != nil {
 		return err
 	}
-	// TODO(kormat): support end2end extensions where the router is the
-	// destination
+	// End-to-end extensions are only parsed once the router is known to be
+	// the destination, see RtrPkt.parseE2EExtns.
 	if err := rp.parseHopExtns(); err != nil {
 		return err
 	}
@@ -41,7 +44,7 @@ func (rp *RtrPkt) Parse() *common.Error {
 	if _, err := rp.DstIA(); err != nil {
 		return err
 	}
-	if *rp.dstIA == *conf.C.IA {
+	if *rp.dstIA == *rp.Conf().IA {
 		// If the destination is local, parse the destination host as well.
 		if _, err := rp.DstHost(); err != nil {
 			return err
@@ -62,14 +65,13 @@ func (rp *RtrPkt) Parse() *common.Error {
 	if _, err := rp.IFNext(); err != nil {
 		return err
 	}
-	if *rp.dstIA != *conf.C.IA {
+	if *rp.dstIA != *rp.Conf().IA {
 		// If the destination isn't local, parse the next interface ID as well.
 		if _, err := rp.IFNext(); err != nil {
 			return err
 		}
 	}
-	rp.setDirTo()
-	return nil
+	return rp.setDirTo()
 }
 
 // parseBasic handles the parsing of the common and address headers.
@@ -77,9 +79,17 @@ func (rp *RtrPkt) parseBasic() *common.Error {
 	var err *common.Error
 	var dstLen, srcLen uint8
 	// Parse common header.
+	if len(rp.Raw) < spkt.CmnHdrLen {
+		// Can't generate SCMP error as the common header is incomplete.
+		return common.NewError(errPktTooShort, "min", spkt.CmnHdrLen, "actual", len(rp.Raw))
+	}
 	if err = rp.CmnHdr.Parse(rp.Raw); err != nil {
 		return err
 	}
+	if int(rp.CmnHdr.HdrLen) > len(rp.Raw) {
+		// Can't generate SCMP error as the headers are incomplete.
+		return common.NewError(errPktTooShort, "hdrLen", rp.CmnHdr.HdrLen, "actual", len(rp.Raw))
+	}
 	// Set indexes for destination and source ISD-ASes.
 	rp.idxs.dstIA = spkt.CmnHdrLen
 	rp.idxs.srcIA = rp.idxs.dstIA + addr.IABytes
@@ -118,64 +128,68 @@ func (rp *RtrPkt) parseHopExtns() *common.Error {
 	rp.idxs.hbhExt = make([]extnIdx, 0, common.ExtnMaxHBH+1)
 	rp.idxs.nextHdrIdx.Type = rp.CmnHdr.NextHdr
 	rp.idxs.nextHdrIdx.Index = int(rp.CmnHdr.HdrLen)
-	nextHdr := &rp.idxs.nextHdrIdx.Type
-	offset := &rp.idxs.nextHdrIdx.Index
-	for *offset < len(rp.Raw) {
-		currHdr := *nextHdr
-		if currHdr != common.HopByHopClass { // Reached end2end header or L4 protocol
-			break
+	w := spkt.NewExtnWalker(rp.Raw, rp.CmnHdr.NextHdr, int(rp.CmnHdr.HdrLen))
+	// Stop at the first end2end header or L4 protocol.
+	for !w.Done() && w.NextHdr() == common.HopByHopClass {
+		eh, err := w.Next()
+		if err != nil {
+			// FIXME(kormat): Can't generate SCMP error in general as we can't
+			// parse anything after the hbh extensions (e.g. a layer 4 header).
+			return err
 		}
-		currExtn := common.ExtnType{Class: currHdr, Type: rp.Raw[*offset+2]}
-		hdrLen := int((rp.Raw[*offset+1] + 1) * common.LineLen)
-		e, err := rp.extnParseHBH(
-			currExtn, *offset+common.ExtnSubHdrLen, *offset+hdrLen, len(rp.idxs.hbhExt))
+		e, err := rp.extnParseHBH(eh.Type, eh.Start(), eh.End(), len(rp.idxs.hbhExt))
 		if err != nil {
 			return err
 		}
 		e.RegisterHooks(&rp.hooks)
 		rp.HBHExt = append(rp.HBHExt, e)
-		rp.idxs.hbhExt = append(rp.idxs.hbhExt, extnIdx{currExtn, *offset})
-		*nextHdr = common.L4ProtocolType(rp.Raw[*offset])
-		*offset += hdrLen
-	}
-	if *offset > len(rp.Raw) {
-		// FIXME(kormat): Can't generate SCMP error in general as we can't
-		// parse anything after the hbh extensions (e.g. a layer 4 header).
-		return common.NewError(errExtChainTooLong, "curr", offset, "max", len(rp.Raw))
+		rp.idxs.hbhExt = append(rp.idxs.hbhExt, extnIdx{eh.Type, eh.Offset})
+		// Only move past the extension once it's been parsed successfully.
+		rp.idxs.nextHdrIdx.Type = w.NextHdr()
+		rp.idxs.nextHdrIdx.Index = w.Offset()
 	}
 	return nil
 }
 
 // setDirTo figures out which Dir a packet is going to, and sets the DirTo
 // field accordingly.
-func (rp *RtrPkt) setDirTo() {
+func (rp *RtrPkt) setDirTo() *common.Error {
 	if assert.On {
 		assert.Must(rp.DirFrom != DirSelf, rp.ErrStr("DirFrom must not be DirSelf."))
 		assert.Must(rp.DirFrom != DirUnset, rp.ErrStr("DirFrom must not be DirUnset."))
 		assert.Must(rp.ifCurr != nil, rp.ErrStr("rp.ifCurr must not be nil."))
 	}
-	if *rp.dstIA != *conf.C.IA {
+	c := rp.Conf()
+	if *rp.dstIA != *c.IA {
 		// Packet is not destined to the local AS, so it can't be DirSelf.
 		if rp.DirFrom == DirLocal {
 			rp.DirTo = DirExternal
 		} else if rp.DirFrom == DirExternal {
-			// XXX(kormat): this logic might be too simple once a router can
-			// have multiple interfaces.
 			rp.DirTo = DirLocal
+			if rp.ifNext != nil {
+				if _, ok := c.Net.IFs[*rp.ifNext]; ok {
+					// The next interface is on this router.
+					rp.DirTo = DirExternal
+				}
+			}
 		}
-		return
+		return nil
 	}
 	// Local AS is the destination, so figure out if it's DirLocal or DirSelf.
-	intf := conf.C.Net.IFs[*rp.ifCurr]
+	intf, err := rp.intf(*rp.ifCurr)
+	if err != nil {
+		return err
+	}
 	var intfHost addr.HostAddr
 	if rp.DirFrom == DirExternal {
 		intfHost = addr.HostFromIP(intf.IFAddr.PublicAddr().IP)
 	} else {
-		intfHost = addr.HostFromIP(conf.C.Net.LocAddr[intf.LocAddrIdx].PublicAddr().IP)
+		intfHost = addr.HostFromIP(c.Net.LocAddr[intf.LocAddrIdx].PublicAddr().IP)
 	}
 	if addr.HostEq(rp.dstHost, intfHost) {
 		rp.DirTo = DirSelf
 	} else {
 		rp.DirTo = DirLocal
 	}
+	return nil
 }
@@ -19,13 +19,29 @@ package rpkt
 import (
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/maccache"
+	"github.com/netsec-ethz/scion/go/border/metrics"
 	"github.com/netsec-ethz/scion/go/lib/assert"
 	"github.com/netsec-ethz/scion/go/lib/common"
 	"github.com/netsec-ethz/scion/go/lib/scmp"
 	"github.com/netsec-ethz/scion/go/lib/spath"
 )
 
+// MAC cache lookup and verification counters, resolved once as they're used
+// for every packet.
+var (
+	macCacheHits   = metrics.MACCacheLookups.WithLabelValues("hit")
+	macCacheMisses = metrics.MACCacheLookups.WithLabelValues("miss")
+	hopFVerified   = map[string]prometheus.Counter{
+		conf.HFKeyCurrent: metrics.HopFVerified.WithLabelValues(conf.HFKeyCurrent),
+		conf.HFKeyPrev:    metrics.HopFVerified.WithLabelValues(conf.HFKeyPrev),
+		conf.HFKeyNext:    metrics.HopFVerified.WithLabelValues(conf.HFKeyNext),
+	}
+)
+
 // validatePath validates the path header.
 func (rp *RtrPkt) validatePath(dirFrom Dir) *common.Error {
 	if assert.On {
@@ -51,44 +67,89 @@ func (rp *RtrPkt) validatePath(dirFrom Dir) *common.Error {
 		return common.NewErrorData("Hop field is VERIFY_ONLY", sdata)
 	}
 	// A forward-only Hop Field cannot be used for local delivery.
-	if rp.hopF.ForwardOnly && rp.dstIA == conf.C.IA {
+	if rp.hopF.ForwardOnly && rp.dstIA.Eq(rp.Conf().IA) {
 		sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_DeliveryFwdOnly, rp.mkInfoPathOffsets())
 		return common.NewErrorData("Hop field is FORWARD_ONLY", sdata)
 	}
 	// Check if Hop Field has expired.
 	hopfExpiry := rp.infoF.Timestamp().Add(
 		time.Duration(rp.hopF.ExpTime) * spath.ExpTimeUnit * time.Second)
-	if time.Now().After(hopfExpiry) {
+	now := time.Now()
+	if now.After(hopfExpiry) {
 		sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_ExpiredHopF, rp.mkInfoPathOffsets())
 		return common.NewErrorData("Hop field expired", sdata, "expiry", hopfExpiry)
 	}
-	// Verify the Hop Field MAC.
-	err := rp.hopF.Verify(conf.C.HFGenBlock, rp.infoF.TsInt, rp.getHopFVer(dirFrom))
-	if err != nil && err.Desc == spath.ErrorHopFBadMac {
-		err.Data = scmp.NewErrData(scmp.C_Path, scmp.T_P_BadMac, rp.mkInfoPathOffsets())
+	return rp.verifyHopF(dirFrom, hopfExpiry, now)
+}
+
+// verifyHopF verifies the current Hop Field's MAC, unless it is found in the
+// MAC cache. The MAC is accepted if any of the currently valid Hop Field keys
+// verifies it. Verified Hop Fields are added to the cache until they or the
+// verifying key expire.
+func (rp *RtrPkt) verifyHopF(dirFrom Dir, expiry, now time.Time) *common.Error {
+	c := rp.Conf()
+	prev := rp.getHopFVer(dirFrom)
+	hOff := int(rp.CmnHdr.CurrHopF)
+	k := maccache.NewKey(rp.infoF.TsInt, rp.Raw[hOff:hOff+spath.HopFieldLength], prev)
+	if name, ok := c.MACCache.Get(k, now); ok {
+		macCacheHits.Inc()
+		hopFVerified[name].Inc()
+		return nil
 	}
+	macCacheMisses.Inc()
+	var err *common.Error
+	for _, key := range c.HFKeys {
+		if !key.Valid(now) {
+			continue
+		}
+		kerr := rp.hopF.Verify(key.Block, rp.infoF.TsInt, prev)
+		if kerr == nil {
+			c.MACCache.Add(k, key.Name, key.Expiry(expiry), now)
+			hopFVerified[key.Name].Inc()
+			return nil
+		}
+		if kerr.Desc != spath.ErrorHopFBadMac {
+			return kerr
+		}
+		// Report the error from the first (i.e. current) key.
+		if err == nil {
+			err = kerr
+		}
+	}
+	if err == nil {
+		return common.NewError("No valid Hop Field key")
+	}
+	err.Data = scmp.NewErrData(scmp.C_Path, scmp.T_P_BadMac, rp.mkInfoPathOffsets())
 	return err
 }
 
 // validateLocalIF makes sure a given interface ID exists in the local AS, and
-// that it isn't revoked. Note that revocations are ignored if the packet's
-// destination is this router.
+// that it isn't revoked or administratively down. Note that both are ignored
+// if the packet's destination is this router.
 func (rp *RtrPkt) validateLocalIF(ifid *spath.IntfID) *common.Error {
 	if ifid == nil {
 		return common.NewError("validateLocalIF: Interface is nil")
 	}
-	if _, ok := conf.C.TopoMeta.IFMap[int(*ifid)]; !ok {
+	c := rp.Conf()
+	if _, ok := c.TopoMeta.IFMap[int(*ifid)]; !ok {
 		// No such interface.
 		sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_BadIF, rp.mkInfoPathOffsets())
-		return common.NewErrorData("Unknown IF", sdata, "ifid", ifid)
-	}
-	conf.C.IFStates.RLock()
-	info, ok := conf.C.IFStates.M[*ifid]
-	conf.C.IFStates.RUnlock()
-	if !ok || info.P.Active() || rp.DirTo == DirSelf {
-		// Either the interface isn't revoked, or the packet is to this
-		// router, in which case revocations are ignored to allow communication
-		// with the router.
+		return common.NewErrorData("Unknown IF", sdata, "ifid", *ifid)
+	}
+	if rp.DirTo == DirSelf {
+		// Revocations and administrative state are ignored to allow
+		// communication with the router.
+		return nil
+	}
+	c.IFStates.RLock()
+	info, ok := c.IFStates.M[*ifid]
+	c.IFStates.RUnlock()
+	if !ok || info.P.Active() {
+		// The interface isn't revoked, so check if it has been disabled.
+		if c.AdminDown.IsDown(*ifid) {
+			sdata := scmp.NewErrData(scmp.C_Routing, scmp.T_R_AdminDenied, nil)
+			return common.NewErrorData(errIntfAdminDown, sdata, "ifid", *ifid)
+		}
 		return nil
 	}
 	// Interface is revoked.
@@ -96,15 +157,20 @@ func (rp *RtrPkt) validateLocalIF(ifid *spath.IntfID) *common.Error {
 		uint16(rp.CmnHdr.CurrInfoF), uint16(rp.CmnHdr.CurrHopF), uint16(*ifid),
 		rp.DirFrom == DirExternal, info.RawRev)
 	sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_RevokedIF, sinfo)
-	return common.NewErrorData(errIntfRevoked, sdata, "ifid", ifid)
+	return common.NewErrorData(errIntfRevoked, sdata, "ifid", *ifid)
 }
 
 // mkInfoPathOffsets is a helper function to create an scmp.InfoPathOffsets
-// instance from the current packet.
+// instance from the current packet. The interface ID is 0 if the current
+// interface isn't known (e.g. in packets created by the router).
 func (rp *RtrPkt) mkInfoPathOffsets() scmp.Info {
+	var ifid spath.IntfID
+	if rp.ifCurr != nil {
+		ifid = *rp.ifCurr
+	}
 	return &scmp.InfoPathOffsets{
 		InfoF: uint16(rp.CmnHdr.CurrInfoF), HopF: uint16(rp.CmnHdr.CurrHopF),
-		IfID: uint16(*rp.ifCurr), Ingress: rp.DirFrom == DirExternal,
+		IfID: uint16(ifid), Ingress: rp.DirFrom == DirExternal,
 	}
 }
 
@@ -319,12 +385,12 @@ func (rp *RtrPkt) IncPath() (bool, *common.Error) {
 	// Check that there's no VERIFY_ONLY fields in the middle of a segment.
 	if vOnly > 0 && !segChgd {
 		sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_BadHopField, rp.mkInfoPathOffsets())
-		return segChgd, common.NewError("VERIFY_ONLY in middle of segment", sdata)
+		return segChgd, common.NewErrorData("VERIFY_ONLY in middle of segment", sdata)
 	}
 	// Check that the segment didn't change from a down-segment to an up-segment.
 	if !origUp && *rp.upFlag {
 		sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_BadSegment, rp.mkInfoPathOffsets())
-		return segChgd, common.NewError("Switched from down-segment to up-segment", sdata)
+		return segChgd, common.NewErrorData("Switched from down-segment to up-segment", sdata)
 	}
 	return segChgd, nil
 }
@@ -396,7 +462,7 @@ func (rp *RtrPkt) checkSetCurrIF(ifid *spath.IntfID) (*spath.IntfID, *common.Err
 	if ifid == nil {
 		return nil, common.NewError("No interface found")
 	}
-	if _, ok := conf.C.Net.IFs[*ifid]; !ok {
+	if _, ok := rp.Conf().Net.IFs[*ifid]; !ok {
 		return nil, common.NewError("Unknown interface", "ifid", *ifid)
 	}
 	rp.ifCurr = ifid
@@ -0,0 +1,181 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpkt
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/maccache"
+	"github.com/netsec-ethz/scion/go/lib/as_conf"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/util"
+)
+
+func Test_VerifyHopF_Cache(t *testing.T) {
+	setupTestConf(t, "br1-11-1")
+	host := net.IPv4(10, 0, 0, 2)
+	Convey("Verified Hop Fields are cached", t, func() {
+		cache := maccache.New()
+		conf.Get().MACCache = cache
+		So(processPkt(mkUDPPkt(t, host, 1000, 2000, 1)), ShouldBeNil)
+		So(cache.Len(), ShouldEqual, 1)
+		So(processPkt(mkUDPPkt(t, host, 1001, 2001, 1)), ShouldBeNil)
+		So(cache.Len(), ShouldEqual, 1)
+		Convey("A cached Hop Field doesn't validate a different MAC", func() {
+			rp := mkUDPPkt(t, host, 1000, 2000, 1)
+			// Byte 6 of the common header is the current Hop Field offset.
+			rp.Raw[int(rp.Raw[6])+spath.HopFieldLength-1] ^= 0xFF
+			err := processPkt(rp)
+			So(err, ShouldNotBeNil)
+			So(err.Desc, ShouldEqual, spath.ErrorHopFBadMac)
+			So(err.Data.(*scmp.ErrData).CT, ShouldResemble,
+				scmp.ClassType{Class: scmp.C_Path, Type: scmp.T_P_BadMac})
+			So(cache.Len(), ShouldEqual, 1)
+		})
+	})
+	Convey("The cache is kept across configurations with the same master AS key", t, func() {
+		old := conf.Get()
+		newConf := *old
+		newConf.MACCache = nil
+		conf.Set(&newConf)
+		So(conf.Get().MACCache, ShouldEqual, old.MACCache)
+		Convey("but not when the key changes", func() {
+			asConf := *old.ASConf
+			asConf.MasterASKey = util.B64Bytes("0123456789abcdef")
+			newConf := *old
+			newConf.ASConf = &asConf
+			newConf.MACCache = nil
+			conf.Set(&newConf)
+			So(conf.Get().MACCache, ShouldNotEqual, old.MACCache)
+			So(conf.Get().MACCache.Len(), ShouldEqual, 0)
+		})
+		Reset(func() { conf.Set(old) })
+	})
+}
+
+func Test_VerifyHopF_KeyRollover(t *testing.T) {
+	setupTestConf(t, "br1-11-1")
+	host := net.IPv4(10, 0, 0, 2)
+	old := conf.Get()
+	oldKey := old.HFKeys[0]
+	newBlock, err := util.InitAES([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("Error creating block cipher: %v", err)
+	}
+	newKey := &conf.HFKey{Name: conf.HFKeyCurrent, Block: newBlock, Master: &as_conf.MasterKey{}}
+	now := time.Now()
+	// mkPkt creates a test packet, with the MACs of the key from testdata/.
+	mkPkt := func() *RtrPkt {
+		conf.Set(old)
+		return mkUDPPkt(t, host, 1000, 2000, 1)
+	}
+	// setKeys installs a configuration with the given keys and an empty cache,
+	// and returns the cache.
+	setKeys := func(keys ...*conf.HFKey) *maccache.Cache {
+		c := *old
+		c.HFKeys = keys
+		c.HFGenBlock = keys[0].Block
+		c.MACCache = maccache.New()
+		conf.Set(&c)
+		return c.MACCache
+	}
+	// mkKey creates a copy of the testdata/ key with the given name and window.
+	mkKey := func(name string, notBefore, notAfter time.Time) *conf.HFKey {
+		return &conf.HFKey{Name: name, Block: oldKey.Block, Master: &as_conf.MasterKey{
+			Key:       oldKey.Master.Key,
+			NotBefore: util.YamlTime{Time: notBefore},
+			NotAfter:  util.YamlTime{Time: notAfter},
+		}}
+	}
+	shouldBeBadMac := func(err *common.Error) {
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, spath.ErrorHopFBadMac)
+		So(err.Data.(*scmp.ErrData).CT, ShouldResemble,
+			scmp.ClassType{Class: scmp.C_Path, Type: scmp.T_P_BadMac})
+	}
+	Convey("A MAC from the previous key is accepted until the key expires", t, func() {
+		rp := mkPkt()
+		notAfter := now.Add(time.Hour)
+		cache := setKeys(newKey, mkKey(conf.HFKeyPrev, time.Time{}, notAfter))
+		rollover := conf.Get()
+		So(processPkt(rp), ShouldBeNil)
+		So(cache.Len(), ShouldEqual, 1)
+		Convey("The cached entry expires with the key", func() {
+			// Processing moved rp on to the next Hop Field, so use a new packet.
+			rp := mkPkt()
+			conf.Set(rollover)
+			So(rp.Parse(), ShouldBeNil)
+			hopfExpiry := notAfter.Add(time.Hour)
+			So(rp.verifyHopF(DirExternal, hopfExpiry, now), ShouldBeNil)
+			So(cache.Len(), ShouldEqual, 1)
+			shouldBeBadMac(rp.verifyHopF(DirExternal, hopfExpiry, notAfter))
+		})
+	})
+	Convey("A MAC from an expired previous key is rejected", t, func() {
+		rp := mkPkt()
+		setKeys(newKey, mkKey(conf.HFKeyPrev, time.Time{}, now.Add(-time.Second)))
+		shouldBeBadMac(processPkt(rp))
+	})
+	Convey("A MAC from the next key is only accepted once the key is valid", t, func() {
+		rp := mkPkt()
+		setKeys(newKey, mkKey(conf.HFKeyNext, now.Add(time.Hour), time.Time{}))
+		shouldBeBadMac(processPkt(rp))
+		rp = mkPkt()
+		cache := setKeys(newKey, mkKey(conf.HFKeyNext, now.Add(-time.Second), time.Time{}))
+		So(processPkt(rp), ShouldBeNil)
+		So(cache.Len(), ShouldEqual, 1)
+	})
+	Convey("A MAC from an unknown key is rejected", t, func() {
+		rp := mkPkt()
+		setKeys(newKey)
+		shouldBeBadMac(processPkt(rp))
+	})
+	conf.Set(old)
+}
+
+func benchmarkVerifyHopF(b *testing.B, cache *maccache.Cache) {
+	setupTestConf(b, "br1-11-1")
+	conf.Get().MACCache = cache
+	rp := mkUDPPkt(b, net.IPv4(10, 0, 0, 2), 1000, 2000, 1)
+	if err := processPkt(rp); err != nil {
+		b.Fatalf("Error processing packet: %v", err)
+	}
+	now := time.Now()
+	expiry := now.Add(time.Hour)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rp.verifyHopF(DirExternal, expiry, now); err != nil {
+			b.Fatalf("Error verifying Hop Field: %v", err)
+		}
+	}
+}
+
+func BenchmarkVerifyHopF_Cached(b *testing.B) {
+	benchmarkVerifyHopF(b, maccache.New())
+}
+
+func BenchmarkVerifyHopF_Uncached(b *testing.B) {
+	cache := maccache.New()
+	// Nothing is ever added to the cache, so every lookup misses.
+	cache.MaxEntries = 0
+	benchmarkVerifyHopF(b, cache)
+}
@@ -25,6 +25,8 @@ import (
 type RevTokenCallbackArgs struct {
 	RevInfo common.RawBytes
 	Addrs   []addr.HostSVC
+	// SrcIA is the source of the SCMP packet that carried the RevInfo.
+	SrcIA *addr.ISD_AS
 }
 
 // parseSCMPPayload is a hook that can be used for hookPayload, to retrieve the
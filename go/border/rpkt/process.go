@@ -40,7 +40,12 @@ const (
 // NeedsLocalProcessing determines if the router needs to do more than just
 // forward a packet (e.g. resolve an SVC destination address).
 func (rp *RtrPkt) NeedsLocalProcessing() *common.Error {
-	if *rp.dstIA != *conf.C.IA {
+	if rp.DirFrom == DirLocal && rp.CmnHdr.DstType == addr.HostTypeSVC {
+		// Path segments sent by the local beacon and path services carry the
+		// hash tree roots that RevInfos are verified against.
+		rp.hooks.Process = append(rp.hooks.Process, rp.processPathSegs)
+	}
+	if *rp.dstIA != *rp.Conf().IA {
 		// Packet isn't to this ISD-AS, so just forward.
 		rp.hooks.Route = append(rp.hooks.Route, rp.forward)
 		return nil
@@ -53,9 +58,13 @@ func (rp *RtrPkt) NeedsLocalProcessing() *common.Error {
 	// Check to see if the destination IP is the address the packet was received
 	// on.
 	dstIP := rp.dstHost.IP()
-	intf := conf.C.Net.IFs[*rp.ifCurr]
+	c := rp.Conf()
+	intf, err := rp.intf(*rp.ifCurr)
+	if err != nil {
+		return err
+	}
 	extPub := intf.IFAddr.PublicAddr()
-	locPub := conf.C.Net.IntfLocalAddr(*rp.ifCurr).PublicAddr()
+	locPub := c.Net.IntfLocalAddr(*rp.ifCurr).PublicAddr()
 	if rp.DirFrom == DirExternal && extPub.IP.Equal(dstIP) {
 		return rp.isDestSelf(extPub)
 	} else if rp.DirFrom == DirLocal && locPub.IP.Equal(dstIP) {
@@ -67,8 +76,9 @@ func (rp *RtrPkt) NeedsLocalProcessing() *common.Error {
 }
 
 // isDestSelf checks if the packet's destination port (if any) matches the
-// router's L4 port. If it does, hooks are registered to parse and process the
-// payload. Otherwise it is forwarded to the local dispatcher.
+// router's L4 port. If it does, the packet's end-to-end extensions are parsed,
+// and hooks are registered to parse and process the payload. Otherwise it is
+// forwarded to the local dispatcher.
 func (rp *RtrPkt) isDestSelf(addr *net.UDPAddr) *common.Error {
 	if _, err := rp.L4Hdr(true); err != nil && err.Desc != UnsupportedL4 {
 		return err
@@ -79,15 +89,24 @@ func (rp *RtrPkt) isDestSelf(addr *net.UDPAddr) *common.Error {
 			goto Self
 		}
 	case *scmp.Hdr:
-		// FIXME(kormat): this should really examine the SCMP header and
-		// determine the real destination.
-		goto Self
+		// SCMP packets addressed to the router are handled by it, see
+		// processSCMPSelf.
+		rp.DirTo = DirSelf
+		if err := rp.parseE2EExtns(); err != nil {
+			return err
+		}
+		rp.hooks.Payload = append(rp.hooks.Payload, rp.parseSCMPPayload)
+		rp.hooks.Process = append(rp.hooks.Process, rp.processDestSelf)
+		return nil
 	}
 	rp.DirTo = DirLocal
 	rp.hooks.Route = append(rp.hooks.Route, rp.forward)
 	return nil
 Self:
 	rp.DirTo = DirSelf
+	if err := rp.parseE2EExtns(); err != nil {
+		return err
+	}
 	rp.hooks.Payload = append(rp.hooks.Payload, rp.parseCtrlPayload)
 	rp.hooks.Process = append(rp.hooks.Process, rp.processDestSelf)
 	return nil
@@ -117,9 +136,11 @@ func (rp *RtrPkt) processDestSelf() (HookResult, *common.Error) {
 	if _, err := rp.Payload(true); err != nil {
 		return HookError, err
 	}
+	if _, ok := rp.pld.(*scmp.Payload); ok {
+		return rp.processSCMPSelf()
+	}
 	cpld, ok := rp.pld.(*spkt.CtrlPld)
 	if !ok {
-		// FIXME(kormat): handle SCMP packets sent to this router.
 		return HookError, common.NewError("Unable to process unsupported payload type",
 			"pldType", fmt.Sprintf("%T", rp.pld), "pld", rp.pld)
 	}
@@ -145,17 +166,26 @@ func (rp *RtrPkt) processDestSelf() (HookResult, *common.Error) {
 }
 
 // processIFID handles IFID (interface ID) packets from neighbouring ISD-ASes.
+// Besides being relayed to the local beacon service, they serve as keepalives
+// for the link they were received on.
 func (rp *RtrPkt) processIFID(pld proto.IFID) (HookResult, *common.Error) {
+	if rp.DirFrom == DirExternal {
+		callbacks.ifIDRecvF(*rp.ifCurr)
+	}
 	// Set the RelayIF field in the payload to the current interface ID.
 	pld.SetRelayIF(uint16(*rp.ifCurr))
 	if err := rp.SetPld(rp.pld); err != nil {
 		return HookError, err
 	}
-	intf := conf.C.Net.IFs[*rp.ifCurr]
-	srcAddr := conf.C.Net.LocAddr[intf.LocAddrIdx].PublicAddr()
+	c := rp.Conf()
+	intf, err := rp.intf(*rp.ifCurr)
+	if err != nil {
+		return HookError, err
+	}
+	srcAddr := c.Net.LocAddr[intf.LocAddrIdx].PublicAddr()
 	// Create base packet to local beacon service (multicast).
 	fwdrp, err := RtrPktFromScnPkt(&spkt.ScnPkt{
-		DstIA: conf.C.IA, SrcIA: conf.C.IA,
+		DstIA: c.IA, SrcIA: c.IA,
 		DstHost: addr.SvcBS.Multicast(), SrcHost: addr.HostFromIP(srcAddr.IP),
 		L4: &l4.UDP{SrcPort: uint16(srcAddr.Port), DstPort: 0},
 	}, DirLocal)
@@ -175,6 +205,126 @@ func (rp *RtrPkt) processIFID(pld proto.IFID) (HookResult, *common.Error) {
 	return HookFinish, nil
 }
 
+// processPathSegs passes the hash tree roots of the AS markings in path
+// segments sent by a local beacon or path service to a beacon or path service
+// (i.e. PCBs, and segment registrations and replies) to the router's callback.
+// The router doesn't verify the segments' signatures, so roots are only taken
+// from the local services, which only send segments they have verified. As the
+// packet is still forwarded, errors are only logged.
+func (rp *RtrPkt) processPathSegs() (HookResult, *common.Error) {
+	dst, err := rp.DstHost()
+	if err != nil {
+		return HookContinue, nil
+	}
+	svc, ok := dst.(addr.HostSVC)
+	if !ok || (svc.Base() != addr.SvcBS && svc.Base() != addr.SvcPS) {
+		return HookContinue, nil
+	}
+	l4h, err := rp.L4Hdr(false)
+	if err != nil {
+		return HookContinue, nil
+	}
+	udp, ok := l4h.(*l4.UDP)
+	if !ok || !rp.fromSegService(int(udp.SrcPort)) {
+		return HookContinue, nil
+	}
+	if len(rp.Raw)-rp.idxs.pld < 4 {
+		// Too short for the control payload length.
+		return HookContinue, nil
+	}
+	cpld, err := spkt.NewCtrlPldFromRaw(rp.Raw[rp.idxs.pld:])
+	if err != nil {
+		rp.Debug("Unable to parse control payload", "err", err)
+		return HookContinue, nil
+	}
+	segs, err := ctrlPathSegs(cpld.SCION)
+	if err != nil {
+		rp.Debug("Unable to get path segments", "err", err)
+		return HookContinue, nil
+	}
+	for _, seg := range segs {
+		asms, serr := seg.Asms()
+		if serr != nil {
+			rp.Debug(proto.ErrorPathSegASMs, "err", serr)
+			continue
+		}
+		for i := 0; i < asms.Len(); i++ {
+			asm := asms.At(i)
+			root, serr := asm.HashTreeRoot()
+			if serr != nil {
+				rp.Debug("Unable to get hash tree root from AS Marking", "err", serr)
+				continue
+			}
+			callbacks.revRootF(*addr.IAFromInt(asm.Isdas()), root)
+		}
+	}
+	return HookContinue, nil
+}
+
+// fromSegService checks if the packet was sent by one of the local beacon or
+// path service instances in the topology, from the given UDP port.
+func (rp *RtrPkt) fromSegService(port int) bool {
+	src, err := rp.SrcHost()
+	if err != nil {
+		return false
+	}
+	topo := rp.Conf().TopoMeta.T
+	for _, svcs := range []map[string]topology.BasicElem{topo.BS, topo.PS} {
+		for _, svc := range svcs {
+			if svc.Port == port && svc.Addr != nil && svc.Addr.IP.Equal(src.IP()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ctrlPathSegs returns the path segments in PCB and PathMgmt segment
+// registration/reply/sync payloads.
+func ctrlPathSegs(scion *proto.SCION) ([]proto.PathSegment, *common.Error) {
+	switch scion.Which() {
+	case proto.SCION_Which_pcb:
+		seg, err := scion.Pcb()
+		if err != nil {
+			return nil, common.NewError(errPldGet, "err", err)
+		}
+		return []proto.PathSegment{seg}, nil
+	case proto.SCION_Which_pathMgmt:
+		pathMgmt, err := scion.PathMgmt()
+		if err != nil {
+			return nil, common.NewError(errPldGet, "err", err)
+		}
+		var recs proto.SegRecs
+		switch pathMgmt.Which() {
+		case proto.PathMgmt_Which_segReg:
+			recs, err = pathMgmt.SegReg()
+		case proto.PathMgmt_Which_segReply:
+			recs, err = pathMgmt.SegReply()
+		case proto.PathMgmt_Which_segSync:
+			recs, err = pathMgmt.SegSync()
+		default:
+			return nil, nil
+		}
+		if err != nil {
+			return nil, common.NewError(errPldGet, "err", err)
+		}
+		metas, err := recs.Recs()
+		if err != nil {
+			return nil, common.NewError(errPldGet, "err", err)
+		}
+		segs := make([]proto.PathSegment, 0, metas.Len())
+		for i := 0; i < metas.Len(); i++ {
+			seg, err := metas.At(i).Pcb()
+			if err != nil {
+				return nil, common.NewError(errPldGet, "err", err)
+			}
+			segs = append(segs, seg)
+		}
+		return segs, nil
+	}
+	return nil, nil
+}
+
 // processPathMgmtSelf handles Path Management SCION control messages.
 func (rp *RtrPkt) processPathMgmtSelf(pathMgmt proto.PathMgmt) (HookResult, *common.Error) {
 	switch pathMgmt.Which() {
@@ -193,7 +343,6 @@ func (rp *RtrPkt) processPathMgmtSelf(pathMgmt proto.PathMgmt) (HookResult, *com
 
 // processSCMP is a processing hook used to handle SCMP payloads.
 func (rp *RtrPkt) processSCMP() (HookResult, *common.Error) {
-	// FIXME(shitz): rate-limit revocations
 	hdr := rp.l4.(*scmp.Hdr)
 	switch {
 	case rp.DirFrom == DirExternal && hdr.Class == scmp.C_Path &&
@@ -201,13 +350,15 @@ func (rp *RtrPkt) processSCMP() (HookResult, *common.Error) {
 		var args RevTokenCallbackArgs
 		pld := rp.pld.(*scmp.Payload)
 		args.RevInfo = pld.Info.(*scmp.InfoRevocation).RevToken
-		if rp.srcIA.I == topology.Curr.T.IA.I && rp.isDownstreamRouter() {
+		args.SrcIA = rp.srcIA
+		topo := rp.Conf().TopoMeta.T
+		if rp.srcIA.I == topo.IA.I && rp.isDownstreamRouter() {
 			// Forward to PS and BS if router is downstream of the failed interface.
 			args.Addrs = append(args.Addrs, addr.SvcBS)
-			if len(topology.Curr.T.PS) > 0 {
+			if len(topo.PS) > 0 {
 				args.Addrs = append(args.Addrs, addr.SvcPS)
 			}
-		} else if rp.dstIA.Eq(topology.Curr.T.IA) && len(topology.Curr.T.PS) > 0 {
+		} else if rp.dstIA.Eq(topo.IA) && len(topo.PS) > 0 {
 			// Forward to PS if we are in the AS of the destination.
 			args.Addrs = append(args.Addrs, addr.SvcPS)
 		}
@@ -222,15 +373,45 @@ func (rp *RtrPkt) processSCMP() (HookResult, *common.Error) {
 	return HookFinish, nil
 }
 
+// processSCMPSelf handles SCMP packets whose destination is this router. Echo
+// requests are answered, via the router's echo callback, and echo replies are
+// passed to the echo reply callback. SCMP errors are ignored, as the router
+// has no state they could relate to.
+func (rp *RtrPkt) processSCMPSelf() (HookResult, *common.Error) {
+	hdr := rp.l4.(*scmp.Hdr)
+	switch {
+	case hdr.Class == scmp.C_General && hdr.Type == scmp.T_G_EchoRequest:
+		info, ok := rp.pld.(*scmp.Payload).Info.(*scmp.InfoEcho)
+		if !ok {
+			return HookError, common.NewError("Missing SCMP echo info")
+		}
+		callbacks.scmpEchoF(rp, info)
+	case hdr.Class == scmp.C_General && hdr.Type == scmp.T_G_EchoReply:
+		info, ok := rp.pld.(*scmp.Payload).Info.(*scmp.InfoEcho)
+		if !ok {
+			return HookError, common.NewError("Missing SCMP echo info")
+		}
+		callbacks.scmpEchoReplyF(rp, info)
+	case rp.SCMPError:
+		rp.Debug("Ignoring SCMP error addressed to router", "class", hdr.Class,
+			"type", hdr.Type.Name(hdr.Class))
+	default:
+		rp.Error("Unsupported destination SCMP payload", "class", hdr.Class,
+			"type", hdr.Type.Name(hdr.Class))
+	}
+	return HookFinish, nil
+}
+
 func (rp *RtrPkt) isDownstreamRouter() bool {
-	intf := conf.C.Net.IFs[*rp.ifCurr]
-	return intf.Type == "PARENT"
+	intf, err := rp.intf(*rp.ifCurr)
+	return err == nil && intf.Type == "PARENT"
 }
 
 // getSVCNamesMap returns the slice of instance names and addresses for a given
 // SVC address.
-func getSVCNamesMap(svc addr.HostSVC) ([]string, map[string]topology.BasicElem, *common.Error) {
-	tm := conf.C.TopoMeta
+func getSVCNamesMap(c *conf.Conf, svc addr.HostSVC) ([]string, map[string]topology.BasicElem,
+	*common.Error) {
+	tm := c.TopoMeta
 	var names []string
 	var elemMap map[string]topology.BasicElem
 	switch svc.Base() {
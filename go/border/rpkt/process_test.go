@@ -0,0 +1,99 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpkt
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+// mkSCMPPkt creates an SCMP packet from 1-12, received on interface 1 and
+// addressed to the router's address on that interface.
+func mkSCMPPkt(t testing.TB, ct scmp.ClassType, info scmp.Info) *RtrPkt {
+	pld := scmp.PldFromQuotes(ct, info, common.L4None, nil)
+	return mkExtPkt(t, &spkt.ScnPkt{
+		DstIA: conf.Get().IA, SrcIA: &addr.ISD_AS{I: 1, A: 12},
+		DstHost: addr.HostFromIP(conf.Get().Net.IFs[1].IFAddr.PublicAddr().IP),
+		SrcHost: addr.HostFromIP(net.IPv4(10, 0, 0, 2)),
+		Path:    mkDownPath(t, [][2]spath.IntfID{{0, 5}, {1, 0}}, 1),
+		L4:      scmp.NewHdr(ct, pld.Len()),
+		Pld:     pld,
+	}, 1)
+}
+
+func Test_Process_SCMPSelf(t *testing.T) {
+	sent := setupTestConf(t, "br1-11-1")
+	var echoes, replies []*scmp.InfoEcho
+	Init(nil, nil, func(rp *RtrPkt, info *scmp.InfoEcho) {
+		echoes = append(echoes, info)
+	}, func(rp *RtrPkt, info *scmp.InfoEcho) {
+		replies = append(replies, info)
+	}, nil, nil)
+	Convey("SCMP echo requests to the router are passed to the echo callback", t, func() {
+		*sent, echoes, replies = nil, nil, nil
+		ct := scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoRequest}
+		rp := mkSCMPPkt(t, ct, &scmp.InfoEcho{Id: 42, Seq: 7})
+		So(processPkt(rp), ShouldBeNil)
+		So(rp.DirTo, ShouldEqual, DirSelf)
+		So(len(echoes), ShouldEqual, 1)
+		So(echoes[0], ShouldResemble, &scmp.InfoEcho{Id: 42, Seq: 7})
+		So(len(replies), ShouldEqual, 0)
+		So(len(*sent), ShouldEqual, 0)
+	})
+	Convey("SCMP echo replies to the router are passed to the echo reply callback", t, func() {
+		*sent, echoes, replies = nil, nil, nil
+		ct := scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoReply}
+		rp := mkSCMPPkt(t, ct, &scmp.InfoEcho{Id: 42, Seq: 7})
+		So(processPkt(rp), ShouldBeNil)
+		So(rp.DirTo, ShouldEqual, DirSelf)
+		So(len(echoes), ShouldEqual, 0)
+		So(len(replies), ShouldEqual, 1)
+		So(replies[0], ShouldResemble, &scmp.InfoEcho{Id: 42, Seq: 7})
+		So(len(*sent), ShouldEqual, 0)
+	})
+}
+
+func Test_Process_FromSegService(t *testing.T) {
+	setupTestConf(t, "br1-11-1")
+	mkPkt := func(src net.IP) *RtrPkt {
+		rp, err := RtrPktFromScnPkt(&spkt.ScnPkt{
+			DstIA: &addr.ISD_AS{I: 1, A: 12}, SrcIA: conf.Get().IA,
+			DstHost: addr.SvcBS, SrcHost: addr.HostFromIP(src),
+			Path: mkDownPath(t, [][2]spath.IntfID{{0, 1}, {5, 0}}, 0),
+		}, DirLocal)
+		if err != nil {
+			t.Fatalf("Error creating packet: %v", err)
+		}
+		return rp
+	}
+	Convey("Packets from the local BS and PS are from segment services", t, func() {
+		So(mkPkt(net.IPv4(127, 0, 0, 65)).fromSegService(30054), ShouldBeTrue)
+		So(mkPkt(net.IPv4(127, 0, 0, 73)).fromSegService(30091), ShouldBeTrue)
+	})
+	Convey("Packets from other ports or hosts are not", t, func() {
+		So(mkPkt(net.IPv4(127, 0, 0, 65)).fromSegService(30091), ShouldBeFalse)
+		So(mkPkt(net.IPv4(127, 0, 0, 73)).fromSegService(30054), ShouldBeFalse)
+		So(mkPkt(net.IPv4(10, 0, 0, 2)).fromSegService(30054), ShouldBeFalse)
+	})
+}
@@ -18,10 +18,12 @@ package rpkt
 
 import (
 	"fmt"
-	"math/rand"
 	"net"
+	"time"
 
+	"github.com/netsec-ethz/scion/go/border/anycast"
 	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/metrics"
 	"github.com/netsec-ethz/scion/go/lib/addr"
 	"github.com/netsec-ethz/scion/go/lib/assert"
 	"github.com/netsec-ethz/scion/go/lib/common"
@@ -55,13 +57,42 @@ func (rp *RtrPkt) Route() *common.Error {
 		return common.NewError("No routing information found", "egress", rp.Egress,
 			"dirFrom", rp.DirFrom, "dirTo", rp.DirTo, "raw", rp.Raw)
 	}
+	c := rp.Conf()
+	if err := rp.checkACL(c); err != nil {
+		return err
+	}
+	// Check that the packet fits all egress links before sending it anywhere.
+	for _, epair := range rp.Egress {
+		if mtu := egressMTU(c, epair); mtu > 0 && len(rp.Raw) > mtu {
+			sdata := scmp.NewErrData(scmp.C_Routing, scmp.T_R_OversizePkt,
+				&scmp.InfoPktSize{Size: uint16(len(rp.Raw)), MTU: uint16(mtu)})
+			return common.NewErrorData(errOversizePkt, sdata, "len", len(rp.Raw), "mtu", mtu,
+				"ifid", epair.IfID)
+		}
+	}
 	// Call all egress functions.
 	for _, epair := range rp.Egress {
+		if epair.F == nil {
+			// The socket may have been removed by a config reload.
+			return common.NewError("No output function for egress", "dst", epair.Dst)
+		}
 		epair.F(rp, epair.Dst)
 	}
 	return nil
 }
 
+// egressMTU returns the MTU for packets sent via epair, i.e. the link MTU for
+// interfaces, and the AS MTU for the local network. 0 means there's no limit.
+func egressMTU(c *conf.Conf, epair EgressPair) int {
+	if epair.IfID == 0 {
+		return c.TopoMeta.T.MTU
+	}
+	if intf, ok := c.Net.IFs[epair.IfID]; ok {
+		return intf.MTU
+	}
+	return 0
+}
+
 // RouteResolveSVC is a hook to resolve SVC addresses for routing packets to
 // the local ISD-AS.
 func (rp *RtrPkt) RouteResolveSVC() (HookResult, *common.Error) {
@@ -70,27 +101,46 @@ func (rp *RtrPkt) RouteResolveSVC() (HookResult, *common.Error) {
 		return HookError, common.NewError("Destination host is NOT an SVC address",
 			"actual", rp.dstHost, "type", fmt.Sprintf("%T", rp.dstHost))
 	}
-	intf := conf.C.Net.IFs[*rp.ifCurr]
-	f := callbacks.locOutFs[intf.LocAddrIdx]
+	intf, err := rp.intf(*rp.ifCurr)
+	if err != nil {
+		return HookError, err
+	}
+	f := GetOutputFuncs().Loc[intf.LocAddrIdx]
 	if svc.IsMulticast() {
 		return rp.RouteResolveSVCMulti(svc, f)
 	}
 	return rp.RouteResolveSVCAny(svc, f)
 }
 
+// defAnycast is used to resolve anycast SVC addresses if the router hasn't set
+// a resolver.
+var defAnycast = &anycast.Resolver{Strategy: anycast.Random{}}
+
 // RouteResolveSVCAny handles routing a packet to an anycast SVC address (i.e.
-// a single instance of a local infrastructure service).
+// a single instance of a local infrastructure service). The instance is
+// selected by the anycast resolver set with SetAnycastResolver.
 func (rp *RtrPkt) RouteResolveSVCAny(svc addr.HostSVC, f OutputFunc) (HookResult, *common.Error) {
-	names, elemMap, err := getSVCNamesMap(svc)
+	names, elemMap, err := getSVCNamesMap(rp.Conf(), svc)
 	if err != nil {
 		return HookError, err
 	}
-	// XXX(kormat): just pick one randomly. TCP will remove the need to have
-	// consistent selection for a given source.
-	name := names[rand.Intn(len(names))]
-	elem := elemMap[name]
-	dst := &net.UDPAddr{IP: elem.Addr.IP, Port: overlay.EndhostPort}
-	rp.Egress = append(rp.Egress, EgressPair{f, dst})
+	insts := make([]anycast.Instance, 0, len(names))
+	for _, name := range names {
+		elem := elemMap[name]
+		insts = append(insts, anycast.Instance{Name: name, IP: elem.Addr.IP, Port: elem.Port})
+	}
+	src, err := rp.SrcHost()
+	if err != nil {
+		return HookError, err
+	}
+	res := callbacks.anycast
+	if res == nil {
+		res = defAnycast
+	}
+	inst := res.Resolve(svc, insts, src, time.Now())
+	metrics.SVCDeliveries.WithLabelValues(inst.Name).Inc()
+	dst := &net.UDPAddr{IP: inst.IP, Port: overlay.EndhostPort}
+	rp.Egress = append(rp.Egress, EgressPair{F: f, Dst: dst})
 	return HookContinue, nil
 }
 
@@ -98,7 +148,7 @@ func (rp *RtrPkt) RouteResolveSVCAny(svc addr.HostSVC, f OutputFunc) (HookResult
 // (i.e. one packet per machine hosting instances for a local infrastructure
 // service).
 func (rp *RtrPkt) RouteResolveSVCMulti(svc addr.HostSVC, f OutputFunc) (HookResult, *common.Error) {
-	_, elemMap, err := getSVCNamesMap(svc)
+	_, elemMap, err := getSVCNamesMap(rp.Conf(), svc)
 	if err != nil {
 		return HookError, err
 	}
@@ -111,12 +161,19 @@ func (rp *RtrPkt) RouteResolveSVCMulti(svc addr.HostSVC, f OutputFunc) (HookResu
 		}
 		seen[strIP] = true
 		dst := &net.UDPAddr{IP: elem.Addr.IP, Port: overlay.EndhostPort}
-		rp.Egress = append(rp.Egress, EgressPair{f, dst})
+		rp.Egress = append(rp.Egress, EgressPair{F: f, Dst: dst})
 	}
 	return HookContinue, nil
 }
 
 func (rp *RtrPkt) forward() (HookResult, *common.Error) {
+	if rp.infoF == nil || rp.hopF == nil {
+		// Path validation lets an empty path through for packets addressed to
+		// the router itself, which can still end up being forwarded (e.g. if
+		// the L4 port turns out not to be the router's).
+		sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_PathRequired, nil)
+		return HookError, common.NewErrorData("Path required", sdata)
+	}
 	switch rp.DirFrom {
 	case DirExternal:
 		return rp.forwardFromExternal()
@@ -137,15 +194,19 @@ func (rp *RtrPkt) forwardFromExternal() (HookResult, *common.Error) {
 		return HookError, common.NewError(
 			"BUG: Non-routing HopF, refusing to forward", "hopF", rp.hopF)
 	}
-	intf := conf.C.Net.IFs[*rp.ifCurr]
-	if rp.dstIA.Eq(conf.C.IA) {
+	c := rp.Conf()
+	intf, err := rp.intf(*rp.ifCurr)
+	if err != nil {
+		return HookError, err
+	}
+	if rp.dstIA.Eq(c.IA) {
 		// Destination is a host in the local ISD-AS.
 		if rp.hopF.ForwardOnly { // Should have been caught by validatePath
 			return HookError, common.NewError("BUG: Delivery forbidden for Forward-only HopF",
 				"hopF", rp.hopF)
 		}
 		dst := &net.UDPAddr{IP: rp.dstHost.IP(), Port: overlay.EndhostPort}
-		rp.Egress = append(rp.Egress, EgressPair{callbacks.locOutFs[intf.LocAddrIdx], dst})
+		rp.Egress = append(rp.Egress, EgressPair{F: GetOutputFuncs().Loc[intf.LocAddrIdx], Dst: dst})
 		return HookContinue, nil
 	}
 	// If this is a cross-over Hop Field, increment the path.
@@ -156,12 +217,17 @@ func (rp *RtrPkt) forwardFromExternal() (HookResult, *common.Error) {
 	} else if err := rp.validateLocalIF(rp.ifNext); err != nil {
 		return HookError, err
 	}
-	// Destination is in a remote ISD-AS, so forward to egress router.
-	// FIXME(kormat): this will need to change when multiple interfaces per
-	// router are supported.
-	nextBR := conf.C.TopoMeta.IFMap[int(*rp.ifNext)]
-	dst := &net.UDPAddr{IP: nextBR.BasicElem.Addr.IP, Port: nextBR.BasicElem.Port}
-	rp.Egress = append(rp.Egress, EgressPair{callbacks.locOutFs[intf.LocAddrIdx], dst})
+	if _, ok := c.Net.IFs[*rp.ifNext]; ok {
+		// The egress interface is on this router, so skip the local network.
+		return rp.forwardToLocalIF()
+	}
+	// Destination is in a remote ISD-AS, so forward to egress router, using
+	// the internal address associated with the egress interface.
+	nextIF := int(*rp.ifNext)
+	nextLoc := c.TopoMeta.IFMap[nextIF].IFLocAddr(nextIF)
+	dst := &net.UDPAddr{IP: nextLoc.Addr.IP, Port: nextLoc.Port}
+	locIdx := c.Net.LocAddrIdxFor(dst.IP, intf.LocAddrIdx)
+	rp.Egress = append(rp.Egress, EgressPair{F: GetOutputFuncs().Loc[locIdx], Dst: dst})
 	return HookContinue, nil
 }
 
@@ -186,7 +252,7 @@ func (rp *RtrPkt) xoverFromExternal() *common.Error {
 	if infoF.Peer {
 		if segChgd {
 			sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_BadSegment, rp.mkInfoPathOffsets())
-			return common.NewError(
+			return common.NewErrorData(
 				"Path inc on ingress caused illegal peer segment change", sdata)
 		}
 		origIF := origIFNext
@@ -200,8 +266,11 @@ func (rp *RtrPkt) xoverFromExternal() *common.Error {
 			newIF = *rp.ifCurr
 		}
 		if origIF != newIF {
+			// Restore the ingress interface, so that the SCMP error is sent
+			// back over it.
+			rp.ifCurr = &origIFCurr
 			sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_BadHopField, rp.mkInfoPathOffsets())
-			return common.NewError(
+			return common.NewErrorData(
 				"Downstream interfaces don't match on peer XOVER hop fields", sdata,
 				"orig", origIF, "new", newIF)
 		}
@@ -211,30 +280,55 @@ func (rp *RtrPkt) xoverFromExternal() *common.Error {
 		// If the segment didn't change, no more checks to make.
 		return nil
 	}
-	prevLink := conf.C.Net.IFs[origIFCurr].Type
-	nextLink := conf.C.TopoMeta.IFMap[int(*rp.ifNext)].IF.LinkType
+	c := rp.Conf()
+	prevIntf, err := rp.intf(origIFCurr)
+	if err != nil {
+		return err
+	}
+	prevLink := prevIntf.Type
+	nextIF := int(*rp.ifNext)
+	nextLink := c.TopoMeta.IFMap[nextIF].GetIF(nextIF).LinkType
 	// Never allowed to switch between core segments.
 	if prevLink == topology.LinkCore && nextLink == topology.LinkCore {
 		sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_BadSegment, rp.mkInfoPathOffsets())
-		return common.NewError("Segment change between CORE links.", sdata)
+		return common.NewErrorData("Segment change between CORE links.", sdata)
 	}
 	// Only allowed to switch from up- to up-segment if the next link is CORE.
 	if infoF.Up && rp.infoF.Up && nextLink != topology.LinkCore {
 		sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_BadSegment, rp.mkInfoPathOffsets())
-		return common.NewError(
+		return common.NewErrorData(
 			"Segment change from up segment to up segment with non-CORE next link", sdata,
 			"prevLink", prevLink, "nextLink", nextLink)
 	}
 	// Only allowed to switch from down- to down-segment if the previous link is CORE.
 	if !infoF.Up && !rp.infoF.Up && prevLink != topology.LinkCore {
 		sdata := scmp.NewErrData(scmp.C_Path, scmp.T_P_BadSegment, rp.mkInfoPathOffsets())
-		return common.NewError(
+		return common.NewErrorData(
 			"Segment change from down segment to down segment with non-CORE previous link",
 			sdata, "prevLink", prevLink, "nextLink", nextLink)
 	}
 	return nil
 }
 
+// forwardToLocalIF handles packets received from a neighbouring ISD-AS whose
+// egress interface is on this router. It does the processing that would
+// otherwise be done by the egress router (see forwardFromLocal), except for
+// validating the Hop Field, which has already been done on ingress.
+func (rp *RtrPkt) forwardToLocalIF() (HookResult, *common.Error) {
+	ifid := *rp.ifNext
+	rp.DirTo = DirExternal
+	if _, err := rp.IncPath(); err != nil {
+		return HookError, err
+	}
+	intf, err := rp.intf(ifid)
+	if err != nil {
+		return HookError, err
+	}
+	rp.Egress = append(rp.Egress, EgressPair{F: GetOutputFuncs().Intf[ifid],
+		Dst: intf.RemoteAddr, IfID: ifid})
+	return HookContinue, nil
+}
+
 // forwardFromLocal handles packet received from the local ISD-AS, to be
 // forwarded to neighbouring ISD-ASes.
 func (rp *RtrPkt) forwardFromLocal() (HookResult, *common.Error) {
@@ -243,7 +337,11 @@ func (rp *RtrPkt) forwardFromLocal() (HookResult, *common.Error) {
 			return HookError, err
 		}
 	}
-	intf := conf.C.Net.IFs[*rp.ifCurr]
-	rp.Egress = append(rp.Egress, EgressPair{callbacks.intfOutFs[*rp.ifCurr], intf.RemoteAddr})
+	intf, err := rp.intf(*rp.ifCurr)
+	if err != nil {
+		return HookError, err
+	}
+	rp.Egress = append(rp.Egress, EgressPair{F: GetOutputFuncs().Intf[*rp.ifCurr],
+		Dst: intf.RemoteAddr, IfID: *rp.ifCurr})
 	return HookContinue, nil
 }
@@ -0,0 +1,257 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpkt
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/spkt"
+)
+
+// sentPkt records a packet passed to an OutputFunc.
+type sentPkt struct {
+	out string
+	dst *net.UDPAddr
+	raw common.RawBytes
+}
+
+// setupTestConf loads the router config from testdata/, and installs output
+// functions that record every packet sent.
+func setupTestConf(t testing.TB, id string) *[]sentPkt {
+	c, err := conf.Load(id, "testdata")
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	conf.Set(c)
+	sent := &[]sentPkt{}
+	mkOutF := func(name string) OutputFunc {
+		return func(rp *RtrPkt, dst *net.UDPAddr) {
+			*sent = append(*sent, sentPkt{name, dst, append(common.RawBytes(nil), rp.Raw...)})
+		}
+	}
+	outFs := &OutputFuncs{
+		Loc:  make(map[int]OutputFunc),
+		Intf: make(map[spath.IntfID]OutputFunc),
+	}
+	for idx := range c.Net.LocAddr {
+		outFs.Loc[idx] = mkOutF(fmt.Sprintf("loc:%d", idx))
+	}
+	for ifid := range c.Net.IFs {
+		outFs.Intf[ifid] = mkOutF(fmt.Sprintf("intf:%d", ifid))
+	}
+	SetOutputFuncs(outFs)
+	return sent
+}
+
+// mkDownPath creates a single down-segment path through the given hops, with
+// the current Hop Field set to curr. Hop Field MACs are only valid for the
+// local AS.
+func mkDownPath(t testing.TB, hops [][2]spath.IntfID, curr int) *spath.Path {
+	raw := make(common.RawBytes, spath.InfoFieldLength+len(hops)*spath.HopFieldLength)
+	infoF := &spath.InfoField{TsInt: uint32(time.Now().Unix()), ISD: 1, Hops: uint8(len(hops))}
+	infoF.Write(raw)
+	for i, h := range hops {
+		off := spath.InfoFieldLength + i*spath.HopFieldLength
+		hopF := spath.NewHopField(raw[off:off+spath.HopFieldLength], h[0], h[1])
+		var prev common.RawBytes
+		if i > 0 {
+			prev = raw[off-spath.HopFieldLength+1 : off]
+		}
+		mac, err := hopF.CalcMac(conf.Get().HFGenBlock, infoF.TsInt, prev)
+		if err != nil {
+			t.Fatalf("Error calculating MAC: %v", err)
+		}
+		hopF.Mac = mac
+		hopF.Write()
+	}
+	return &spath.Path{Raw: raw, InfOff: 0,
+		HopOff: uint8(spath.InfoFieldLength + curr*spath.HopFieldLength)}
+}
+
+// mkExtPkt creates a packet as if received from the neighbouring ISD-AS over
+// the given interface.
+func mkExtPkt(t testing.TB, sp *spkt.ScnPkt, ifid spath.IntfID) *RtrPkt {
+	tmp, err := RtrPktFromScnPkt(sp, DirExternal)
+	if err != nil {
+		t.Fatalf("Error creating packet: %v", err)
+	}
+	intf := conf.Get().Net.IFs[ifid]
+	rp := NewRtrPkt()
+	rp.Raw = rp.Raw[:copy(rp.Raw, tmp.Raw)]
+	rp.DirFrom = DirExternal
+	rp.TimeIn = tmp.TimeIn
+	rp.Ingress.Dst = intf.IFAddr.BindAddr()
+	rp.Ingress.Src = intf.RemoteAddr
+	rp.Ingress.IfIDs = []spath.IntfID{ifid}
+	rp.Logger = log.New("rpkt", "test")
+	return rp
+}
+
+// processPkt runs a packet through the same stages as Router.processPacket.
+func processPkt(rp *RtrPkt) *common.Error {
+	if err := rp.Parse(); err != nil {
+		return err
+	}
+	if err := rp.Validate(); err != nil {
+		return err
+	}
+	if err := rp.NeedsLocalProcessing(); err != nil {
+		return err
+	}
+	if _, err := rp.Payload(true); err != nil {
+		return err
+	}
+	if err := rp.Process(); err != nil {
+		return err
+	}
+	if rp.DirTo == DirSelf {
+		return nil
+	}
+	return rp.Route()
+}
+
+func Test_Route_MultiIF(t *testing.T) {
+	sent := setupTestConf(t, "br1-11-1")
+	src := &addr.ISD_AS{I: 1, A: 12}
+	Convey("Packet forwarded between two interfaces of the same router", t, func() {
+		*sent = nil
+		path := mkDownPath(t, [][2]spath.IntfID{{0, 5}, {1, 2}, {6, 0}}, 1)
+		rp := mkExtPkt(t, &spkt.ScnPkt{
+			DstIA: &addr.ISD_AS{I: 1, A: 13}, SrcIA: src,
+			DstHost: addr.HostFromIP(net.IPv4(10, 0, 0, 1)),
+			SrcHost: addr.HostFromIP(net.IPv4(10, 0, 0, 2)),
+			Path:    path,
+		}, 1)
+		So(processPkt(rp), ShouldBeNil)
+		So(rp.DirTo, ShouldEqual, DirExternal)
+		So(len(*sent), ShouldEqual, 1)
+		So((*sent)[0].out, ShouldEqual, "intf:2")
+		So((*sent)[0].dst, ShouldResemble, conf.Get().Net.IFs[2].RemoteAddr)
+		Convey("The path is incremented to the next AS", func() {
+			cmnHdr, err := spkt.CmnHdrFromRaw((*sent)[0].raw)
+			So(err, ShouldBeNil)
+			So(cmnHdr.CurrHopF, ShouldEqual, rp.CmnHdr.CurrHopF)
+			hopF, err := spath.HopFFromRaw((*sent)[0].raw[cmnHdr.CurrHopF:])
+			So(err, ShouldBeNil)
+			So(hopF.Ingress, ShouldEqual, 6)
+		})
+	})
+	Convey("Packet to an interface on another router is sent via the local network", t, func() {
+		*sent = nil
+		path := mkDownPath(t, [][2]spath.IntfID{{0, 5}, {1, 3}, {6, 0}}, 1)
+		rp := mkExtPkt(t, &spkt.ScnPkt{
+			DstIA: &addr.ISD_AS{I: 1, A: 14}, SrcIA: src,
+			DstHost: addr.HostFromIP(net.IPv4(10, 0, 0, 1)),
+			SrcHost: addr.HostFromIP(net.IPv4(10, 0, 0, 2)),
+			Path:    path,
+		}, 1)
+		So(processPkt(rp), ShouldBeNil)
+		So(rp.DirTo, ShouldEqual, DirLocal)
+		So(len(*sent), ShouldEqual, 1)
+		Convey("Using the internal address of the egress interface", func() {
+			So((*sent)[0].dst.IP.Equal(net.ParseIP("::2")), ShouldBeTrue)
+		})
+		Convey("From a local address of the same address family", func() {
+			So((*sent)[0].out, ShouldEqual, "loc:1")
+		})
+	})
+	Convey("Packet with a Hop Field for a different ingress interface is dropped", t, func() {
+		*sent = nil
+		path := mkDownPath(t, [][2]spath.IntfID{{0, 5}, {2, 1}, {6, 0}}, 1)
+		rp := mkExtPkt(t, &spkt.ScnPkt{
+			DstIA: &addr.ISD_AS{I: 1, A: 12}, SrcIA: &addr.ISD_AS{I: 1, A: 13},
+			DstHost: addr.HostFromIP(net.IPv4(10, 0, 0, 1)),
+			SrcHost: addr.HostFromIP(net.IPv4(10, 0, 0, 2)),
+			Path:    path,
+		}, 1)
+		err := processPkt(rp)
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, errCurrIntfInvalid)
+		So(len(*sent), ShouldEqual, 0)
+	})
+}
+
+func Test_Route_AdminDown(t *testing.T) {
+	sent := setupTestConf(t, "br1-11-1")
+	Convey("Packet to an administratively down interface is dropped", t, func() {
+		*sent = nil
+		conf.Get().AdminDown.Set(2, true)
+		defer conf.Get().AdminDown.Set(2, false)
+		path := mkDownPath(t, [][2]spath.IntfID{{0, 5}, {1, 2}, {6, 0}}, 1)
+		rp := mkExtPkt(t, &spkt.ScnPkt{
+			DstIA: &addr.ISD_AS{I: 1, A: 13}, SrcIA: &addr.ISD_AS{I: 1, A: 12},
+			DstHost: addr.HostFromIP(net.IPv4(10, 0, 0, 1)),
+			SrcHost: addr.HostFromIP(net.IPv4(10, 0, 0, 2)),
+			Path:    path,
+		}, 1)
+		err := processPkt(rp)
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, errIntfAdminDown)
+		sdata, ok := err.Data.(*scmp.ErrData)
+		So(ok, ShouldBeTrue)
+		So(sdata.CT, ShouldResemble,
+			scmp.ClassType{Class: scmp.C_Routing, Type: scmp.T_R_AdminDenied})
+		So(len(*sent), ShouldEqual, 0)
+	})
+}
+
+func Test_Route_MTU(t *testing.T) {
+	sent := setupTestConf(t, "br1-11-1")
+	Convey("Packet larger than the egress link MTU is dropped", t, func() {
+		*sent = nil
+		intf := conf.Get().Net.IFs[2]
+		origMTU := intf.MTU
+		intf.MTU = 40
+		defer func() { intf.MTU = origMTU }()
+		path := mkDownPath(t, [][2]spath.IntfID{{0, 5}, {1, 2}, {6, 0}}, 1)
+		rp := mkExtPkt(t, &spkt.ScnPkt{
+			DstIA: &addr.ISD_AS{I: 1, A: 13}, SrcIA: &addr.ISD_AS{I: 1, A: 12},
+			DstHost: addr.HostFromIP(net.IPv4(10, 0, 0, 1)),
+			SrcHost: addr.HostFromIP(net.IPv4(10, 0, 0, 2)),
+			Path:    path,
+		}, 1)
+		So(len(rp.Raw), ShouldBeGreaterThan, 40)
+		err := processPkt(rp)
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, errOversizePkt)
+		sdata, ok := err.Data.(*scmp.ErrData)
+		So(ok, ShouldBeTrue)
+		So(sdata.CT, ShouldResemble,
+			scmp.ClassType{Class: scmp.C_Routing, Type: scmp.T_R_OversizePkt})
+		So(sdata.Info, ShouldResemble,
+			&scmp.InfoPktSize{Size: uint16(len(rp.Raw)), MTU: 40})
+		So(len(*sent), ShouldEqual, 0)
+	})
+	Convey("Packet buffers are sized for the largest MTU", t, func() {
+		defer SetMaxMTU(0)
+		SetMaxMTU(conf.Get().MaxMTU())
+		So(PktBufSize(), ShouldEqual, 1473)
+		rp := NewRtrPkt()
+		So(cap(rp.Raw), ShouldEqual, 1473)
+		// The spare byte is kept for detecting oversized packets.
+		So(len(rp.Raw), ShouldEqual, 1472)
+	})
+}
@@ -22,10 +22,14 @@ package rpkt
 import (
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 
+	"github.com/netsec-ethz/scion/go/border/anycast"
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/netconf"
 	"github.com/netsec-ethz/scion/go/lib/addr"
 	"github.com/netsec-ethz/scion/go/lib/common"
 	"github.com/netsec-ethz/scion/go/lib/l4"
@@ -35,27 +39,97 @@ import (
 	"github.com/netsec-ethz/scion/go/proto"
 )
 
-// pktBufSize is the maxiumum size of a packet buffer.
-// FIXME(kormat): this should be reduced as soon as we respect the actual link MTU.
-const pktBufSize = 1 << 16
+// defPktBufSize is the size of packet buffers if no MTU is configured.
+const defPktBufSize = 1 << 16
+
+// pktBufSize is the size of newly allocated packet buffers. It is accessed
+// atomically, and set via SetMaxMTU.
+var pktBufSize int64 = defPktBufSize
+
+// SetMaxMTU sizes packet buffers to hold packets of up to mtu bytes, which
+// should be the largest MTU of any link the router sends packets on. Larger
+// packets would be dropped by Route anyway. If mtu is not positive, the
+// default size is used.
+func SetMaxMTU(mtu int) {
+	size := int64(defPktBufSize)
+	if mtu > 0 {
+		// Packet buffers keep a spare byte at the end, see RtrPkt.Reset.
+		size = int64(mtu) + 1
+	}
+	atomic.StoreInt64(&pktBufSize, size)
+}
+
+// PktBufSize returns the size of newly allocated packet buffers.
+func PktBufSize() int {
+	return int(atomic.LoadInt64(&pktBufSize))
+}
 
 // callbacks is an anonymous struct used for functions supplied by the router
 // for various processing tasks.
 var callbacks struct {
-	locOutFs   map[int]OutputFunc
-	intfOutFs  map[spath.IntfID]OutputFunc
+	// outFs holds the current *OutputFuncs. It is replaced atomically
+	// whenever the router reconfigures its sockets.
+	outFs      atomic.Value
 	ifStateUpd func(proto.IFStateInfos)
 	revTokenF  func(RevTokenCallbackArgs)
+	scmpEchoF  func(*RtrPkt, *scmp.InfoEcho)
+	// scmpEchoReplyF is called for SCMP echo replies addressed to the router.
+	scmpEchoReplyF func(*RtrPkt, *scmp.InfoEcho)
+	// ifIDRecvF is called with the interface an IFID packet from a
+	// neighbouring ISD-AS was received on.
+	ifIDRecvF func(spath.IntfID)
+	// revRootF is called with the hash tree roots of the AS markings in path
+	// segments sent by the local beacon and path services.
+	revRootF func(addr.ISD_AS, common.RawBytes)
+	// anycast resolves anycast SVC addresses. If it is nil, a random instance
+	// is picked.
+	anycast *anycast.Resolver
 }
 
 // Init takes callback functions provided by the router and stores them for use
 // by the rpkt package.
-func Init(locOut map[int]OutputFunc, intfOut map[spath.IntfID]OutputFunc,
-	ifStateUpd func(proto.IFStateInfos), revTokenF func(RevTokenCallbackArgs)) {
-	callbacks.locOutFs = locOut
-	callbacks.intfOutFs = intfOut
+func Init(ifStateUpd func(proto.IFStateInfos), revTokenF func(RevTokenCallbackArgs),
+	scmpEchoF, scmpEchoReplyF func(*RtrPkt, *scmp.InfoEcho), ifIDRecvF func(spath.IntfID),
+	revRootF func(addr.ISD_AS, common.RawBytes)) {
 	callbacks.ifStateUpd = ifStateUpd
 	callbacks.revTokenF = revTokenF
+	callbacks.scmpEchoF = scmpEchoF
+	callbacks.scmpEchoReplyF = scmpEchoReplyF
+	callbacks.ifIDRecvF = ifIDRecvF
+	callbacks.revRootF = revRootF
+}
+
+// SetAnycastResolver sets the resolver used for anycast SVC addresses. It must
+// be called before any packets are processed.
+func SetAnycastResolver(r *anycast.Resolver) {
+	callbacks.anycast = r
+}
+
+// OutputFuncs contains the functions supplied by the router for sending
+// packets.
+type OutputFuncs struct {
+	// Loc maps local address indices to functions for sending packets to
+	// local destinations (i.e. within the local ISD-AS).
+	Loc map[int]OutputFunc
+	// Intf maps interface IDs to functions for sending packets to
+	// neighbouring ISD-ASes.
+	Intf map[spath.IntfID]OutputFunc
+}
+
+// SetOutputFuncs atomically replaces the output functions used for sending
+// packets. The maps in outFs must not be modified after this call.
+func SetOutputFuncs(outFs *OutputFuncs) {
+	callbacks.outFs.Store(outFs)
+}
+
+// GetOutputFuncs returns the output functions currently used for sending
+// packets. The returned maps must not be modified.
+func GetOutputFuncs() *OutputFuncs {
+	outFs, _ := callbacks.outFs.Load().(*OutputFuncs)
+	if outFs == nil {
+		return &OutputFuncs{}
+	}
+	return outFs
 }
 
 // Router representation of SCION packet, including metadata.  The comments for the members have
@@ -109,8 +183,8 @@ type RtrPkt struct {
 	upFlag *bool
 	// HBHExt is the list of Hop-by-hop extensions, if any. (PARSE)
 	HBHExt []rExtension
-	// E2EExt is the list of end2end extensions, if any. (PARSE, only if needed)
-	// TODO(kormat): The router currently ignores these.
+	// E2EExt is the list of end2end extensions, if any. They are only parsed if the packet is
+	// destined to this router. (PROCESS, only if needed)
 	E2EExt []rExtension
 	// L4Type is the type of the L4 protocol. If there isn't an L4 header, this will be L4None
 	// (PROCESS, only if needed)
@@ -125,6 +199,10 @@ type RtrPkt struct {
 	// SCMPError flags if the packet is an SCMP Error packet, in which case it should never trigger
 	// an error response packet. (PARSE, if SCMP extension header is present)
 	SCMPError bool
+	// conf is the router config used to process this packet, so that a config
+	// reload can't change it part way through. See RtrPkt.Conf. (RECV, or on
+	// first use)
+	conf *conf.Conf
 	// Logger is used to log messages associated with a packet. The Id field is automatically
 	// included in the output.
 	log.Logger
@@ -132,7 +210,8 @@ type RtrPkt struct {
 
 func NewRtrPkt() *RtrPkt {
 	r := &RtrPkt{}
-	r.Raw = make(common.RawBytes, pktBufSize)
+	// Keep the spare byte at the end, as Reset does.
+	r.Raw = make(common.RawBytes, PktBufSize()-1, PktBufSize())
 	return r
 }
 
@@ -172,6 +251,9 @@ type addrIFPair struct {
 	Dst   *net.UDPAddr
 	Src   *net.UDPAddr
 	IfIDs []spath.IntfID
+	// Id is the metrics id of the socket the packet was received on (e.g.
+	// "intf:1" or "loc:0").
+	Id string
 }
 
 // OutputFunc is the type of callback required for sending a packet.
@@ -182,6 +264,9 @@ type OutputFunc func(*RtrPkt, *net.UDPAddr)
 type EgressPair struct {
 	F   OutputFunc
 	Dst *net.UDPAddr
+	// IfID is the interface the packet is sent on, or 0 if it is sent to the
+	// local AS. It determines the MTU the packet is checked against.
+	IfID spath.IntfID
 }
 
 // packetIdxs provides offsets into a packet buffer to the start of various
@@ -220,13 +305,15 @@ type extnIdx struct {
 // Fields that are assumed to be overwritten (and hence aren't reset):
 // Id, TimeIn, CmnHdr, Logger
 func (rp *RtrPkt) Reset() {
-	// Reset the length of the buffer to the max size.
+	// Reset the length of the buffer to the max size, less a spare byte. This
+	// allows readers to detect packets that are larger than the max size.
 	rp.Raw = rp.Raw[:cap(rp.Raw)-1]
 	rp.DirFrom = DirUnset
 	rp.DirTo = DirUnset
 	rp.Ingress.Dst = nil
 	rp.Ingress.Src = nil
 	rp.Ingress.IfIDs = nil
+	rp.Ingress.Id = ""
 	rp.Egress = rp.Egress[:0]
 	rp.IncrementedPath = false
 	rp.idxs = packetIdxs{}
@@ -246,6 +333,27 @@ func (rp *RtrPkt) Reset() {
 	rp.pld = nil
 	rp.hooks = hooks{}
 	rp.SCMPError = false
+	rp.conf = nil
+}
+
+// Conf returns the router config to use for processing this packet. The
+// current config is taken on first use, and the same one is returned
+// thereafter, even if the router config is reloaded in the meantime.
+func (rp *RtrPkt) Conf() *conf.Conf {
+	if rp.conf == nil {
+		rp.conf = conf.Get()
+	}
+	return rp.conf
+}
+
+// intf returns the config of the local interface with the given ID, or an
+// error if it doesn't exist (e.g. because it was removed by a config reload).
+func (rp *RtrPkt) intf(ifid spath.IntfID) (*netconf.Interface, *common.Error) {
+	intf, ok := rp.Conf().Net.IFs[ifid]
+	if !ok {
+		return nil, common.NewError(errCurrIntfInvalid, "ifid", ifid)
+	}
+	return intf, nil
 }
 
 // ToScnPkt converts this RtrPkt into an spkt.ScnPkt. The verify argument
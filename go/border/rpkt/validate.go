@@ -17,24 +17,33 @@
 package rpkt
 
 import (
-	"github.com/netsec-ethz/scion/go/border/conf"
 	"github.com/netsec-ethz/scion/go/lib/addr"
 	"github.com/netsec-ethz/scion/go/lib/common"
 	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
 )
 
 const (
 	errCurrIntfInvalid = "Invalid current interface"
 	errIntfRevoked     = "Interface revoked"
+	errIntfAdminDown   = "Interface administratively down"
+	errOversizePkt     = "Packet larger than egress MTU"
+	errAdminDenied     = "Packet denied by ACL"
 	errHookResponse    = "Extension hook return value unrecognised"
 )
 
 // Validate performs basic validation of a packet, including calling any
 // registered validation hooks.
 func (rp *RtrPkt) Validate() *common.Error {
-	intf, ok := conf.C.Net.IFs[*rp.ifCurr]
-	if !ok {
-		return common.NewError(errCurrIntfInvalid, "ifid", *rp.ifCurr)
+	intf, err := rp.intf(*rp.ifCurr)
+	if err != nil {
+		return err
+	}
+	if rp.DirFrom == DirExternal && !rp.ingressIF(*rp.ifCurr) {
+		// Packets from a neighbouring ISD-AS must use the interface they
+		// arrived on.
+		return common.NewError(errCurrIntfInvalid, "ifid", *rp.ifCurr,
+			"ingress", rp.Ingress.IfIDs)
 	}
 	// XXX(kormat): the rest of the common header is checked by the parsing phase.
 	if !addr.HostTypeCheck(rp.CmnHdr.DstType) {
@@ -77,3 +86,14 @@ func (rp *RtrPkt) Validate() *common.Error {
 	}
 	return nil
 }
+
+// ingressIF checks if the given interface ID is one of those associated with
+// the socket the packet was received on.
+func (rp *RtrPkt) ingressIF(ifid spath.IntfID) bool {
+	for _, id := range rp.Ingress.IfIDs {
+		if id == ifid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file handles SCMP echo requests addressed to the router, so that
+// individual routers on a path can be pinged.
+
+package main
+
+import (
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+)
+
+// Results of handling an SCMP echo request, used as metrics labels.
+const (
+	echoReplied     = "replied"
+	echoRateLimited = "rate_limited"
+	echoError       = "error"
+)
+
+// SCMPEchoCallback is called for SCMP echo requests addressed to the router,
+// and replies with an SCMP echo reply carrying the same echo info.
+func (r *Router) SCMPEchoCallback(rp *rpkt.RtrPkt, info *scmp.InfoEcho) {
+	srcIA, err := rp.SrcIA()
+	if err != nil {
+		metrics.SCMPEchoRequests.WithLabelValues(echoError).Inc()
+		rp.Error("Unable to get source ISD-AS of SCMP echo request", err.Ctx...)
+		return
+	}
+	if !r.scmpLimit.allowEcho(srcIA) {
+		metrics.SCMPEchoRequests.WithLabelValues(echoRateLimited).Inc()
+		return
+	}
+	ct := scmp.ClassType{Class: scmp.C_General, Type: scmp.T_G_EchoReply}
+	reply, err := r.createSCMPReply(rp, ct, info.Copy(), nil)
+	if err != nil {
+		metrics.SCMPEchoRequests.WithLabelValues(echoError).Inc()
+		rp.Error("Error creating SCMP echo reply", err.Ctx...)
+		return
+	}
+	if err := reply.Route(); err != nil {
+		metrics.SCMPEchoRequests.WithLabelValues(echoError).Inc()
+		rp.Error("Error routing SCMP echo reply", err.Ctx...)
+		return
+	}
+	metrics.SCMPEchoRequests.WithLabelValues(echoReplied).Inc()
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains the rate limiting of SCMP error replies, which would
+// otherwise allow a neighbour to make the router send an SCMP error for every
+// bad packet it sends. Replies are limited by token buckets per ingress
+// interface, per SCMP class/type, and per source ISD-AS of the offending
+// packet. A reply is only sent if all three limits allow it, and tokens are
+// only taken once all limits have been checked, so that a reply suppressed by
+// one limit doesn't use up the others. Replies to packets denied by the ACL
+// are additionally limited per source ISD-AS, and SCMP echo replies are
+// limited separately, per source ISD-AS.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/ratelimit"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+var (
+	scmpLimitIntf = flag.String("scmp.limit.intf", "100/200",
+		"SCMP error rate limit per ingress interface, as '[ifid=]rate[/burst],...'. "+
+			"Packets from the local AS use interface 0.")
+	scmpLimitCT = flag.String("scmp.limit.ct", "",
+		"SCMP error rate limit per SCMP class/type, as '[class:type=]rate[/burst],...' "+
+			"(E.g. 'path:bad_mac=10')")
+	scmpLimitIA = flag.String("scmp.limit.ia", "",
+		"SCMP error rate limit per source ISD-AS, as '[isd-as=]rate[/burst],...'")
+	scmpLimitDenied = flag.String("scmp.limit.denied", "10/10",
+		"SCMP AdminDenied reply rate limit per source ISD-AS, as '[isd-as=]rate[/burst],...'")
+	scmpLimitEcho = flag.String("scmp.limit.echo", "100/100",
+		"SCMP echo reply rate limit per source ISD-AS, as '[isd-as=]rate[/burst],...'")
+)
+
+const (
+	scmpLimitIntfLabel   = "intf"
+	scmpLimitCTLabel     = "class_type"
+	scmpLimitIALabel     = "src_ia"
+	scmpLimitDeniedLabel = "denied"
+)
+
+// scmpLimiter holds the rate limiters for SCMP replies.
+type scmpLimiter struct {
+	intf   *ratelimit.Limiter
+	ct     *ratelimit.Limiter
+	ia     *ratelimit.Limiter
+	denied *ratelimit.Limiter
+	echo   *ratelimit.Limiter
+}
+
+// newSCMPLimiter creates the SCMP error rate limiters from the command-line
+// flags.
+func newSCMPLimiter() (*scmpLimiter, *common.Error) {
+	l := &scmpLimiter{}
+	var err *common.Error
+	if l.intf, err = ratelimit.Parse(*scmpLimitIntf, normIntfKey); err != nil {
+		return nil, err
+	}
+	if l.ct, err = ratelimit.Parse(*scmpLimitCT, normCTKey); err != nil {
+		return nil, err
+	}
+	if l.ia, err = ratelimit.Parse(*scmpLimitIA, normIAKey); err != nil {
+		return nil, err
+	}
+	if l.denied, err = ratelimit.Parse(*scmpLimitDenied, normIAKey); err != nil {
+		return nil, err
+	}
+	if l.echo, err = ratelimit.Parse(*scmpLimitEcho, normIAKey); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// scmpLimit is a single limit applied to an SCMP error reply.
+type scmpLimit struct {
+	l     *ratelimit.Limiter
+	key   string
+	label string
+}
+
+// allow checks whether an SCMP error reply of the given class/type may be
+// sent for rp. The limits are checked from the most to the least specific,
+// and a token is only taken from each if all of them allow the reply. If not,
+// the suppressed reply is counted, labelled with the first limit that denied
+// it.
+func (l *scmpLimiter) allow(rp *rpkt.RtrPkt, ct scmp.ClassType, srcIA *addr.ISD_AS) bool {
+	now := time.Now()
+	ifid := strconv.Itoa(int(scmpLimitIFID(rp)))
+	ia := srcIA.String()
+	limits := make([]scmpLimit, 0, 4)
+	if ct == adminDeniedCT {
+		limits = append(limits, scmpLimit{l.denied, ia, scmpLimitDeniedLabel})
+	}
+	limits = append(limits, scmpLimit{l.ia, ia, scmpLimitIALabel},
+		scmpLimit{l.ct, ctKey(ct), scmpLimitCTLabel},
+		scmpLimit{l.intf, ifid, scmpLimitIntfLabel})
+	for _, lim := range limits {
+		if !lim.l.Check(lim.key, now) {
+			metrics.SCMPErrSuppressed.WithLabelValues(ifid, lim.label, ct.String()).Inc()
+			return false
+		}
+	}
+	for _, lim := range limits {
+		lim.l.Allow(lim.key, now)
+	}
+	return true
+}
+
+// allowEcho checks whether an SCMP echo reply may be sent to srcIA.
+func (l *scmpLimiter) allowEcho(srcIA *addr.ISD_AS) bool {
+	return l.echo.Allow(srcIA.String(), time.Now())
+}
+
+// scmpLimitIFID returns the interface that rp arrived on, or 0 if it arrived
+// from the local AS.
+func scmpLimitIFID(rp *rpkt.RtrPkt) spath.IntfID {
+	if rp.DirFrom != rpkt.DirExternal || len(rp.Ingress.IfIDs) == 0 {
+		return 0
+	}
+	return rp.Ingress.IfIDs[0]
+}
+
+var adminDeniedCT = scmp.ClassType{Class: scmp.C_Routing, Type: scmp.T_R_AdminDenied}
+
+func ctKey(ct scmp.ClassType) string {
+	return strconv.Itoa(int(ct.Class)) + ":" + strconv.Itoa(int(ct.Type))
+}
+
+func normIntfKey(key string) (string, error) {
+	ifid, err := strconv.ParseUint(key, 10, 16)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(int(ifid)), nil
+}
+
+func normCTKey(key string) (string, error) {
+	parts := strings.SplitN(key, ":", 2)
+	class, err := scmp.ClassFromString(parts[0])
+	if err != nil {
+		return "", err
+	}
+	if len(parts) != 2 {
+		return "", fmt.Errorf("Missing SCMP type in %q", key)
+	}
+	t, err := scmp.TypeFromString(class, parts[1])
+	if err != nil {
+		return "", err
+	}
+	return ctKey(scmp.ClassType{Class: class, Type: t}), nil
+}
+
+func normIAKey(key string) (string, error) {
+	ia, err := addr.IAFromString(key)
+	if err != nil {
+		return "", err
+	}
+	return ia.String(), nil
+}
@@ -44,6 +44,9 @@ var (
 	hsrIPMap = make(map[string]bool)
 	// See hsr.AddrMs
 	hsrAddrMs []hsr.AddrMeta
+	// hsrStarted is set once libhsr has been initialized, after which no more
+	// addresses can be added to it.
+	hsrStarted bool
 )
 
 func init() {
@@ -60,17 +63,15 @@ func setupHSRNetStart(r *Router) (rpkt.HookResult, *common.Error) {
 	return rpkt.HookContinue, nil
 }
 
-func setupHSRAddLocal(r *Router, idx int, over *overlay.UDP,
+func setupHSRAddLocal(r *Router, idx int, over *overlay.UDP, ifids []spath.IntfID,
 	labels prometheus.Labels) (rpkt.HookResult, *common.Error) {
 	bind := over.BindAddr()
 	if _, hsr := hsrIPMap[bind.IP.String()]; !hsr {
 		return rpkt.HookContinue, nil
 	}
-	var ifids []spath.IntfID
-	for _, intf := range conf.C.Net.IFs {
-		if intf.LocAddrIdx == idx {
-			ifids = append(ifids, intf.Id)
-		}
+	if hsrStarted {
+		return rpkt.HookError, common.NewError("Unable to add HSR address after startup",
+			"addr", bind)
 	}
 	hsrAddrMs = append(hsrAddrMs, hsr.AddrMeta{GoAddr: bind,
 		DirFrom: rpkt.DirLocal, IfIDs: ifids, Labels: labels})
@@ -86,6 +87,10 @@ func setupHSRAddExt(r *Router, intf *netconf.Interface,
 	if _, hsr := hsrIPMap[bind.IP.String()]; !hsr {
 		return rpkt.HookContinue, nil
 	}
+	if hsrStarted {
+		return rpkt.HookError, common.NewError("Unable to add HSR interface after startup",
+			"addr", bind)
+	}
 	hsrAddrMs = append(hsrAddrMs, hsr.AddrMeta{
 		GoAddr: bind, DirFrom: rpkt.DirExternal, IfIDs: []spath.IntfID{intf.Id}, Labels: labels})
 	r.intfOutFs[intf.Id] = func(rp *rpkt.RtrPkt, dst *net.UDPAddr) {
@@ -98,11 +103,12 @@ func setupHSRNetFinish(r *Router) (rpkt.HookResult, *common.Error) {
 	if len(hsrAddrMs) == 0 {
 		return rpkt.HookContinue, nil
 	}
-	err := hsr.Init(filepath.Join(conf.C.Dir, fmt.Sprintf("%s.zlog.conf", r.Id)),
+	err := hsr.Init(filepath.Join(conf.Get().Dir, fmt.Sprintf("%s.zlog.conf", r.Id)),
 		flag.Args(), hsrAddrMs)
 	if err != nil {
 		return rpkt.HookError, err
 	}
+	hsrStarted = true
 	q := make(chan *rpkt.RtrPkt)
 	r.inQs = append(r.inQs, q)
 	go r.readHSRInput(q)
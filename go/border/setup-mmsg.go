@@ -0,0 +1,135 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux,go1.9
+
+// This file handles configuring POSIX(/BSD) sockets that use batched IO (via
+// go/border/mmsg). It is only used if the -batch flag is set to more than 1;
+// otherwise the hooks defer to the standard POSIX hooks in setup.go. It
+// requires Linux and Go 1.9 or later (see go/border/mmsg); otherwise the -batch
+// flag isn't available.
+
+package main
+
+import (
+	"flag"
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netsec-ethz/scion/go/border/mmsg"
+	"github.com/netsec-ethz/scion/go/border/netconf"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/overlay"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+var (
+	batchSize = flag.Int("batch", 0,
+		"Max packets per recvmmsg/sendmmsg call on POSIX sockets (0 or 1 disables batching)")
+	// mmsgWs holds the output goroutines of batched sockets, so that they can
+	// be stopped when the socket is removed. It is keyed by socket, as a
+	// config reload opens the new socket for a local address or interface
+	// before closing the old one.
+	mmsgWs = make(map[*net.UDPConn]*mmsgWriter)
+)
+
+// N.B. file init order follows file names, so these hooks run after those of
+// setup-hsr.go, which then gets first pick of the addresses it manages.
+func init() {
+	setupAddLocalHooks = append(setupAddLocalHooks, setupMmsgAddLocal)
+	setupAddExtHooks = append(setupAddExtHooks, setupMmsgAddExt)
+	setupDelLocalHooks = append(setupDelLocalHooks, setupMmsgDelLocal)
+	setupDelExtHooks = append(setupDelExtHooks, setupMmsgDelExt)
+}
+
+// setupMmsgAddLocal configures a local POSIX(/BSD) socket with batched IO.
+func setupMmsgAddLocal(r *Router, idx int, over *overlay.UDP, ifids []spath.IntfID,
+	labels prometheus.Labels) (rpkt.HookResult, *common.Error) {
+	if *batchSize <= 1 {
+		return rpkt.HookContinue, nil
+	}
+	// Listen on the socket.
+	if err := over.Listen(); err != nil {
+		return rpkt.HookError, common.NewError("Unable to listen on local socket", "err", err)
+	}
+	conn, err := mmsg.New(over.Conn, *batchSize)
+	if err != nil {
+		over.Conn.Close()
+		return rpkt.HookError, common.NewError("Unable to set up batched local socket",
+			"err", err)
+	}
+	// Create a channel for this socket.
+	q := make(chan *rpkt.RtrPkt)
+	r.inQs = append(r.inQs, q)
+	// Start input and output goroutines for the socket.
+	go r.readMmsgInput(conn, rpkt.DirLocal, ifids, labels, q)
+	w := newMmsgWriter(conn, labels, nil)
+	go w.run()
+	mmsgWs[over.Conn] = w
+	r.locOutFs[idx] = w.write
+	return rpkt.HookFinish, nil
+}
+
+// setupMmsgAddExt configures a POSIX(/BSD) interface socket with batched IO.
+func setupMmsgAddExt(r *Router, intf *netconf.Interface,
+	labels prometheus.Labels) (rpkt.HookResult, *common.Error) {
+	if *batchSize <= 1 {
+		return rpkt.HookContinue, nil
+	}
+	// Connect to remote address.
+	if err := intf.IFAddr.Connect(intf.RemoteAddr); err != nil {
+		return rpkt.HookError, common.NewError("Unable to listen on external socket", "err", err)
+	}
+	conn, err := mmsg.New(intf.IFAddr.Conn, *batchSize)
+	if err != nil {
+		intf.IFAddr.Conn.Close()
+		return rpkt.HookError, common.NewError("Unable to set up batched external socket",
+			"err", err)
+	}
+	// Create a channel for this socket.
+	q := make(chan *rpkt.RtrPkt)
+	r.inQs = append(r.inQs, q)
+	// Start input and output goroutines for the socket. An interface can only
+	// send packets to a fixed remote address.
+	go r.readMmsgInput(conn, rpkt.DirExternal, []spath.IntfID{intf.Id}, labels, q)
+	w := newMmsgWriter(conn, labels, intf.IFAddr.Conn.RemoteAddr().(*net.UDPAddr))
+	go w.run()
+	mmsgWs[intf.IFAddr.Conn] = w
+	r.intfOutFs[intf.Id] = w.write
+	return rpkt.HookFinish, nil
+}
+
+// setupMmsgDelLocal stops the output goroutine of a batched local socket.
+// Closing the socket itself is left to setupPosixDelLocal.
+func setupMmsgDelLocal(r *Router, idx int, over *overlay.UDP,
+	labels prometheus.Labels) (rpkt.HookResult, *common.Error) {
+	if w, ok := mmsgWs[over.Conn]; ok {
+		w.stop()
+		delete(mmsgWs, over.Conn)
+	}
+	return rpkt.HookContinue, nil
+}
+
+// setupMmsgDelExt stops the output goroutine of a batched interface socket.
+// Closing the socket itself is left to setupPosixDelExt.
+func setupMmsgDelExt(r *Router, intf *netconf.Interface,
+	labels prometheus.Labels) (rpkt.HookResult, *common.Error) {
+	if w, ok := mmsgWs[intf.IFAddr.Conn]; ok {
+		w.stop()
+		delete(mmsgWs, intf.IFAddr.Conn)
+	}
+	return rpkt.HookContinue, nil
+}
@@ -37,17 +37,24 @@ import (
 )
 
 type setupNetHook func(r *Router) (rpkt.HookResult, *common.Error)
-type setupAddLocalHook func(r *Router, idx int, over *overlay.UDP, labels prometheus.Labels) (
-	rpkt.HookResult, *common.Error)
+type setupAddLocalHook func(r *Router, idx int, over *overlay.UDP, ifids []spath.IntfID,
+	labels prometheus.Labels) (rpkt.HookResult, *common.Error)
 type setupAddExtHook func(r *Router, intf *netconf.Interface, labels prometheus.Labels) (
 	rpkt.HookResult, *common.Error)
+type setupDelLocalHook func(r *Router, idx int, over *overlay.UDP, labels prometheus.Labels) (
+	rpkt.HookResult, *common.Error)
+type setupDelExtHook func(r *Router, intf *netconf.Interface, labels prometheus.Labels) (
+	rpkt.HookResult, *common.Error)
 
 // Setup hooks enables the network stack to be modular. Any network stack that
 // wants to be included defines its own init function which adds hooks to these
-// hook slices. See setup-hsr.go for an example.
+// hook slices. See setup-hsr.go for an example. The Del hooks are used when a
+// config reload removes or changes a local address or interface.
 var setupNetStartHooks []setupNetHook
 var setupAddLocalHooks []setupAddLocalHook
 var setupAddExtHooks []setupAddExtHook
+var setupDelLocalHooks []setupDelLocalHook
+var setupDelExtHooks []setupDelExtHook
 var setupNetFinishHooks []setupNetHook
 
 // setup creates the router's channels and map, loads the configuration, and
@@ -58,14 +65,20 @@ func (r *Router) setup(confDir string) *common.Error {
 	r.freePkts = make(chan *rpkt.RtrPkt, 1024)
 	r.revInfoQ = make(chan rpkt.RevTokenCallbackArgs)
 
-	if err := conf.Load(r.Id, confDir); err != nil {
+	c, err := conf.Load(r.Id, confDir)
+	if err != nil {
 		return err
 	}
-	log.Debug("Topology loaded", "topo", conf.C.BR)
-	log.Debug("AS Conf loaded", "conf", conf.C.ASConf)
+	conf.Set(c)
+	rpkt.SetMaxMTU(c.MaxMTU())
+	r.updateAnycast(c)
+	log.Debug("Topology loaded", "topo", c.BR)
+	log.Debug("AS Conf loaded", "conf", c.ASConf)
 
 	// Configure the rpkt package with the callbacks it needs.
-	rpkt.Init(r.locOutFs, r.intfOutFs, r.ProcessIFStates, r.RevTokenCallback)
+	rpkt.Init(r.ProcessIFStates, r.RevTokenCallback, r.SCMPEchoCallback,
+		r.SCMPEchoReplyCallback, r.IFIDCallback, r.RevRootCallback)
+	rpkt.SetAnycastResolver(r.anycast)
 	return nil
 }
 
@@ -77,6 +90,8 @@ func (r *Router) setupNet() *common.Error {
 	// they appear before the posix ones.
 	setupAddLocalHooks = append(setupAddLocalHooks, setupPosixAddLocal)
 	setupAddExtHooks = append(setupAddExtHooks, setupPosixAddExt)
+	setupDelLocalHooks = append(setupDelLocalHooks, setupPosixDelLocal)
+	setupDelExtHooks = append(setupDelExtHooks, setupPosixDelExt)
 	// Run startup hooks, if any.
 	for _, f := range setupNetStartHooks {
 		ret, err := f(r)
@@ -89,40 +104,21 @@ func (r *Router) setupNet() *common.Error {
 			break
 		}
 	}
+	c := conf.Get()
 	// Iterate over local addresses, configuring them via provided hooks.
 	var addrs []string
-	for i, a := range conf.C.Net.LocAddr {
+	for i, a := range c.Net.LocAddr {
 		addrs = append(addrs, a.BindAddr().String())
-		labels := prometheus.Labels{"id": fmt.Sprintf("loc:%d", i)}
-		for _, f := range setupAddLocalHooks {
-			ret, err := f(r, i, a, labels)
-			switch {
-			case err != nil:
-				return err
-			case ret == rpkt.HookContinue:
-				continue
-			case ret == rpkt.HookFinish:
-				break
-			}
+		if err := r.addLocal(c.Net, i); err != nil {
+			return err
 		}
 	}
 	// Export prometheus metrics on all local addresses
 	metrics.Export(addrs)
 	// Iterate over interfaces, configuring them via provided hooks.
-	for _, intf := range conf.C.Net.IFs {
-		labels := prometheus.Labels{"id": fmt.Sprintf("intf:%d", intf.Id)}
-	InnerLoop:
-		for _, f := range setupAddExtHooks {
-			ret, err := f(r, intf, labels)
-			switch {
-			case err != nil:
-				return err
-			case ret == rpkt.HookContinue:
-				continue
-			case ret == rpkt.HookFinish:
-				// Break out of switch statement and inner loop.
-				break InnerLoop
-			}
+	for _, intf := range c.Net.IFs {
+		if err := r.addExt(intf); err != nil {
+			return err
 		}
 	}
 	// Run finish hooks, if any.
@@ -137,6 +133,8 @@ func (r *Router) setupNet() *common.Error {
 			break
 		}
 	}
+	// Make the output functions available for routing packets.
+	r.publishOutputFuncs()
 	// Drop capability privileges, if any.
 	caps, err := capability.NewPid(0)
 	if err != nil {
@@ -150,31 +148,121 @@ func (r *Router) setupNet() *common.Error {
 	return nil
 }
 
+// addLocal configures the local address with the given index, using the
+// registered setupAddLocalHooks.
+func (r *Router) addLocal(n *netconf.NetConf, idx int) *common.Error {
+	over := n.LocAddr[idx]
+	// Find interfaces that use this local address.
+	ifids := n.LocAddrIFIDMap[over.BindAddr().String()]
+	labels := prometheus.Labels{"id": fmt.Sprintf("loc:%d", idx)}
+	for _, f := range setupAddLocalHooks {
+		ret, err := f(r, idx, over, ifids, labels)
+		switch {
+		case err != nil:
+			return err
+		case ret == rpkt.HookContinue:
+			continue
+		case ret == rpkt.HookFinish:
+			return nil
+		}
+	}
+	return nil
+}
+
+// addExt configures an interface, using the registered setupAddExtHooks.
+func (r *Router) addExt(intf *netconf.Interface) *common.Error {
+	labels := prometheus.Labels{"id": fmt.Sprintf("intf:%d", intf.Id)}
+	// Export the administrative state, which may have been set before a reload.
+	var down float64
+	if conf.Get().AdminDown.IsDown(intf.Id) {
+		down = 1
+	}
+	metrics.IFAdminDown.With(labels).Set(down)
+	for _, f := range setupAddExtHooks {
+		ret, err := f(r, intf, labels)
+		switch {
+		case err != nil:
+			return err
+		case ret == rpkt.HookContinue:
+			continue
+		case ret == rpkt.HookFinish:
+			return nil
+		}
+	}
+	return nil
+}
+
+// delLocal removes the local address with the given index, using the
+// registered setupDelLocalHooks.
+func (r *Router) delLocal(n *netconf.NetConf, idx int) *common.Error {
+	labels := prometheus.Labels{"id": fmt.Sprintf("loc:%d", idx)}
+	for _, f := range setupDelLocalHooks {
+		ret, err := f(r, idx, n.LocAddr[idx], labels)
+		switch {
+		case err != nil:
+			return err
+		case ret == rpkt.HookContinue:
+			continue
+		case ret == rpkt.HookFinish:
+			return nil
+		}
+	}
+	return common.NewError("Unable to remove local address", "idx", idx)
+}
+
+// delExt removes an interface, using the registered setupDelExtHooks.
+func (r *Router) delExt(intf *netconf.Interface) *common.Error {
+	labels := prometheus.Labels{"id": fmt.Sprintf("intf:%d", intf.Id)}
+	for _, f := range setupDelExtHooks {
+		ret, err := f(r, intf, labels)
+		switch {
+		case err != nil:
+			return err
+		case ret == rpkt.HookContinue:
+			continue
+		case ret == rpkt.HookFinish:
+			return nil
+		}
+	}
+	return common.NewError("Unable to remove interface", "ifid", intf.Id)
+}
+
+// publishOutputFuncs makes a copy of the router's output functions available
+// to the rpkt package, so that the router can continue to modify its own maps
+// (e.g. during a config reload) while packets are being routed.
+func (r *Router) publishOutputFuncs() {
+	outFs := &rpkt.OutputFuncs{
+		Loc:  make(map[int]rpkt.OutputFunc, len(r.locOutFs)),
+		Intf: make(map[spath.IntfID]rpkt.OutputFunc, len(r.intfOutFs)),
+	}
+	for k, v := range r.locOutFs {
+		outFs.Loc[k] = v
+	}
+	for k, v := range r.intfOutFs {
+		outFs.Intf[k] = v
+	}
+	rpkt.SetOutputFuncs(outFs)
+}
+
 // setupPosixAddLocal configures a local POSIX(/BSD) socket.
-func setupPosixAddLocal(r *Router, idx int, over *overlay.UDP,
+func setupPosixAddLocal(r *Router, idx int, over *overlay.UDP, ifids []spath.IntfID,
 	labels prometheus.Labels) (rpkt.HookResult, *common.Error) {
 	// Listen on the socket.
 	if err := over.Listen(); err != nil {
 		return rpkt.HookError, common.NewError("Unable to listen on local socket", "err", err)
 	}
-	// Find interfaces that use this local address.
-	var ifids []spath.IntfID
-	for _, intf := range conf.C.Net.IFs {
-		if intf.LocAddrIdx == idx {
-			ifids = append(ifids, intf.Id)
-		}
-	}
 	// Create a channel for this socket.
 	q := make(chan *rpkt.RtrPkt)
 	r.inQs = append(r.inQs, q)
 	// Start an input goroutine for the socket.
 	go r.readPosixInput(over.Conn, rpkt.DirLocal, ifids, labels, q)
 	// Add an output callback for the socket.
+	local := over.Conn.LocalAddr().(*net.UDPAddr)
 	f := func(b common.RawBytes, dst *net.UDPAddr) (int, error) {
 		return over.Conn.WriteToUDP(b, dst)
 	}
 	r.locOutFs[idx] = func(rp *rpkt.RtrPkt, dst *net.UDPAddr) {
-		r.writePosixOutput(labels, rp, dst, f)
+		r.writePosixOutput(labels, rp, local, dst, f)
 	}
 	return rpkt.HookFinish, nil
 }
@@ -193,13 +281,44 @@ func setupPosixAddExt(r *Router, intf *netconf.Interface,
 	go r.readPosixInput(intf.IFAddr.Conn, rpkt.DirExternal, []spath.IntfID{intf.Id}, labels, q)
 	// Add an output callback for the socket.
 	conn := intf.IFAddr.Conn
+	local := conn.LocalAddr().(*net.UDPAddr)
 	dst := conn.RemoteAddr().(*net.UDPAddr)
 	f := func(b common.RawBytes, _ *net.UDPAddr) (int, error) {
 		return conn.Write(b)
 	}
 	r.intfOutFs[intf.Id] = func(rp *rpkt.RtrPkt, _ *net.UDPAddr) {
 		// An interface can only send packets to a fixed remote address, so ignore the UDPAddr arg.
-		r.writePosixOutput(labels, rp, dst, f)
+		r.writePosixOutput(labels, rp, local, dst, f)
+	}
+	return rpkt.HookFinish, nil
+}
+
+// setupPosixDelLocal closes a local POSIX(/BSD) socket. The corresponding
+// input goroutine exits once the socket is closed. The output function is left
+// to the caller, which might already have replaced it.
+func setupPosixDelLocal(r *Router, idx int, over *overlay.UDP,
+	labels prometheus.Labels) (rpkt.HookResult, *common.Error) {
+	if over.Conn == nil {
+		// Not a POSIX socket.
+		return rpkt.HookContinue, nil
+	}
+	if err := over.Conn.Close(); err != nil {
+		return rpkt.HookError, common.NewError("Unable to close local socket", "err", err)
+	}
+	return rpkt.HookFinish, nil
+}
+
+// setupPosixDelExt closes a POSIX(/BSD) interface socket. The corresponding
+// input goroutine exits once the socket is closed. The output function is left
+// to the caller, as for setupPosixDelLocal.
+func setupPosixDelExt(r *Router, intf *netconf.Interface,
+	labels prometheus.Labels) (rpkt.HookResult, *common.Error) {
+	if intf.IFAddr.Conn == nil {
+		// Not a POSIX socket.
+		return rpkt.HookContinue, nil
+	}
+	if err := intf.IFAddr.Conn.Close(); err != nil {
+		return rpkt.HookError, common.NewError("Unable to close external socket", "err", err)
 	}
 	return rpkt.HookFinish, nil
 }
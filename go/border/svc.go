@@ -0,0 +1,80 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file sets up the resolution of anycast SVC addresses (see the anycast
+// package), and feeds the health of service instances.
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/netsec-ethz/scion/go/border/anycast"
+	"github.com/netsec-ethz/scion/go/border/conf"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/l4"
+)
+
+var (
+	svcStrategy = flag.String("svc.strategy", anycast.StrategyRandom,
+		"Strategy for selecting the instance for anycast SVC addresses "+
+			"(random, hash, round-robin)")
+	svcTimeout = flag.Duration("svc.timeout", 0,
+		"Time after which instances that have stopped sending packets "+
+			"are skipped for anycast SVC addresses (0 disables)")
+)
+
+// newAnycastResolverFromFlags creates an anycast.Resolver using the -svc.*
+// flags. Its instances are set by updateAnycast.
+func newAnycastResolverFromFlags() (*anycast.Resolver, *common.Error) {
+	strategy, err := anycast.NewStrategy(*svcStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if *svcTimeout < 0 {
+		return nil, common.NewError("Invalid SVC timeout", "timeout", *svcTimeout)
+	}
+	return &anycast.Resolver{Strategy: strategy, Health: anycast.NewHealth(*svcTimeout, nil)},
+		nil
+}
+
+// updateAnycast updates the service instances tracked by the anycast resolver
+// from the topology of c.
+func (r *Router) updateAnycast(c *conf.Conf) {
+	r.anycast.Health.Update(anycast.AllInstances(c.TopoMeta))
+}
+
+// svcSeen records that a valid packet has been received from the local
+// ISD-AS, for the health of the service instance that sent it. The instance is
+// identified by the packet's source host address and UDP source port, as all
+// packets from a host are received from its dispatcher.
+func (r *Router) svcSeen(rp *rpkt.RtrPkt) {
+	if rp.DirFrom != rpkt.DirLocal || r.anycast.Health.Timeout == 0 {
+		return
+	}
+	src, err := rp.SrcHost()
+	if err != nil || (src.Type() != addr.HostTypeIPv4 && src.Type() != addr.HostTypeIPv6) {
+		return
+	}
+	l4h, err := rp.L4Hdr(false)
+	if err != nil {
+		return
+	}
+	if udp, ok := l4h.(*l4.UDP); ok {
+		r.anycast.Health.Seen(src.IP(), int(udp.SrcPort), time.Now())
+	}
+}
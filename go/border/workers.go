@@ -0,0 +1,123 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file handles the pool of worker goroutines that process packets.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netsec-ethz/scion/go/border/metrics"
+	"github.com/netsec-ethz/scion/go/border/rpkt"
+	"github.com/netsec-ethz/scion/go/lib/log"
+)
+
+var (
+	numWorkers = flag.Int("workers", 0,
+		"Number of packet processing workers (0 processes packets in one goroutine per socket)")
+	workerQLen = flag.Int("workers.qlen", 1024,
+		"Max packets queued per worker before further packets are dropped")
+)
+
+// workerDepthInterval is how often the worker queue depth gauges are updated.
+const workerDepthInterval = time.Second
+
+// workerPool spreads packets from all input queues over a fixed number of
+// workers. Packets are assigned to workers by rpkt.FlowHash, so all packets
+// of a flow are processed (and hence forwarded) in order by the same worker.
+type workerPool struct {
+	qs     []chan *rpkt.RtrPkt
+	depths []prometheus.Gauge
+	drops  []prometheus.Counter
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(n, qlen int) *workerPool {
+	p := &workerPool{
+		qs:     make([]chan *rpkt.RtrPkt, n),
+		depths: make([]prometheus.Gauge, n),
+		drops:  make([]prometheus.Counter, n),
+	}
+	for i := range p.qs {
+		p.qs[i] = make(chan *rpkt.RtrPkt, qlen)
+		labels := prometheus.Labels{"worker": strconv.Itoa(i)}
+		p.depths[i] = metrics.WorkerQueueDepth.With(labels)
+		p.drops[i] = metrics.WorkerDrops.With(labels)
+	}
+	return p
+}
+
+// start starts the workers, and a goroutine to update the queue depth gauges
+// until ctx is cancelled.
+func (p *workerPool) start(ctx context.Context, r *Router) {
+	for _, q := range p.qs {
+		p.wg.Add(1)
+		go p.work(r, q)
+	}
+	go p.monitor(ctx)
+}
+
+func (p *workerPool) work(r *Router, q chan *rpkt.RtrPkt) {
+	defer liblog.PanicLog()
+	defer p.wg.Done()
+	for rp := range q {
+		r.handlePkt(rp)
+	}
+}
+
+// dispatch queues a packet for its flow's worker. If that worker's queue is
+// full, the packet is dropped rather than blocking the input queue (and thus
+// all other flows from the same socket).
+func (p *workerPool) dispatch(r *Router, rp *rpkt.RtrPkt) {
+	i := rpkt.FlowHash(rp.Raw) % uint32(len(p.qs))
+	select {
+	case p.qs[i] <- rp:
+	default:
+		p.drops[i].Inc()
+		countDrop(rp.Ingress.Id, dropWorkerQueue)
+		r.recyclePkt(rp)
+	}
+}
+
+func (p *workerPool) monitor(ctx context.Context) {
+	defer liblog.PanicLog()
+	ticker := time.NewTicker(workerDepthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for i, q := range p.qs {
+				p.depths[i].Set(float64(len(q)))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop waits for the workers to process all queued packets, and then stops
+// them. It must only be called once nothing more will be dispatched.
+func (p *workerPool) stop() {
+	for _, q := range p.qs {
+		close(q)
+	}
+	p.wg.Wait()
+}
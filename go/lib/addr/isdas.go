@@ -36,7 +36,11 @@ const (
 )
 
 func IAFromRaw(b common.RawBytes) *ISD_AS {
-	iaInt := common.Order.Uint32(b)
+	return IAFromInt(common.Order.Uint32(b))
+}
+
+// IAFromInt creates an ISD-AS from its 32-bit integer representation.
+func IAFromInt(iaInt uint32) *ISD_AS {
 	return &ISD_AS{I: int(iaInt >> 20), A: int(iaInt & 0x000FFFFF)}
 }
 
@@ -17,6 +17,7 @@ package as_conf
 import (
 	"fmt"
 	"io/ioutil"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -30,6 +31,46 @@ type ASConf struct {
 	PropagateTime    int           `yaml:"PropagateTime"`
 	RegisterPath     bool          `yaml:"RegisterPath"`
 	RegisterTime     int           `yaml:"RegisterTime"`
+	// PrevMasterASKey is the master AS key that MasterASKey replaced. It is
+	// still accepted for verification until its NotAfter time.
+	PrevMasterASKey *MasterKey `yaml:"PrevMasterASKey,omitempty"`
+	// NextMasterASKey is the master AS key that will replace MasterASKey. It
+	// is accepted for verification from its NotBefore time on.
+	NextMasterASKey *MasterKey `yaml:"NextMasterASKey,omitempty"`
+}
+
+// MasterKey is a master AS key with a validity window. A zero NotBefore or
+// NotAfter leaves the window open on that side.
+type MasterKey struct {
+	Key       util.B64Bytes `yaml:"Key"`
+	NotBefore util.YamlTime `yaml:"NotBefore,omitempty"`
+	NotAfter  util.YamlTime `yaml:"NotAfter,omitempty"`
+}
+
+// Valid returns true if the key may be used at time now.
+func (m *MasterKey) Valid(now time.Time) bool {
+	if !m.NotBefore.IsZero() && now.Before(m.NotBefore.Time) {
+		return false
+	}
+	return m.NotAfter.IsZero() || now.Before(m.NotAfter.Time)
+}
+
+func (m *MasterKey) validate() *common.Error {
+	if len(m.Key) == 0 {
+		return common.NewError("No key specified")
+	}
+	if !m.NotBefore.IsZero() && !m.NotAfter.IsZero() && !m.NotAfter.After(m.NotBefore.Time) {
+		return common.NewError("NotAfter must be after NotBefore",
+			"NotBefore", m.NotBefore.Time, "NotAfter", m.NotAfter.Time)
+	}
+	return nil
+}
+
+func (m *MasterKey) String() string {
+	if m == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%s[%v,%v]", m.Key, m.NotBefore.Time, m.NotAfter.Time)
 }
 
 const CfgName = "as.yml"
@@ -39,30 +80,40 @@ const (
 	ErrorParse = "Unable to parse AS conf"
 )
 
-var CurrConf *ASConf
-
-func Load(path string) *common.Error {
+// Load loads an AS configuration from a file.
+func Load(path string) (*ASConf, *common.Error) {
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		return common.NewError(ErrorOpen, "err", err)
-	}
-	if err := Parse(b, path); err != nil {
-		return err
+		return nil, common.NewError(ErrorOpen, "err", err)
 	}
-	return nil
+	return Parse(b, path)
 }
 
-func Parse(data []byte, path string) *common.Error {
+// Parse parses an AS configuration from its YAML representation.
+func Parse(data []byte, path string) (*ASConf, *common.Error) {
 	c := &ASConf{}
 	if err := yaml.Unmarshal(data, c); err != nil {
-		return common.NewError(ErrorParse, "err", err, "path", path)
+		return nil, common.NewError(ErrorParse, "err", err, "path", path)
 	}
-	CurrConf = c
-	return nil
+	if c.PrevMasterASKey != nil {
+		if err := c.PrevMasterASKey.validate(); err != nil {
+			return nil, common.NewError(ErrorParse, "err", err, "key", "PrevMasterASKey",
+				"path", path)
+		}
+	}
+	if c.NextMasterASKey != nil {
+		if err := c.NextMasterASKey.validate(); err != nil {
+			return nil, common.NewError(ErrorParse, "err", err, "key", "NextMasterASKey",
+				"path", path)
+		}
+	}
+	return c, nil
 }
 
 func (a ASConf) String() string {
 	return fmt.Sprintf(
-		"CertChainVersion:%d MasterASKey:%s PropagateTime:%d RegisterPath:%t RegisterTime:%d",
-		a.CertChainVersion, a.MasterASKey, a.PropagateTime, a.RegisterPath, a.RegisterTime)
+		"CertChainVersion:%d MasterASKey:%s PropagateTime:%d RegisterPath:%t RegisterTime:%d "+
+			"PrevMasterASKey:%s NextMasterASKey:%s",
+		a.CertChainVersion, a.MasterASKey, a.PropagateTime, a.RegisterPath, a.RegisterTime,
+		a.PrevMasterASKey, a.NextMasterASKey)
 }
@@ -16,6 +16,7 @@ package as_conf
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 
@@ -24,12 +25,35 @@ import (
 
 func Test_ASConf(t *testing.T) {
 	Convey("Loading test config `testdata/basic.yml`", t, func() {
-		if err := Load("testdata/basic.yml"); err != nil {
+		c, err := Load("testdata/basic.yml")
+		if err != nil {
 			t.Fatalf("Error loading config: %v", err)
 		}
-		c := CurrConf
 		So(c, ShouldResemble, &ASConf{
-			1, util.B64Bytes("VV?=tJ\xae\x85s\r8\x9d\xfc\xe5\x94\xa5"), 5, true, 60,
+			1, util.B64Bytes("VV?=tJ\xae\x85s\r8\x9d\xfc\xe5\x94\xa5"), 5, true, 60, nil, nil,
 		})
 	})
+	Convey("Loading test config `testdata/rollover.yml`", t, func() {
+		c, err := Load("testdata/rollover.yml")
+		if err != nil {
+			t.Fatalf("Error loading config: %v", err)
+		}
+		So(c.PrevMasterASKey, ShouldNotBeNil)
+		So(c.NextMasterASKey, ShouldNotBeNil)
+		prevEnd := time.Date(2017, 6, 2, 0, 0, 0, 0, time.UTC)
+		nextStart := time.Date(2017, 7, 1, 0, 0, 0, 0, time.UTC)
+		So(c.PrevMasterASKey.NotAfter.Equal(prevEnd), ShouldBeTrue)
+		So(c.PrevMasterASKey.NotBefore.IsZero(), ShouldBeTrue)
+		So(c.NextMasterASKey.NotBefore.Equal(nextStart), ShouldBeTrue)
+		Convey("Validity windows", func() {
+			So(c.PrevMasterASKey.Valid(prevEnd.Add(-time.Second)), ShouldBeTrue)
+			So(c.PrevMasterASKey.Valid(prevEnd), ShouldBeFalse)
+			So(c.NextMasterASKey.Valid(nextStart.Add(-time.Second)), ShouldBeFalse)
+			So(c.NextMasterASKey.Valid(nextStart), ShouldBeTrue)
+		})
+	})
+	Convey("Loading test config `testdata/rollover_bad.yml`", t, func() {
+		_, err := Load("testdata/rollover_bad.yml")
+		So(err, ShouldNotBeNil)
+	})
 }
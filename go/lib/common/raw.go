@@ -23,3 +23,22 @@ type RawBytes []byte
 func (r RawBytes) String() string {
 	return fmt.Sprintf("%x", []byte(r))
 }
+
+// RawBytes can be used as an opaque payload.
+var _ Payload = (RawBytes)(nil)
+
+func (r RawBytes) Len() int {
+	return len(r)
+}
+
+func (r RawBytes) Copy() (Payload, *Error) {
+	return append(RawBytes(nil), r...), nil
+}
+
+func (r RawBytes) Write(b RawBytes) (int, *Error) {
+	if len(b) < len(r) {
+		return 0, NewError("Buffer too short", "method", "RawBytes.Write",
+			"expected", len(r), "actual", len(b))
+	}
+	return copy(b, r), nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provides token bucket rate limiters.
+//
+// A Limiter keeps a separate token bucket per key (e.g. per interface), all
+// sharing a default rate unless overridden for specific keys. Limiters are
+// configured with a spec string of comma-separated entries of the form
+// "[key=]rate[/burst]", where rate is in tokens per second, and burst is the
+// bucket size (defaulting to the rate, rounded up). The entry without a key
+// sets the default rate. A rate of 0 means no limit. For example:
+//
+//	100/200,1=10,2=0
+//
+// allows 100 tokens per second with bursts of 200 for all keys, except key 1,
+// which is limited to 10 per second, and key 2, which isn't limited at all.
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+const ErrorSpec = "Invalid rate limit spec"
+
+const (
+	// DefMaxKeys is the default maximum number of buckets kept by a Limiter.
+	DefMaxKeys = 4096
+	// pruneInterval limits how often a full Limiter is scanned for idle
+	// buckets.
+	pruneInterval = time.Second
+)
+
+// Rate describes a token bucket. The zero value means no limit.
+type Rate struct {
+	// PerSec is the number of tokens added to the bucket per second.
+	PerSec float64
+	// Burst is the maximum number of tokens in the bucket.
+	Burst int
+}
+
+// Unlimited returns true if the rate doesn't limit anything.
+func (r Rate) Unlimited() bool {
+	return r.PerSec <= 0
+}
+
+func (r Rate) String() string {
+	if r.Unlimited() {
+		return "unlimited"
+	}
+	return strconv.FormatFloat(r.PerSec, 'f', -1, 64) + "/" + strconv.Itoa(r.Burst)
+}
+
+// ParseRate parses a rate of the form "rate[/burst]".
+func ParseRate(s string) (Rate, *common.Error) {
+	parts := strings.SplitN(s, "/", 2)
+	perSec, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || perSec < 0 || math.IsInf(perSec, 0) || math.IsNaN(perSec) {
+		return Rate{}, common.NewError(ErrorSpec, "rate", s)
+	}
+	r := Rate{PerSec: perSec, Burst: int(math.Ceil(perSec))}
+	if len(parts) > 1 {
+		burst, err := strconv.Atoi(parts[1])
+		if err != nil || burst < 1 {
+			return Rate{}, common.NewError(ErrorSpec, "rate", s)
+		}
+		r.Burst = burst
+	}
+	return r, nil
+}
+
+// Bucket is a single token bucket. It is not safe for concurrent use.
+type Bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a full bucket.
+func NewBucket(rate Rate, now time.Time) *Bucket {
+	return &Bucket{rate: rate, tokens: float64(rate.Burst), last: now}
+}
+
+// Allow takes a token from the bucket, returning false if none is available.
+func (b *Bucket) Allow(now time.Time) bool {
+	if b.rate.Unlimited() {
+		return true
+	}
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Available returns true if a token is available, without taking it.
+func (b *Bucket) Available(now time.Time) bool {
+	if b.rate.Unlimited() {
+		return true
+	}
+	b.refill(now)
+	return b.tokens >= 1
+}
+
+// Full returns true if the bucket has refilled completely, i.e. it has been
+// idle long enough that discarding it makes no difference.
+func (b *Bucket) Full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.rate.Burst)
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.rate.Burst), b.tokens+elapsed.Seconds()*b.rate.PerSec)
+		b.last = now
+	}
+}
+
+// Limiter rate limits events per key. It is safe for concurrent use.
+type Limiter struct {
+	// Default is the rate used for keys without an override.
+	Default Rate
+	// Overrides contains per-key rates.
+	Overrides map[string]Rate
+	// MaxKeys limits the number of buckets kept. When it is reached, idle
+	// buckets are discarded (at most once per pruneInterval), and if that
+	// isn't enough, keys without an override share a single overflow bucket
+	// until buckets become idle.
+	MaxKeys   int
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	overflow  *Bucket
+	lastPrune time.Time
+}
+
+// New creates a Limiter with the given default rate and no overrides.
+func New(def Rate) *Limiter {
+	return &Limiter{Default: def, Overrides: make(map[string]Rate), MaxKeys: DefMaxKeys,
+		buckets: make(map[string]*Bucket)}
+}
+
+// Parse creates a Limiter from a spec string (see the package
+// documentation). If normKey is not nil, it is used to validate keys in the
+// spec, and convert them to the form passed to Limiter.Allow.
+func Parse(spec string, normKey func(string) (string, error)) (*Limiter, *common.Error) {
+	l := New(Rate{})
+	if strings.TrimSpace(spec) == "" {
+		return l, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) == 1 {
+			rate, err := ParseRate(kv[0])
+			if err != nil {
+				return nil, err
+			}
+			l.Default = rate
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		if normKey != nil {
+			var err error
+			if key, err = normKey(key); err != nil {
+				return nil, common.NewError(ErrorSpec, "entry", entry, "err", err)
+			}
+		}
+		rate, err := ParseRate(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, err
+		}
+		l.Overrides[key] = rate
+	}
+	return l, nil
+}
+
+// Rate returns the rate applied to key.
+func (l *Limiter) Rate(key string) Rate {
+	if rate, ok := l.Overrides[key]; ok {
+		return rate
+	}
+	return l.Default
+}
+
+// Allow takes a token from the bucket for key, returning false if the event
+// should be suppressed.
+func (l *Limiter) Allow(key string, now time.Time) bool {
+	rate := l.Rate(key)
+	if rate.Unlimited() {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bucket(key, rate, now).Allow(now)
+}
+
+// Check returns true if Allow would allow an event for key, without taking a
+// token. This allows several limiters to be checked before a token is taken
+// from any of them. A concurrent Allow for the same key can still take the
+// token in between.
+func (l *Limiter) Check(key string, now time.Time) bool {
+	rate := l.Rate(key)
+	if rate.Unlimited() {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bucket(key, rate, now).Available(now)
+}
+
+func (l *Limiter) bucket(key string, rate Rate, now time.Time) *Bucket {
+	if b, ok := l.buckets[key]; ok {
+		return b
+	}
+	if l.MaxKeys > 0 && len(l.buckets) >= l.MaxKeys && now.Sub(l.lastPrune) >= pruneInterval {
+		l.prune(now)
+	}
+	_, override := l.Overrides[key]
+	if l.MaxKeys > 0 && len(l.buckets) >= l.MaxKeys && !override {
+		if l.overflow == nil {
+			l.overflow = NewBucket(rate, now)
+		}
+		return l.overflow
+	}
+	b := NewBucket(rate, now)
+	l.buckets[key] = b
+	return b
+}
+
+// prune discards idle buckets.
+func (l *Limiter) prune(now time.Time) {
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if b.Full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of buckets currently kept.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Bucket(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	Convey("A bucket allows bursts, then refills at its rate", t, func() {
+		b := NewBucket(Rate{PerSec: 10, Burst: 3}, now)
+		for i := 0; i < 3; i++ {
+			So(b.Allow(now), ShouldBeTrue)
+		}
+		So(b.Allow(now), ShouldBeFalse)
+		So(b.Allow(now.Add(50*time.Millisecond)), ShouldBeFalse)
+		So(b.Allow(now.Add(100*time.Millisecond)), ShouldBeTrue)
+		So(b.Full(now.Add(time.Second)), ShouldBeTrue)
+		// Refilling never exceeds the burst size.
+		for i := 0; i < 3; i++ {
+			So(b.Allow(now.Add(time.Hour)), ShouldBeTrue)
+		}
+		So(b.Allow(now.Add(time.Hour)), ShouldBeFalse)
+	})
+	Convey("Available doesn't take a token", t, func() {
+		b := NewBucket(Rate{PerSec: 1, Burst: 1}, now)
+		So(b.Available(now), ShouldBeTrue)
+		So(b.Available(now), ShouldBeTrue)
+		So(b.Allow(now), ShouldBeTrue)
+		So(b.Available(now), ShouldBeFalse)
+		So(b.Available(now.Add(time.Second)), ShouldBeTrue)
+	})
+	Convey("An unlimited bucket allows everything", t, func() {
+		b := NewBucket(Rate{}, now)
+		for i := 0; i < 100; i++ {
+			So(b.Allow(now), ShouldBeTrue)
+		}
+	})
+}
+
+func Test_ParseRate(t *testing.T) {
+	valid := map[string]Rate{
+		"0": {}, "10": {10, 10}, "0.5": {0.5, 1}, "10/20": {10, 20}, "2.5/1": {2.5, 1},
+	}
+	for spec, rate := range valid {
+		Convey(fmt.Sprintf("Rate %q", spec), t, func() {
+			r, err := ParseRate(spec)
+			So(err, ShouldBeNil)
+			So(r, ShouldResemble, rate)
+		})
+	}
+	for _, spec := range []string{"", "x", "-1", "10/0", "10/x", "Inf", "NaN"} {
+		Convey(fmt.Sprintf("Invalid rate %q", spec), t, func() {
+			_, err := ParseRate(spec)
+			So(err, ShouldNotBeNil)
+			So(err.Desc, ShouldEqual, ErrorSpec)
+		})
+	}
+}
+
+func Test_Limiter(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	upper := func(key string) (string, error) {
+		if key == "bad" {
+			return "", fmt.Errorf("bad key")
+		}
+		return strings.ToUpper(key), nil
+	}
+	Convey("Parse sets the default and overrides", t, func() {
+		l, err := Parse("1/2, a=5, b=0", upper)
+		So(err, ShouldBeNil)
+		So(l.Rate("X"), ShouldResemble, Rate{1, 2})
+		So(l.Rate("A"), ShouldResemble, Rate{5, 5})
+		So(l.Rate("B").Unlimited(), ShouldBeTrue)
+		Convey("Keys have separate buckets", func() {
+			So(l.Allow("X", now), ShouldBeTrue)
+			So(l.Allow("X", now), ShouldBeTrue)
+			So(l.Allow("X", now), ShouldBeFalse)
+			So(l.Allow("Y", now), ShouldBeTrue)
+			for i := 0; i < 5; i++ {
+				So(l.Allow("A", now), ShouldBeTrue)
+			}
+			So(l.Allow("A", now), ShouldBeFalse)
+			for i := 0; i < 10; i++ {
+				So(l.Allow("B", now), ShouldBeTrue)
+			}
+			So(l.Len(), ShouldEqual, 3)
+		})
+		Convey("Check doesn't take a token", func() {
+			So(l.Check("X", now), ShouldBeTrue)
+			So(l.Allow("X", now), ShouldBeTrue)
+			So(l.Allow("X", now), ShouldBeTrue)
+			So(l.Check("X", now), ShouldBeFalse)
+			So(l.Check("B", now), ShouldBeTrue)
+		})
+	})
+	Convey("An empty spec doesn't limit anything", t, func() {
+		l, err := Parse("", nil)
+		So(err, ShouldBeNil)
+		So(l.Default.Unlimited(), ShouldBeTrue)
+		So(l.Allow("a", now), ShouldBeTrue)
+		So(l.Len(), ShouldEqual, 0)
+	})
+	Convey("Invalid specs are rejected", t, func() {
+		for _, spec := range []string{"x", "1,bad=1", "a=x", "a="} {
+			_, err := Parse(spec, upper)
+			So(err, ShouldNotBeNil)
+		}
+	})
+	Convey("The number of buckets is limited", t, func() {
+		l := New(Rate{1, 1})
+		l.MaxKeys = 2
+		So(l.Allow("a", now), ShouldBeTrue)
+		So(l.Allow("b", now), ShouldBeTrue)
+		// c and d share the overflow bucket.
+		So(l.Allow("c", now), ShouldBeTrue)
+		So(l.Allow("d", now), ShouldBeFalse)
+		So(l.Len(), ShouldEqual, 2)
+		// Once buckets are idle, they are discarded.
+		later := now.Add(time.Second)
+		So(l.Allow("d", later), ShouldBeTrue)
+		So(l.Allow("d", later), ShouldBeFalse)
+		So(l.Len(), ShouldEqual, 1)
+	})
+	Convey("Idle buckets are discarded at most once per prune interval", t, func() {
+		l := New(Rate{10, 1})
+		l.MaxKeys = 2
+		So(l.Allow("a", now), ShouldBeTrue)
+		So(l.Allow("b", now), ShouldBeTrue)
+		// Nothing is idle yet, so c uses the overflow bucket.
+		So(l.Allow("c", now), ShouldBeTrue)
+		// a and b are idle, but the last prune was too recent.
+		So(l.Allow("d", now.Add(pruneInterval/2)), ShouldBeTrue)
+		So(l.Len(), ShouldEqual, 2)
+		So(l.Allow("e", now.Add(pruneInterval)), ShouldBeTrue)
+		So(l.Len(), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revinfo
+
+import (
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+const ErrorUnknownIF = "Interface not in hash tree"
+
+// HashTree is the hash tree of an AS for a single TTL window, with a leaf per
+// interface and epoch. It is used to create RevInfo proofs.
+type HashTree struct {
+	ia    addr.ISD_AS
+	seed  []byte
+	depth uint
+	// nodes holds the tree in heap order, i.e. the children of node i are
+	// nodes 2i+1 and 2i+2.
+	nodes  []common.RawBytes
+	if2idx map[uint64]int
+}
+
+// NewHashTree creates the hash tree of the given interfaces of ia. The seed is
+// secret, and determines the nonces of the leaves.
+func NewHashTree(ia addr.ISD_AS, ifIDs []uint64, seed []byte) *HashTree {
+	t := &HashTree{ia: ia, seed: seed, if2idx: make(map[uint64]int)}
+	leaves := len(ifIDs) * NEpochs
+	for 1<<t.depth < leaves {
+		t.depth++
+	}
+	t.nodes = make([]common.RawBytes, 1<<(t.depth+1)-1)
+	idx := 1<<t.depth - 1
+	for _, ifID := range ifIDs {
+		t.if2idx[ifID] = idx
+		for epoch := 0; epoch < NEpochs; epoch++ {
+			t.nodes[idx] = hash(leafInput(ifID, uint16(epoch), t.nonce(ifID, uint16(epoch))))
+			idx++
+		}
+	}
+	// Pad the tree to a complete binary tree.
+	for ; idx < len(t.nodes); idx++ {
+		t.nodes[idx] = hash([]byte("0"))
+	}
+	for idx = 1<<t.depth - 2; idx >= 0; idx-- {
+		t.nodes[idx] = hash(t.nodes[2*idx+1], t.nodes[2*idx+2])
+	}
+	return t
+}
+
+// Root returns the root of the tree.
+func (t *HashTree) Root() common.RawBytes {
+	return t.nodes[0]
+}
+
+// Proof creates the proof revoking ifID for epoch, given the roots of the
+// previous and next trees.
+func (t *HashTree) Proof(ifID uint64, epoch uint16,
+	prevRoot, nextRoot common.RawBytes) (*Proof, *common.Error) {
+	start, ok := t.if2idx[ifID]
+	if !ok {
+		return nil, common.NewError(ErrorUnknownIF, "ia", t.ia, "ifid", ifID)
+	}
+	if int(epoch) >= NEpochs {
+		return nil, common.NewError(ErrorMalformed, "field", "epoch", "epoch", epoch)
+	}
+	p := &Proof{IA: t.ia, IfID: ifID, Epoch: epoch, Nonce: t.nonce(ifID, epoch),
+		PrevRoot: prevRoot, NextRoot: nextRoot}
+	for idx := start + int(epoch); idx > 0; idx = (idx - 1) / 2 {
+		if idx%2 == 0 {
+			p.Siblings = append(p.Siblings, Sibling{IsLeft: true, Hash: t.nodes[idx-1]})
+		} else {
+			p.Siblings = append(p.Siblings, Sibling{IsLeft: false, Hash: t.nodes[idx+1]})
+		}
+	}
+	return p, nil
+}
+
+func (t *HashTree) nonce(ifID uint64, epoch uint16) common.RawBytes {
+	return hash(t.seed, leafInput(ifID, epoch, nil))
+}
+
+// ConnectedRoot returns the root connecting the hash trees with roots root1
+// and root2 of consecutive TTL windows.
+func ConnectedRoot(root1, root2 common.RawBytes) common.RawBytes {
+	return hash(root1, root2)
+}
@@ -0,0 +1,208 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package revinfo verifies revocation infos (RevInfos). A RevInfo revokes an
+// interface of an AS for one epoch, and proves that it was issued by the AS
+// with a path through the AS's time-connected hash tree: hashing the
+// (interface, epoch) leaf with the sibling hashes in the RevInfo gives the root
+// of the tree for the current TTL window, and hashing that with the root of
+// the previous or next window gives one of the AS's connected roots. ASes
+// publish their current connected root in the AS markings of their path
+// segments, and RootStore keeps the roots learnt from them.
+//
+// The hash tree and epochs follow lib/crypto/hash_tree.py, and HashTree can
+// create RevInfo proofs that verify against the Python implementation.
+package revinfo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/proto"
+)
+
+const (
+	// EpochTime is the length of an epoch, i.e. the time a RevInfo is valid for.
+	EpochTime = 10 * time.Second
+	// EpochTolerance is how long a RevInfo for the previous epoch is still
+	// accepted, to allow for clock skew and propagation delay.
+	EpochTolerance = 5 * time.Second
+	// TTL is the time covered by a single hash tree.
+	TTL = 30 * time.Minute
+	// NEpochs is the number of epochs per hash tree.
+	NEpochs = int(TTL / EpochTime)
+	// HashLen is the length of all hashes in a proof.
+	HashLen = sha256.Size
+	// MaxSiblings is the maximum number of sibling hashes in a proof.
+	MaxSiblings = 64
+)
+
+const (
+	ErrorMalformed  = "Malformed RevInfo"
+	ErrorStaleEpoch = "RevInfo epoch is not current"
+	ErrorNoRoot     = "No hash tree roots for RevInfo issuer"
+	ErrorBadProof   = "RevInfo proof doesn't match any hash tree root"
+)
+
+// Sibling is a hash on the path from a leaf to the root of a hash tree.
+type Sibling struct {
+	// IsLeft is set if the sibling is the left child of its parent.
+	IsLeft bool
+	Hash   common.RawBytes
+}
+
+// Proof is the proof contained in a RevInfo.
+type Proof struct {
+	// IA is the issuer of the RevInfo.
+	IA       addr.ISD_AS
+	IfID     uint64
+	Epoch    uint16
+	Nonce    common.RawBytes
+	Siblings []Sibling
+	// PrevRoot and NextRoot are the roots of the hash trees of the previous
+	// and next TTL windows.
+	PrevRoot common.RawBytes
+	NextRoot common.RawBytes
+}
+
+// ProofFromProto extracts the proof from a RevInfo, and checks that it is well
+// formed.
+func ProofFromProto(r proto.RevInfo) (*Proof, *common.Error) {
+	p := &Proof{IA: *addr.IAFromInt(r.Isdas()), IfID: r.IfID(), Epoch: r.Epoch()}
+	var err error
+	if p.Nonce, err = r.Nonce(); err != nil {
+		return nil, common.NewError(ErrorMalformed, "field", "nonce", "err", err)
+	}
+	if p.PrevRoot, err = r.PrevRoot(); err != nil {
+		return nil, common.NewError(ErrorMalformed, "field", "prevRoot", "err", err)
+	}
+	if p.NextRoot, err = r.NextRoot(); err != nil {
+		return nil, common.NewError(ErrorMalformed, "field", "nextRoot", "err", err)
+	}
+	siblings, err := r.Siblings()
+	if err != nil {
+		return nil, common.NewError(ErrorMalformed, "field", "siblings", "err", err)
+	}
+	if siblings.Len() > MaxSiblings {
+		return nil, common.NewError(ErrorMalformed,
+			"field", "siblings", "len", siblings.Len(), "max", MaxSiblings)
+	}
+	for i := 0; i < siblings.Len(); i++ {
+		s := siblings.At(i)
+		hash, err := s.Hash()
+		if err != nil {
+			return nil, common.NewError(ErrorMalformed, "field", "siblings", "err", err)
+		}
+		p.Siblings = append(p.Siblings, Sibling{IsLeft: s.IsLeft(), Hash: hash})
+	}
+	if cerr := p.Validate(); cerr != nil {
+		return nil, cerr
+	}
+	return p, nil
+}
+
+// Validate checks that the epoch is in range, and that all hashes have the
+// right length.
+func (p *Proof) Validate() *common.Error {
+	if int(p.Epoch) >= NEpochs {
+		return common.NewError(ErrorMalformed, "field", "epoch", "epoch", p.Epoch)
+	}
+	if len(p.Siblings) > MaxSiblings {
+		return common.NewError(ErrorMalformed,
+			"field", "siblings", "len", len(p.Siblings), "max", MaxSiblings)
+	}
+	fields := []struct {
+		name string
+		b    common.RawBytes
+	}{{"nonce", p.Nonce}, {"prevRoot", p.PrevRoot}, {"nextRoot", p.NextRoot}}
+	for _, f := range fields {
+		if len(f.b) != HashLen {
+			return common.NewError(ErrorMalformed, "field", f.name, "len", len(f.b))
+		}
+	}
+	for i, s := range p.Siblings {
+		if len(s.Hash) != HashLen {
+			return common.NewError(ErrorMalformed, "field", "siblings", "idx", i,
+				"len", len(s.Hash))
+		}
+	}
+	return nil
+}
+
+// Roots returns the connected roots the proof leads to: prev is the root
+// connecting the previous hash tree with the proof's tree, next the root
+// connecting the proof's tree with the next one.
+func (p *Proof) Roots() (prev, next common.RawBytes) {
+	curr := hash(leafInput(p.IfID, p.Epoch, p.Nonce))
+	for _, s := range p.Siblings {
+		if s.IsLeft {
+			curr = hash(s.Hash, curr)
+		} else {
+			curr = hash(curr, s.Hash)
+		}
+	}
+	return hash(p.PrevRoot, curr), hash(curr, p.NextRoot)
+}
+
+// Verify returns true if the proof leads to root.
+func (p *Proof) Verify(root common.RawBytes) bool {
+	prev, next := p.Roots()
+	return bytes.Equal(prev, root) || bytes.Equal(next, root)
+}
+
+func (p *Proof) String() string {
+	return fmt.Sprintf("%v#%d@%d", p.IA, p.IfID, p.Epoch)
+}
+
+// CurrentEpoch returns the epoch at time now.
+func CurrentEpoch(now time.Time) uint16 {
+	return uint16(now.UnixNano() % int64(TTL) / int64(EpochTime))
+}
+
+// VerifyEpoch checks that epoch is current at time now. The previous epoch is
+// accepted during the first EpochTolerance of an epoch.
+func VerifyEpoch(epoch uint16, now time.Time) *common.Error {
+	cur := CurrentEpoch(now)
+	if epoch == cur {
+		return nil
+	}
+	sinceEpoch := time.Duration(now.UnixNano() % int64(EpochTime))
+	if int(cur) == (int(epoch)+1)%NEpochs && sinceEpoch < EpochTolerance {
+		return nil
+	}
+	return common.NewError(ErrorStaleEpoch, "epoch", epoch, "current", cur)
+}
+
+// leafInput returns the hash input of the (ifID, epoch) leaf, excluding the
+// nonce if nonce is nil.
+func leafInput(ifID uint64, epoch uint16, nonce common.RawBytes) []byte {
+	b := make([]byte, 16, 16+len(nonce))
+	binary.BigEndian.PutUint64(b, ifID)
+	binary.BigEndian.PutUint64(b[8:], uint64(epoch))
+	return append(b, nonce...)
+}
+
+// hash returns the SHA256 hash of the concatenation of parts.
+func hash(parts ...[]byte) common.RawBytes {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
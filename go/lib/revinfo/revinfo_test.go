@@ -0,0 +1,131 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revinfo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+func Test_HashTree(t *testing.T) {
+	ia := addr.ISD_AS{I: 1, A: 11}
+	Convey("The root matches lib/crypto/hash_tree.py", t, func() {
+		ht := NewHashTree(ia, []uint64{1, 2}, []byte("seed"))
+		So(hex.EncodeToString(ht.Root()), ShouldEqual,
+			"c2e97d32b5ec8d3a57f340d423235f0efb27b2a73a403080f34d413d198a9f52")
+	})
+	Convey("Proofs for unknown interfaces can't be created", t, func() {
+		ht := NewHashTree(ia, []uint64{1, 2}, []byte("seed"))
+		_, err := ht.Proof(3, 0, nil, nil)
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, ErrorUnknownIF)
+	})
+}
+
+func Test_Proof_Verify(t *testing.T) {
+	ia := addr.ISD_AS{I: 1, A: 11}
+	prev := NewHashTree(ia, []uint64{1, 2, 3}, []byte("seed0")).Root()
+	ht := NewHashTree(ia, []uint64{1, 2, 3}, []byte("seed1"))
+	next := NewHashTree(ia, []uint64{1, 2, 3}, []byte("seed2")).Root()
+	root01 := ConnectedRoot(prev, ht.Root())
+	root12 := ConnectedRoot(ht.Root(), next)
+	Convey("Proofs lead to both connected roots of their tree", t, func() {
+		for _, ifID := range []uint64{1, 2, 3} {
+			for _, epoch := range []uint16{0, 1, uint16(NEpochs - 1)} {
+				p, err := ht.Proof(ifID, epoch, prev, next)
+				So(err, ShouldBeNil)
+				So(p.Validate(), ShouldBeNil)
+				So(p.Verify(root01), ShouldBeTrue)
+				So(p.Verify(root12), ShouldBeTrue)
+				So(p.Verify(ConnectedRoot(prev, next)), ShouldBeFalse)
+			}
+		}
+	})
+	Convey("Modified proofs don't verify", t, func() {
+		mods := map[string]func(p *Proof){
+			"interface": func(p *Proof) { p.IfID = 2 },
+			"epoch":     func(p *Proof) { p.Epoch++ },
+			"nonce":     func(p *Proof) { p.Nonce = flip(p.Nonce) },
+			"sibling":   func(p *Proof) { p.Siblings[3].Hash = flip(p.Siblings[3].Hash) },
+			"position":  func(p *Proof) { p.Siblings[0].IsLeft = !p.Siblings[0].IsLeft },
+		}
+		for name, mod := range mods {
+			Convey(fmt.Sprintf("Modified %s", name), func() {
+				p, err := ht.Proof(1, 5, prev, next)
+				So(err, ShouldBeNil)
+				mod(p)
+				So(p.Verify(root01), ShouldBeFalse)
+				So(p.Verify(root12), ShouldBeFalse)
+			})
+		}
+	})
+	Convey("Malformed proofs are rejected", t, func() {
+		mods := map[string]func(p *Proof){
+			"epoch":    func(p *Proof) { p.Epoch = uint16(NEpochs) },
+			"nonce":    func(p *Proof) { p.Nonce = p.Nonce[1:] },
+			"prevRoot": func(p *Proof) { p.PrevRoot = nil },
+			"siblings": func(p *Proof) { p.Siblings[1].Hash = append(p.Siblings[1].Hash, 0) },
+		}
+		for name, mod := range mods {
+			Convey(fmt.Sprintf("Malformed %s", name), func() {
+				p, _ := ht.Proof(1, 5, prev, next)
+				mod(p)
+				err := p.Validate()
+				So(err, ShouldNotBeNil)
+				So(err.Desc, ShouldEqual, ErrorMalformed)
+			})
+		}
+	})
+}
+
+func Test_VerifyEpoch(t *testing.T) {
+	// 1500000000 is 600s into a TTL window, i.e. the start of epoch 60.
+	start := time.Unix(1500000000, 0)
+	Convey("The current epoch is accepted", t, func() {
+		So(CurrentEpoch(start), ShouldEqual, 60)
+		So(VerifyEpoch(60, start), ShouldBeNil)
+		So(VerifyEpoch(60, start.Add(EpochTime-time.Nanosecond)), ShouldBeNil)
+	})
+	Convey("The previous epoch is accepted within the tolerance", t, func() {
+		So(VerifyEpoch(59, start.Add(EpochTolerance-time.Nanosecond)), ShouldBeNil)
+		err := VerifyEpoch(59, start.Add(EpochTolerance))
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, ErrorStaleEpoch)
+	})
+	Convey("The last epoch of the previous window is accepted within the tolerance", t, func() {
+		windowStart := start.Add(-10 * time.Minute)
+		So(CurrentEpoch(windowStart), ShouldEqual, 0)
+		So(VerifyEpoch(uint16(NEpochs-1), windowStart), ShouldBeNil)
+	})
+	Convey("Other epochs are rejected", t, func() {
+		for _, epoch := range []uint16{0, 58, 61} {
+			So(VerifyEpoch(epoch, start), ShouldNotBeNil)
+		}
+	})
+}
+
+// flip returns a copy of b with the bits of its first byte flipped.
+func flip(b common.RawBytes) common.RawBytes {
+	c := append(common.RawBytes(nil), b...)
+	c[0] ^= 0xFF
+	return c
+}
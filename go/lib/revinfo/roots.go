@@ -0,0 +1,127 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revinfo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+const (
+	// RootTTL is how long a root is kept after it was last seen. An AS
+	// publishes a new connected root every TTL.
+	RootTTL = TTL
+	// MaxRootsPerIA limits the number of roots kept per ISD-AS. If it is
+	// reached, the root that would expire first is replaced.
+	MaxRootsPerIA = 8
+	// DefMaxIAs is the default maximum number of ISD-ASes roots are kept for.
+	DefMaxIAs = 10000
+)
+
+// RootStore holds the connected hash tree roots of ISD-ASes, as published in
+// the AS markings of their path segments, along with the time each root
+// expires. It is safe for concurrent use.
+type RootStore struct {
+	// MaxIAs limits the number of ISD-ASes roots are kept for. If it is
+	// reached and no ISD-AS's roots have all expired, roots of new ISD-ASes
+	// are not added.
+	MaxIAs int
+	mu     sync.Mutex
+	roots  map[addr.ISD_AS]map[string]time.Time
+}
+
+// NewRootStore creates a root store with the default size limit.
+func NewRootStore() *RootStore {
+	return &RootStore{MaxIAs: DefMaxIAs, roots: make(map[addr.ISD_AS]map[string]time.Time)}
+}
+
+// Add adds (or refreshes) a root of ia, seen at time now. It returns false if
+// the root wasn't added, because it isn't a hash or the store is full.
+func (s *RootStore) Add(ia addr.ISD_AS, root common.RawBytes, now time.Time) bool {
+	if len(root) != HashLen {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roots, ok := s.roots[ia]
+	if !ok {
+		if len(s.roots) >= s.MaxIAs {
+			s.expire(now)
+		}
+		if len(s.roots) >= s.MaxIAs {
+			return false
+		}
+		roots = make(map[string]time.Time)
+		s.roots[ia] = roots
+	}
+	key := string(root)
+	if _, ok := roots[key]; !ok && len(roots) >= MaxRootsPerIA {
+		var first string
+		for k, expiry := range roots {
+			if first == "" || expiry.Before(roots[first]) {
+				first = k
+			}
+		}
+		delete(roots, first)
+	}
+	roots[key] = now.Add(RootTTL)
+	return true
+}
+
+// Verify checks that the proof leads to one of the unexpired roots of its
+// issuer at time now.
+func (s *RootStore) Verify(p *Proof, now time.Time) *common.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for root, expiry := range s.roots[p.IA] {
+		if !now.Before(expiry) {
+			continue
+		}
+		found = true
+		if p.Verify(common.RawBytes(root)) {
+			return nil
+		}
+	}
+	if !found {
+		return common.NewError(ErrorNoRoot, "ia", p.IA)
+	}
+	return common.NewError(ErrorBadProof, "revInfo", p)
+}
+
+// Len returns the number of ISD-ASes roots are kept for, including ones whose
+// roots have all expired but haven't been removed yet.
+func (s *RootStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.roots)
+}
+
+// expire removes all expired roots, and the ISD-ASes left without roots.
+func (s *RootStore) expire(now time.Time) {
+	for ia, roots := range s.roots {
+		for root, expiry := range roots {
+			if !now.Before(expiry) {
+				delete(roots, root)
+			}
+		}
+		if len(roots) == 0 {
+			delete(s.roots, ia)
+		}
+	}
+}
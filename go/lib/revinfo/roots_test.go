@@ -0,0 +1,92 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revinfo
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+func Test_RootStore(t *testing.T) {
+	ia := addr.ISD_AS{I: 1, A: 11}
+	prev := NewHashTree(ia, []uint64{1, 2}, []byte("seed0")).Root()
+	ht := NewHashTree(ia, []uint64{1, 2}, []byte("seed1"))
+	next := NewHashTree(ia, []uint64{1, 2}, []byte("seed2")).Root()
+	root := ConnectedRoot(prev, ht.Root())
+	now := time.Unix(1500000000, 0)
+	p, _ := ht.Proof(2, 7, prev, next)
+	shouldFail := func(err *common.Error, desc string) {
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, desc)
+	}
+	Convey("Proofs verify against the roots of their ISD-AS", t, func() {
+		s := NewRootStore()
+		So(s.Add(ia, root, now), ShouldBeTrue)
+		So(s.Verify(p, now), ShouldBeNil)
+		Convey("but not those of other ISD-ASes", func() {
+			ia12 := addr.ISD_AS{I: 1, A: 12}
+			So(s.Add(ia12, ConnectedRoot(next, prev), now), ShouldBeTrue)
+			q := *p
+			q.IA = ia12
+			shouldFail(s.Verify(&q, now), ErrorBadProof)
+			q.IA = addr.ISD_AS{I: 1, A: 13}
+			shouldFail(s.Verify(&q, now), ErrorNoRoot)
+		})
+	})
+	Convey("Roots expire RootTTL after they were last seen", t, func() {
+		s := NewRootStore()
+		s.Add(ia, root, now)
+		s.Add(ia, root, now.Add(time.Minute))
+		So(s.Verify(p, now.Add(RootTTL)), ShouldBeNil)
+		shouldFail(s.Verify(p, now.Add(time.Minute+RootTTL)), ErrorNoRoot)
+	})
+	Convey("Roots must be hashes", t, func() {
+		s := NewRootStore()
+		So(s.Add(ia, root[1:], now), ShouldBeFalse)
+		So(s.Len(), ShouldEqual, 0)
+	})
+	Convey("Roots are copied", t, func() {
+		s := NewRootStore()
+		b := append(common.RawBytes(nil), root...)
+		s.Add(ia, b, now)
+		b[0] ^= 0xFF
+		So(s.Verify(p, now), ShouldBeNil)
+	})
+	Convey("The roots that expire first are replaced when an ISD-AS has too many", t,
+		func() {
+			s := NewRootStore()
+			s.Add(ia, root, now)
+			for i := 0; i < MaxRootsPerIA; i++ {
+				s.Add(ia, ConnectedRoot(next, common.RawBytes{byte(i)}), now.Add(time.Second))
+			}
+			shouldFail(s.Verify(p, now), ErrorBadProof)
+		})
+	Convey("Roots of new ISD-ASes are only added if there is space", t, func() {
+		s := NewRootStore()
+		s.MaxIAs = 1
+		So(s.Add(ia, root, now), ShouldBeTrue)
+		So(s.Add(ia, flip(root), now), ShouldBeTrue)
+		ia12 := addr.ISD_AS{I: 1, A: 12}
+		So(s.Add(ia12, root, now), ShouldBeFalse)
+		So(s.Add(ia12, root, now.Add(RootTTL)), ShouldBeTrue)
+		So(s.Len(), ShouldEqual, 1)
+		shouldFail(s.Verify(p, now.Add(RootTTL)), ErrorNoRoot)
+	})
+}
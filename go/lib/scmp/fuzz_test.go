@@ -0,0 +1,62 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.18
+
+// Native fuzzing (testing.F) requires Go 1.18 or later.
+
+package scmp
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+// FuzzPldFromRaw checks that PldFromRaw never panics, and that a payload it
+// accepts can be written back out.
+func FuzzPldFromRaw(f *testing.F) {
+	quote := func(blk RawBlock) common.RawBytes {
+		return make(common.RawBytes, (int(blk)+1)*common.LineLen)
+	}
+	seeds := []struct {
+		ct   ClassType
+		info Info
+	}{
+		{ClassType{C_General, T_G_EchoRequest}, &InfoEcho{Id: 1, Seq: 2}},
+		{ClassType{C_Routing, T_R_OversizePkt}, &InfoPktSize{Size: 1500, MTU: 1472}},
+		{ClassType{C_CmnHdr, T_C_BadVersion}, nil},
+		{ClassType{C_Path, T_P_PathRequired}, nil},
+		{ClassType{C_Path, T_P_BadMac}, &InfoPathOffsets{InfoF: 1, HopF: 2, IfID: 3}},
+		{ClassType{C_Path, T_P_RevokedIF},
+			NewInfoRevocation(1, 2, 3, true, common.RawBytes{1, 2, 3, 4})},
+		{ClassType{C_Ext, T_E_BadHopByHop}, &InfoExtIdx{Idx: 1}},
+	}
+	for _, s := range seeds {
+		p := PldFromQuotes(s.ct, s.info, common.L4UDP, quote)
+		b := make(common.RawBytes, p.Len())
+		if _, err := p.Write(b); err != nil {
+			f.Fatalf("Unable to write seed payload %v: %v", s.ct, err)
+		}
+		f.Add([]byte(b), uint16(s.ct.Class), uint16(s.ct.Type))
+	}
+	f.Fuzz(func(t *testing.T, raw []byte, class, type_ uint16) {
+		p, err := PldFromRaw(common.RawBytes(raw), ClassType{Class(class), Type(type_)})
+		if err != nil {
+			return
+		}
+		b := make(common.RawBytes, p.Len())
+		p.Write(b)
+	})
+}
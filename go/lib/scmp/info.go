@@ -182,6 +182,10 @@ func NewInfoRevocation(infoF, hopF, ifID uint16, ingress bool,
 
 func InfoRevocationFromRaw(b common.RawBytes) (*InfoRevocation, *common.Error) {
 	p := &InfoRevocation{InfoPathOffsets: &InfoPathOffsets{}}
+	if len(b) < p.InfoPathOffsets.Len() {
+		return nil, common.NewError("SCMP Revocation info too short",
+			"min", p.InfoPathOffsets.Len(), "actual", len(b))
+	}
 	if err := restruct.Unpack(b, common.Order, &p.InfoPathOffsets); err != nil {
 		return nil, common.NewError("Failed to unpack SCMP Revocation info", "err", err)
 	}
@@ -221,6 +225,9 @@ type InfoExtIdx struct {
 }
 
 func InfoExtIdxFromRaw(b common.RawBytes) (*InfoExtIdx, *common.Error) {
+	if len(b) < 1 {
+		return nil, common.NewError("SCMP Ext Idx info too short", "min", 1, "actual", len(b))
+	}
 	return &InfoExtIdx{Idx: b[0]}, nil
 }
 
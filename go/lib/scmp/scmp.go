@@ -16,6 +16,8 @@ package scmp
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	//log "github.com/inconshreveable/log15"
 )
 
@@ -41,6 +43,21 @@ func (c Class) String() string {
 	return fmt.Sprintf("%s(%d)", classNames[c], c)
 }
 
+// ClassFromString parses an SCMP class, given either by name (e.g. "path",
+// case-insensitive) or by number.
+func ClassFromString(s string) (Class, error) {
+	for i, name := range classNames {
+		if strings.EqualFold(s, name) {
+			return Class(i), nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Unknown SCMP class %q", s)
+	}
+	return Class(n), nil
+}
+
 type Type uint16
 
 // C_General types
@@ -124,6 +141,21 @@ func (t Type) Name(c Class) string {
 	return fmt.Sprintf("%s(%d)", names[t], t)
 }
 
+// TypeFromString parses an SCMP type of class c, given either by name (e.g.
+// "bad_mac", case-insensitive) or by number.
+func TypeFromString(c Class, s string) (Type, error) {
+	for i, name := range typeNameMap[c] {
+		if strings.EqualFold(s, name) {
+			return Type(i), nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Unknown SCMP type %q for class %v", s, c)
+	}
+	return Type(n), nil
+}
+
 type ClassType struct {
 	Class Class
 	Type  Type
@@ -0,0 +1,48 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.18
+
+// Native fuzzing (testing.F) requires Go 1.18 or later.
+
+package spath
+
+import (
+	"testing"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+// FuzzPathReverse checks that Path.Reverse never panics, and that a path it
+// accepts can be reversed again.
+func FuzzPathReverse(f *testing.F) {
+	for _, c := range pathReverseCases {
+		for _, offs := range c.inOffs {
+			path := mkPathRevCase(c.in, offs[0], offs[1])
+			f.Add([]byte(path.Raw), path.InfOff, path.HopOff)
+		}
+	}
+	f.Fuzz(func(t *testing.T, raw []byte, infOff, hopOff uint8) {
+		path := &Path{Raw: common.RawBytes(raw), InfOff: infOff, HopOff: hopOff}
+		if err := path.Reverse(); err != nil {
+			return
+		}
+		if len(path.Raw) != len(raw) {
+			t.Fatalf("Reversed path length changed: %d != %d", len(path.Raw), len(raw))
+		}
+		if err := path.Reverse(); err != nil {
+			t.Fatalf("Unable to reverse a reversed path: %v", err)
+		}
+	})
+}
@@ -62,6 +62,11 @@ func (p *Path) Reverse() *common.Error {
 				"currOff", origOff, "max", len(p.Raw))
 		}
 	}
+	if origOff != len(p.Raw) {
+		// More than 3 segments, or trailing garbage.
+		return common.NewError("Unable to reverse corrupt path",
+			"currOff", origOff, "max", len(p.Raw))
+	}
 	revRaw := make(common.RawBytes, len(p.Raw))
 	revOff := 0
 	newInfIdx := 0
@@ -0,0 +1,87 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spkt
+
+import (
+	"fmt"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+var _ common.Extension = (*PathProbe)(nil)
+
+// PathProbe is an end-to-end extension used to check whether a path that has
+// previously failed is working again. The destination acknowledges a probe by
+// replying with an extension with IsAck set and the same ProbeID.
+type PathProbe struct {
+	IsAck   bool
+	ProbeID uint32
+}
+
+const PathProbeLen = common.ExtnFirstLineLen
+
+func PathProbeFromRaw(b common.RawBytes) (*PathProbe, *common.Error) {
+	if len(b) != PathProbeLen {
+		return nil, common.NewError("Invalid PathProbe length",
+			"expected", PathProbeLen, "actual", len(b))
+	}
+	return &PathProbe{IsAck: b[0] != 0, ProbeID: common.Order.Uint32(b[1:])}, nil
+}
+
+func (p *PathProbe) Write(b common.RawBytes) *common.Error {
+	if len(b) < p.Len() {
+		return common.NewError("Buffer too short", "method", "PathProbe.Write")
+	}
+	b[0] = 0
+	if p.IsAck {
+		b[0] = 1
+	}
+	common.Order.PutUint32(b[1:], p.ProbeID)
+	return nil
+}
+
+func (p *PathProbe) Pack() (common.RawBytes, *common.Error) {
+	b := make(common.RawBytes, p.Len())
+	if err := p.Write(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (p *PathProbe) Copy() common.Extension {
+	return &PathProbe{IsAck: p.IsAck, ProbeID: p.ProbeID}
+}
+
+// Reverse turns a probe into its acknowledgement.
+func (p *PathProbe) Reverse() (bool, *common.Error) {
+	p.IsAck = true
+	return true, nil
+}
+
+func (p *PathProbe) Len() int {
+	return PathProbeLen
+}
+
+func (p *PathProbe) Class() common.L4ProtocolType {
+	return common.End2EndClass
+}
+
+func (p *PathProbe) Type() common.ExtnType {
+	return common.ExtnPathProbeType
+}
+
+func (p *PathProbe) String() string {
+	return fmt.Sprintf("PathProbe (%dB): Ack: %v ProbeID: %d", p.Len(), p.IsAck, p.ProbeID)
+}
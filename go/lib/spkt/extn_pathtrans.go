@@ -0,0 +1,219 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spkt
+
+import (
+	"fmt"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/util"
+)
+
+var _ common.Extension = (*PathTrans)(nil)
+
+// PathTransType is the format of the path carried by a PathTrans extension.
+type PathTransType uint8
+
+const (
+	// PathTransOFPath is a data-plane path, see PathTransOFPath.
+	PathTransOFPath PathTransType = iota
+	// PathTransPCBPath is a control-plane path, i.e. a capnp-encoded path
+	// segment.
+	PathTransPCBPath
+)
+
+func (t PathTransType) String() string {
+	switch t {
+	case PathTransOFPath:
+		return "OF_PATH"
+	case PathTransPCBPath:
+		return "PCB_PATH"
+	}
+	return fmt.Sprintf("UNKNOWN (%d)", t)
+}
+
+// PathTrans is an end-to-end extension used to transport a path to the
+// destination, e.g. so that it can reply using the same path.
+type PathTrans struct {
+	PathType PathTransType
+	// Path is the raw path. Trailing padding is ignored by the path parsers.
+	Path common.RawBytes
+}
+
+// NewPathTransOF creates a PathTrans extension transporting a data-plane path.
+func NewPathTransOF(of *PathTransOF) *PathTrans {
+	b := make(common.RawBytes, of.Len())
+	of.Write(b)
+	return &PathTrans{PathType: PathTransOFPath, Path: b}
+}
+
+func PathTransFromRaw(b common.RawBytes) (*PathTrans, *common.Error) {
+	if len(b) < common.ExtnFirstLineLen {
+		return nil, common.NewError("PathTrans too short",
+			"min", common.ExtnFirstLineLen, "actual", len(b))
+	}
+	p := &PathTrans{PathType: PathTransType(b[0]), Path: append(common.RawBytes(nil), b[1:]...)}
+	switch p.PathType {
+	case PathTransOFPath:
+		if _, err := p.OFPath(); err != nil {
+			return nil, err
+		}
+	case PathTransPCBPath:
+	default:
+		return nil, common.NewError("Unsupported PathTrans path type", "type", p.PathType)
+	}
+	return p, nil
+}
+
+// OFPath parses a path of type PathTransOFPath.
+func (p *PathTrans) OFPath() (*PathTransOF, *common.Error) {
+	if p.PathType != PathTransOFPath {
+		return nil, common.NewError("Not a PathTrans OF path", "type", p.PathType)
+	}
+	return PathTransOFFromRaw(p.Path)
+}
+
+func (p *PathTrans) Write(b common.RawBytes) *common.Error {
+	if len(b) < p.Len() {
+		return common.NewError("Buffer too short", "method", "PathTrans.Write")
+	}
+	b[0] = uint8(p.PathType)
+	n := copy(b[1:], p.Path)
+	copy(b[1+n:p.Len()], make(common.RawBytes, p.Len()-1-n))
+	return nil
+}
+
+func (p *PathTrans) Pack() (common.RawBytes, *common.Error) {
+	b := make(common.RawBytes, p.Len())
+	if err := p.Write(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (p *PathTrans) Copy() common.Extension {
+	return &PathTrans{PathType: p.PathType, Path: append(common.RawBytes(nil), p.Path...)}
+}
+
+// Reverse drops the extension, as the path is only meant for the destination.
+func (p *PathTrans) Reverse() (bool, *common.Error) {
+	return false, nil
+}
+
+// Len returns the length of the extension, padded so that it (plus the
+// subheader) is a multiple of the line length.
+func (p *PathTrans) Len() int {
+	l := common.ExtnSubHdrLen + 1 + len(p.Path)
+	return l + util.CalcPadding(l, common.LineLen) - common.ExtnSubHdrLen
+}
+
+func (p *PathTrans) Class() common.L4ProtocolType {
+	return common.End2EndClass
+}
+
+func (p *PathTrans) Type() common.ExtnType {
+	return common.ExtnPathTransType
+}
+
+func (p *PathTrans) String() string {
+	if p.PathType == PathTransOFPath {
+		if of, err := p.OFPath(); err == nil {
+			return fmt.Sprintf("PathTrans (%dB): %v: %v", p.Len(), p.PathType, of)
+		}
+	}
+	return fmt.Sprintf("PathTrans (%dB): %v: %v", p.Len(), p.PathType, p.Path)
+}
+
+// PathTransOF is a data-plane path transported by a PathTrans extension,
+// together with its source and destination addresses. The raw format is the
+// source and destination host types (1B each), the source and destination
+// addresses (ISD-AS followed by host address), then the path itself.
+type PathTransOF struct {
+	SrcIA, DstIA     *addr.ISD_AS
+	SrcHost, DstHost addr.HostAddr
+	Path             *spath.Path
+}
+
+func PathTransOFFromRaw(b common.RawBytes) (*PathTransOF, *common.Error) {
+	if len(b) < 2 {
+		return nil, common.NewError("PathTrans OF path too short", "min", 2, "actual", len(b))
+	}
+	p := &PathTransOF{}
+	offset := 2
+	var err *common.Error
+	if p.SrcIA, p.SrcHost, err = ofAddrFromRaw(b, &offset, addr.HostAddrType(b[0])); err != nil {
+		return nil, err
+	}
+	if p.DstIA, p.DstHost, err = ofAddrFromRaw(b, &offset, addr.HostAddrType(b[1])); err != nil {
+		return nil, err
+	}
+	// Any trailing partial line is padding.
+	plen := (len(b) - offset) / common.LineLen * common.LineLen
+	p.Path = &spath.Path{Raw: append(common.RawBytes(nil), b[offset:offset+plen]...)}
+	return p, nil
+}
+
+func ofAddrFromRaw(b common.RawBytes, offset *int,
+	htype addr.HostAddrType) (*addr.ISD_AS, addr.HostAddr, *common.Error) {
+	hlen, err := addr.HostLen(htype)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *offset+addr.IABytes+int(hlen) > len(b) {
+		return nil, nil, common.NewError("PathTrans OF path too short",
+			"min", *offset+addr.IABytes+int(hlen), "actual", len(b))
+	}
+	ia := addr.IAFromRaw(b[*offset:])
+	*offset += addr.IABytes
+	host, err := addr.HostFromRaw(b[*offset:*offset+int(hlen)], htype)
+	if err != nil {
+		return nil, nil, err
+	}
+	*offset += int(hlen)
+	return ia, host.Copy(), nil
+}
+
+// Write writes the path in its raw format, returning the number of bytes
+// written. b must be at least Len() bytes long.
+func (p *PathTransOF) Write(b common.RawBytes) int {
+	b[0] = uint8(p.SrcHost.Type())
+	b[1] = uint8(p.DstHost.Type())
+	offset := 2
+	p.SrcIA.Write(b[offset:])
+	offset += addr.IABytes
+	offset += copy(b[offset:], p.SrcHost.Pack())
+	p.DstIA.Write(b[offset:])
+	offset += addr.IABytes
+	offset += copy(b[offset:], p.DstHost.Pack())
+	if p.Path != nil {
+		offset += copy(b[offset:], p.Path.Raw)
+	}
+	return offset
+}
+
+func (p *PathTransOF) Len() int {
+	l := 2 + 2*addr.IABytes + p.SrcHost.Size() + p.DstHost.Size()
+	if p.Path != nil {
+		l += len(p.Path.Raw)
+	}
+	return l
+}
+
+func (p *PathTransOF) String() string {
+	return fmt.Sprintf("%v,[%v] -> %v,[%v] Path: %v", p.SrcIA, p.SrcHost, p.DstIA, p.DstHost,
+		p.Path.Raw)
+}
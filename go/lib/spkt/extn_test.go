@@ -0,0 +1,102 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spkt
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+)
+
+func Test_PathProbe(t *testing.T) {
+	Convey("PathProbe round-trips through its raw format", t, func() {
+		p := &PathProbe{ProbeID: 0xdeadbeef}
+		raw, err := p.Pack()
+		So(err, ShouldBeNil)
+		So((len(raw)+common.ExtnSubHdrLen)%common.LineLen, ShouldEqual, 0)
+		So(raw, ShouldResemble, common.RawBytes{0, 0xde, 0xad, 0xbe, 0xef})
+		p2, err := PathProbeFromRaw(raw)
+		So(err, ShouldBeNil)
+		So(p2, ShouldResemble, p)
+		Convey("Reversing acknowledges the probe", func() {
+			keep, err := p2.Reverse()
+			So(err, ShouldBeNil)
+			So(keep, ShouldBeTrue)
+			So(p2.IsAck, ShouldBeTrue)
+		})
+	})
+	Convey("PathProbeFromRaw rejects bad lengths", t, func() {
+		_, err := PathProbeFromRaw(make(common.RawBytes, PathProbeLen+common.LineLen))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_PathTrans(t *testing.T) {
+	of := &PathTransOF{
+		SrcIA: &addr.ISD_AS{I: 1, A: 11}, SrcHost: addr.HostFromIP(net.IPv4(10, 0, 0, 1)),
+		DstIA: &addr.ISD_AS{I: 2, A: 22}, DstHost: addr.SvcBS,
+		Path: &spath.Path{Raw: common.RawBytes{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14,
+			15, 16}},
+	}
+	Convey("PathTrans with an OF path round-trips through its raw format", t, func() {
+		p := NewPathTransOF(of)
+		raw, err := p.Pack()
+		So(err, ShouldBeNil)
+		So((len(raw)+common.ExtnSubHdrLen)%common.LineLen, ShouldEqual, 0)
+		p2, err := PathTransFromRaw(raw)
+		So(err, ShouldBeNil)
+		So(p2.PathType, ShouldEqual, PathTransOFPath)
+		of2, err := p2.OFPath()
+		So(err, ShouldBeNil)
+		So(of2.SrcIA, ShouldResemble, of.SrcIA)
+		So(of2.DstIA, ShouldResemble, of.DstIA)
+		So(of2.SrcHost.String(), ShouldEqual, of.SrcHost.String())
+		So(of2.DstHost.String(), ShouldEqual, of.DstHost.String())
+		So(of2.Path.Raw, ShouldResemble, of.Path.Raw)
+	})
+	Convey("PathTransFromRaw rejects malformed extensions", t, func() {
+		raw, _ := NewPathTransOF(of).Pack()
+		cases := map[string]func(b common.RawBytes) common.RawBytes{
+			"Too short":         func(b common.RawBytes) common.RawBytes { return b[:4] },
+			"Unknown path type": func(b common.RawBytes) common.RawBytes { b[0] = 2; return b },
+			"Bad host type":     func(b common.RawBytes) common.RawBytes { b[1] = 0x7f; return b },
+			"Truncated address": func(b common.RawBytes) common.RawBytes { return b[:13] },
+		}
+		for desc, mod := range cases {
+			Convey(desc, func() {
+				_, err := PathTransFromRaw(mod(append(common.RawBytes(nil), raw...)))
+				So(err, ShouldNotBeNil)
+			})
+		}
+	})
+}
+
+func Test_ScnPkt_Reverse_Extns(t *testing.T) {
+	Convey("Reversing a packet drops the extensions that don't apply to replies", t, func() {
+		tr := NewTraceroute(2)
+		sp := &ScnPkt{
+			HBHExt: []common.Extension{tr, &OneHopPath{}},
+			E2EExt: []common.Extension{&PathProbe{ProbeID: 3}, &PathTrans{}},
+		}
+		So(sp.Reverse(), ShouldBeNil)
+		So(sp.HBHExt, ShouldResemble, []common.Extension{tr})
+		So(sp.E2EExt, ShouldResemble, []common.Extension{&PathProbe{IsAck: true, ProbeID: 3}})
+	})
+}
@@ -32,6 +32,29 @@ type Traceroute struct {
 	Hops []*TracerouteEntry
 }
 
+// TracerouteFromRaw parses a Traceroute extension, excluding the sub-header.
+func TracerouteFromRaw(b common.RawBytes) (*Traceroute, *common.Error) {
+	if len(b) < common.ExtnFirstLineLen || (len(b)-common.ExtnFirstLineLen)%common.LineLen != 0 {
+		return nil, common.NewError("Invalid Traceroute length", "len", len(b))
+	}
+	t := NewTraceroute((len(b) - common.ExtnFirstLineLen) / common.LineLen)
+	numHops := int(b[0])
+	if numHops > t.TotalHops() {
+		return nil, common.NewError("Traceroute has more hops than space for them",
+			"numHops", numHops, "totalHops", t.TotalHops())
+	}
+	offset := common.ExtnFirstLineLen
+	for i := 0; i < numHops; i++ {
+		t.Hops = append(t.Hops, &TracerouteEntry{
+			IA:        *addr.IAFromRaw(b[offset:]),
+			IfID:      common.Order.Uint16(b[offset+addr.IABytes:]),
+			TimeStamp: common.Order.Uint16(b[offset+addr.IABytes+2:]),
+		})
+		offset += common.LineLen
+	}
+	return t, nil
+}
+
 func NewTraceroute(totalHops int) *Traceroute {
 	t := &Traceroute{}
 	t.Hops = make([]*TracerouteEntry, 0, totalHops)
@@ -50,8 +73,10 @@ func (t *Traceroute) Write(b common.RawBytes) *common.Error {
 	if len(b) < t.Len() {
 		return common.NewError("Buffer too short", "method", "Traceroute.Write")
 	}
+	copy(b[:common.ExtnFirstLineLen], make(common.RawBytes, common.ExtnFirstLineLen))
 	b[0] = uint8(t.NumHops())
-	offset := common.ExtnSubHdrLen
+	// Entries start after the first line, which contains the sub-header.
+	offset := common.ExtnFirstLineLen
 	for _, h := range t.Hops {
 		h.Write(b[offset:])
 		offset += TracerouteEntryLen
@@ -0,0 +1,123 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains a walker for the extension header chain of SCION packets.
+
+package spkt
+
+import (
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+const (
+	ErrorExtChainTooLong = "Extension header chain longer than packet"
+	ErrorHdrLenTooLong   = "Header length indicated in common header is longer than packet"
+)
+
+// ExtnHdr describes an extension header found by ExtnWalker.
+type ExtnHdr struct {
+	Type common.ExtnType
+	// Offset is the offset of the extension's sub-header in the walked buffer.
+	Offset int
+	// Len is the length of the extension in bytes, including the sub-header.
+	Len int
+}
+
+// Start returns the offset of the extension's body, i.e. after the sub-header.
+func (e *ExtnHdr) Start() int {
+	return e.Offset + common.ExtnSubHdrLen
+}
+
+// End returns the offset of the end of the extension.
+func (e *ExtnHdr) End() int {
+	return e.Offset + e.Len
+}
+
+// ExtnWalker walks the chain of hop-by-hop and end-to-end extension headers
+// in a raw SCION packet, up to the L4 header. Every header is bounds-checked
+// against the buffer before being returned, so a malformed chain results in
+// an error rather than a panic.
+//
+// A packet without an L4 header has a NextHdr of common.L4None, which is the
+// same value as common.HopByHopClass, so the chain also ends when the end of
+// the buffer is reached.
+type ExtnWalker struct {
+	raw     common.RawBytes
+	nextHdr common.L4ProtocolType
+	offset  int
+}
+
+// NewExtnWalker creates a walker over raw, starting at offset, where the
+// header at offset is of type nextHdr.
+func NewExtnWalker(raw common.RawBytes, nextHdr common.L4ProtocolType, offset int) *ExtnWalker {
+	return &ExtnWalker{raw: raw, nextHdr: nextHdr, offset: offset}
+}
+
+// ExtnWalkerFromRaw creates a walker starting after the SCION header of the
+// raw packet.
+func ExtnWalkerFromRaw(raw common.RawBytes) (*ExtnWalker, *common.Error) {
+	if len(raw) < CmnHdrLen {
+		return nil, common.NewError(ErrorHdrLenTooLong, "min", CmnHdrLen, "actual", len(raw))
+	}
+	cmnHdr, err := CmnHdrFromRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+	if int(cmnHdr.HdrLen) > len(raw) {
+		return nil, common.NewError(ErrorHdrLenTooLong,
+			"hdrLen", cmnHdr.HdrLen, "actual", len(raw))
+	}
+	return NewExtnWalker(raw, cmnHdr.NextHdr, int(cmnHdr.HdrLen)), nil
+}
+
+// NextHdr returns the type of the next header, i.e. the next extension class
+// or the L4 protocol once Done returns true.
+func (w *ExtnWalker) NextHdr() common.L4ProtocolType {
+	return w.nextHdr
+}
+
+// Offset returns the offset of the next header.
+func (w *ExtnWalker) Offset() int {
+	return w.offset
+}
+
+// Done returns true if the next header isn't an extension, or the end of the
+// buffer has been reached.
+func (w *ExtnWalker) Done() bool {
+	return w.offset >= len(w.raw) ||
+		(w.nextHdr != common.HopByHopClass && w.nextHdr != common.End2EndClass)
+}
+
+// Next returns the next extension header, and advances the walker past it. It
+// returns nil once Done returns true. If the extension doesn't fit in the
+// buffer, an error is returned and the walker isn't advanced.
+func (w *ExtnWalker) Next() (*ExtnHdr, *common.Error) {
+	if w.Done() {
+		return nil, nil
+	}
+	if w.offset+common.ExtnSubHdrLen > len(w.raw) {
+		return nil, common.NewError(ErrorExtChainTooLong, "curr", w.offset, "max", len(w.raw))
+	}
+	e := &ExtnHdr{
+		Type:   common.ExtnType{Class: w.nextHdr, Type: w.raw[w.offset+2]},
+		Offset: w.offset,
+		Len:    (int(w.raw[w.offset+1]) + 1) * common.LineLen,
+	}
+	if e.End() > len(w.raw) {
+		return nil, common.NewError(ErrorExtChainTooLong, "curr", e.End(), "max", len(w.raw))
+	}
+	w.nextHdr = common.L4ProtocolType(w.raw[w.offset])
+	w.offset = e.End()
+	return e, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spkt
+
+import (
+	"math/rand"
+	"testing"
+	"testing/quick"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+// testChain is a randomly generated extension header chain, followed by an
+// L4 header.
+type testChain struct {
+	raw  common.RawBytes
+	exts []ExtnHdr
+	l4   common.L4ProtocolType
+}
+
+var testL4Types = []common.L4ProtocolType{common.L4SCMP, common.L4TCP, common.L4UDP, common.L4SSP}
+
+func newTestChain(r *rand.Rand) *testChain {
+	c := &testChain{l4: testL4Types[r.Intn(len(testL4Types))]}
+	nHBH, nE2E := r.Intn(common.ExtnMaxHBH+2), r.Intn(4)
+	for i := 0; i < nHBH+nE2E; i++ {
+		class := common.HopByHopClass
+		if i >= nHBH {
+			class = common.End2EndClass
+		}
+		lines := 1 + r.Intn(4)
+		e := ExtnHdr{
+			Type:   common.ExtnType{Class: class, Type: uint8(r.Intn(256))},
+			Offset: len(c.raw),
+			Len:    lines * common.LineLen,
+		}
+		b := make(common.RawBytes, e.Len)
+		r.Read(b)
+		b[1] = uint8(lines - 1)
+		b[2] = e.Type.Type
+		c.raw = append(c.raw, b...)
+		c.exts = append(c.exts, e)
+	}
+	// Set the NextHdr fields now that all header types are known.
+	for i, e := range c.exts {
+		next := c.l4
+		if i+1 < len(c.exts) {
+			next = c.exts[i+1].Type.Class
+		}
+		c.raw[e.Offset] = uint8(next)
+	}
+	// Add an L4 header and payload.
+	l4 := make(common.RawBytes, common.LineLen*(1+r.Intn(3)))
+	r.Read(l4)
+	c.raw = append(c.raw, l4...)
+	return c
+}
+
+func (c *testChain) first() common.L4ProtocolType {
+	if len(c.exts) == 0 {
+		return c.l4
+	}
+	return c.exts[0].Type.Class
+}
+
+func (c *testChain) l4Offset() int {
+	if len(c.exts) == 0 {
+		return 0
+	}
+	return c.exts[len(c.exts)-1].End()
+}
+
+// walkAll walks w to the end of the chain, checking that each extension
+// returned lies within the buffer, after the previous one.
+func walkAll(w *ExtnWalker, bufLen int) ([]ExtnHdr, *common.Error, bool) {
+	var exts []ExtnHdr
+	prevEnd := w.Offset()
+	for !w.Done() {
+		e, err := w.Next()
+		if err != nil {
+			return exts, err, true
+		}
+		if e.Offset != prevEnd || e.Len < common.LineLen || e.End() > bufLen ||
+			w.Offset() != e.End() {
+			return exts, nil, false
+		}
+		prevEnd = e.End()
+		exts = append(exts, *e)
+	}
+	e, err := w.Next()
+	return exts, err, e == nil && err == nil
+}
+
+func Test_ExtnWalker(t *testing.T) {
+	Convey("Walking a generated chain returns all extensions and the L4 header", t, func() {
+		f := func(seed int64) bool {
+			c := newTestChain(rand.New(rand.NewSource(seed)))
+			w := NewExtnWalker(c.raw, c.first(), 0)
+			exts, err, ok := walkAll(w, len(c.raw))
+			if !ok || err != nil || len(exts) != len(c.exts) {
+				return false
+			}
+			for i := range exts {
+				if exts[i] != c.exts[i] {
+					return false
+				}
+			}
+			return w.NextHdr() == c.l4 && w.Offset() == c.l4Offset()
+		}
+		So(quick.Check(f, nil), ShouldBeNil)
+	})
+	Convey("Truncated chains return an error or end at the end of the buffer", t, func() {
+		f := func(seed int64) bool {
+			r := rand.New(rand.NewSource(seed))
+			c := newTestChain(r)
+			if len(c.exts) == 0 {
+				return true
+			}
+			raw := c.raw[:r.Intn(c.l4Offset())]
+			w := NewExtnWalker(raw, c.first(), 0)
+			exts, err, ok := walkAll(w, len(raw))
+			if !ok {
+				return false
+			}
+			// All extensions returned must be ones that fit completely.
+			for i := range exts {
+				if exts[i] != c.exts[i] || exts[i].End() > len(raw) {
+					return false
+				}
+			}
+			return err != nil || w.Offset() == len(raw)
+		}
+		So(quick.Check(f, nil), ShouldBeNil)
+	})
+	Convey("Random buffers never cause out of bounds extensions", t, func() {
+		f := func(raw []byte, nextHdr uint8, offset uint8) bool {
+			if int(offset) > len(raw) {
+				offset = uint8(len(raw))
+			}
+			w := NewExtnWalker(raw, common.L4ProtocolType(nextHdr), int(offset))
+			_, _, ok := walkAll(w, len(raw))
+			return ok
+		}
+		So(quick.Check(f, nil), ShouldBeNil)
+	})
+	Convey("ExtnWalkerFromRaw starts after the SCION header", t, func() {
+		c := newTestChain(rand.New(rand.NewSource(1)))
+		hdrLen := CmnHdrLen + common.LineLen
+		raw := make(common.RawBytes, hdrLen+len(c.raw))
+		copy(raw[hdrLen:], c.raw)
+		cmnHdr := &CmnHdr{TotalLen: uint16(len(raw)), HdrLen: uint8(hdrLen), NextHdr: c.first()}
+		cmnHdr.Write(raw)
+		w, err := ExtnWalkerFromRaw(raw)
+		So(err, ShouldBeNil)
+		exts, err, ok := walkAll(w, len(raw))
+		So(ok, ShouldBeTrue)
+		So(err, ShouldBeNil)
+		So(len(exts), ShouldEqual, len(c.exts))
+		So(w.Offset(), ShouldEqual, hdrLen+c.l4Offset())
+		Convey("An overlong header length is rejected", func() {
+			_, err := ExtnWalkerFromRaw(raw[:hdrLen-1])
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.18
+
+// Native fuzzing (testing.F) requires Go 1.18 or later.
+
+package spkt
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+)
+
+// FuzzParseScnPkt checks that ParseScnPkt never panics, and that any
+// extensions it accepts are also accepted by ExtnWalker.
+func FuzzParseScnPkt(f *testing.F) {
+	for seed := int64(0); seed < 4; seed++ {
+		c := newTestChain(rand.New(rand.NewSource(seed)))
+		// IPv4 source and destination hosts, and an 8B path.
+		hdrLen := CmnHdrLen + 2*addr.IABytes + 2*addr.HostLenIPv4 + common.LineLen
+		raw := make(common.RawBytes, hdrLen+len(c.raw))
+		copy(raw[hdrLen:], c.raw)
+		cmnHdr := &CmnHdr{DstType: addr.HostTypeIPv4, SrcType: addr.HostTypeIPv4,
+			TotalLen: uint16(len(raw)), HdrLen: uint8(hdrLen), NextHdr: c.first()}
+		cmnHdr.Write(raw)
+		f.Add([]byte(raw))
+	}
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		s, err := ParseScnPkt(raw)
+		if err != nil {
+			return
+		}
+		// ParseScnPkt ignores anything after the common header's total length.
+		cmnHdr, _ := CmnHdrFromRaw(raw)
+		w, err := ExtnWalkerFromRaw(raw[:cmnHdr.TotalLen])
+		if err != nil {
+			t.Fatalf("Walker rejected parsed packet: %v", err)
+		}
+		n := 0
+		for !w.Done() {
+			if _, err := w.Next(); err != nil {
+				t.Fatalf("Walker rejected parsed packet: %v", err)
+			}
+			n++
+		}
+		if n != len(s.HBHExt)+len(s.E2EExt) {
+			t.Fatalf("Extension count mismatch: walker %d parser %d",
+				n, len(s.HBHExt)+len(s.E2EExt))
+		}
+	})
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file handles parsing raw SCION packets into ScnPkts.
+
+package spkt
+
+import (
+	"github.com/netsec-ethz/scion/go/lib/addr"
+	"github.com/netsec-ethz/scion/go/lib/common"
+	"github.com/netsec-ethz/scion/go/lib/l4"
+	"github.com/netsec-ethz/scion/go/lib/scmp"
+	"github.com/netsec-ethz/scion/go/lib/spath"
+	"github.com/netsec-ethz/scion/go/lib/util"
+)
+
+const (
+	ErrorPktTooShort = "Packet shorter than length indicated in common header"
+	ErrorUnsuppExtn  = "Unsupported extension"
+	ErrorExtnOrder   = "Hop-by-hop extension after end-to-end extension"
+	ErrorUnsuppL4    = "Unsupported L4 header type"
+)
+
+// ParseScnPkt parses a raw SCION packet. Unlike the router, which parses
+// packets lazily, all of the packet is parsed, so any unsupported extension
+// or L4 protocol results in an error. The payload of a UDP packet is returned
+// as common.RawBytes. The returned ScnPkt doesn't reference b.
+func ParseScnPkt(b common.RawBytes) (*ScnPkt, *common.Error) {
+	if len(b) < CmnHdrLen {
+		return nil, common.NewError(ErrorPktTooShort, "min", CmnHdrLen, "actual", len(b))
+	}
+	cmnHdr, err := CmnHdrFromRaw(b)
+	if err != nil {
+		return nil, err
+	}
+	if int(cmnHdr.TotalLen) > len(b) {
+		return nil, common.NewError(ErrorPktTooShort,
+			"totalLen", cmnHdr.TotalLen, "actual", len(b))
+	}
+	b = append(common.RawBytes(nil), b[:cmnHdr.TotalLen]...)
+	s := &ScnPkt{}
+	pathStart, err := s.parseAddrs(b, cmnHdr)
+	if err != nil {
+		return nil, err
+	}
+	// spath.Path uses offsets relative to the start of its buffer, whereas the
+	// common header uses offsets relative to the start of the packet.
+	s.Path = &spath.Path{
+		Raw:    b[pathStart:cmnHdr.HdrLen],
+		InfOff: cmnHdr.CurrInfoF - uint8(pathStart),
+		HopOff: cmnHdr.CurrHopF - uint8(pathStart),
+	}
+	w := NewExtnWalker(b, cmnHdr.NextHdr, int(cmnHdr.HdrLen))
+	for !w.Done() {
+		eh, err := w.Next()
+		if err != nil {
+			return nil, err
+		}
+		e, err := extnFromRaw(eh.Type, b[eh.Start():eh.End()])
+		if err != nil {
+			return nil, err
+		}
+		if e.Class() == common.HopByHopClass {
+			if len(s.E2EExt) > 0 {
+				return nil, common.NewError(ErrorExtnOrder, "offset", eh.Offset)
+			}
+			s.HBHExt = append(s.HBHExt, e)
+		} else {
+			s.E2EExt = append(s.E2EExt, e)
+		}
+	}
+	if err := s.parseL4(b, w.NextHdr(), w.Offset()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseAddrs parses the address header, returning the offset of the path
+// header.
+func (s *ScnPkt) parseAddrs(b common.RawBytes, cmnHdr *CmnHdr) (int, *common.Error) {
+	dstLen, err := addr.HostLen(cmnHdr.DstType)
+	if err != nil {
+		return 0, err
+	}
+	srcLen, err := addr.HostLen(cmnHdr.SrcType)
+	if err != nil {
+		return 0, err
+	}
+	addrLen := addr.IABytes*2 + int(dstLen) + int(srcLen)
+	pathStart := CmnHdrLen + addrLen + util.CalcPadding(addrLen, common.LineLen)
+	if pathStart > int(cmnHdr.HdrLen) || int(cmnHdr.HdrLen) > len(b) {
+		return 0, common.NewError("Invalid header length", "min", pathStart,
+			"hdrLen", cmnHdr.HdrLen, "max", len(b))
+	}
+	offset := CmnHdrLen
+	s.DstIA = addr.IAFromRaw(b[offset:])
+	offset += addr.IABytes
+	s.SrcIA = addr.IAFromRaw(b[offset:])
+	offset += addr.IABytes
+	if s.DstHost, err = addr.HostFromRaw(b[offset:], cmnHdr.DstType); err != nil {
+		return 0, err
+	}
+	offset += int(dstLen)
+	if s.SrcHost, err = addr.HostFromRaw(b[offset:], cmnHdr.SrcType); err != nil {
+		return 0, err
+	}
+	return pathStart, nil
+}
+
+// extnFromRaw parses an extension, excluding the sub-header.
+func extnFromRaw(t common.ExtnType, b common.RawBytes) (common.Extension, *common.Error) {
+	switch t {
+	case common.ExtnTracerouteType:
+		return TracerouteFromRaw(b)
+	case common.ExtnOneHopPathType:
+		return &OneHopPath{}, nil
+	case common.ExtnSCMPType:
+		return scmp.ExtnFromRaw(b)
+	case common.ExtnPathTransType:
+		return PathTransFromRaw(b)
+	case common.ExtnPathProbeType:
+		return PathProbeFromRaw(b)
+	}
+	return nil, common.NewError(ErrorUnsuppExtn, "type", t)
+}
+
+// parseL4 parses the L4 header of type l4Type at offset, and the payload
+// following it.
+func (s *ScnPkt) parseL4(b common.RawBytes, l4Type common.L4ProtocolType,
+	offset int) *common.Error {
+	if offset == len(b) && l4Type == common.L4None {
+		// No L4 header.
+		return nil
+	}
+	var err *common.Error
+	switch l4Type {
+	case common.L4SCMP:
+		if offset+scmp.HdrLen > len(b) {
+			return common.NewError(ErrorPktTooShort, "l4", l4Type, "offset", offset)
+		}
+		var h *scmp.Hdr
+		if h, err = scmp.HdrFromRaw(b[offset:]); err != nil {
+			return err
+		}
+		s.L4 = h
+		ct := scmp.ClassType{Class: h.Class, Type: h.Type}
+		var pld *scmp.Payload
+		if pld, err = scmp.PldFromRaw(b[offset+scmp.HdrLen:], ct); err != nil {
+			return err
+		}
+		s.Pld = pld
+	case common.L4UDP:
+		if offset+l4.UDPLen > len(b) {
+			return common.NewError(ErrorPktTooShort, "l4", l4Type, "offset", offset)
+		}
+		if s.L4, err = l4.UDPFromRaw(b[offset:]); err != nil {
+			return err
+		}
+		s.Pld = b[offset+l4.UDPLen:]
+	default:
+		return common.NewError(ErrorUnsuppL4, "type", l4Type)
+	}
+	return nil
+}
@@ -73,13 +73,35 @@ func (s *ScnPkt) Reverse() *common.Error {
 			return err
 		}
 	}
-	// FIXME(kormat): handle reversing extensions
+	var err *common.Error
+	if s.HBHExt, err = reverseExtns(s.HBHExt); err != nil {
+		return err
+	}
+	if s.E2EExt, err = reverseExtns(s.E2EExt); err != nil {
+		return err
+	}
 	if s.L4 != nil {
 		s.L4.Reverse()
 	}
 	return nil
 }
 
+// reverseExtns reverses each extension, and returns the ones that are kept in
+// the reversed packet.
+func reverseExtns(exts []common.Extension) ([]common.Extension, *common.Error) {
+	var kept []common.Extension
+	for _, e := range exts {
+		keep, err := e.Reverse()
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
+
 func (s *ScnPkt) AddrLen() int {
 	addrLen := addr.IABytes*2 + s.DstHost.Size() + s.SrcHost.Size()
 	return addrLen + util.CalcPadding(addrLen, common.LineLen)
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 
@@ -59,12 +60,54 @@ func (b BasicElem) String() string {
 }
 
 type TopoBR struct {
+	// BasicElem is the router's primary internal address (index 0).
 	BasicElem `yaml:",inline"`
-	IF        *TopoIF `yaml:"Interface"`
+	// InternalAddrs lists any further internal addresses, starting at index 1.
+	InternalAddrs []BasicElem `yaml:"InternalAddrs"`
+	// CtrlAddrIdx is the index of the internal address used as the source of
+	// control traffic generated by the router itself.
+	CtrlAddrIdx int       `yaml:"CtrlAddrIdx"`
+	IFs         []*TopoIF `yaml:"Interfaces"`
+	// IF is the old single-interface format. When parsing, it is moved to IFs.
+	IF *TopoIF `yaml:"Interface"`
+}
+
+// LocAddrs returns all internal addresses of the router, in index order.
+func (t TopoBR) LocAddrs() []BasicElem {
+	return append([]BasicElem{t.BasicElem}, t.InternalAddrs...)
+}
+
+// IFLocAddr returns the internal address used by the interface with the given
+// IFID. If the router doesn't have that interface, the primary internal
+// address is returned.
+func (t TopoBR) IFLocAddr(ifid int) BasicElem {
+	if intf := t.GetIF(ifid); intf != nil && intf.InternalAddrIdx > 0 {
+		return t.InternalAddrs[intf.InternalAddrIdx-1]
+	}
+	return t.BasicElem
+}
+
+// GetIF returns the router's interface with the given IFID, or nil if the
+// router doesn't have that interface.
+func (t TopoBR) GetIF(ifid int) *TopoIF {
+	for _, intf := range t.IFs {
+		if intf.IFID == ifid {
+			return intf
+		}
+	}
+	return nil
 }
 
 func (t TopoBR) String() string {
-	return fmt.Sprintf("Loc addrs:\n  %s\nInterfaces:\n  %s", t.BasicElem, t.IF)
+	var locs, ifs []string
+	for _, loc := range t.LocAddrs() {
+		locs = append(locs, loc.String())
+	}
+	for _, intf := range t.IFs {
+		ifs = append(ifs, intf.String())
+	}
+	return fmt.Sprintf("Loc addrs:\n  %s\nInterfaces:\n  %s", strings.Join(locs, "\n  "),
+		strings.Join(ifs, "\n  "))
 }
 
 type TopoIF struct {
@@ -77,12 +120,16 @@ type TopoIF struct {
 	MTU       int          `yaml:"MTU"`
 	BW        int          `yaml:"Bandwidth"`
 	LinkType  string       `yaml:"LinkType"`
+	// InternalAddrIdx is the index of the router's internal address that is
+	// used for traffic to and from this interface.
+	InternalAddrIdx int `yaml:"InternalAddrIdx"`
 }
 
 func (t *TopoIF) String() string {
 	return fmt.Sprintf(
-		"IFID: %d Link: %s Local: %s:%d Remote: %s:%d IA: %s MTU: %d BW: %d",
+		"IFID: %d Link: %s Local: %s:%d Remote: %s:%d IA: %s MTU: %d BW: %d InternalAddrIdx: %d",
 		t.IFID, t.LinkType, t.Addr, t.UdpPort, t.ToAddr, t.ToUdpPort, t.IA, t.MTU, t.BW,
+		t.InternalAddrIdx,
 	)
 
 }
@@ -90,8 +137,10 @@ func (t *TopoIF) String() string {
 const CfgName = "topology.yml"
 
 const (
-	ErrorOpen  = "Unable to open topology"
-	ErrorParse = "Unable to parse topology"
+	ErrorOpen    = "Unable to open topology"
+	ErrorParse   = "Unable to parse topology"
+	ErrorDupIF   = "Duplicate interface ID in topology"
+	ErrorAddrIdx = "Invalid internal address index in topology"
 )
 
 const (
@@ -101,34 +150,53 @@ const (
 	LinkPeer   = "PEER"
 )
 
-var Curr *TopoMeta
-
-func Load(path string) *common.Error {
+// Load loads a topology from a file.
+func Load(path string) (*TopoMeta, *common.Error) {
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		return common.NewError(ErrorOpen, "err", err)
-	}
-	if err := Parse(b, path); err != nil {
-		return err
+		return nil, common.NewError(ErrorOpen, "err", err)
 	}
-	return nil
+	return Parse(b, path)
 }
 
-func Parse(data []byte, path string) *common.Error {
+// Parse parses a topology from its YAML representation.
+func Parse(data []byte, path string) (*TopoMeta, *common.Error) {
 	tm := &TopoMeta{}
 	tm.IFMap = make(map[int]TopoBR)
 	if err := yaml.Unmarshal(data, &tm.T); err != nil {
-		return common.NewError(ErrorParse, "err", err, "path", path)
+		return nil, common.NewError(ErrorParse, "err", err, "path", path)
 	}
-	tm.populateMeta()
-	Curr = tm
-	return nil
+	if err := tm.populateMeta(); err != nil {
+		err.Ctx = append(err.Ctx, "path", path)
+		return nil, err
+	}
+	return tm, nil
 }
 
-func (tm *TopoMeta) populateMeta() {
+func (tm *TopoMeta) populateMeta() *common.Error {
 	for k, v := range tm.T.BR {
+		if v.IF != nil {
+			v.IFs = append(v.IFs, v.IF)
+			v.IF = nil
+			tm.T.BR[k] = v
+		}
 		tm.BRNames = append(tm.BRNames, k)
-		tm.IFMap[v.IF.IFID] = v
+		numLocs := 1 + len(v.InternalAddrs)
+		if v.CtrlAddrIdx < 0 || v.CtrlAddrIdx >= numLocs {
+			return common.NewError(ErrorAddrIdx, "br", k, "ctrlAddrIdx", v.CtrlAddrIdx,
+				"numAddrs", numLocs)
+		}
+		for _, intf := range v.IFs {
+			if intf.InternalAddrIdx < 0 || intf.InternalAddrIdx >= numLocs {
+				return common.NewError(ErrorAddrIdx, "br", k, "ifid", intf.IFID,
+					"internalAddrIdx", intf.InternalAddrIdx, "numAddrs", numLocs)
+			}
+			if other, ok := tm.IFMap[intf.IFID]; ok {
+				return common.NewError(ErrorDupIF, "ifid", intf.IFID,
+					"br", k, "other", other.BasicElem)
+			}
+			tm.IFMap[intf.IFID] = v
+		}
 	}
 	for k := range tm.T.BS {
 		tm.BSNames = append(tm.BSNames, k)
@@ -151,4 +219,5 @@ func (tm *TopoMeta) populateMeta() {
 	sort.Strings(tm.CSNames)
 	sort.Strings(tm.SBNames)
 	sort.Ints(tm.ZKIDs)
+	return nil
 }
@@ -38,9 +38,9 @@ func Test_Topo(t *testing.T) {
 	}
 	brs := map[string]TopoBR{
 		"br1-11-1": {
-			BasicElem{mkYIP("127.0.0.69"), 30097},
-			&TopoIF{mkYIP("127.0.0.6"), 50001, mkYIP("127.0.0.7"), 50000,
-				1, &addr.ISD_AS{I: 1, A: 12}, 1472, 1000, "CORE"},
+			BasicElem: BasicElem{mkYIP("127.0.0.69"), 30097},
+			IFs: []*TopoIF{{mkYIP("127.0.0.6"), 50001, mkYIP("127.0.0.7"), 50000,
+				1, &addr.ISD_AS{I: 1, A: 12}, 1472, 1000, "CORE", 0}},
 		},
 	}
 	pses := map[string]BasicElem{
@@ -58,10 +58,11 @@ func Test_Topo(t *testing.T) {
 
 	// Finally testing
 	Convey("Loading test config `testdata/basic.yml`", t, func() {
-		if err := Load("testdata/basic.yml"); err != nil {
+		tm, err := Load("testdata/basic.yml")
+		if err != nil {
 			t.Fatalf("Error loading config: %v", err)
 		}
-		c := Curr.T
+		c := tm.T
 		So(c.BS, ShouldResemble, bses)
 		So(c.CS, ShouldResemble, cses)
 		So(c.BR, ShouldResemble, brs)
@@ -80,6 +81,64 @@ func Test_Topo(t *testing.T) {
 	})
 }
 
+func Test_Topo_MultiIF(t *testing.T) {
+	Convey("Loading test config `testdata/multi_if.yml`", t, func() {
+		tm, err := Load("testdata/multi_if.yml")
+		if err != nil {
+			t.Fatalf("Error loading config: %v", err)
+		}
+		br := tm.T.BR["br1-11-1"]
+		So(len(br.IFs), ShouldEqual, 2)
+		So(br.IF, ShouldBeNil)
+		Convey("All interfaces are in the IFMap", func() {
+			So(tm.IFMap, ShouldContainKey, 1)
+			So(tm.IFMap, ShouldContainKey, 2)
+			So(tm.IFMap, ShouldContainKey, 3)
+			So(tm.IFMap[1].BasicElem, ShouldResemble, br.BasicElem)
+			So(tm.IFMap[2].BasicElem, ShouldResemble, br.BasicElem)
+			So(tm.IFMap[3].BasicElem, ShouldResemble, tm.T.BR["br1-11-2"].BasicElem)
+		})
+		Convey("Interfaces can be looked up by IFID", func() {
+			So(br.GetIF(1).LinkType, ShouldEqual, LinkCore)
+			So(br.GetIF(2).LinkType, ShouldEqual, LinkChild)
+			So(br.GetIF(2).IA, ShouldResemble, &addr.ISD_AS{I: 1, A: 13})
+			So(br.GetIF(3), ShouldBeNil)
+		})
+	})
+	Convey("Loading test config `testdata/dup_if.yml`", t, func() {
+		_, err := Load("testdata/dup_if.yml")
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, ErrorDupIF)
+	})
+}
+
+func Test_Topo_MultiLoc(t *testing.T) {
+	Convey("Loading test config `testdata/multi_loc.yml`", t, func() {
+		tm, err := Load("testdata/multi_loc.yml")
+		if err != nil {
+			t.Fatalf("Error loading config: %v", err)
+		}
+		br := tm.T.BR["br1-11-1"]
+		So(br.CtrlAddrIdx, ShouldEqual, 2)
+		Convey("All internal addresses are listed in order", func() {
+			So(br.LocAddrs(), ShouldResemble, []BasicElem{
+				{mkYIP("127.0.0.69"), 30097},
+				{mkYIP("127.0.1.69"), 30097},
+				{mkYIP("::1"), 30098},
+			})
+		})
+		Convey("Interfaces use their configured internal address", func() {
+			So(br.IFLocAddr(1), ShouldResemble, BasicElem{mkYIP("127.0.0.69"), 30097})
+			So(br.IFLocAddr(2), ShouldResemble, BasicElem{mkYIP("127.0.1.69"), 30097})
+		})
+	})
+	Convey("Loading test config `testdata/bad_addr_idx.yml`", t, func() {
+		_, err := Load("testdata/bad_addr_idx.yml")
+		So(err, ShouldNotBeNil)
+		So(err.Desc, ShouldEqual, ErrorAddrIdx)
+	})
+}
+
 func mkYIP(ip string) *util.YamlIP {
 	return &util.YamlIP{IP: net.ParseIP(ip)}
 }
@@ -29,7 +29,7 @@ func CalcPadding(length, blkSize int) int {
 func FillPadding(b common.RawBytes, length, blkSize int) int {
 	padding := CalcPadding(length, blkSize)
 	total := length + padding
-	for i := range b[length:total] {
+	for i := length; i < total; i++ {
 		b[i] = 0
 	}
 	return total
@@ -19,6 +19,8 @@ import (
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion/go/lib/common"
 )
 
 func Test_CalcPadding(t *testing.T) {
@@ -35,3 +37,11 @@ func Test_CalcPadding(t *testing.T) {
 		}
 	})
 }
+
+func Test_FillPadding(t *testing.T) {
+	Convey("FillPadding should only zero the padding", t, func() {
+		b := common.RawBytes{1, 2, 3, 4, 5, 6, 7, 8, 9}
+		So(FillPadding(b, 3, 8), ShouldEqual, 8)
+		So(b, ShouldResemble, common.RawBytes{1, 2, 3, 0, 0, 0, 0, 0, 9})
+	})
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net"
+	"time"
 )
 
 type B64Bytes []byte
@@ -58,3 +59,24 @@ func (y *YamlIP) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 	return nil
 }
+
+// YamlTime is a time in RFC 3339 format, e.g. 2017-06-01T12:00:00Z.
+type YamlTime struct {
+	time.Time
+}
+
+func (y YamlTime) MarshalYAML() (interface{}, error) {
+	return y.Format(time.RFC3339), nil
+}
+
+func (y *YamlTime) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	var err error
+	if err = unmarshal(&s); err != nil {
+		return err
+	}
+	if y.Time, err = time.Parse(time.RFC3339, s); err != nil {
+		return fmt.Errorf("Invalid time '%v': %v", s, err)
+	}
+	return nil
+}
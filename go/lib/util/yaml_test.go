@@ -17,6 +17,7 @@ package util
 import (
 	"net"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/yaml.v2"
@@ -98,3 +99,22 @@ func Test_YamlIP_UnmarshalYAML_Success(t *testing.T) {
 		}
 	})
 }
+
+// Interface assertions
+var _ yaml.Marshaler = (*YamlTime)(nil)
+var _ yaml.Unmarshaler = (*YamlTime)(nil)
+
+func Test_YamlTime_UnmarshalYAML(t *testing.T) {
+	Convey("Time parse error", t, func() {
+		var y YamlTime
+		So(yaml.Unmarshal([]byte("2017-06-01 12:00"), &y), ShouldNotBeNil)
+		So(y, ShouldBeZeroValue)
+	})
+	Convey("Valid time", t, func() {
+		var y YamlTime
+		So(yaml.Unmarshal([]byte("2017-06-01T12:00:00+02:00"), &y), ShouldBeNil)
+		So(y.Equal(time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)), ShouldBeTrue)
+		out, _ := yaml.Marshal(y)
+		So(string(out), ShouldEqual, "\"2017-06-01T12:00:00+02:00\"\n")
+	})
+}
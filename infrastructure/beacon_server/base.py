@@ -145,6 +145,7 @@ class BeaconServer(SCIONElement, metaclass=ABCMeta):
             },
             PayloadClass.PATH: {
                 PMT.IFSTATE_REQ: self._handle_ifstate_request,
+                PMT.IFSTATE_INFOS: self._handle_ifstate_infos,
                 PMT.REVOCATION: self._handle_revocation,
             },
         }
@@ -803,3 +804,42 @@ class BeaconServer(SCIONElement, metaclass=ABCMeta):
             return
         payload = IFStatePayload.from_values(infos)
         self.send_meta(payload, meta, (meta.host, meta.port))
+
+    def _handle_ifstate_infos(self, pld, meta):
+        """
+        Handles interface state notifications from border routers, which are
+        sent when a router detects that a link went up or down. A link reported
+        down is timed out right away, so that _handle_if_timeouts revokes it
+        without waiting for IFID_TOUT. Links reported up are ignored, as those
+        are activated by the IFID packets relayed by the border routers.
+        Notifications are only accepted from the border routers of the local
+        AS.
+        """
+        assert isinstance(pld, IFStatePayload)
+        if not self._from_local_br(meta):
+            logging.warning("Ignoring ifstate infos from %s, which is not a "
+                            "local border router.", meta.get_addr())
+            return
+        with self.ifid_state_lock:
+            for info in pld.p.infos:
+                if info.active:
+                    continue
+                if info.ifID not in self.ifid_state:
+                    logging.error("Received ifstate info from %s for unknown "
+                                  "interface %s.", meta.get_addr(), info.ifID)
+                    continue
+                logging.info("IF %d reported down by %s.", info.ifID,
+                             meta.get_addr())
+                self.ifid_state[info.ifID].time_out()
+
+    def _from_local_br(self, meta):
+        """
+        Check if a message was sent by one of the border routers of the local
+        AS.
+        """
+        if meta.ia != self.addr.isd_as:
+            return False
+        for br in self.topology.get_all_border_routers():
+            if br.addr == meta.host:
+                return True
+        return False
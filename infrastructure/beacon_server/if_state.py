@@ -66,6 +66,15 @@ class InterfaceState(object):
             self.last_updated = time.time()
             self._state = self.INACTIVE
 
+    def time_out(self):
+        """
+        Sets the state of the interface to timed out, unless it's already
+        revoked.
+        """
+        with self._lock:
+            if self._state != self.REVOKED:
+                self._state = self.TIMED_OUT
+
     def revoke_if_expired(self):
         """
         Sets the state of the interface to revoked.
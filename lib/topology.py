@@ -98,20 +98,46 @@ class InterfaceElement(Element):
 
 class RouterElement(Element):
     """
-    The RouterElement class represents one of the border routers.
+    The RouterElement class represents one of the interfaces of a border
+    router. A border router with several interfaces is represented by one
+    RouterElement per interface, whose address is the internal address the
+    router uses for that interface.
     """
-    def __init__(self, router_dict, name=None):  # pragma: no cover
+    def __init__(self, router_dict, name=None, interface_dict=None):
         """
         :param dict router_dict: contains information about an border router.
         :param str name: router element name or id
+        :param dict interface_dict:
+            the router's interface. Defaults to the single interface of the
+            legacy 'Interface' key.
         """
-        super().__init__(router_dict['Addr'], router_dict['Port'], name)
-        self.interface = InterfaceElement(router_dict['Interface'])
+        if interface_dict is None:
+            interface_dict = router_dict['Interface']
+        idx = interface_dict.get('InternalAddrIdx', 0)
+        int_addr = router_dict
+        if idx:
+            int_addr = router_dict['InternalAddrs'][idx - 1]
+        super().__init__(int_addr['Addr'], int_addr['Port'], name)
+        self.interface = InterfaceElement(interface_dict)
 
     def __lt__(self, other):  # pragma: no cover
         return self.interface.if_id < other.interface.if_id
 
 
+def router_interfaces(router_dict):
+    """
+    Return the interfaces of a border router, from either the 'Interfaces'
+    list or the legacy single 'Interface' entry.
+
+    :param dict router_dict: contains information about a border router.
+    :returns: the interface dictionaries.
+    :rtype: list
+    """
+    if 'Interfaces' in router_dict:
+        return router_dict['Interfaces']
+    return [router_dict['Interface']]
+
+
 class Topology(object):
     """
     The Topology class parses the topology file of an AS and stores such
@@ -190,15 +216,16 @@ class Topology(object):
                 list_.append(ServerElement(v, k))
 
     def _parse_router_dicts(self, topology):
+        ntype_map = {
+            LinkType.PARENT: self.parent_border_routers,
+            LinkType.CHILD: self.child_border_routers,
+            LinkType.PEER: self.peer_border_routers,
+            LinkType.CORE: self.core_border_routers,
+        }
         for k, v in topology['BorderRouters'].items():
-            router = RouterElement(v, k)
-            ntype_map = {
-                LinkType.PARENT: self.parent_border_routers,
-                LinkType.CHILD: self.child_border_routers,
-                LinkType.PEER: self.peer_border_routers,
-                LinkType.CORE: self.core_border_routers,
-            }
-            ntype_map[router.interface.link_type].append(router)
+            for if_dict in router_interfaces(v):
+                router = RouterElement(v, k, if_dict)
+                ntype_map[router.interface.link_type].append(router)
 
     def _parse_zk_dicts(self, topology):
         for zk in topology['Zookeepers'].values():
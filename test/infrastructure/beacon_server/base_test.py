@@ -0,0 +1,109 @@
+# Copyright 2017 ETH Zurich
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#   http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""
+:mod:`base_test` --- infrastructure.beacon_server.base unit tests
+=================================================================
+"""
+# Stdlib
+from threading import Lock
+
+# External packages
+import nose
+import nose.tools as ntools
+
+# SCION
+from infrastructure.beacon_server.base import BeaconServer
+from lib.packet.host_addr import haddr_parse_interface
+from lib.packet.path_mgmt.ifstate import IFStatePayload
+from lib.packet.scion_addr import ISD_AS
+from test.testcommon import create_mock, create_mock_full
+
+
+class TestBeaconServerHandleIfstateInfos(object):
+    """
+    Unit tests for
+    infrastructure.beacon_server.base.BeaconServer._handle_ifstate_infos
+    """
+    def _setup(self, local):
+        inst = create_mock(["_from_local_br", "ifid_state",
+                            "ifid_state_lock"])
+        inst._from_local_br.return_value = local
+        inst.ifid_state_lock = Lock()
+        inst.ifid_state = {1: create_mock(["time_out"]),
+                           2: create_mock(["time_out"])}
+        pld = create_mock(["p"], class_=IFStatePayload)
+        pld.p = create_mock(["infos"])
+        pld.p.infos = [
+            create_mock_full({"ifID": 1, "active": False}),
+            create_mock_full({"ifID": 2, "active": True}),
+            create_mock_full({"ifID": 3, "active": False}),
+        ]
+        meta = create_mock(["get_addr"])
+        return inst, pld, meta
+
+    def test_local_br(self):
+        inst, pld, meta = self._setup(True)
+        # Call
+        BeaconServer._handle_ifstate_infos(inst, pld, meta)
+        # Tests
+        inst._from_local_br.assert_called_once_with(meta)
+        inst.ifid_state[1].time_out.assert_called_once_with()
+        ntools.assert_false(inst.ifid_state[2].time_out.called)
+
+    def test_other_sender(self):
+        inst, pld, meta = self._setup(False)
+        # Call
+        BeaconServer._handle_ifstate_infos(inst, pld, meta)
+        # Tests
+        for state in inst.ifid_state.values():
+            ntools.assert_false(state.time_out.called)
+
+
+class TestBeaconServerFromLocalBr(object):
+    """
+    Unit tests for infrastructure.beacon_server.base.BeaconServer._from_local_br
+    """
+    def _setup(self):
+        inst = create_mock(["addr", "topology"])
+        inst.addr = create_mock(["isd_as"])
+        inst.addr.isd_as = ISD_AS("1-11")
+        brs = []
+        for addr in ("127.0.0.1", "127.0.0.2"):
+            br = create_mock(["addr"])
+            br.addr = haddr_parse_interface(addr)
+            brs.append(br)
+        inst.topology = create_mock(["get_all_border_routers"])
+        inst.topology.get_all_border_routers.return_value = brs
+        return inst
+
+    def _check(self, isd_as, host, expected):
+        inst = self._setup()
+        meta = create_mock(["ia", "host"])
+        meta.ia = ISD_AS(isd_as)
+        meta.host = haddr_parse_interface(host)
+        # Call
+        ntools.eq_(BeaconServer._from_local_br(inst, meta), expected)
+
+    def test(self):
+        for isd_as, host, expected in (
+            ("1-11", "127.0.0.1", True),
+            ("1-11", "127.0.0.2", True),
+            ("1-11", "127.0.0.3", False),
+            ("1-12", "127.0.0.1", False),
+        ):
+            yield self._check, isd_as, host, expected
+
+
+if __name__ == "__main__":
+    nose.run(defaultTest=__name__)
@@ -28,7 +28,9 @@ from lib.errors import SCIONKeyError
 from lib.topology import (
     Element,
     InterfaceElement,
-    Topology
+    RouterElement,
+    Topology,
+    router_interfaces,
 )
 from test.testcommon import assert_these_calls, create_mock
 
@@ -86,6 +88,51 @@ class TestInterfaceElementInit(object):
         ntools.eq_(inst.to_addr, parse.return_value)
 
 
+class TestRouterElementInit(object):
+    """
+    Unit tests for lib.topology.RouterElement.__init__
+    """
+    def _router_dict(self):
+        return {
+            'Addr': 'addr0', 'Port': 30000,
+            'InternalAddrs': [{'Addr': 'addr1', 'Port': 30001}],
+            'Interface': {'IFID': 1},
+        }
+
+    @patch("lib.topology.InterfaceElement", autospec=True)
+    @patch("lib.topology.Element.__init__", autospec=True)
+    def test_legacy(self, super_init, intf):
+        router_dict = self._router_dict()
+        # Call
+        inst = RouterElement(router_dict, "name")
+        # Tests
+        super_init.assert_called_once_with(inst, 'addr0', 30000, "name")
+        intf.assert_called_once_with({'IFID': 1})
+        ntools.eq_(inst.interface, intf.return_value)
+
+    @patch("lib.topology.InterfaceElement", autospec=True)
+    @patch("lib.topology.Element.__init__", autospec=True)
+    def test_internal_addr(self, super_init, intf):
+        router_dict = self._router_dict()
+        if_dict = {'IFID': 2, 'InternalAddrIdx': 1}
+        # Call
+        inst = RouterElement(router_dict, "name", if_dict)
+        # Tests
+        super_init.assert_called_once_with(inst, 'addr1', 30001, "name")
+        intf.assert_called_once_with(if_dict)
+
+
+class TestRouterInterfaces(object):
+    """
+    Unit tests for lib.topology.router_interfaces
+    """
+    def test_list(self):
+        ntools.eq_(router_interfaces({'Interfaces': [1, 2]}), [1, 2])
+
+    def test_legacy(self):
+        ntools.eq_(router_interfaces({'Interface': 1}), [1])
+
+
 class TestTopologyParseDict(object):
     """
     Unit tests for lib.topology.Topology.parse_dict
@@ -150,15 +197,23 @@ class TestTopologyParseRouterDicts(object):
             return m
         routers = defaultdict(list)
         router_dict = {
-            "br-parent": "PARENT", "br-child": "CHILD",
-            "br-peer": "PEER", "br-core0": "CORE",
-            "br-core1": "CORE",
+            "br-parent": {"Interfaces": ["PARENT"]},
+            "br-child-peer": {"Interfaces": ["CHILD", "PEER"]},
+            "br-core0": {"Interface": "CORE"},
+            "br-core1": {"Interfaces": ["CORE"]},
         }
         inst = Topology()
-        router.side_effect = lambda v, k: _mk_router(v)
+        router.side_effect = lambda v, k, i: _mk_router(i)
         # Call
         inst._parse_router_dicts({"BorderRouters": router_dict})
         # Tests
+        assert_these_calls(router, [
+            call(router_dict["br-parent"], "br-parent", "PARENT"),
+            call(router_dict["br-child-peer"], "br-child-peer", "CHILD"),
+            call(router_dict["br-child-peer"], "br-child-peer", "PEER"),
+            call(router_dict["br-core0"], "br-core0", "CORE"),
+            call(router_dict["br-core1"], "br-core1", "CORE"),
+        ], any_order=True)
         ntools.assert_count_equal(inst.parent_border_routers, routers["PARENT"])
         ntools.assert_count_equal(inst.child_border_routers, routers["CHILD"])
         ntools.assert_count_equal(inst.peer_border_routers, routers["PEER"])
@@ -502,8 +502,9 @@ class TopoGenerator(object):
         subnet = self.subnet_gen.register(topo_id)
         return subnet.register(elem_id)
 
-    def _reg_link_addrs(self, local_br, remote_br):
-        link_name = str(sorted((local_br, remote_br)))
+    def _reg_link_addrs(self, local_br, local_ifid, remote_br, remote_ifid):
+        link_name = str(sorted(((local_br, local_ifid),
+                                (remote_br, remote_ifid))))
         subnet = self.subnet_gen.register(link_name)
         return subnet.register(local_br), subnet.register(remote_br)
 
@@ -522,8 +523,27 @@ class TopoGenerator(object):
         return self.topo_dicts, self.zookeepers, networks
 
     def _read_links(self):
+        # Links can name the router of either end with the optional "a_br" and
+        # "b_br" attributes, so that several links share a router. Links
+        # without a name get a router of their own.
+        named_brs = set()
+        for attrs in self.topo_config["links"]:
+            for end in ("a", "b"):
+                if "%s_br" % end in attrs:
+                    named_brs.add(self._br_name(
+                        TopoID(attrs[end]), attrs["%s_br" % end]))
         br_ids = defaultdict(int)
         if_ids = defaultdict(lambda: IFIDGenerator())
+
+        def _next_br(topo_id, name):
+            if name is not None:
+                return self._br_name(topo_id, name)
+            while True:
+                br_ids[topo_id] += 1
+                br = self._br_name(topo_id, br_ids[topo_id])
+                if br not in named_brs:
+                    return br
+
         for attrs in self.topo_config["links"]:
             # Pop the basic attributes, then append the remainder to the link
             # entry.
@@ -533,14 +553,14 @@ class TopoGenerator(object):
             if ltype == LinkType.PARENT:
                 ltype_a = LinkType.CHILD
                 ltype_b = LinkType.PARENT
-            br_ids[a] += 1
-            a_br = "br%s-%d" % (a, br_ids[a])
+            a_br = _next_br(a, attrs.pop("a_br", None))
             a_ifid = if_ids[a].new()
-            br_ids[b] += 1
-            b_br = "br%s-%d" % (b, br_ids[b])
+            b_br = _next_br(b, attrs.pop("b_br", None))
             b_ifid = if_ids[b].new()
-            self.links[a].append((ltype_a, b, attrs, a_br, b_br, a_ifid))
-            self.links[b].append((ltype_b, a, attrs, b_br, a_br, b_ifid))
+            self.links[a].append(
+                (ltype_a, b, attrs, a_br, b_br, a_ifid, b_ifid))
+            self.links[b].append(
+                (ltype_b, a, attrs, b_br, a_br, b_ifid, a_ifid))
             a_desc = "%s %s" % (a_br, a_ifid)
             b_desc = "%s %s" % (b_br, b_ifid)
             self.ifid_map.setdefault(str(a), {})
@@ -548,6 +568,9 @@ class TopoGenerator(object):
             self.ifid_map.setdefault(str(b), {})
             self.ifid_map[str(b)][b_desc] = a_desc
 
+    def _br_name(self, topo_id, name):
+        return "br%s-%s" % (topo_id, name)
+
     def _generate_as_topo(self, topo_id, as_conf):
         mtu = as_conf.get('mtu', self.default_mtu)
         assert mtu >= SCION_MIN_MTU, mtu
@@ -584,29 +607,32 @@ class TopoGenerator(object):
 
     def _gen_br_entries(self, topo_id):
         for (ltype, remote, attrs, local_br,
-             remote_br, ifid) in self.links[topo_id]:
+             remote_br, ifid, remote_ifid) in self.links[topo_id]:
             self._gen_br_entry(topo_id, ifid, remote, ltype, attrs, local_br,
-                               remote_br)
+                               remote_br, remote_ifid)
 
     def _gen_br_entry(self, local, ifid, remote, remote_type, attrs, local_br,
-                      remote_br):
+                      remote_br, remote_ifid):
         public_addr, remote_addr = self._reg_link_addrs(
-            local_br, remote_br)
-        self.topo_dicts[local]["BorderRouters"][local_br] = {
-            'Addr': self._reg_addr(local, local_br),
-            'Port': random.randint(30050, 30100),
-            'Interface': {
-                'IFID': ifid,
-                'ISD_AS': str(remote),
-                'LinkType': remote_type,
-                'Addr': public_addr,
-                'ToAddr': remote_addr,
-                'UdpPort': SCION_ROUTER_PORT,
-                'ToUdpPort': SCION_ROUTER_PORT,
-                'Bandwidth': attrs.get('bw', DEFAULT_LINK_BW),
-                'MTU': attrs.get('mtu', DEFAULT_MTU),
+            local_br, ifid, remote_br, remote_ifid)
+        brs = self.topo_dicts[local]["BorderRouters"]
+        if local_br not in brs:
+            brs[local_br] = {
+                'Addr': self._reg_addr(local, local_br),
+                'Port': random.randint(30050, 30100),
+                'Interfaces': [],
             }
-        }
+        brs[local_br]['Interfaces'].append({
+            'IFID': ifid,
+            'ISD_AS': str(remote),
+            'LinkType': remote_type,
+            'Addr': public_addr,
+            'ToAddr': remote_addr,
+            'UdpPort': SCION_ROUTER_PORT,
+            'ToUdpPort': SCION_ROUTER_PORT,
+            'Bandwidth': attrs.get('bw', DEFAULT_LINK_BW),
+            'MTU': attrs.get('mtu', DEFAULT_MTU),
+        })
 
     def _gen_zk_entries(self, topo_id, as_conf):
         zk_conf = {}